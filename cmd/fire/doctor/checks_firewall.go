@@ -0,0 +1,162 @@
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+)
+
+func init() {
+	Register("kernel-modules", checkKernelModules)
+	Register("iptables-backend", checkIptablesBackend)
+}
+
+// moduleUse is a kernel module the firewall needs because of a feature in use.
+type moduleUse struct {
+	// modules are alternatives, any one of them is enough
+	modules []string
+	feature string
+}
+
+func checkKernelModules(env *Env) []Result {
+	var uses []moduleUse
+	if rs := env.Ruleset; rs != nil && len(rs.Tables) > 0 {
+		uses = append(uses, moduleUse{[]string{"nf_tables"}, "nftables tables"})
+
+		keys := ruleKeys(env)
+		if keys["ct"] {
+			uses = append(uses, moduleUse{[]string{"nf_conntrack"}, "ct expressions"})
+		}
+		hasNatChain := slices.ContainsFunc(rs.Chains, func(c ruleset.Chain) bool { return c.Type == "nat" })
+		if hasNatChain || keys["snat"] || keys["dnat"] || keys["masquerade"] || keys["redirect"] {
+			uses = append(uses, moduleUse{[]string{"nf_nat"}, "NAT"})
+		}
+		if keys["log"] {
+			uses = append(uses, moduleUse{[]string{"nf_log_syslog", "nf_log_ipv4", "nf_log_ipv6"}, "log statements"})
+		}
+		if len(rs.Flowtables) > 0 {
+			uses = append(uses, moduleUse{[]string{"nf_flow_table"}, "flowtables"})
+		}
+		for _, obj := range rs.Objects {
+			if obj.Kind == "ct helper" && obj.Type != "" {
+				uses = append(uses, moduleUse{[]string{"nf_conntrack_" + obj.Type}, "ct helper " + obj.Name})
+			}
+		}
+	}
+	if len(env.WireGuard) > 0 {
+		uses = append(uses, moduleUse{[]string{"wireguard"}, "WireGuard"})
+	}
+	if len(uses) == 0 {
+		return pass("no feature in use needs an extra kernel module")
+	}
+
+	var results []Result
+	var loaded []string
+	for _, use := range uses {
+		i := slices.IndexFunc(use.modules, func(name string) bool { return moduleLoaded(env, name) })
+		if i >= 0 {
+			if !slices.Contains(loaded, use.modules[i]) {
+				loaded = append(loaded, use.modules[i])
+			}
+			continue
+		}
+		results = append(results, Result{
+			Status:  StatusFail,
+			Message: fmt.Sprintf("%s is not loaded, needed for %s", strings.Join(use.modules, " or "), use.feature),
+			Fix: fmt.Sprintf("modprobe %s and add it to /etc/modules-load.d/ so it is loaded at boot; "+
+				"when modprobe fails the kernel was built without it", use.modules[0]),
+		})
+	}
+	if len(results) == 0 {
+		return pass("%s loaded", strings.Join(loaded, ", "))
+	}
+	return results
+}
+
+func moduleLoaded(env *Env, name string) bool {
+	if slices.Contains(env.Status.KernelModules, name) {
+		return true
+	}
+	_, err := env.System.Stat("/sys/module/" + name)
+	return err == nil
+}
+
+// ruleKeys collects every object key used in rule expressions, e.g. "ct", "log" or "dnat".
+func ruleKeys(env *Env) map[string]bool {
+	keys := make(map[string]bool)
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for key, value := range v {
+				keys[key] = true
+				walk(value)
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	for _, rule := range env.Ruleset.Rules {
+		for _, expr := range rule.Expr {
+			var v any
+			if json.Unmarshal(expr, &v) == nil {
+				walk(v)
+			}
+		}
+	}
+	return keys
+}
+
+func checkIptablesBackend(env *Env) []Result {
+	status := env.Status
+	nftBackend := strings.Contains(status.Iptables.Type, "nf_tables")
+	legacyBackend := strings.Contains(status.Iptables.Type, "legacy backend")
+	nftTables := env.Ruleset != nil && len(env.Ruleset.Tables) > 0
+
+	var results []Result
+	switch {
+	case nftBackend && len(status.Iptables.LegacyTables) > 0:
+		results = append(results, Result{
+			Status: StatusFail,
+			Message: fmt.Sprintf("iptables uses the nf_tables backend but legacy tables are loaded (%s); "+
+				"their rules still filter packets and are invisible to iptables and nft", strings.Join(status.Iptables.LegacyTables, ", ")),
+			Fix: "find the tool still calling iptables-legacy, move its rules with iptables-legacy-save | iptables-nft-restore, " +
+				"then flush the legacy tables with iptables-legacy -F and unload the iptable_* modules",
+		})
+	case legacyBackend && nftTables:
+		results = append(results, Result{
+			Status:  StatusWarn,
+			Message: "iptables uses the legacy backend while nftables tables are loaded, both rulesets see every packet",
+			Fix:     "switch to iptables-nft (update-alternatives --set iptables /usr/sbin/iptables-nft) so all rules live in one ruleset",
+		})
+	}
+
+	for _, other := range []struct {
+		name      string
+		installed bool
+		typ       string
+	}{
+		{"ebtables", status.Ebtables.Installed, status.Ebtables.Type},
+		{"arptables", status.Arptables.Installed, status.Arptables.Type},
+	} {
+		if !other.installed {
+			continue
+		}
+		if nftBackend && other.typ == "legacy" || legacyBackend && other.typ == "nf_tables" {
+			results = append(results, Result{
+				Status:  StatusWarn,
+				Message: fmt.Sprintf("%s uses the %s backend, iptables does not", other.name, other.typ),
+				Fix:     fmt.Sprintf("point %s at the same backend as iptables with update-alternatives", other.name),
+			})
+		}
+	}
+	if len(results) == 0 {
+		return pass("iptables backend: %s", status.Iptables.Type)
+	}
+	return results
+}
@@ -0,0 +1,302 @@
+package doctor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/woshikedayaa/fire/common/nftables"
+	"github.com/woshikedayaa/fire/common/wireguard"
+)
+
+func init() {
+	Register("wireguard-config", checkConfig)
+	Register("wireguard-listen-port", checkListenPort)
+	Register("wireguard-forwarding", checkForwarding)
+	Register("wireguard-mtu", checkMTU)
+}
+
+// WireGuard adds 60 bytes of headers over IPv4 and 80 over IPv6, wg-quick
+// subtracts the latter from the uplink MTU when none is configured.
+const (
+	wireguardOverhead4 = 60
+	wireguardOverhead6 = 80
+)
+
+func noWireGuard() []Result {
+	return pass("no WireGuard config given, use --wireguard")
+}
+
+// checkConfig reports what the parser skipped, wg-quick skips some of it
+// too without a word.
+func checkConfig(env *Env) []Result {
+	if len(env.WireGuard) == 0 {
+		return noWireGuard()
+	}
+	var results []Result
+	for _, wg := range env.WireGuard {
+		if len(wg.Warnings) == 0 {
+			results = append(results, pass("%s: %d peers parsed", wg.Name, len(wg.Peers))...)
+			continue
+		}
+		for _, warning := range wg.Warnings {
+			results = append(results, Result{
+				Status:  StatusWarn,
+				Message: warning.Error(),
+				Fix:     "fix or remove the line in " + wg.Path,
+			})
+		}
+	}
+	return results
+}
+
+func checkListenPort(env *Env) []Result {
+	if len(env.WireGuard) == 0 {
+		return noWireGuard()
+	}
+	var results []Result
+	for _, wg := range env.WireGuard {
+		port := int(wg.Interface.ListenPort)
+		if port == 0 {
+			results = append(results, pass("%s: no ListenPort, it only dials out", wg.Name)...)
+			continue
+		}
+		if env.Ruleset == nil {
+			msg := "nftables is not installed"
+			if env.RulesetError != nil {
+				msg = env.RulesetError.Error()
+			}
+			results = append(results, Result{
+				Status:  StatusWarn,
+				Message: fmt.Sprintf("%s: cannot tell whether %d/udp is allowed, the ruleset is unavailable: %s", wg.Name, port, msg),
+				Fix:     "run fire doctor as root so the ruleset can be listed",
+			})
+			continue
+		}
+
+		// without the uplink a rule accepting the port on it may be missed
+		uplink, _ := uplinkMTU(env)
+		known, on := uplink != "", uplink
+		if !known {
+			uplink, on = otherInterface, "an unknown uplink"
+		}
+		for _, family := range []nftables.Family{nftables.FamilyIPv4, nftables.FamilyIPv6} {
+			r, uncertain := handshakeInput(env.Ruleset, family, uplink, uint16(port))
+			uncertain = uncertain || !known && r.Verdict != "accept"
+			where := r.Decided
+			if where == "" {
+				where = "no input chain drops it"
+			}
+			fix := fmt.Sprintf("nft insert rule %s udp dport %d accept", inputChainOf(r.Decided), port)
+			switch {
+			case r.Verdict != "accept" && !uncertain:
+				results = append(results, Result{
+					Status:  StatusFail,
+					Message: fmt.Sprintf("%s: ListenPort %d/udp is dropped for %s by %s", wg.Name, port, family, where),
+					Fix:     fix,
+				})
+			case uncertain:
+				results = append(results, Result{
+					Status: StatusWarn,
+					Message: fmt.Sprintf("%s: ListenPort %d/udp is only allowed for %s under conditions fire cannot check, decided by %s for a packet on %s",
+						wg.Name, port, family, where, on),
+					Fix: "make sure a rule accepts the port on the uplink interface, e.g. " + fix,
+				})
+			default:
+				results = append(results, pass("%s: ListenPort %d/udp is allowed for %s (%s)", wg.Name, port, family, where)...)
+			}
+		}
+	}
+	return results
+}
+
+// inputChainOf extracts "family table chain" from an outcome location, with a
+// generic fallback for locations that do not name a chain.
+func inputChainOf(where string) string {
+	fields := strings.Fields(where)
+	if len(fields) >= 3 {
+		return strings.Join(fields[:3], " ")
+	}
+	return "inet filter input"
+}
+
+// isHub reports whether the interface routes between its peers.
+func isHub(wg WireGuardConfig) bool {
+	return len(wg.Peers) > 1
+}
+
+func checkForwarding(env *Env) []Result {
+	if len(env.WireGuard) == 0 {
+		return noWireGuard()
+	}
+	sysctl := func(key string) (string, bool) {
+		for _, v := range env.Status.Sysctls {
+			if v.Key == key {
+				return v.Value, true
+			}
+		}
+		return "", false
+	}
+
+	var results []Result
+	for _, wg := range env.WireGuard {
+		if !isHub(wg) {
+			results = append(results, pass("%s: %d peer, not a hub", wg.Name, len(wg.Peers))...)
+			continue
+		}
+		has4, has6 := false, false
+		for _, addr := range wg.Interface.Addresses {
+			if addr.Addr().Is4() {
+				has4 = true
+			} else {
+				has6 = true
+			}
+		}
+		ok := true
+		for _, want := range []struct {
+			used bool
+			key  string
+		}{
+			{has4, "net.ipv4.ip_forward"},
+			{has6, "net.ipv6.conf.all.forwarding"},
+		} {
+			if !want.used {
+				continue
+			}
+			value, found := sysctl(want.key)
+			switch {
+			case !found:
+				ok = false
+				results = append(results, Result{
+					Status:  StatusWarn,
+					Message: fmt.Sprintf("%s: hub with %d peers, %s could not be read", wg.Name, len(wg.Peers), want.key),
+					Fix:     "check that " + want.key + " is 1",
+				})
+			case value == "0":
+				ok = false
+				results = append(results, Result{
+					Status:  StatusFail,
+					Message: fmt.Sprintf("%s: hub with %d peers but %s is 0, peers cannot reach each other", wg.Name, len(wg.Peers), want.key),
+					Fix:     fmt.Sprintf("sysctl -w %s=1 and persist it in /etc/sysctl.d/99-wireguard.conf", want.key),
+				})
+			}
+		}
+		if ok {
+			results = append(results, pass("%s: hub with %d peers, forwarding is enabled", wg.Name, len(wg.Peers))...)
+		}
+	}
+	return results
+}
+
+func checkMTU(env *Env) []Result {
+	if len(env.WireGuard) == 0 {
+		return noWireGuard()
+	}
+	uplink, uplinkMTU := uplinkMTU(env)
+
+	var results []Result
+	for _, wg := range env.WireGuard {
+		mtu := wg.Interface.MTU
+		var problems []Result
+
+		if mtu > 0 && uplinkMTU > 0 {
+			ipv6Endpoint := false
+			for _, peer := range wg.Peers {
+				if peer.Endpoint.Is6() {
+					ipv6Endpoint = true
+				}
+			}
+			switch {
+			case mtu > uplinkMTU-wireguardOverhead4:
+				problems = append(problems, Result{
+					Status:  StatusFail,
+					Message: fmt.Sprintf("%s: MTU %d leaves no room for the WireGuard header on %s (MTU %d)", wg.Name, mtu, uplink, uplinkMTU),
+					Fix:     fmt.Sprintf("set MTU = %d, or remove MTU and let wg-quick pick it", uplinkMTU-wireguardOverhead6),
+				})
+			case ipv6Endpoint && mtu > uplinkMTU-wireguardOverhead6:
+				problems = append(problems, Result{
+					Status:  StatusWarn,
+					Message: fmt.Sprintf("%s: MTU %d is too large for IPv6 endpoints on %s (MTU %d)", wg.Name, mtu, uplink, uplinkMTU),
+					Fix:     fmt.Sprintf("set MTU = %d", uplinkMTU-wireguardOverhead6),
+				})
+			}
+		}
+
+		if live, err := readInt(env, "/sys/class/net/"+wg.Name+"/mtu"); err == nil && mtu > 0 && live != mtu {
+			problems = append(problems, Result{
+				Status:  StatusWarn,
+				Message: fmt.Sprintf("%s: the running interface has MTU %d, the config says %d", wg.Name, live, mtu),
+				Fix:     fmt.Sprintf("restart it with wg-quick down %s && wg-quick up %s", wg.Name, wg.Name),
+			})
+		}
+
+		for _, other := range env.WireGuard {
+			if other.Path == wg.Path || other.Interface.MTU == 0 || mtu == 0 || other.Interface.MTU == mtu {
+				continue
+			}
+			if peersWith(wg, other) && wg.Path < other.Path {
+				problems = append(problems, Result{
+					Status: StatusWarn,
+					Message: fmt.Sprintf("%s and %s are peers of each other with different MTUs (%d and %d)",
+						wg.Name, other.Name, mtu, other.Interface.MTU),
+					Fix: "use the same MTU on both ends of the tunnel",
+				})
+			}
+		}
+
+		if len(problems) > 0 {
+			results = append(results, problems...)
+			continue
+		}
+		switch {
+		case mtu == 0 && uplinkMTU > 0:
+			results = append(results, pass("%s: MTU unset, wg-quick derives it from %s (MTU %d)", wg.Name, uplink, uplinkMTU)...)
+		case mtu == 0:
+			results = append(results, pass("%s: MTU unset, wg-quick derives it from the uplink", wg.Name)...)
+		default:
+			results = append(results, pass("%s: MTU %d", wg.Name, mtu)...)
+		}
+	}
+	return results
+}
+
+// peersWith reports whether a has b as a peer.
+func peersWith(a, b WireGuardConfig) bool {
+	pub, err := wireguard.GenPublicKey(b.Interface.PrivateKey)
+	if err != nil {
+		return false
+	}
+	for _, peer := range a.Peers {
+		if peer.PublicKey == pub {
+			return true
+		}
+	}
+	return false
+}
+
+// uplinkMTU returns the interface of the IPv4 default route and its MTU.
+func uplinkMTU(env *Env) (string, int) {
+	data, err := env.System.ReadFile("/proc/net/route")
+	if err != nil {
+		return "", 0
+	}
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] != "00000000" {
+			continue
+		}
+		mtu, err := readInt(env, "/sys/class/net/"+fields[0]+"/mtu")
+		if err == nil {
+			return fields[0], mtu
+		}
+	}
+	return "", 0
+}
+
+func readInt(env *Env, path string) (int, error) {
+	data, err := env.System.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
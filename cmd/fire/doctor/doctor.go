@@ -0,0 +1,225 @@
+package doctor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/woshikedayaa/fire/cmd/fire/nftables"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/system"
+	"github.com/woshikedayaa/fire/common/wireguard"
+)
+
+var (
+	doctorOutput    output.Options
+	doctorWireGuard []string
+	doctorChecks    []string
+	doctorStrict    bool
+
+	MainCommand = &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose the firewall and WireGuard setup",
+		Long: `Run a set of checks over the firewall status collected by "fire nftables status"
+and the WireGuard configs given with --wireguard. Every check reports pass,
+warn or fail, and how to fix it. The exit status is non-zero when a check
+fails, or with --strict when one warns.`,
+		Args: cobra.NoArgs,
+		RunE: runDoctor,
+	}
+)
+
+func init() {
+	doctorOutput.Bind(MainCommand, output.FormatText)
+	MainCommand.Flags().StringSliceVarP(&doctorWireGuard, "wireguard", "w", nil, "wg-quick config to check, may be repeated or a glob like /etc/wireguard/*.conf")
+	MainCommand.Flags().StringSliceVar(&doctorChecks, "check", nil, "Only run these checks")
+	MainCommand.Flags().BoolVar(&doctorStrict, "strict", false, "Also exit non-zero when a check warns")
+}
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+type Result struct {
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+// Env is everything a check looks at.
+type Env struct {
+	System system.System
+	Status nftables.StatusData
+	// Ruleset is nil when it could not be listed, RulesetError tells why.
+	Ruleset      *ruleset.Ruleset
+	RulesetError error
+	WireGuard    []WireGuardConfig
+}
+
+type WireGuardConfig struct {
+	Path string
+	// Name is the interface name, wg-quick takes it from the file name.
+	Name      string
+	Interface wireguard.Interface
+	Peers     []wireguard.Peer
+	// Warnings are what the lenient parser skipped, like a broken peer.
+	Warnings []error
+}
+
+// CheckFunc returns one result per finding, or a single pass.
+type CheckFunc func(env *Env) []Result
+
+type check struct {
+	name string
+	run  CheckFunc
+}
+
+var checks []check
+
+// Register adds a check, names must be unique.
+func Register(name string, run CheckFunc) {
+	if slices.ContainsFunc(checks, func(c check) bool { return c.name == name }) {
+		panic("doctor: duplicate check " + name)
+	}
+	checks = append(checks, check{name: name, run: run})
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	if err := doctorOutput.Validate(); err != nil {
+		return err
+	}
+	for _, name := range doctorChecks {
+		if !slices.ContainsFunc(checks, func(c check) bool { return c.name == name }) {
+			return E.New("unknown check: ", name)
+		}
+	}
+	configs, err := loadWireGuardConfigs(doctorWireGuard)
+	if err != nil {
+		return err
+	}
+
+	env := &Env{
+		System:    system.Local(),
+		Status:    nftables.CollectStatus(),
+		WireGuard: configs,
+	}
+	if env.Status.Nftables.Installed {
+		env.Ruleset, env.RulesetError = listRuleset(env.System)
+	}
+
+	var rep report
+	for _, c := range checks {
+		if len(doctorChecks) > 0 && !slices.Contains(doctorChecks, c.name) {
+			continue
+		}
+		for _, r := range c.run(env) {
+			r.Check = c.name
+			rep.Results = append(rep.Results, r)
+		}
+	}
+	for _, r := range rep.Results {
+		switch r.Status {
+		case StatusPass:
+			rep.Summary.Pass++
+		case StatusWarn:
+			rep.Summary.Warn++
+		case StatusFail:
+			rep.Summary.Fail++
+		}
+	}
+
+	if err = doctorOutput.Print(cmd, rep); err != nil {
+		return err
+	}
+	if rep.Summary.Fail > 0 || (doctorStrict && rep.Summary.Warn > 0) {
+		cmd.SilenceUsage = true
+		return E.New(rep.Summary.Fail, " checks failed, ", rep.Summary.Warn, " warned")
+	}
+	return nil
+}
+
+func listRuleset(sys system.System) (*ruleset.Ruleset, error) {
+	out, err := system.Output(sys, "nft", "-j", "list", "ruleset")
+	if err != nil {
+		return nil, err
+	}
+	return ruleset.Parse(bytes.NewReader(out))
+}
+
+func loadWireGuardConfigs(patterns []string) ([]WireGuardConfig, error) {
+	var configs []WireGuardConfig
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, E.When("glob "+pattern, err)
+		}
+		if len(paths) == 0 {
+			return nil, E.New("no wireguard config matches ", pattern)
+		}
+		for _, path := range paths {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			iface, peers, warnings, err := wireguard.ParseWireguardConfWith(file, wireguard.ParseOptions{File: path})
+			_ = file.Close()
+			if err != nil {
+				return nil, err
+			}
+			configs = append(configs, WireGuardConfig{
+				Path:      path,
+				Name:      strings.TrimSuffix(filepath.Base(path), ".conf"),
+				Interface: iface,
+				Peers:     peers,
+				Warnings:  warnings,
+			})
+		}
+	}
+	return configs, nil
+}
+
+type report struct {
+	Results []Result `json:"results"`
+	Summary struct {
+		Pass int `json:"pass"`
+		Warn int `json:"warn"`
+		Fail int `json:"fail"`
+	} `json:"summary"`
+}
+
+// RenderText implements output.Text.
+func (rep report) RenderText(w io.Writer) error {
+	for _, r := range rep.Results {
+		fmt.Fprintf(w, "[%s] %s: %s\n", r.Status, r.Check, r.Message)
+		if r.Fix != "" && r.Status != StatusPass {
+			fmt.Fprintf(w, "       fix: %s\n", r.Fix)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d warnings, %d failed\n", rep.Summary.Pass, rep.Summary.Warn, rep.Summary.Fail)
+	return err
+}
+
+// Table implements output.Table.
+func (rep report) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(rep.Results))
+	for _, r := range rep.Results {
+		rows = append(rows, []string{r.Check, string(r.Status), r.Message, r.Fix})
+	}
+	return []string{"CHECK", "STATUS", "MESSAGE", "FIX"}, rows
+}
+
+func pass(format string, a ...any) []Result {
+	return []Result{{Status: StatusPass, Message: fmt.Sprintf(format, a...)}}
+}
@@ -0,0 +1,103 @@
+package doctor
+
+import (
+	"os"
+	"testing"
+
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+func loadRuleset(t *testing.T, name string) *ruleset.Ruleset {
+	t.Helper()
+	file, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rs, err := ruleset.Parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+// uplink is a host with its default route on eth0.
+func uplink() system.System {
+	var c system.Cassette
+	c.AddFile("/proc/net/route", "Iface\tDestination\tGateway\tFlags\tRefCnt\tUse\tMetric\tMask\tMTU\tWindow\tIRTT\n"+
+		"eth0\t00000000\t0102A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n")
+	c.AddFile("/sys/class/net/eth0/mtu", "1500\n")
+	return system.Replay(c)
+}
+
+func listenPortEnv(t *testing.T, port uint16) *Env {
+	wg := WireGuardConfig{Path: "wg0.conf", Name: "wg0"}
+	wg.Interface.ListenPort = port
+	return &Env{System: uplink(), Ruleset: loadRuleset(t, "input.json"), WireGuard: []WireGuardConfig{wg}}
+}
+
+func TestCheckListenPort(t *testing.T) {
+	for _, tt := range []struct {
+		port uint16
+		want []Result
+	}{
+		{51820, []Result{
+			{Status: StatusPass, Message: "wg0: ListenPort 51820/udp is allowed for ip (inet filter input handle 5)"},
+			{Status: StatusPass, Message: "wg0: ListenPort 51820/udp is allowed for ip6 (inet filter input handle 5)"},
+		}},
+		{51821, []Result{
+			{Status: StatusPass, Message: "wg0: ListenPort 51821/udp is allowed for ip (inet filter input handle 5)"},
+			{Status: StatusFail, Message: "wg0: ListenPort 51821/udp is dropped for ip6 by ip6 guard input handle 2",
+				Fix: "nft insert rule ip6 guard input udp dport 51821 accept"},
+		}},
+		// the rule accepting it on eth0 applies, the default route is there
+		{51822, []Result{
+			{Status: StatusPass, Message: "wg0: ListenPort 51822/udp is allowed for ip (inet filter input handle 6)"},
+			{Status: StatusPass, Message: "wg0: ListenPort 51822/udp is allowed for ip6 (inet filter input handle 6)"},
+		}},
+		{51830, []Result{
+			{Status: StatusFail, Message: "wg0: ListenPort 51830/udp is dropped for ip by inet filter input policy drop",
+				Fix: "nft insert rule inet filter input udp dport 51830 accept"},
+			{Status: StatusFail, Message: "wg0: ListenPort 51830/udp is dropped for ip6 by inet filter input policy drop",
+				Fix: "nft insert rule inet filter input udp dport 51830 accept"},
+		}},
+	} {
+		got := checkListenPort(listenPortEnv(t, tt.port))
+		if len(got) != len(tt.want) {
+			t.Errorf("port %d: results %+v", tt.port, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("port %d:\n%+v\nwant\n%+v", tt.port, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestCheckListenPortUnknownUplink(t *testing.T) {
+	env := listenPortEnv(t, 51822)
+	env.System = system.Replay(system.Cassette{})
+	got := checkListenPort(env)
+	want := Result{
+		Status:  StatusWarn,
+		Message: "wg0: ListenPort 51822/udp is only allowed for ip under conditions fire cannot check, decided by inet filter input policy drop for a packet on an unknown uplink",
+		Fix:     "make sure a rule accepts the port on the uplink interface, e.g. nft insert rule inet filter input udp dport 51822 accept",
+	}
+	if len(got) != 2 || got[0] != want || got[1].Status != StatusWarn {
+		t.Errorf("results %+v", got)
+	}
+}
+
+func TestCheckListenPortWithoutRuleset(t *testing.T) {
+	env := listenPortEnv(t, 51820)
+	env.Ruleset = nil
+	if got := checkListenPort(env); len(got) != 1 || got[0].Status != StatusWarn {
+		t.Errorf("results %+v", got)
+	}
+	env.WireGuard[0].Interface.ListenPort = 0
+	if got := checkListenPort(env); len(got) != 1 || got[0].Status != StatusPass {
+		t.Errorf("results without ListenPort %+v", got)
+	}
+}
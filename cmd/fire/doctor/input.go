@@ -0,0 +1,50 @@
+package doctor
+
+import (
+	"net/netip"
+
+	"github.com/woshikedayaa/fire/common/nftables"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+	"github.com/woshikedayaa/fire/common/nftables/simulate"
+)
+
+// Packets of the documentation ranges stand in for the peers and the address
+// of the host, which the ruleset may match on.
+var (
+	peerAddrs = map[nftables.Family][]netip.Addr{
+		nftables.FamilyIPv4: {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("203.0.113.1")},
+		nftables.FamilyIPv6: {netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8:1::1")},
+	}
+	hostAddrs = map[nftables.Family]netip.Addr{
+		nftables.FamilyIPv4: netip.MustParseAddr("198.51.100.1"),
+		nftables.FamilyIPv6: netip.MustParseAddr("2001:db8:2::1"),
+	}
+)
+
+// otherInterface stands in for the uplink when it is unknown.
+const otherInterface = "fire-doctor0"
+
+// handshakeInput simulates the first packet of a WireGuard handshake to port
+// arriving on iif. It is sent from several peers, the verdict is uncertain
+// when it changes with them or the simulator had to assume something, so it
+// may not hold for every peer.
+func handshakeInput(rs *ruleset.Ruleset, family nftables.Family, iif string, port uint16) (result *simulate.Result, uncertain bool) {
+	for _, src := range peerAddrs[family] {
+		p := simulate.Packet{
+			Family:  family,
+			Iif:     iif,
+			Src:     src,
+			Dst:     hostAddrs[family],
+			Proto:   "udp",
+			Sport:   port,
+			Dport:   port,
+			CtState: "new",
+		}
+		r := simulate.Simulate(rs, p, simulate.DirectionInput)
+		if result == nil {
+			result = r
+		}
+		uncertain = uncertain || r.Verdict != result.Verdict || len(r.Notes) > 0
+	}
+	return result, uncertain || result.Verdict == "queue"
+}
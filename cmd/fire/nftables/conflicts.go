@@ -0,0 +1,201 @@
+package nftables
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/woshikedayaa/fire/common/nftables"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+)
+
+type severity string
+
+const (
+	severityInfo     severity = "info"
+	severityWarning  severity = "warning"
+	severityCritical severity = "critical"
+)
+
+type conflict struct {
+	ID          string   `json:"id"`
+	Severity    severity `json:"severity"`
+	Message     string   `json:"message"`
+	Remediation string   `json:"remediation"`
+}
+
+// managedChainPrefixes are chains created by container and orchestration tools,
+// they rewrite their chains on restart and will fight with a hand-written ruleset.
+var managedChainPrefixes = []struct {
+	prefix string
+	owner  string
+}{
+	{"DOCKER", "Docker"},
+	{"KUBE-", "kube-proxy"},
+	{"CNI-", "CNI plugins (Podman/Kubernetes)"},
+	{"NETAVARK", "Podman (netavark)"},
+	{"CILIUM_", "Cilium"},
+	{"cali-", "Calico"},
+}
+
+func detectConflicts(data nftablesStatusData) []conflict {
+	var conflicts []conflict
+	conflicts = append(conflicts, detectLegacyAndNft(data)...)
+	conflicts = append(conflicts, detectFirewallManagers(data)...)
+	conflicts = append(conflicts, detectManagedChains(data)...)
+	conflicts = append(conflicts, detectPriorityClashes(data.Nftables.Ruleset)...)
+	return conflicts
+}
+
+func countConflicts(conflicts []conflict, severities ...severity) int {
+	n := 0
+	for _, c := range conflicts {
+		if slices.Contains(severities, c.Severity) {
+			n++
+		}
+	}
+	return n
+}
+
+func detectLegacyAndNft(data nftablesStatusData) []conflict {
+	inv := data.Nftables.Ruleset
+	if len(data.Iptables.LegacyTables) == 0 || inv == nil || len(inv.Tables) == 0 {
+		return nil
+	}
+	return []conflict{{
+		ID:       "legacy-and-nft",
+		Severity: severityCritical,
+		Message: fmt.Sprintf("rules are loaded in iptables-legacy (%s) and in nf_tables (%d tables) at the same time",
+			strings.Join(data.Iptables.LegacyTables, ", "), len(inv.Tables)),
+		Remediation: "migrate the legacy rules with iptables-legacy-save | iptables-restore-translate, " +
+			"then flush them with iptables-legacy -F and switch the iptables alternative to iptables-nft",
+	}}
+}
+
+func detectFirewallManagers(data nftablesStatusData) []conflict {
+	if data.Firewall.Type != "firewalld and ufw" {
+		return nil
+	}
+	return []conflict{{
+		ID:          "firewalld-and-ufw",
+		Severity:    severityCritical,
+		Message:     "firewalld and ufw are both installed and will overwrite each other's rules",
+		Remediation: "keep one firewall manager: disable and uninstall either firewalld or ufw",
+	}}
+}
+
+func detectManagedChains(data nftablesStatusData) []conflict {
+	found := make(map[string][]string)
+	var owners []string
+	check := func(where, chain string) {
+		for _, managed := range managedChainPrefixes {
+			if strings.HasPrefix(chain, managed.prefix) {
+				if _, ok := found[managed.owner]; !ok {
+					owners = append(owners, managed.owner)
+				}
+				if !slices.Contains(found[managed.owner], where) {
+					found[managed.owner] = append(found[managed.owner], where)
+				}
+				return
+			}
+		}
+	}
+	if inv := data.Nftables.Ruleset; inv != nil {
+		for _, table := range inv.Tables {
+			for _, chain := range table.Chains {
+				check(fmt.Sprintf("%s %s", table.Family, table.Name), chain.Name)
+			}
+		}
+	}
+	for i, rs := range []*xtablesRuleset{data.Iptables.IPv4, data.Iptables.IPv6} {
+		if rs == nil {
+			continue
+		}
+		command := [...]string{"iptables", "ip6tables"}[i]
+		for _, table := range rs.Tables {
+			for _, chain := range table.Chains {
+				check(fmt.Sprintf("%s %s", command, table.Name), chain.Name)
+			}
+		}
+	}
+
+	var conflicts []conflict
+	for _, owner := range owners {
+		conflicts = append(conflicts, conflict{
+			ID:       "managed-chains",
+			Severity: severityWarning,
+			Message:  fmt.Sprintf("%s chains are present in %s", owner, strings.Join(found[owner], ", ")),
+			Remediation: fmt.Sprintf("keep your rules out of the tables %s manages and hook them at a different priority, "+
+				"or disable its firewall management", owner),
+		})
+	}
+	return conflicts
+}
+
+// detectPriorityClashes finds base chains of different tables registered at the same hook and priority.
+// The kernel gives no ordering guarantee between them.
+func detectPriorityClashes(inv *ruleset.Inventory) []conflict {
+	if inv == nil {
+		return nil
+	}
+	type hookKey struct {
+		family   nftables.Family
+		hook     string
+		priority int
+	}
+	clashes := make(map[hookKey][]string)
+	var order []hookKey
+	for _, table := range inv.Tables {
+		for _, chain := range table.Chains {
+			if chain.Hook == "" || chain.Priority == nil {
+				continue
+			}
+			name := fmt.Sprintf("%s %s %s", table.Family, table.Name, chain.Name)
+			// an inet chain sees the packets of both the ip and the ip6 hooks
+			families := []nftables.Family{table.Family}
+			if table.Family == nftables.FamilyInet {
+				families = []nftables.Family{nftables.FamilyIPv4, nftables.FamilyIPv6}
+			}
+			for _, family := range families {
+				key := hookKey{family, chain.Hook, *chain.Priority}
+				if _, ok := clashes[key]; !ok {
+					order = append(order, key)
+				}
+				clashes[key] = append(clashes[key], name)
+			}
+		}
+	}
+
+	var conflicts []conflict
+	seen := make(map[string]bool)
+	for _, key := range order {
+		chains := clashes[key]
+		if len(chains) < 2 || !differentTables(chains) {
+			continue
+		}
+		message := fmt.Sprintf("base chains %s are hooked at %s priority %d", strings.Join(chains, ", "), key.hook, key.priority)
+		if seen[message] {
+			continue
+		}
+		seen[message] = true
+		conflicts = append(conflicts, conflict{
+			ID:          "same-priority",
+			Severity:    severityWarning,
+			Message:     message,
+			Remediation: "give each base chain a distinct priority so their evaluation order is defined",
+		})
+	}
+	return conflicts
+}
+
+func differentTables(chains []string) bool {
+	table := func(chain string) string {
+		return chain[:strings.LastIndexByte(chain, ' ')]
+	}
+	for _, chain := range chains[1:] {
+		if table(chain) != table(chains[0]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package nftables
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/woshikedayaa/fire/common/iptables"
+	"github.com/woshikedayaa/fire/common/nftables"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+)
+
+func baseChain(name, hook string, priority int) ruleset.ChainInventory {
+	return ruleset.ChainInventory{Name: name, Type: "filter", Hook: hook, Priority: &priority, Policy: "accept"}
+}
+
+func TestDetectConflicts(t *testing.T) {
+	var data nftablesStatusData
+	data.Iptables.LegacyTables = []string{"ip filter"}
+	data.Iptables.IPv4 = &xtablesRuleset{Tables: []iptables.TableInventory{
+		{Name: "filter", Chains: []iptables.ChainInventory{{Name: "INPUT"}, {Name: "DOCKER-USER"}}},
+	}}
+	data.Firewall.Type = "firewalld and ufw"
+	data.Nftables.Ruleset = &ruleset.Inventory{Tables: []ruleset.TableInventory{
+		{Family: nftables.FamilyInet, Name: "filter", Chains: []ruleset.ChainInventory{
+			baseChain("input", "input", 0), baseChain("output", "output", 0),
+		}},
+		// an ip chain shares the hook with the ip half of an inet chain
+		{Family: nftables.FamilyIPv4, Name: "fw", Chains: []ruleset.ChainInventory{
+			baseChain("input", "input", 0), baseChain("output", "output", 10),
+		}},
+		{Family: nftables.FamilyIPv4, Name: "nat", Chains: []ruleset.ChainInventory{
+			{Name: "DOCKER"}, {Name: "KUBE-SERVICES"},
+		}},
+	}}
+
+	conflicts := detectConflicts(data)
+	var got []string
+	for _, c := range conflicts {
+		got = append(got, c.ID+": "+c.Message)
+		if c.Remediation == "" {
+			t.Errorf("%s has no remediation", c.ID)
+		}
+	}
+	want := []string{
+		"legacy-and-nft: rules are loaded in iptables-legacy (ip filter) and in nf_tables (3 tables) at the same time",
+		"firewalld-and-ufw: firewalld and ufw are both installed and will overwrite each other's rules",
+		"managed-chains: Docker chains are present in ip nat, iptables filter",
+		"managed-chains: kube-proxy chains are present in ip nat",
+		"same-priority: base chains inet filter input, ip fw input are hooked at input priority 0",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("conflicts\n%q\nwant\n%q", got, want)
+	}
+	if n := countConflicts(conflicts, severityCritical); n != 2 {
+		t.Errorf("%d critical conflicts", n)
+	}
+}
+
+func TestDetectConflictsNone(t *testing.T) {
+	var data nftablesStatusData
+	data.Iptables.LegacyTables = []string{"ip filter"}
+	data.Firewall.Type = "ufw"
+	// chains of one table at the same priority run in a defined order
+	data.Nftables.Ruleset = &ruleset.Inventory{}
+	if conflicts := detectConflicts(data); len(conflicts) != 0 {
+		t.Errorf("conflicts = %+v", conflicts)
+	}
+	data.Nftables.Ruleset.Tables = []ruleset.TableInventory{{Family: nftables.FamilyInet, Name: "filter", Chains: []ruleset.ChainInventory{
+		baseChain("input", "input", 0), baseChain("input2", "input", 0),
+	}}}
+	data.Iptables.LegacyTables = nil
+	if conflicts := detectConflicts(data); len(conflicts) != 0 {
+		t.Errorf("conflicts = %+v", conflicts)
+	}
+}
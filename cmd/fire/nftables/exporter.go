@@ -0,0 +1,246 @@
+package nftables
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+var (
+	exporterListen string
+	exporterPath   string
+
+	exporterCommand = &cobra.Command{
+		Use:   "exporter",
+		Short: "Serve nftables counters and status as Prometheus metrics",
+		Long: `Run an HTTP server exposing Prometheus text-format metrics:
+rule and named counters, quotas, set sizes, conntrack usage and the booleans of
+"fire nftables status". Every scrape reads the live ruleset.`,
+		Args: cobra.NoArgs,
+		RunE: runExporter,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(exporterCommand)
+	exporterCommand.Flags().StringVar(&exporterListen, "listen", ":9630", "Address to listen on")
+	exporterCommand.Flags().StringVar(&exporterPath, "path", "/metrics", "HTTP path serving the metrics")
+}
+
+func runExporter(cmd *cobra.Command, args []string) error {
+	mux := http.NewServeMux()
+	mux.Handle(exporterPath, newExporter(sys, func(sys system.System, list rulesetLister) nftablesStatusData {
+		return collectStatusDataWith(sys, sourceAuto, list)
+	}))
+	server := &http.Server{
+		Addr:              exporterListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      time.Minute,
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "serving metrics on %s%s\n", exporterListen, exporterPath)
+	return server.ListenAndServe()
+}
+
+// statusFunc collects the status of sys, taking the ruleset from list.
+type statusFunc func(sys system.System, list rulesetLister) nftablesStatusData
+
+type exporter struct {
+	sys    system.System
+	status statusFunc
+}
+
+func newExporter(sys system.System, status statusFunc) *exporter {
+	return &exporter{sys: sys, status: status}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	e.collect().writeTo(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (e *exporter) collect() *metricSet {
+	m := newMetricSet()
+
+	up := 0.0
+	rs, err := listRuleset(e.sys)
+	if err == nil {
+		up = 1
+		e.collectRuleset(m, rs)
+	}
+	m.gauge("fire_nftables_up", "Whether the last ruleset listing succeeded.", up, nil)
+
+	e.collectConntrack(m)
+	if e.status != nil {
+		// the status reuses the listing of this scrape
+		e.collectStatus(m, e.status(e.sys, func(system.System) (*ruleset.Ruleset, error) { return rs, err }))
+	}
+	return m
+}
+
+func (e *exporter) collectRuleset(m *metricSet, rs *ruleset.Ruleset) {
+	for _, rule := range rs.Rules {
+		packets, bytes, ok := ruleCounter(rule)
+		if !ok {
+			continue
+		}
+		labels := []string{
+			"family", string(rule.Family), "table", rule.Table, "chain", rule.Chain,
+			"handle", strconv.Itoa(rule.Handle), "comment", rule.Comment,
+		}
+		m.counter("fire_nftables_rule_packets_total", "Packets matched by a rule counter.", float64(packets), labels)
+		m.counter("fire_nftables_rule_bytes_total", "Bytes matched by a rule counter.", float64(bytes), labels)
+	}
+
+	for _, obj := range rs.Objects {
+		labels := []string{"family", string(obj.Family), "table", obj.Table, "name", obj.Name, "comment", obj.Comment}
+		switch obj.Kind {
+		case "counter":
+			m.counter("fire_nftables_counter_packets_total", "Packets of a named counter.", float64(obj.Packets), labels)
+			m.counter("fire_nftables_counter_bytes_total", "Bytes of a named counter.", float64(obj.Bytes), labels)
+		case "quota":
+			m.gauge("fire_nftables_quota_bytes", "Size of a named quota.", float64(obj.Bytes), labels)
+			m.gauge("fire_nftables_quota_used_bytes", "Bytes consumed from a named quota.", float64(obj.Used), labels)
+		}
+	}
+
+	for _, set := range rs.Sets {
+		kind := "set"
+		if set.IsMap() {
+			kind = "map"
+		}
+		m.gauge("fire_nftables_set_elements", "Number of elements in a named set or map.", float64(len(set.Elements)),
+			[]string{"family", string(set.Family), "table", set.Table, "name", set.Name, "kind", kind})
+	}
+}
+
+func (e *exporter) collectConntrack(m *metricSet) {
+	read := func(name string) (float64, bool) {
+		data, err := e.sys.ReadFile(procNetfilterSysctl + "/" + name)
+		if err != nil {
+			return 0, false
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		return v, err == nil
+	}
+	if v, ok := read("nf_conntrack_count"); ok {
+		m.gauge("fire_conntrack_entries", "Number of entries in the conntrack table.", v, nil)
+	}
+	if v, ok := read("nf_conntrack_max"); ok {
+		m.gauge("fire_conntrack_entries_max", "Maximum size of the conntrack table.", v, nil)
+	}
+}
+
+func (e *exporter) collectStatus(m *metricSet, data nftablesStatusData) {
+	m.gauge("fire_nftables_installed", "Whether nftables is available.", boolValue(data.Nftables.Installed), nil)
+	m.gauge("fire_ebtables_installed", "Whether ebtables is available.", boolValue(data.Ebtables.Installed), nil)
+	m.gauge("fire_arptables_installed", "Whether arptables is available.", boolValue(data.Arptables.Installed), nil)
+	m.gauge("fire_iptables_legacy_tables", "Number of tables loaded in the iptables-legacy backend.", float64(len(data.Iptables.LegacyTables)), nil)
+	m.gauge("fire_firewall_manager_installed", "Whether a firewall manager is installed.",
+		boolValue(data.Firewall.Type != "not installed"), []string{"type", data.Firewall.Type})
+	for _, s := range []severity{severityInfo, severityWarning, severityCritical} {
+		m.gauge("fire_status_conflicts", "Number of conflicts found by nftables status.",
+			float64(countConflicts(data.Conflicts, s)), []string{"severity", string(s)})
+	}
+}
+
+// ruleCounter returns the values of an anonymous counter statement in the rule,
+// references to named counters are reported through the named counter.
+func ruleCounter(rule ruleset.Rule) (packets, bytes uint64, ok bool) {
+	for _, raw := range rule.Expr {
+		var stmt struct {
+			Counter json.RawMessage `json:"counter"`
+		}
+		if json.Unmarshal(raw, &stmt) != nil || len(stmt.Counter) == 0 {
+			continue
+		}
+		var counter struct {
+			Packets uint64 `json:"packets"`
+			Bytes   uint64 `json:"bytes"`
+		}
+		if json.Unmarshal(stmt.Counter, &counter) != nil {
+			continue
+		}
+		return counter.Packets, counter.Bytes, true
+	}
+	return 0, 0, false
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// metricSet collects samples grouped by metric family, in the order they were first seen.
+type metricSet struct {
+	order    []string
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	help    string
+	typ     string
+	samples []string
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{families: make(map[string]*metricFamily)}
+}
+
+func (m *metricSet) counter(name, help string, value float64, labels []string) {
+	m.add(name, help, "counter", value, labels)
+}
+
+func (m *metricSet) gauge(name, help string, value float64, labels []string) {
+	m.add(name, help, "gauge", value, labels)
+}
+
+// add records one sample, labels are given as name, value pairs.
+func (m *metricSet) add(name, help, typ string, value float64, labels []string) {
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{help: help, typ: typ}
+		m.families[name] = family
+		m.order = append(m.order, name)
+	}
+	sample := name
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+		}
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	family.samples = append(family.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (m *metricSet) writeTo(w io.Writer) {
+	for _, name := range m.order {
+		family := m.families[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, family.typ)
+		for _, sample := range family.samples {
+			fmt.Fprintln(w, sample)
+		}
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
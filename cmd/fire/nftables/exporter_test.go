@@ -0,0 +1,106 @@
+package nftables
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+const exporterRuleset = `{"nftables":[
+{"metainfo":{"json_schema_version":1}},
+{"table":{"family":"inet","name":"filter","handle":1}},
+{"chain":{"family":"inet","table":"filter","name":"input","handle":1,"type":"filter","hook":"input","prio":0,"policy":"drop"}},
+{"rule":{"family":"inet","table":"filter","chain":"input","handle":4,"comment":"ssh \"admin\"","expr":[
+	{"match":{"op":"==","left":{"payload":{"protocol":"tcp","field":"dport"}},"right":22}},
+	{"counter":{"packets":12,"bytes":3456}},
+	{"accept":null}]}},
+{"rule":{"family":"inet","table":"filter","chain":"input","handle":5,"expr":[{"drop":null}]}},
+{"counter":{"family":"inet","table":"filter","name":"dropped","handle":6,"packets":7,"bytes":420}},
+{"quota":{"family":"inet","table":"filter","name":"monthly","handle":7,"bytes":1000,"used":250}},
+{"set":{"family":"inet","table":"filter","name":"blocked","handle":8,"type":"ipv4_addr","elem":["192.0.2.1","192.0.2.2"]}}
+]}`
+
+func scrape(t *testing.T, h http.Handler) (string, *http.Response) {
+	t.Helper()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body), resp
+}
+
+func TestExporter(t *testing.T) {
+	var c system.Cassette
+	c.AddRun(system.Command("nft", "-j", "list", "ruleset"), exporterRuleset)
+	c.AddFile(procNetfilterSysctl+"/nf_conntrack_count", "42\n")
+	c.AddFile(procNetfilterSysctl+"/nf_conntrack_max", "262144\n")
+
+	var data nftablesStatusData
+	data.Nftables.Installed = true
+	data.Firewall.Type = "not installed"
+	data.Conflicts = []conflict{{ID: "legacy", Severity: severityWarning}}
+
+	recorder := system.Record(system.Replay(c))
+	body, resp := scrape(t, newExporter(recorder, func(sys system.System, list rulesetLister) nftablesStatusData {
+		if sys != recorder {
+			t.Error("status collected from another system")
+		}
+		if rs, err := list(sys); err != nil || len(rs.Rules) != 2 {
+			t.Errorf("status got the ruleset %v, %v", rs, err)
+		}
+		return data
+	}))
+	// the status reuses the listing of the counters
+	var runs int
+	for _, entry := range recorder.Cassette().Entries {
+		if entry.Op == system.OpRun {
+			runs++
+		}
+	}
+	if runs != 1 {
+		t.Errorf("nft ran %d times in a scrape", runs)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, want := range []string{
+		"# TYPE fire_nftables_up gauge\nfire_nftables_up 1\n",
+		"# TYPE fire_nftables_rule_packets_total counter\n" +
+			`fire_nftables_rule_packets_total{family="inet",table="filter",chain="input",handle="4",comment="ssh \"admin\""} 12` + "\n",
+		`fire_nftables_rule_bytes_total{family="inet",table="filter",chain="input",handle="4",comment="ssh \"admin\""} 3456` + "\n",
+		`fire_nftables_counter_packets_total{family="inet",table="filter",name="dropped",comment=""} 7` + "\n",
+		`fire_nftables_quota_used_bytes{family="inet",table="filter",name="monthly",comment=""} 250` + "\n",
+		`fire_nftables_set_elements{family="inet",table="filter",name="blocked",kind="set"} 2` + "\n",
+		"fire_conntrack_entries 42\n",
+		"fire_conntrack_entries_max 262144\n",
+		"fire_nftables_installed 1\n",
+		`fire_firewall_manager_installed{type="not installed"} 0` + "\n",
+		`fire_status_conflicts{severity="warning"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics miss %q", want)
+		}
+	}
+	// only rules with a counter statement are exported
+	if strings.Contains(body, `handle="5"`) {
+		t.Error("metrics hold the rule without a counter")
+	}
+}
+
+func TestExporterWithoutNft(t *testing.T) {
+	body, _ := scrape(t, newExporter(system.Replay(system.Cassette{}), nil))
+	if body != "# HELP fire_nftables_up Whether the last ruleset listing succeeded.\n# TYPE fire_nftables_up gauge\nfire_nftables_up 0\n" {
+		t.Errorf("metrics without nft:\n%s", body)
+	}
+}
@@ -0,0 +1,171 @@
+package nftables
+
+import (
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables/forward"
+	"github.com/woshikedayaa/fire/common/output"
+)
+
+var (
+	forwardStatePath string
+	forwardDryRun    bool
+	forwardNew       forward.Forward
+	forwardSources   []string
+	forwardOutput    output.Options
+
+	forwardCommand = &cobra.Command{
+		Use:   "forward",
+		Short: "Manage DNAT port forwards",
+		Long: `Forward ports of this host to other hosts. The forwards are kept in a state
+file and loaded as the inet table ` + forward.DefaultTable + `, which is replaced atomically on
+every change: a dnat rule in prerouting, an accept of the forwarded
+connections in forward and, with --hairpin, the masquerade that lets hosts
+on other interfaces use the forward too. Deleting the last forward deletes
+the table.
+
+The forward accept cannot override a drop in another table; a firewall that
+drops forwarded traffic has to allow the connections itself.`,
+	}
+	forwardAddCommand = &cobra.Command{
+		Use:   "add <port[-port]> <addr[:port]>",
+		Short: "Add a port forward",
+		Example: `  fire nftables forward add 8080 192.168.1.10:80 --iif eth0 --hairpin
+  fire nftables forward add 60000-60100 192.168.1.20 --proto udp --source 203.0.113.0/24`,
+		Args: cobra.ExactArgs(2),
+		RunE: forwardAdd,
+	}
+	forwardDelCommand = &cobra.Command{
+		Use:     "del <name>...",
+		Aliases: []string{"delete", "rm"},
+		Short:   "Delete port forwards",
+		Args:    cobra.MinimumNArgs(1),
+		RunE:    forwardDel,
+	}
+	forwardListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "List the port forwards",
+		Args:  cobra.NoArgs,
+		RunE:  forwardList,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(forwardCommand)
+	forwardCommand.AddCommand(forwardAddCommand, forwardDelCommand, forwardListCommand)
+	forwardCommand.PersistentFlags().StringVar(&forwardStatePath, "state", forward.DefaultState, "File keeping the forwards")
+
+	flags := forwardAddCommand.Flags()
+	flags.StringVar(&forwardNew.Name, "name", "", "Name of the forward (default PROTOCOLS_PORT, e.g. tcp_8080)")
+	flags.StringSliceVar(&forwardNew.Protocols, "proto", []string{"tcp"}, "Protocols to forward: tcp, udp or both")
+	flags.StringSliceVar(&forwardSources, "source", nil, "Only forward connections from these addresses or prefixes")
+	flags.StringVar(&forwardNew.Interface, "iif", "", "Only forward connections arriving on this interface")
+	flags.BoolVar(&forwardNew.Hairpin, "hairpin", false, "Also forward connections from other interfaces to the addresses of this host, needs --iif")
+	flags.StringVar(&forwardNew.Comment, "comment", "", "Comment of the forward")
+	for _, cmd := range []*cobra.Command{forwardAddCommand, forwardDelCommand} {
+		cmd.Flags().BoolVar(&forwardDryRun, "dry-run", false, "Print the nft script instead of loading it and saving the state")
+	}
+	forwardOutput.Bind(forwardListCommand, output.FormatText)
+}
+
+func forwardAdd(cmd *cobra.Command, args []string) error {
+	f := forwardNew
+	f.Port = args[0]
+	if addrPort, err := netip.ParseAddrPort(args[1]); err == nil {
+		f.To, f.ToPort = addrPort.Addr(), strconv.Itoa(int(addrPort.Port()))
+	} else if f.To, err = netip.ParseAddr(args[1]); err != nil {
+		return E.New("invalid target ", strconv.Quote(args[1]), ", expected an address with an optional port")
+	}
+	for _, source := range forwardSources {
+		prefix, err := netip.ParsePrefix(source)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(source)
+			if addrErr != nil {
+				return E.New("invalid source ", strconv.Quote(source))
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		f.Sources = append(f.Sources, prefix)
+	}
+
+	state, err := forward.Load(forwardStatePath)
+	if err != nil {
+		return err
+	}
+	if err = state.Add(f); err != nil {
+		return err
+	}
+	return forwardApply(cmd, state)
+}
+
+func forwardDel(cmd *cobra.Command, args []string) error {
+	state, err := forward.Load(forwardStatePath)
+	if err != nil {
+		return err
+	}
+	if err = state.Remove(args...); err != nil {
+		return err
+	}
+	return forwardApply(cmd, state)
+}
+
+// forwardApply loads the table of state and saves the state once the kernel
+// took it.
+func forwardApply(cmd *cobra.Command, state *forward.State) error {
+	script, err := state.Compile()
+	if err != nil {
+		return err
+	}
+	if forwardDryRun {
+		_, err = io.WriteString(cmd.OutOrStdout(), script)
+		return err
+	}
+	if err = runNftScript([]byte(script), false); err != nil {
+		return E.When("load table inet "+state.Table, err)
+	}
+	return state.Save(forwardStatePath)
+}
+
+func forwardList(cmd *cobra.Command, args []string) error {
+	if err := forwardOutput.Validate(); err != nil {
+		return err
+	}
+	state, err := forward.Load(forwardStatePath)
+	if err != nil {
+		return err
+	}
+	return forwardOutput.Print(cmd, forwardTable(state.Forwards))
+}
+
+type forwardTable []forward.Forward
+
+func (forwards forwardTable) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(forwards))
+	for _, f := range forwards {
+		to := f.To.String()
+		if f.ToPort != "" {
+			if f.To.Is6() {
+				to = "[" + to + "]"
+			}
+			to += ":" + f.ToPort
+		}
+		sources := make([]string, 0, len(f.Sources))
+		for _, source := range f.Sources {
+			sources = append(sources, source.String())
+		}
+		iface := f.Interface
+		if f.Hairpin {
+			iface += " (hairpin)"
+		}
+		rows = append(rows, []string{
+			f.Name, strings.Join(f.Protocols, ","), f.Port, to,
+			strings.Join(sources, ","), iface, f.Comment,
+		})
+	}
+	return []string{"NAME", "PROTO", "PORT", "TO", "SOURCES", "INTERFACE", "COMMENT"}, rows
+}
@@ -0,0 +1,251 @@
+package nftables
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables"
+	"github.com/woshikedayaa/fire/common/nftables/monitor"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+// traceTable holds the rules "fire nftables monitor trace" inserts.
+const traceTable = "fire_trace"
+
+var (
+	monitorOutput   output.Options
+	monitorActions  []string
+	monitorKinds    []string
+	monitorFamilies []string
+	monitorTables   []string
+	monitorChains   []string
+	traceHooks      []string
+
+	monitorCommand = &cobra.Command{
+		Use:   "monitor",
+		Short: "Stream ruleset changes and trace events",
+		Long: `Run nft -j monitor and print every table, chain, rule, set, element and
+trace event as it happens. The json format prints one object per line.`,
+		Args: cobra.NoArgs,
+		RunE: runMonitor,
+	}
+	monitorTraceCommand = &cobra.Command{
+		Use:   "trace SELECTOR...",
+		Short: "Trace the packets matching a selector",
+		Long: `Add "SELECTOR meta nftrace set 1" to the table inet ` + traceTable + ` and print the
+trace events of the matching packets. The table is removed on exit.
+
+  fire nftables monitor trace ip saddr 192.0.2.1 tcp dport 22`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runMonitorTrace,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(monitorCommand)
+	monitorCommand.AddCommand(monitorTraceCommand)
+	monitorOutput.Bind(monitorCommand, output.FormatText)
+	monitorOutput.Bind(monitorTraceCommand, output.FormatText)
+	flags := monitorCommand.PersistentFlags()
+	flags.StringSliceVar(&monitorActions, "action", nil, "Only show these actions: add, delete, replace, trace")
+	flags.StringSliceVar(&monitorKinds, "kind", nil, "Only show these objects: table, chain, rule, set, map, element, flowtable, object, trace")
+	flags.StringSliceVar(&monitorFamilies, "family", nil, "Only show events of these families")
+	flags.StringSliceVar(&monitorTables, "table", nil, "Only show events of these tables")
+	flags.StringSliceVar(&monitorChains, "chain", nil, "Only show events of these chains")
+	monitorTraceCommand.Flags().StringSliceVar(&traceHooks, "hook", []string{"prerouting", "output"}, "Hooks to mark packets at")
+}
+
+func monitorFilter() monitor.Filter {
+	var filter monitor.Filter
+	for _, v := range monitorActions {
+		filter.Actions = append(filter.Actions, monitor.Action(v))
+	}
+	for _, v := range monitorKinds {
+		filter.Kinds = append(filter.Kinds, monitor.Kind(v))
+	}
+	for _, v := range monitorFamilies {
+		filter.Families = append(filter.Families, nftables.Family(v))
+	}
+	filter.Tables, filter.Chains = monitorTables, monitorChains
+	return filter
+}
+
+func runMonitor(cmd *cobra.Command, args []string) error {
+	stream, err := monitorOutput.Stream(cmd)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	cmd.SilenceUsage = true
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return streamEvents(ctx, stream, cmd.ErrOrStderr(), nil, monitorFilter())
+}
+
+func runMonitorTrace(cmd *cobra.Command, args []string) error {
+	for _, hook := range traceHooks {
+		if !slices.Contains([]string{"prerouting", "input", "forward", "output", "postrouting"}, hook) {
+			return E.New("unsupported hook: ", hook)
+		}
+	}
+	stream, err := monitorOutput.Stream(cmd)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	filter := monitorFilter()
+	filter.Kinds = []monitor.Kind{monitor.KindTrace}
+	cmd.SilenceUsage = true
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err = installTrace(strings.Join(args, " "), traceHooks); err != nil {
+		return err
+	}
+	defer func() {
+		if err := removeTrace(); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "remove table inet %s: %s\n", traceTable, err)
+		}
+	}()
+	fmt.Fprintf(cmd.ErrOrStderr(), "tracing %q, press Ctrl-C to stop\n", strings.Join(args, " "))
+	return streamEvents(ctx, stream, cmd.ErrOrStderr(), []string{"trace"}, filter)
+}
+
+// installTrace (re)creates the trace table. Its chains run before the raw
+// priority so even notrack rules show up in the trace.
+func installTrace(selector string, hooks []string) error {
+	var script strings.Builder
+	// declaring the table first makes the delete work when it does not exist yet
+	fmt.Fprintf(&script, "table inet %s\ndelete table inet %s\n", traceTable, traceTable)
+	fmt.Fprintf(&script, "table inet %s {\n", traceTable)
+	for _, hook := range hooks {
+		fmt.Fprintf(&script, "\tchain %s {\n\t\ttype filter hook %s priority -301; policy accept;\n\t\t%s meta nftrace set 1\n\t}\n",
+			hook, hook, selector)
+	}
+	script.WriteString("}\n")
+	return runNftScript([]byte(script.String()), false)
+}
+
+func removeTrace() error {
+	_, _, err := sys.Run(system.Command("nft", "delete", "table", "inet", traceTable))
+	return err
+}
+
+// streamEvents runs nft -j monitor with args and prints the events passing filter.
+func streamEvents(ctx context.Context, stream *output.Stream, errOut io.Writer, args []string, filter monitor.Filter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := sys.Stream(ctx, system.Command("nft", append([]string{"-j", "monitor"}, args...)...), writer)
+		_ = writer.Close()
+		done <- err
+	}()
+
+	decoder := monitor.NewDecoder(reader)
+	for {
+		event, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		var lineErr *E.PositionError
+		if E.As(err, &lineErr) {
+			fmt.Fprintln(errOut, "skip event:", err)
+			continue
+		}
+		if err != nil {
+			cancel()
+			_ = reader.CloseWithError(err)
+			<-done
+			return E.When("read nft monitor", err)
+		}
+		if !filter.Match(event) {
+			continue
+		}
+		if err = stream.Write(monitorEvent{event}); err != nil {
+			cancel()
+			_ = reader.CloseWithError(err)
+			<-done
+			return err
+		}
+	}
+	if err := <-done; err != nil && !E.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// monitorEvent prints an event on one line in the text format.
+type monitorEvent struct {
+	*monitor.Event
+}
+
+func (e monitorEvent) RenderText(w io.Writer) error {
+	return writeEventText(w, e.Event)
+}
+
+func writeEventText(w io.Writer, e *monitor.Event) error {
+	if t := e.Trace; t != nil {
+		line := fmt.Sprintf("trace %08x %s %s %s %s", t.ID, t.Family, t.Table, t.Chain, t.Type)
+		switch t.Type {
+		case monitor.TracePacket:
+			keys := make([]string, 0, len(t.Packet))
+			for key := range t.Packet {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if t.Iif != "" {
+				line += " iif " + t.Iif
+			}
+			if t.Oif != "" {
+				line += " oif " + t.Oif
+			}
+			for _, key := range keys {
+				line += fmt.Sprintf(" %s=%v", key, t.Packet[key])
+			}
+		case monitor.TraceRule:
+			line += fmt.Sprintf(" handle %d verdict %s", t.Handle, t.Verdict)
+			if t.JumpTarget != "" {
+				line += " " + t.JumpTarget
+			}
+		case monitor.TracePolicy:
+			line += " " + t.Policy
+		}
+		_, err := fmt.Fprintln(w, line)
+		return err
+	}
+
+	line := fmt.Sprintf("%s %s %s %s", e.Action, e.Kind, e.Family, e.Table)
+	switch {
+	case e.Chain != "":
+		line += " " + e.Chain
+	case e.Name != "":
+		line += " " + e.Name
+	}
+	switch {
+	case e.Rule != nil:
+		line += fmt.Sprintf(" handle %d", e.Rule.Handle)
+		if e.Rule.Comment != "" {
+			line += fmt.Sprintf(" comment %q", e.Rule.Comment)
+		}
+	case e.Element != nil:
+		elements := make([]string, 0, len(e.Element.Elements))
+		for _, raw := range e.Element.Elements {
+			elements = append(elements, string(raw))
+		}
+		line += " { " + strings.Join(elements, ", ") + " }"
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
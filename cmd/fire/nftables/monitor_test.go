@@ -0,0 +1,55 @@
+package nftables
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/woshikedayaa/fire/common/nftables/monitor"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+const monitorEvents = `{"add": {"table": {"family": "inet", "name": "filter", "handle": 3}}}
+{"add": {"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 7, "expr": [{"accept": null}]}}}
+`
+
+func TestStreamEvents(t *testing.T) {
+	var c system.Cassette
+	c.Entries = append(c.Entries, system.Entry{Op: system.OpStream, Cmd: &system.Cmd{Name: "nft", Args: []string{"-j", "monitor"}}, Stdout: []byte(monitorEvents)})
+	old := sys
+	sys = system.Replay(c)
+	t.Cleanup(func() { sys = old })
+
+	stream := func(format output.Format) string {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.SetOut(&out)
+		s, err := output.Options{Format: format}.Stream(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = streamEvents(context.Background(), s, &out, nil, monitor.Filter{}); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if got, want := stream(output.FormatText), "add table inet filter\nadd rule inet filter input handle 7\n"; got != want {
+		t.Errorf("text:\n%s\nwant:\n%s", got, want)
+	}
+	// json is one object per line
+	lines := strings.Split(strings.TrimSuffix(stream(output.FormatJSON), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("json lines:\n%s", strings.Join(lines, "\n"))
+	}
+	for _, line := range lines {
+		var event monitor.Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil || event.Action != "add" || event.Family != "inet" {
+			t.Errorf("json line %s: %+v, %v", line, event, err)
+		}
+	}
+}
@@ -1,12 +1,85 @@
 package nftables
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+var (
+	// sys is the machine every nftables command inspects and changes
+	sys system.System = system.Local()
+
+	sysRoot   string
+	sysNetns  string
+	sysRecord string
+	sysReplay string
+	recorder  *system.Recorder
 )
 
 var MainCommand = &cobra.Command{
-	Use:   "nftables",
-	Short: "nftables utility",
-	Long:  `Manage and inspect nftables firewall system`,
-	RunE:  nil,
+	Use:               "nftables",
+	Short:             "nftables utility",
+	Long:              `Manage and inspect nftables firewall system`,
+	RunE:              nil,
+	PersistentPreRunE: setupSystem,
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		return saveRecording()
+	},
+}
+
+func init() {
+	flags := MainCommand.PersistentFlags()
+	flags.StringVar(&sysRoot, "root", "", "Inspect the root filesystem at this path, e.g. /proc/PID/root of a container; its network state is read with --netns instead")
+	flags.StringVar(&sysNetns, "netns", "", "Run in the network namespace at this path, e.g. /run/netns/NAME")
+	flags.StringVar(&sysRecord, "record", "", "Record every command and file read to this cassette")
+	flags.StringVar(&sysReplay, "replay", "", "Serve commands and files from this cassette instead of the system")
+	_ = flags.MarkHidden("record")
+	_ = flags.MarkHidden("replay")
+}
+
+func setupSystem(cmd *cobra.Command, args []string) error {
+	switch {
+	case sysRoot != "" && sysNetns != "":
+		return E.New("--root and --netns cannot be combined")
+	case sysReplay != "" && (sysRoot != "" || sysNetns != ""):
+		return E.New("--replay cannot be combined with --root or --netns")
+	case sysReplay != "":
+		file, err := os.Open(sysReplay)
+		if err != nil {
+			return E.When("open cassette", err)
+		}
+		defer file.Close()
+		cassette, err := system.LoadCassette(file)
+		if err != nil {
+			return err
+		}
+		sys = system.Replay(cassette)
+	case sysRoot != "":
+		sys = system.Chroot(sysRoot)
+	case sysNetns != "":
+		sys = system.Netns(sysNetns, system.Local())
+	}
+	if sysRecord != "" {
+		recorder = system.Record(sys)
+		sys = recorder
+	}
+	return nil
+}
+
+func saveRecording() error {
+	if recorder == nil {
+		return nil
+	}
+	file, err := os.Create(sysRecord)
+	if err != nil {
+		return E.When("create cassette", err)
+	}
+	if err = recorder.Cassette().Save(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
 }
@@ -0,0 +1,177 @@
+package nftables
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+	"github.com/woshikedayaa/fire/common/nftables/simulate"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+var (
+	simulateRuleset   string
+	simulateFamily    string
+	simulateSrc       string
+	simulateDst       string
+	simulateDirection string
+	simulateExpect    string
+	simulatePacket    simulate.Packet
+	simulateOutput    output.Options
+
+	simulateCommand = &cobra.Command{
+		Use:   "simulate",
+		Short: "Show what the ruleset does with a packet",
+		Long: `Walk a synthetic packet through the base chains of every hook it passes, in
+priority order, and print the matching rules and the verdict. The ruleset is
+read from --ruleset (nft -j list ruleset output) or from the running system;
+no packet is sent and nothing is changed.
+
+  fire nftables simulate --iif eth0 --src 203.0.113.9 --dst 192.0.2.10 --dport 22 --expect accept`,
+		Args: cobra.NoArgs,
+		RunE: runSimulate,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(simulateCommand)
+	flags := simulateCommand.Flags()
+	flags.StringVar(&simulateRuleset, "ruleset", "", "Read the nft JSON ruleset from this file, - for stdin, instead of the system")
+	flags.StringVar(&simulateFamily, "family", "", "Packet family: ip, ip6 (default from the addresses)")
+	flags.StringVar(&simulatePacket.Iif, "iif", "", "Input interface name")
+	flags.StringVar(&simulatePacket.Oif, "oif", "", "Output interface name")
+	flags.StringVar(&simulateSrc, "src", "", "Source address")
+	flags.StringVar(&simulateDst, "dst", "", "Destination address")
+	flags.StringVar(&simulatePacket.Proto, "proto", "tcp", "Layer 4 protocol, name or number")
+	flags.Uint16Var(&simulatePacket.Sport, "sport", 40000, "Source port")
+	flags.Uint16Var(&simulatePacket.Dport, "dport", 0, "Destination port")
+	flags.StringVar(&simulatePacket.CtState, "ct-state", "new", "Conntrack state: new, established, related, invalid, untracked")
+	flags.Uint32Var(&simulatePacket.Mark, "mark", 0, "Packet mark")
+	flags.StringVar(&simulateDirection, "direction", "", "input, forward or output (default from --iif and --oif)")
+	flags.StringVar(&simulateExpect, "expect", "", "Exit non-zero unless the verdict is accept or drop")
+	simulateOutput.Bind(simulateCommand, output.FormatText)
+	_ = simulateCommand.MarkFlagRequired("src")
+	_ = simulateCommand.MarkFlagRequired("dst")
+}
+
+func runSimulate(cmd *cobra.Command, args []string) error {
+	if err := simulateOutput.Validate(); err != nil {
+		return err
+	}
+	switch simulateExpect {
+	case "", "accept", "drop":
+	default:
+		return E.New("--expect must be accept or drop")
+	}
+	packet := simulatePacket
+	var err error
+	if packet.Src, err = netip.ParseAddr(simulateSrc); err != nil {
+		return E.When("parse --src", err)
+	}
+	if packet.Dst, err = netip.ParseAddr(simulateDst); err != nil {
+		return E.When("parse --dst", err)
+	}
+	switch family := nftables.Family(simulateFamily); family {
+	case nftables.FamilyUnspecified, nftables.FamilyIPv4, nftables.FamilyIPv6:
+		packet.Family = family
+	default:
+		return E.New("unsupported family: ", simulateFamily)
+	}
+	if err = packet.Normalize(); err != nil {
+		return err
+	}
+	direction := packet.Direction()
+	switch simulateDirection {
+	case "":
+	case string(simulate.DirectionInput), string(simulate.DirectionForward), string(simulate.DirectionOutput):
+		direction = simulate.Direction(simulateDirection)
+	default:
+		return E.New("unsupported direction: ", simulateDirection)
+	}
+
+	rs, err := loadSimulateRuleset(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+	result := simulate.Simulate(rs, packet, direction)
+	if err = simulateOutput.Print(cmd, simulation{result}); err != nil {
+		return err
+	}
+	if simulateExpect != "" && result.Verdict != simulateExpect {
+		cmd.SilenceUsage = true
+		return E.New("expected ", simulateExpect, ", got ", result.Verdict)
+	}
+	return nil
+}
+
+func loadSimulateRuleset(stdin io.Reader) (*ruleset.Ruleset, error) {
+	switch simulateRuleset {
+	case "":
+		out, err := system.Output(sys, "nft", "-j", "list", "ruleset")
+		if err != nil {
+			return nil, E.When("list ruleset", err)
+		}
+		return ruleset.Parse(bytes.NewReader(out))
+	case "-":
+		return ruleset.Parse(stdin)
+	}
+	file, err := os.Open(simulateRuleset)
+	if err != nil {
+		return nil, E.When("open ruleset", err)
+	}
+	defer file.Close()
+	return ruleset.Parse(file)
+}
+
+type simulation struct {
+	*simulate.Result
+}
+
+// RenderText implements output.Text.
+func (s simulation) RenderText(w io.Writer) error {
+	fmt.Fprintf(w, "packet: %s (%s)\n", s.Packet.String(), s.Direction)
+	hook := ""
+	for _, step := range s.Steps {
+		if step.Hook != hook {
+			hook = step.Hook
+			fmt.Fprintf(w, "%s:\n", hook)
+		}
+		indent := strings.Repeat("  ", step.Depth+1)
+		switch step.Kind {
+		case simulate.StepChain:
+			if step.Depth == 0 {
+				fmt.Fprintf(w, "%schain %s %s %s (priority %d)\n", indent, step.Family, step.Table, step.Chain, step.Priority)
+			} else {
+				fmt.Fprintf(w, "%schain %s\n", indent, step.Chain)
+			}
+		case simulate.StepRule:
+			fmt.Fprintf(w, "%s  [%d] %s", indent, step.Handle, step.Rule)
+			if step.Verdict != "" && !strings.HasSuffix(step.Rule, step.Verdict) {
+				fmt.Fprintf(w, "  => %s", step.Verdict)
+			}
+			fmt.Fprintln(w)
+		case simulate.StepPolicy:
+			fmt.Fprintf(w, "%s  policy %s\n", indent, step.Verdict)
+		}
+	}
+	if s.Translated != nil {
+		fmt.Fprintf(w, "translated: %s\n", s.Translated.String())
+	}
+	for _, note := range s.Notes {
+		fmt.Fprintf(w, "note: %s\n", note)
+	}
+	verdict := "verdict: " + s.Verdict
+	if s.Decided != "" {
+		verdict += " by " + s.Decided
+	}
+	_, err := fmt.Fprintln(w, verdict)
+	return err
+}
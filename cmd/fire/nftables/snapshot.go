@@ -0,0 +1,266 @@
+package nftables
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/woshikedayaa/fire/cmd/fire"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables/snapshot"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+const defaultSnapshotStore = "/var/lib/fire/snapshots"
+
+var (
+	snapshotStoreDir  string
+	snapshotMessage   string
+	snapshotRetention snapshot.Retention
+	snapshotShowJSON  bool
+	snapshotCheckOnly bool
+	snapshotNoBackup  bool
+	snapshotDryRun    bool
+	snapshotOutput    output.Options
+
+	snapshotCommand = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Keep a local history of the nftables ruleset",
+		Long: `Capture the full ruleset (nft JSON and text) into a versioned local store,
+inspect earlier snapshots and restore any of them atomically.`,
+	}
+	snapshotSaveCommand = &cobra.Command{
+		Use:   "save",
+		Short: "Save the current ruleset as a new snapshot",
+		Args:  cobra.NoArgs,
+		RunE:  snapshotSave,
+	}
+	snapshotListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "List snapshots, oldest first",
+		Args:  cobra.NoArgs,
+		RunE:  snapshotList,
+	}
+	snapshotShowCommand = &cobra.Command{
+		Use:   "show <id|sequence|latest>",
+		Short: "Print the ruleset stored in a snapshot",
+		Args:  cobra.ExactArgs(1),
+		RunE:  snapshotShow,
+	}
+	snapshotRestoreCommand = &cobra.Command{
+		Use:   "restore <id|sequence|latest>",
+		Short: "Atomically replace the current ruleset with a snapshot",
+		Args:  cobra.ExactArgs(1),
+		RunE:  snapshotRestore,
+	}
+	snapshotPruneCommand = &cobra.Command{
+		Use:   "prune",
+		Short: "Remove snapshots according to a retention policy",
+		Args:  cobra.NoArgs,
+		RunE:  snapshotPrune,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(snapshotCommand)
+	snapshotCommand.AddCommand(
+		snapshotSaveCommand,
+		snapshotListCommand,
+		snapshotShowCommand,
+		snapshotRestoreCommand,
+		snapshotPruneCommand,
+	)
+	snapshotCommand.PersistentFlags().StringVar(&snapshotStoreDir, "store", defaultSnapshotStore, "Snapshot store directory")
+
+	snapshotSaveCommand.Flags().StringVarP(&snapshotMessage, "message", "m", "", "Message describing the snapshot")
+	for _, cmd := range []*cobra.Command{snapshotSaveCommand, snapshotPruneCommand} {
+		cmd.Flags().IntVar(&snapshotRetention.KeepLast, "keep-last", 0, "Keep the newest N snapshots")
+		cmd.Flags().DurationVar(&snapshotRetention.KeepWithin, "keep-within", 0, "Keep snapshots younger than the duration, e.g. 720h")
+		cmd.Flags().IntVar(&snapshotRetention.KeepDaily, "keep-daily", 0, "Keep the newest snapshot of each of the last N days")
+	}
+	snapshotPruneCommand.Flags().BoolVar(&snapshotDryRun, "dry-run", false, "Only print the snapshots that would be removed")
+
+	snapshotShowCommand.Flags().BoolVar(&snapshotShowJSON, "json", false, "Print the stored nft JSON ruleset as is, ignoring --format")
+	for _, cmd := range []*cobra.Command{snapshotSaveCommand, snapshotListCommand, snapshotShowCommand, snapshotPruneCommand} {
+		snapshotOutput.Bind(cmd, output.FormatText)
+	}
+
+	snapshotRestoreCommand.Flags().BoolVar(&snapshotCheckOnly, "check", false, "Only check that the snapshot can be loaded, do not apply it")
+	snapshotRestoreCommand.Flags().BoolVar(&snapshotNoBackup, "no-backup", false, "Do not snapshot the current ruleset before restoring")
+}
+
+func snapshotSave(cmd *cobra.Command, args []string) error {
+	store, err := snapshot.Open(snapshotStoreDir)
+	if err != nil {
+		return err
+	}
+	meta, err := saveRulesetSnapshot(store, snapshotMessage)
+	if err != nil {
+		return err
+	}
+	if !snapshotRetention.IsZero() {
+		if _, err = store.Prune(snapshotRetention, time.Now(), false); err != nil {
+			return err
+		}
+	}
+	return snapshotOutput.Print(cmd, snapshotMetas{meta})
+}
+
+func snapshotList(cmd *cobra.Command, args []string) error {
+	store, err := snapshot.Open(snapshotStoreDir)
+	if err != nil {
+		return err
+	}
+	metas, err := store.List()
+	if err != nil {
+		return err
+	}
+	return snapshotOutput.Print(cmd, snapshotMetas(metas))
+}
+
+func snapshotShow(cmd *cobra.Command, args []string) error {
+	store, err := snapshot.Open(snapshotStoreDir)
+	if err != nil {
+		return err
+	}
+	snap, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+	if snapshotShowJSON {
+		_, err = cmd.OutOrStdout().Write(snap.JSON)
+		return err
+	}
+	return snapshotOutput.Print(cmd, snapshotView{
+		Meta:    snap.Meta,
+		Ruleset: json.RawMessage(snap.JSON),
+		text:    snap.Text,
+	})
+}
+
+func snapshotRestore(cmd *cobra.Command, args []string) error {
+	store, err := snapshot.Open(snapshotStoreDir)
+	if err != nil {
+		return err
+	}
+	snap, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+
+	// "flush ruleset" and the snapshot are loaded by nft as a single
+	// transaction, so the kernel either gets the whole snapshot or nothing.
+	script := new(bytes.Buffer)
+	script.WriteString("flush ruleset\n")
+	script.Write(snap.Text)
+
+	if snapshotCheckOnly {
+		return runNftScript(script.Bytes(), true)
+	}
+	if !snapshotNoBackup {
+		backup, err := saveRulesetSnapshot(store, "before restore of "+snap.ID)
+		if err != nil {
+			return E.When("backup current ruleset", err)
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "current ruleset saved as %s\n", backup.ID)
+	}
+	if err = runNftScript(script.Bytes(), false); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "restored %s\n", snap.ID)
+	return nil
+}
+
+func snapshotPrune(cmd *cobra.Command, args []string) error {
+	if snapshotRetention.IsZero() {
+		return E.New("no retention policy given, see --keep-last, --keep-within and --keep-daily")
+	}
+	store, err := snapshot.Open(snapshotStoreDir)
+	if err != nil {
+		return err
+	}
+	removed, err := store.Prune(snapshotRetention, time.Now(), snapshotDryRun)
+	if err != nil {
+		return err
+	}
+	return snapshotOutput.Print(cmd, snapshotMetas(removed))
+}
+
+type snapshotMetas []snapshot.Meta
+
+func (metas snapshotMetas) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(metas))
+	for _, meta := range metas {
+		rows = append(rows, []string{
+			strconv.FormatUint(meta.Sequence, 10), meta.ID, meta.Time.Local().Format(time.DateTime),
+			meta.Hostname, meta.Version, meta.Message,
+		})
+	}
+	return []string{"SEQ", "ID", "TIME", "HOSTNAME", "VERSION", "MESSAGE"}, rows
+}
+
+type snapshotView struct {
+	Meta    snapshot.Meta   `json:"meta"`
+	Ruleset json.RawMessage `json:"ruleset"`
+
+	text []byte
+}
+
+// RenderText prints the text ruleset behind a comment header, so the output can be fed to nft -f.
+func (v snapshotView) RenderText(w io.Writer) error {
+	fmt.Fprintf(w, "# id: %s\n", v.Meta.ID)
+	fmt.Fprintf(w, "# time: %s\n", v.Meta.Time.Local().Format(time.RFC3339))
+	fmt.Fprintf(w, "# hostname: %s\n", v.Meta.Hostname)
+	fmt.Fprintf(w, "# version: %s\n", v.Meta.Version)
+	if v.Meta.Message != "" {
+		fmt.Fprintf(w, "# message: %s\n", v.Meta.Message)
+	}
+	_, err := w.Write(v.text)
+	return err
+}
+
+func saveRulesetSnapshot(store *snapshot.Store, message string) (snapshot.Meta, error) {
+	jsonRuleset, err := system.Output(sys, "nft", "-j", "list", "ruleset")
+	if err != nil {
+		return snapshot.Meta{}, E.When("nft -j list ruleset", err)
+	}
+	textRuleset, err := system.Output(sys, "nft", "list", "ruleset")
+	if err != nil {
+		return snapshot.Meta{}, E.When("nft list ruleset", err)
+	}
+	hostname, _ := os.Hostname()
+
+	return store.Save(snapshot.Snapshot{
+		Meta: snapshot.Meta{
+			Time:     time.Now(),
+			Hostname: hostname,
+			Version:  fireVersion(),
+			Message:  message,
+		},
+		JSON: jsonRuleset,
+		Text: textRuleset,
+	})
+}
+
+// runNftScript feeds script to nft on stdin, nft applies it as one transaction.
+func runNftScript(script []byte, check bool) error {
+	args := []string{"-f", "-"}
+	if check {
+		args = append([]string{"-c"}, args...)
+	}
+	_, _, err := sys.Run(system.Command("nft", args...).WithStdin(script))
+	return err
+}
+
+func fireVersion() string {
+	if fire.Version == "" {
+		return "unknown"
+	}
+	return fire.Version
+}
@@ -0,0 +1,66 @@
+package nftables
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/woshikedayaa/fire/common/nftables/snapshot"
+)
+
+// fakeNft puts an nft on PATH that logs its arguments and stdin and lists a
+// small ruleset.
+func fakeNft(t *testing.T) (dir, log string) {
+	t.Helper()
+	dir = t.TempDir()
+	log = filepath.Join(dir, "log")
+	script := `#!/bin/sh
+echo "nft $*" >> "` + log + `"
+case "$*" in
+"-j list ruleset") echo '{"nftables":[]}' ;;
+"list ruleset") echo 'table inet current {'; echo '}' ;;
+*) cat >> "` + log + `" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "nft"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir, log
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	_, log := fakeNft(t)
+	snapshotStoreDir = t.TempDir()
+	store, err := snapshot.Open(snapshotStoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := store.Save(snapshot.Snapshot{
+		Meta: snapshot.Meta{Time: time.Now()},
+		JSON: []byte(`{"nftables":[]}`),
+		Text: []byte("table inet saved {\n}\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotCheckOnly, snapshotNoBackup = false, false
+	if err = snapshotRestore(snapshotRestoreCommand, []string{saved.ID}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the current ruleset is saved first, the snapshot is loaded in one transaction after a flush
+	want := "nft -j list ruleset\nnft list ruleset\nnft -f -\nflush ruleset\ntable inet saved {\n}\n"
+	if string(got) != want {
+		t.Errorf("nft calls:\n%s\nwant:\n%s", got, want)
+	}
+	backup, err := store.Get("latest")
+	if err != nil || string(backup.Text) != "table inet current {\n}\n" || backup.Message != "before restore of "+saved.ID {
+		t.Errorf("backup = %+v, %v", backup, err)
+	}
+}
@@ -1,20 +1,26 @@
 package nftables
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
 	"fmt"
-	"os"
-	"os/exec"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"text/template"
 
 	"github.com/spf13/cobra"
+	"github.com/woshikedayaa/fire/common/iptables"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/system"
 )
 
 var (
-	outputFormat string
+	statusOutput output.Options
 	filterTmpl   string
+	statusSource string
+	statusStrict bool
 
 	nftablesStatusCommand = &cobra.Command{
 		Use:   "status",
@@ -22,37 +28,56 @@ var (
 		Long: `Check the status of system firewall implementations including:
 - nftables installation and version
 - iptables implementation type (legacy/nft)
-- other firewall components status`,
+- other firewall components status
+- conflicts between them, with a remediation hint for each
+
+With --source=kernel the status is read from /proc and /sys only, so no
+nft, iptables, ebtables or arptables binaries are needed. The default
+--source=auto falls back to the kernel for every tool that is missing.`,
 		RunE: nftablesStatus,
 	}
 )
 
 func init() {
 	MainCommand.AddCommand(nftablesStatusCommand)
-	nftablesStatusCommand.Flags().StringVar(&outputFormat, "format", "test", "Output format: text, json")
+	statusOutput.Bind(nftablesStatusCommand, output.FormatText)
 	nftablesStatusCommand.Flags().StringVar(&filterTmpl, "filter", "", "Go template filter for output")
+	_ = nftablesStatusCommand.Flags().MarkDeprecated("filter", "use --format go-template --template instead")
+	nftablesStatusCommand.Flags().StringVar(&statusSource, "source", string(sourceAuto), "Where to collect status from: auto, exec, kernel")
+	nftablesStatusCommand.Flags().BoolVar(&statusStrict, "strict", false, "Exit non-zero when a warning or critical conflict is found")
 }
 
 func nftablesStatus(cmd *cobra.Command, args []string) error {
-	data := collectStatusData()
+	mode := collectMode(statusSource)
+	if !mode.Valid() {
+		return fmt.Errorf("unsupported source: %s", statusSource)
+	}
 	if filterTmpl != "" {
-		tmpl, err := template.New("filter").Parse(filterTmpl)
-		if err != nil {
-			return fmt.Errorf("invalid template: %v", err)
+		statusOutput.Format, statusOutput.Template = output.FormatTemplate, filterTmpl
+	}
+	if err := statusOutput.Validate(); err != nil {
+		return err
+	}
+	data := collectStatusData(sys, mode)
+	if err := statusOutput.Print(cmd, data); err != nil {
+		return err
+	}
+	if statusStrict {
+		if n := countConflicts(data.Conflicts, severityWarning, severityCritical); n > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d conflicts found", n)
 		}
-		return tmpl.Execute(os.Stdout, data)
 	}
+	return nil
+}
 
-	// output
-	switch outputFormat {
-	case "json":
-		return json.NewEncoder(os.Stdout).Encode(data)
-	case "text":
-		renderText(data)
-		return nil
-	default:
-		return fmt.Errorf("unsupported format: %s", outputFormat)
-	}
+// StatusData is what "fire nftables status" reports, for use by other commands.
+type StatusData = nftablesStatusData
+
+// CollectStatus collects StatusData, preferring the userspace tools and falling
+// back to the kernel for each missing one.
+func CollectStatus() StatusData {
+	return collectStatusData(sys, sourceAuto)
 }
 
 type nftablesStatusData struct {
@@ -61,33 +86,64 @@ type nftablesStatusData struct {
 		Version   string `json:"version"`
 		Backend   string `json:"backend,omitempty"`  // nf_tables backend info
 		Features  string `json:"features,omitempty"` // supported features
+		Source    string `json:"source"`
+		// Sources tells where each field was collected from, by its JSON name
+		Sources fieldSources `json:"sources,omitempty"`
+
+		Ruleset      *ruleset.Inventory `json:"ruleset,omitempty"`
+		RulesetError string             `json:"ruleset_error,omitempty"`
 	} `json:"nftables"`
 	Iptables struct {
-		Type    string   `json:"type"`
-		Version string   `json:"version"`
-		Tables  []string `json:"tables,omitempty"` // loaded tables
-		Chains  []string `json:"chains,omitempty"` // default chain
+		Type       string       `json:"type"`
+		Version    string       `json:"version"`
+		Tables     []string     `json:"tables,omitempty"`      // loaded tables
+		IPv6Tables []string     `json:"ipv6_tables,omitempty"` // loaded ip6 tables, kernel source only
+		Chains     []string     `json:"chains,omitempty"`      // default chain
+		Source     string       `json:"source"`
+		Sources    fieldSources `json:"sources,omitempty"`
+
+		// IPv4 and IPv6 are the iptables-save and ip6tables-save dumps, exec source only
+		IPv4 *xtablesRuleset `json:"ipv4,omitempty"`
+		IPv6 *xtablesRuleset `json:"ipv6,omitempty"`
+
+		// LegacyTables are the x_tables tables loaded in the kernel, as "ip filter" or "ip6 nat"
+		LegacyTables []string `json:"legacy_tables,omitempty"`
 	} `json:"iptables"`
 	Ebtables struct {
-		Installed bool   `json:"installed"`
-		Version   string `json:"version"`
-		Type      string `json:"type"` // legacy/nft backend
+		Installed bool         `json:"installed"`
+		Version   string       `json:"version"`
+		Type      string       `json:"type"` // legacy/nft backend
+		Source    string       `json:"source"`
+		Sources   fieldSources `json:"sources,omitempty"`
+
+		Ruleset *xtablesRuleset `json:"ruleset,omitempty"`
 	} `json:"ebtables"`
 	Arptables struct {
-		Installed bool   `json:"installed"`
-		Version   string `json:"version"`
-		Type      string `json:"type"` // legacy/nft backend
+		Installed bool         `json:"installed"`
+		Version   string       `json:"version"`
+		Type      string       `json:"type"` // legacy/nft backend
+		Tables    []string     `json:"tables,omitempty"`
+		Source    string       `json:"source"`
+		Sources   fieldSources `json:"sources,omitempty"`
+
+		Ruleset *xtablesRuleset `json:"ruleset,omitempty"`
 	} `json:"arptables"`
-	KernelModules []string `json:"kernel_modules"`
-	Firewall      struct {
-		Type    string `json:"type"`
-		Version string `json:"version"`
+	KernelModules       []string `json:"kernel_modules"`
+	KernelModulesSource string   `json:"kernel_modules_source"`
+	Firewall            struct {
+		Type    string       `json:"type"`
+		Version string       `json:"version"`
+		Source  string       `json:"source"`
+		Sources fieldSources `json:"sources,omitempty"`
 	} `json:"firewall"`
+	Conntrack conntrackStatus `json:"conntrack"`
+	Sysctls   []sysctlValue   `json:"sysctls,omitempty"`
+	Conflicts []conflict      `json:"conflicts"`
 }
 
-func getFirewallType() (string, string) {
-	hasFirewalld := checkCommand("firewalld")
-	hasUfw := checkCommand("ufw")
+func getFirewallType(sys system.System) (string, string, string) {
+	hasFirewalld := checkCommand(sys, "firewalld")
+	hasUfw := checkCommand(sys, "ufw")
 
 	var fwType string
 	var version string
@@ -95,93 +151,117 @@ func getFirewallType() (string, string) {
 	switch {
 	case hasFirewalld && hasUfw:
 		fwType = "firewalld and ufw"
-		version = getCommandVersion("firewalld")
+		version = getCommandVersion(sys, "firewalld")
 	case hasFirewalld:
 		fwType = "firewalld"
-		version = getCommandVersion("firewalld")
+		version = getCommandVersion(sys, "firewalld")
 	case hasUfw:
 		fwType = "ufw"
-		version = getCommandVersion("ufw")
+		version = getCommandVersion(sys, "ufw")
 	default:
 		fwType = "not installed"
 	}
 
-	return fwType, version
+	return fwType, version, execSource("firewalld", "ufw")
 }
 
-func getLoadedKernelModules() []string {
-	file, err := os.Open("/proc/modules")
-	if err != nil {
-		return nil
-	}
-	defer file.Close()
-
-	var modules []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
-		if len(fields) > 0 {
-			moduleName := fields[0]
-			if strings.HasPrefix(moduleName, "nf_") ||
-				strings.HasPrefix(moduleName, "nft_") ||
-				strings.HasPrefix(moduleName, "ipt_") ||
-				strings.HasPrefix(moduleName, "iptable_") ||
-				strings.HasPrefix(moduleName, "ip6t_") ||
-				strings.HasPrefix(moduleName, "ip6table_") ||
-				strings.HasPrefix(moduleName, "ebt_") ||
-				strings.HasPrefix(moduleName, "ebtable_") ||
-				strings.HasPrefix(moduleName, "arpt_") ||
-				strings.HasPrefix(moduleName, "arptable_") ||
-				strings.Contains(moduleName, "netfilter") {
-				modules = append(modules, moduleName)
-			}
+func isNetfilterModule(moduleName string) bool {
+	return strings.HasPrefix(moduleName, "nf_") ||
+		strings.HasPrefix(moduleName, "nft_") ||
+		strings.HasPrefix(moduleName, "ipt_") ||
+		strings.HasPrefix(moduleName, "iptable_") ||
+		strings.HasPrefix(moduleName, "ip6t_") ||
+		strings.HasPrefix(moduleName, "ip6table_") ||
+		strings.HasPrefix(moduleName, "ebt_") ||
+		strings.HasPrefix(moduleName, "ebtable_") ||
+		strings.HasPrefix(moduleName, "arpt_") ||
+		strings.HasPrefix(moduleName, "arptable_") ||
+		strings.Contains(moduleName, "netfilter")
+}
+
+func getIptablesInfo(sys system.System) (string, string, *xtablesRuleset, *xtablesRuleset) {
+	implType := getIptablesType(sys)
+	version := getCommandVersion(sys, "iptables")
+	return implType, version, getXtablesRuleset(sys, "iptables-save"), getXtablesRuleset(sys, "ip6tables-save")
+}
+
+// getLegacyTablesExec lists the tables iptables-legacy-save and
+// ip6tables-legacy-save dump, as getLegacyTables does from the kernel.
+func getLegacyTablesExec(sys system.System) []string {
+	var tables []string
+	for family, command := range map[string]string{"ip": "iptables-legacy-save", "ip6": "ip6tables-legacy-save"} {
+		for _, name := range getXtablesRuleset(sys, command).tableNames() {
+			tables = append(tables, family+" "+name)
 		}
 	}
+	slices.Sort(tables)
+	return tables
+}
 
-	return modules
+// xtablesRuleset is the per-table summary of an iptables-save style dump.
+type xtablesRuleset struct {
+	Tables []iptables.TableInventory `json:"tables"`
+	Error  string                    `json:"error,omitempty"`
 }
 
-func getIptablesInfo() (string, string, []string, []string) {
-	implType := getIptablesType()
-	version := getCommandVersion("iptables")
+// getXtablesRuleset dumps the ruleset with counters. Not every save command
+// knows -c, those are asked again without it.
+func getXtablesRuleset(sys system.System, command string) *xtablesRuleset {
+	if !checkCommand(sys, command) {
+		return nil
+	}
+	out, err := system.Output(sys, command, "-c")
+	if err != nil {
+		out, err = system.Output(sys, command)
+	}
+	if err != nil {
+		return &xtablesRuleset{Error: err.Error()}
+	}
+	tables, err := iptables.Parse(bytes.NewReader(out))
+	if err != nil {
+		return &xtablesRuleset{Error: err.Error()}
+	}
+	return &xtablesRuleset{Tables: iptables.Inventory(tables)}
+}
 
-	var tables, chains []string
+func (rs *xtablesRuleset) tableNames() []string {
+	if rs == nil {
+		return nil
+	}
+	var names []string
+	for _, table := range rs.Tables {
+		names = append(names, table.Name)
+	}
+	return names
+}
 
-	cmd := exec.Command("iptables-save", "-c")
-	out, err := cmd.CombinedOutput()
-	if err == nil {
-		scanner := bufio.NewScanner(strings.NewReader(string(out)))
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.HasPrefix(line, "*") {
-				tables = append(tables, strings.TrimPrefix(line, "*"))
-			} else if strings.HasPrefix(line, ":") {
-				chain := strings.Fields(line)[0][1:]
-				chains = append(chains, chain)
-			}
+func (rs *xtablesRuleset) chainNames() []string {
+	if rs == nil {
+		return nil
+	}
+	var names []string
+	for _, table := range rs.Tables {
+		for _, chain := range table.Chains {
+			names = append(names, chain.Name)
 		}
 	}
-
-	return implType, version, tables, chains
+	return names
 }
 
-func getNftablesInfo() (bool, string, string, string) {
-	if !checkCommand("nft") {
+func getNftablesInfo(sys system.System) (bool, string, string, string) {
+	if !checkCommand(sys, "nft") {
 		return false, "", "", ""
 	}
 
-	version := getCommandVersion("nft")
+	version := getCommandVersion(sys, "nft")
 
-	cmd := exec.Command("nft", "--check")
-	out, _ := cmd.CombinedOutput()
+	out, _ := system.CombinedOutput(sys, "nft", "--check")
 	backend := ""
 	if strings.Contains(string(out), "nf_tables") {
 		backend = "nf_tables"
 	}
 
-	cmd = exec.Command("nft", "-v")
-	out, _ = cmd.CombinedOutput()
+	out, _ = system.CombinedOutput(sys, "nft", "-v")
 	features := ""
 	if strings.Contains(string(out), "debug") {
 		features = "debug support"
@@ -190,16 +270,27 @@ func getNftablesInfo() (bool, string, string, string) {
 	return true, version, backend, features
 }
 
-func getEbtablesInfo() (bool, string, string) {
-	if !checkCommand("ebtables") {
+// rulesetLister lists the nftables ruleset of a system.
+type rulesetLister func(system.System) (*ruleset.Ruleset, error)
+
+// listRuleset lists the whole ruleset with `nft -j`.
+func listRuleset(sys system.System) (*ruleset.Ruleset, error) {
+	out, err := system.Output(sys, "nft", "-j", "list", "ruleset")
+	if err != nil {
+		return nil, err
+	}
+	return ruleset.Parse(bytes.NewReader(out))
+}
+
+func getEbtablesInfo(sys system.System) (bool, string, string) {
+	if !checkCommand(sys, "ebtables") {
 		return false, "", ""
 	}
 
-	version := getCommandVersion("ebtables")
+	version := getCommandVersion(sys, "ebtables")
 
 	implType := "legacy"
-	cmd := exec.Command("ebtables", "--version")
-	out, _ := cmd.CombinedOutput()
+	out, _ := system.CombinedOutput(sys, "ebtables", "--version")
 	if strings.Contains(string(out), "nf_tables") {
 		implType = "nf_tables"
 	}
@@ -207,16 +298,15 @@ func getEbtablesInfo() (bool, string, string) {
 	return true, version, implType
 }
 
-func getArptablesInfo() (bool, string, string) {
-	if !checkCommand("arptables") {
+func getArptablesInfo(sys system.System) (bool, string, string) {
+	if !checkCommand(sys, "arptables") {
 		return false, "", ""
 	}
 
-	version := getCommandVersion("arptables")
+	version := getCommandVersion(sys, "arptables")
 
 	implType := "legacy"
-	cmd := exec.Command("arptables", "--version")
-	out, _ := cmd.CombinedOutput()
+	out, _ := system.CombinedOutput(sys, "arptables", "--version")
 	if strings.Contains(string(out), "nf_tables") {
 		implType = "nf_tables"
 	}
@@ -224,37 +314,110 @@ func getArptablesInfo() (bool, string, string) {
 	return true, version, implType
 }
 
-func collectStatusData() nftablesStatusData {
+func collectStatusData(sys system.System, mode collectMode) nftablesStatusData {
+	return collectStatusDataWith(sys, mode, listRuleset)
+}
+
+// collectStatusDataWith takes the nftables ruleset from list, for callers
+// that have listed it already.
+func collectStatusDataWith(sys system.System, mode collectMode, list rulesetLister) nftablesStatusData {
 	var data nftablesStatusData
+	loaded, loadedErr := getLoadedModules(sys)
 
 	// nftables
-	data.Nftables.Installed, data.Nftables.Version, data.Nftables.Backend, data.Nftables.Features = getNftablesInfo()
+	if mode.useExec(sys, "nft") {
+		data.Nftables.Installed, data.Nftables.Version, data.Nftables.Backend, data.Nftables.Features = getNftablesInfo(sys)
+		data.Nftables.Source = execSource("nft")
+		data.Nftables.Sources.set(data.Nftables.Source, "installed", "version", "backend", "features")
+		if data.Nftables.Installed {
+			rs, err := list(sys)
+			if err != nil {
+				data.Nftables.RulesetError = err.Error()
+			} else {
+				data.Nftables.Ruleset = rs.Inventory()
+			}
+			data.Nftables.Sources.set(data.Nftables.Source, "ruleset", "ruleset_error")
+		}
+	} else {
+		data.Nftables.Installed, data.Nftables.Backend, data.Nftables.Source = getKernelNftablesInfo(sys, loaded)
+		data.Nftables.Sources.set(data.Nftables.Source, "installed", "backend")
+	}
 
 	// iptables
-	data.Iptables.Type, data.Iptables.Version, data.Iptables.Tables, data.Iptables.Chains = getIptablesInfo()
+	if mode.useExec(sys, "iptables") {
+		data.Iptables.Type, data.Iptables.Version, data.Iptables.IPv4, data.Iptables.IPv6 = getIptablesInfo(sys)
+		data.Iptables.Tables, data.Iptables.Chains = data.Iptables.IPv4.tableNames(), data.Iptables.IPv4.chainNames()
+		data.Iptables.IPv6Tables = data.Iptables.IPv6.tableNames()
+		data.Iptables.Source = execSource("iptables", "iptables-save", "ip6tables-save")
+		data.Iptables.Sources.set(execSource("iptables"), "type", "version")
+		data.Iptables.Sources.set(execSource("iptables-save"), "tables", "chains", "ipv4")
+		data.Iptables.Sources.set(execSource("ip6tables-save"), "ipv6_tables", "ipv6")
+	} else {
+		data.Iptables.Type, data.Iptables.Tables, data.Iptables.IPv6Tables = getKernelIptablesInfo(sys, loaded)
+		data.Iptables.Source = kernelSource(procIPTablesNames, procIP6TablesNames)
+		data.Iptables.Sources.set(kernelSource(procIPTablesNames, procIP6TablesNames, filepath.Join(sysModule, "nft_compat"), procModules), "type")
+		data.Iptables.Sources.set(kernelSource(procIPTablesNames), "tables")
+		data.Iptables.Sources.set(kernelSource(procIP6TablesNames), "ipv6_tables")
+	}
+	if mode.useExec(sys, "iptables-legacy-save", "ip6tables-legacy-save") {
+		data.Iptables.LegacyTables = getLegacyTablesExec(sys)
+		data.Iptables.Sources.set(execSource("iptables-legacy-save", "ip6tables-legacy-save"), "legacy_tables")
+	} else {
+		data.Iptables.LegacyTables = getLegacyTables(sys)
+		data.Iptables.Sources.set(kernelSource(procIPTablesNames, procIP6TablesNames), "legacy_tables")
+	}
 
 	// ebtables
-	data.Ebtables.Installed, data.Ebtables.Version, data.Ebtables.Type = getEbtablesInfo()
+	if mode.useExec(sys, "ebtables") {
+		data.Ebtables.Installed, data.Ebtables.Version, data.Ebtables.Type = getEbtablesInfo(sys)
+		data.Ebtables.Ruleset = getXtablesRuleset(sys, "ebtables-save")
+		data.Ebtables.Source = execSource("ebtables", "ebtables-save")
+		data.Ebtables.Sources.set(execSource("ebtables"), "installed", "version", "type")
+		data.Ebtables.Sources.set(execSource("ebtables-save"), "ruleset")
+	} else {
+		data.Ebtables.Installed, data.Ebtables.Type, data.Ebtables.Source = getKernelEbtablesInfo(sys, loaded)
+		data.Ebtables.Sources.set(data.Ebtables.Source, "installed", "type")
+	}
 
 	// arptables
-	data.Arptables.Installed, data.Arptables.Version, data.Arptables.Type = getArptablesInfo()
+	if mode.useExec(sys, "arptables") {
+		data.Arptables.Installed, data.Arptables.Version, data.Arptables.Type = getArptablesInfo(sys)
+		data.Arptables.Ruleset = getXtablesRuleset(sys, "arptables-save")
+		data.Arptables.Tables = data.Arptables.Ruleset.tableNames()
+		data.Arptables.Source = execSource("arptables", "arptables-save")
+		data.Arptables.Sources.set(execSource("arptables"), "installed", "version", "type")
+		data.Arptables.Sources.set(execSource("arptables-save"), "tables", "ruleset")
+	} else {
+		data.Arptables.Installed, data.Arptables.Type, data.Arptables.Tables, data.Arptables.Source = getKernelArptablesInfo(sys, loaded)
+		data.Arptables.Sources.set(data.Arptables.Source, "installed", "type")
+		data.Arptables.Sources.set(kernelSource(procARPTablesNames), "tables")
+	}
+
+	data.KernelModules, data.KernelModulesSource = getKernelModules(sys, loaded, loadedErr)
+
+	if mode.useExec(sys, "firewalld", "ufw") {
+		data.Firewall.Type, data.Firewall.Version, data.Firewall.Source = getFirewallType(sys)
+		data.Firewall.Sources.set(data.Firewall.Source, "type", "version")
+	} else {
+		data.Firewall.Type, data.Firewall.Source = getFirewallTypeFromFiles(sys)
+		data.Firewall.Sources.set(data.Firewall.Source, "type")
+	}
 
-	data.KernelModules = getLoadedKernelModules()
-	data.Firewall.Type, data.Firewall.Version = getFirewallType()
+	data.Conntrack = getConntrackStatus(sys)
+	data.Sysctls = getFirewallSysctls(sys)
 
+	data.Conflicts = detectConflicts(data)
 	return data
 }
 
 // checkCommand checks if a command exists in PATH
-func checkCommand(name string) bool {
-	_, err := exec.LookPath(name)
-	return err == nil
+func checkCommand(sys system.System, name string) bool {
+	return system.Exists(sys, name)
 }
 
 // getCommandVersion attempts to get the version of a command
-func getCommandVersion(name string) string {
-	cmd := exec.Command(name, "--version")
-	out, err := cmd.CombinedOutput()
+func getCommandVersion(sys system.System, name string) string {
+	out, err := system.CombinedOutput(sys, name, "--version")
 	if err != nil {
 		return "unknown"
 	}
@@ -262,7 +425,7 @@ func getCommandVersion(name string) string {
 }
 
 // getIptablesType determines the iptables implementation type
-func getIptablesType() string {
+func getIptablesType(sys system.System) string {
 	paths := []string{
 		"/usr/sbin/iptables-legacy",
 		"/usr/sbin/iptables-nft",
@@ -272,7 +435,7 @@ func getIptablesType() string {
 
 	var legacyExists, nftExists bool
 	for _, path := range paths {
-		if _, err := os.Stat(path); err == nil {
+		if _, err := sys.Stat(path); err == nil {
 			if strings.Contains(path, "legacy") {
 				legacyExists = true
 			}
@@ -283,8 +446,7 @@ func getIptablesType() string {
 	}
 
 	// Check which implementation is currently active
-	cmd := exec.Command("iptables", "-V")
-	out, err := cmd.CombinedOutput()
+	out, err := system.CombinedOutput(sys, "iptables", "-V")
 	if err == nil {
 		output := strings.ToLower(string(out))
 		if strings.Contains(output, "nf_tables") {
@@ -307,7 +469,33 @@ func getIptablesType() string {
 		return "not found"
 	}
 }
-func renderText(data nftablesStatusData) {
+
+// Table implements output.Table with one summary row per component.
+func (data nftablesStatusData) Table() ([]string, [][]string) {
+	installed := func(b bool) string {
+		if b {
+			return "installed"
+		}
+		return "not installed"
+	}
+	conntrack := "not available"
+	if data.Conntrack.Available {
+		conntrack = fmt.Sprintf("%d/%d (%.1f%%)", data.Conntrack.Count, data.Conntrack.Max, data.Conntrack.Usage)
+	}
+	rows := [][]string{
+		{"nftables", installed(data.Nftables.Installed), data.Nftables.Version, data.Nftables.Source},
+		{"iptables", data.Iptables.Type, data.Iptables.Version, data.Iptables.Source},
+		{"ebtables", installed(data.Ebtables.Installed), data.Ebtables.Version, data.Ebtables.Source},
+		{"arptables", installed(data.Arptables.Installed), data.Arptables.Version, data.Arptables.Source},
+		{"firewall", data.Firewall.Type, data.Firewall.Version, data.Firewall.Source},
+		{"conntrack", conntrack, "", data.Conntrack.Source},
+		{"conflicts", strconv.Itoa(len(data.Conflicts)), "", ""},
+	}
+	return []string{"COMPONENT", "STATUS", "VERSION", "SOURCE"}, rows
+}
+
+// RenderText implements output.Text.
+func (data nftablesStatusData) RenderText(w io.Writer) error {
 	nftStatus := "not installed"
 	if data.Nftables.Installed {
 		nftStatus = fmt.Sprintf("installed (version: %s)", data.Nftables.Version)
@@ -318,41 +506,186 @@ func renderText(data nftablesStatusData) {
 			nftStatus += fmt.Sprintf(", features: %s", data.Nftables.Features)
 		}
 	}
-	fmt.Printf("nftables\t: %s\n", nftStatus)
+	fmt.Fprintf(w, "nftables\t: %s [%s]\n", nftStatus, data.Nftables.Source)
+	if data.Nftables.RulesetError != "" {
+		fmt.Fprintf(w, "ruleset\t\t: error: %s\n", data.Nftables.RulesetError)
+	} else if data.Nftables.Ruleset != nil {
+		renderRulesetInventory(w, data.Nftables.Ruleset)
+	}
 
 	iptStatus := data.Iptables.Type
 	if iptStatus != "not installed" {
-		iptStatus = fmt.Sprintf("%s (version: %s)", iptStatus, data.Iptables.Version)
+		if data.Iptables.Version != "" {
+			iptStatus = fmt.Sprintf("%s (version: %s)", iptStatus, data.Iptables.Version)
+		}
 		if len(data.Iptables.Tables) > 0 {
 			iptStatus += fmt.Sprintf(", tables: %s", strings.Join(data.Iptables.Tables, ","))
 		}
+		if len(data.Iptables.IPv6Tables) > 0 {
+			iptStatus += fmt.Sprintf(", ip6 tables: %s", strings.Join(data.Iptables.IPv6Tables, ","))
+		}
 	}
-	fmt.Printf("iptables\t: %s\n", iptStatus)
+	fmt.Fprintf(w, "iptables\t: %s [%s]\n", iptStatus, data.Iptables.Source)
+	renderXtablesRuleset(w, "ipv4", data.Iptables.IPv4)
+	renderXtablesRuleset(w, "ipv6", data.Iptables.IPv6)
 
 	ebtStatus := "not installed"
 	if data.Ebtables.Installed {
-		ebtStatus = fmt.Sprintf("installed (version: %s, type: %s)",
-			data.Ebtables.Version, data.Ebtables.Type)
+		ebtStatus = fmt.Sprintf("installed (type: %s)", data.Ebtables.Type)
+		if data.Ebtables.Version != "" {
+			ebtStatus = fmt.Sprintf("installed (version: %s, type: %s)",
+				data.Ebtables.Version, data.Ebtables.Type)
+		}
 	}
-	fmt.Printf("ebtables\t: %s\n", ebtStatus)
+	fmt.Fprintf(w, "ebtables\t: %s [%s]\n", ebtStatus, data.Ebtables.Source)
+	renderXtablesRuleset(w, "bridge", data.Ebtables.Ruleset)
 
 	arpStatus := "not installed"
 	if data.Arptables.Installed {
-		arpStatus = fmt.Sprintf("installed (version: %s, type: %s)",
-			data.Arptables.Version, data.Arptables.Type)
+		arpStatus = fmt.Sprintf("installed (type: %s)", data.Arptables.Type)
+		if data.Arptables.Version != "" {
+			arpStatus = fmt.Sprintf("installed (version: %s, type: %s)",
+				data.Arptables.Version, data.Arptables.Type)
+		}
+		if len(data.Arptables.Tables) > 0 {
+			arpStatus += fmt.Sprintf(", tables: %s", strings.Join(data.Arptables.Tables, ","))
+		}
 	}
-	fmt.Printf("arptables\t: %s\n", arpStatus)
+	fmt.Fprintf(w, "arptables\t: %s [%s]\n", arpStatus, data.Arptables.Source)
+	renderXtablesRuleset(w, "arp", data.Arptables.Ruleset)
 
-	fmt.Print("kernel_modules\t: ")
+	fmt.Fprint(w, "kernel_modules\t: ")
 	if len(data.KernelModules) > 0 {
-		fmt.Println(strings.Join(data.KernelModules, ", "))
+		fmt.Fprint(w, strings.Join(data.KernelModules, ", "))
 	} else {
-		fmt.Println("none")
+		fmt.Fprint(w, "none")
 	}
+	fmt.Fprintf(w, " [%s]\n", data.KernelModulesSource)
 
 	fwStatus := data.Firewall.Type
 	if fwStatus != "not installed" && data.Firewall.Version != "" {
 		fwStatus = fmt.Sprintf("%s (version: %s)", fwStatus, data.Firewall.Version)
 	}
-	fmt.Printf("firewall\t: %s\n", fwStatus)
+	fmt.Fprintf(w, "firewall\t: %s [%s]\n", fwStatus, data.Firewall.Source)
+
+	ctStatus := "not available"
+	if data.Conntrack.Available {
+		ctStatus = fmt.Sprintf("%d/%d (%.1f%%), buckets %d, drop %d, early_drop %d, insert_failed %d",
+			data.Conntrack.Count, data.Conntrack.Max, data.Conntrack.Usage, data.Conntrack.Buckets,
+			data.Conntrack.Drop, data.Conntrack.EarlyDrop, data.Conntrack.InsertFailed)
+	}
+	fmt.Fprintf(w, "conntrack\t: %s [%s]\n", ctStatus, data.Conntrack.Source)
+	for _, cpu := range data.Conntrack.PerCPU {
+		if cpu.Drop > 0 || cpu.EarlyDrop > 0 || cpu.InsertFailed > 0 {
+			fmt.Fprintf(w, "  cpu%d: drop %d, early_drop %d, insert_failed %d\n", cpu.CPU, cpu.Drop, cpu.EarlyDrop, cpu.InsertFailed)
+		}
+	}
+	for _, f := range data.Conntrack.Findings {
+		fmt.Fprintf(w, "  [%s] %s\n", f.Severity, f.Message)
+	}
+
+	if len(data.Sysctls) > 0 {
+		fmt.Fprintln(w, "sysctl\t\t:")
+		for _, v := range data.Sysctls {
+			fmt.Fprintf(w, "  %s = %s\n", v.Key, v.Value)
+			if v.Note != "" {
+				fmt.Fprintf(w, "    [%s] %s\n", v.Severity, v.Note)
+			}
+		}
+	}
+
+	fmt.Fprint(w, "conflicts\t: ")
+	if len(data.Conflicts) == 0 {
+		fmt.Fprintln(w, "none")
+		return nil
+	}
+	fmt.Fprintln(w, len(data.Conflicts))
+	for _, c := range data.Conflicts {
+		fmt.Fprintf(w, "  [%s] %s\n", c.Severity, c.Message)
+		fmt.Fprintf(w, "    fix: %s\n", c.Remediation)
+	}
+	return nil
+}
+
+func renderRulesetInventory(w io.Writer, inv *ruleset.Inventory) {
+	if len(inv.Tables) == 0 {
+		fmt.Fprintln(w, "ruleset\t\t: empty")
+		return
+	}
+	families := inv.Families()
+	counts := make([]string, 0, len(families))
+	for _, table := range inv.Tables {
+		if n, ok := families[table.Family]; ok {
+			counts = append(counts, fmt.Sprintf("%s: %d", table.Family, n))
+			delete(families, table.Family)
+		}
+	}
+	fmt.Fprintf(w, "ruleset\t\t: %d tables (%s)\n", len(inv.Tables), strings.Join(counts, ", "))
+
+	for _, table := range inv.Tables {
+		fmt.Fprintf(w, "  table %s %s\n", table.Family, table.Name)
+		for _, chain := range table.Chains {
+			line := fmt.Sprintf("    chain %s", chain.Name)
+			if chain.Hook != "" {
+				line += fmt.Sprintf(" (type %s hook %s", chain.Type, chain.Hook)
+				if chain.Priority != nil {
+					line += fmt.Sprintf(" priority %d", *chain.Priority)
+				}
+				if chain.Policy != "" {
+					line += fmt.Sprintf(" policy %s", chain.Policy)
+				}
+				line += ")"
+			}
+			fmt.Fprintf(w, "%s: %d rules\n", line, chain.Rules)
+		}
+		for _, set := range table.Sets {
+			kind, typ := "set", strings.Join(set.Type, " . ")
+			if len(set.Map) > 0 {
+				kind, typ = "map", typ+" : "+strings.Join(set.Map, " . ")
+			}
+			line := fmt.Sprintf("    %s %s (type %s", kind, set.Name, typ)
+			if len(set.Flags) > 0 {
+				line += fmt.Sprintf(" flags %s", strings.Join(set.Flags, ","))
+			}
+			fmt.Fprintf(w, "%s): %d elements\n", line, set.Elements)
+		}
+		for _, ft := range table.Flowtables {
+			line := fmt.Sprintf("    flowtable %s (hook %s", ft.Name, ft.Hook)
+			if ft.Priority != nil {
+				line += fmt.Sprintf(" priority %d", *ft.Priority)
+			}
+			fmt.Fprintf(w, "%s): devices %s\n", line, strings.Join(ft.Devices, ","))
+		}
+		for _, obj := range table.Objects {
+			fmt.Fprintf(w, "    %s %s\n", obj.Kind, obj.Name)
+		}
+	}
+}
+
+func renderXtablesRuleset(w io.Writer, family string, rs *xtablesRuleset) {
+	if rs == nil {
+		return
+	}
+	if rs.Error != "" {
+		fmt.Fprintf(w, "  %s: error: %s\n", family, rs.Error)
+		return
+	}
+	if len(rs.Tables) == 0 {
+		fmt.Fprintf(w, "  %s: no tables\n", family)
+		return
+	}
+	for _, table := range rs.Tables {
+		fmt.Fprintf(w, "  %s table %s\n", family, table.Name)
+		for _, chain := range table.Chains {
+			line := fmt.Sprintf("    chain %s", chain.Name)
+			if chain.Policy != "" {
+				line += fmt.Sprintf(" (policy %s, %d packets, %d bytes)", chain.Policy, chain.Packets, chain.Bytes)
+			}
+			line += fmt.Sprintf(": %d rules (%d packets, %d bytes)", chain.Rules, chain.RulePackets, chain.RuleBytes)
+			if len(chain.Targets) > 0 {
+				line += fmt.Sprintf(", targets %s", strings.Join(chain.Targets, ","))
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
 }
@@ -0,0 +1,213 @@
+package nftables
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+const (
+	procConntrackStat = "/proc/net/stat/nf_conntrack"
+	procSysNet        = "/proc/sys/net"
+)
+
+type conntrackStatus struct {
+	Available    bool               `json:"available"`
+	Count        uint64             `json:"count"`
+	Max          uint64             `json:"max"`
+	Buckets      uint64             `json:"buckets"`
+	Usage        float64            `json:"usage"` // Count/Max in percent
+	Drop         uint64             `json:"drop"`
+	EarlyDrop    uint64             `json:"early_drop"`
+	InsertFailed uint64             `json:"insert_failed"`
+	PerCPU       []conntrackCPUStat `json:"per_cpu,omitempty"`
+	Findings     []finding          `json:"findings,omitempty"`
+	Source       string             `json:"source"`
+}
+
+type conntrackCPUStat struct {
+	CPU          int    `json:"cpu"`
+	Found        uint64 `json:"found"`
+	Invalid      uint64 `json:"invalid"`
+	Drop         uint64 `json:"drop"`
+	EarlyDrop    uint64 `json:"early_drop"`
+	InsertFailed uint64 `json:"insert_failed"`
+}
+
+type sysctlValue struct {
+	Key      string   `json:"key"`
+	Value    string   `json:"value"`
+	Severity severity `json:"severity,omitempty"`
+	Note     string   `json:"note,omitempty"`
+}
+
+type finding struct {
+	Severity severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func getConntrackStatus(sys system.System) conntrackStatus {
+	var ct conntrackStatus
+	ct.Source = kernelSource(procNetfilterSysctl, procConntrackStat)
+
+	var err error
+	if ct.Max, err = readUintFile(sys, filepath.Join(procNetfilterSysctl, "nf_conntrack_max")); err != nil {
+		return ct
+	}
+	ct.Available = true
+	ct.Count, _ = readUintFile(sys, filepath.Join(procNetfilterSysctl, "nf_conntrack_count"))
+	ct.Buckets, _ = readUintFile(sys, filepath.Join(procNetfilterSysctl, "nf_conntrack_buckets"))
+	if ct.Max > 0 {
+		ct.Usage = float64(ct.Count) * 100 / float64(ct.Max)
+	}
+	if data, err := sys.ReadFile(procConntrackStat); err == nil {
+		ct.PerCPU = parseConntrackStat(data)
+	}
+	for _, cpu := range ct.PerCPU {
+		ct.Drop += cpu.Drop
+		ct.EarlyDrop += cpu.EarlyDrop
+		ct.InsertFailed += cpu.InsertFailed
+	}
+	ct.Findings = conntrackFindings(ct)
+	return ct
+}
+
+func conntrackFindings(ct conntrackStatus) []finding {
+	var findings []finding
+	switch {
+	case ct.Usage >= 90:
+		findings = append(findings, finding{severityCritical,
+			fmt.Sprintf("conntrack table is %.1f%% full, new connections will be dropped; raise net.netfilter.nf_conntrack_max", ct.Usage)})
+	case ct.Usage >= 75:
+		findings = append(findings, finding{severityWarning,
+			fmt.Sprintf("conntrack table is %.1f%% full; consider raising net.netfilter.nf_conntrack_max", ct.Usage)})
+	}
+	if ct.Buckets > 0 && ct.Max/ct.Buckets > 8 {
+		findings = append(findings, finding{severityWarning,
+			fmt.Sprintf("nf_conntrack_max is %d times nf_conntrack_buckets, lookups walk long hash chains; raise the hashsize", ct.Max/ct.Buckets)})
+	}
+	if ct.Drop > 0 || ct.EarlyDrop > 0 {
+		findings = append(findings, finding{severityWarning,
+			fmt.Sprintf("conntrack dropped packets because the table was full (drop %d, early_drop %d)", ct.Drop, ct.EarlyDrop)})
+	}
+	if ct.InsertFailed > 0 {
+		findings = append(findings, finding{severityWarning,
+			fmt.Sprintf("conntrack failed to insert %d entries, usually clashing UDP/DNS flows", ct.InsertFailed)})
+	}
+	return findings
+}
+
+// parseConntrackStat parses /proc/net/stat/nf_conntrack: a header line, then one line of hex counters per CPU.
+func parseConntrackStat(data []byte) []conntrackCPUStat {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() {
+		return nil
+	}
+	header := strings.Fields(scanner.Text())
+	var stats []conntrackCPUStat
+	for cpu := 0; scanner.Scan(); cpu++ {
+		fields := strings.Fields(scanner.Text())
+		value := func(name string) uint64 {
+			i := slices.Index(header, name)
+			if i < 0 || i >= len(fields) {
+				return 0
+			}
+			v, _ := strconv.ParseUint(fields[i], 16, 64)
+			return v
+		}
+		stats = append(stats, conntrackCPUStat{
+			CPU:          cpu,
+			Found:        value("found"),
+			Invalid:      value("invalid"),
+			Drop:         value("drop"),
+			EarlyDrop:    value("early_drop"),
+			InsertFailed: value("insert_failed"),
+		})
+	}
+	return stats
+}
+
+// getFirewallSysctls reads the sysctls that change how packets reach the firewall.
+func getFirewallSysctls(sys system.System) []sysctlValue {
+	var values []sysctlValue
+	readPath := func(key string, path ...string) (sysctlValue, bool) {
+		data, err := sys.ReadFile(filepath.Join(append([]string{procSysNet}, path...)...))
+		if err != nil {
+			return sysctlValue{}, false
+		}
+		return sysctlValue{Key: key, Value: strings.TrimSpace(string(data))}, true
+	}
+	read := func(key string) (sysctlValue, bool) {
+		return readPath(key, strings.Split(strings.TrimPrefix(key, "net."), ".")...)
+	}
+	add := func(v sysctlValue, ok bool) {
+		if ok {
+			values = append(values, v)
+		}
+	}
+
+	ipv4Forward, hasIPv4Forward := read("net.ipv4.ip_forward")
+	add(ipv4Forward, hasIPv4Forward)
+	ipv6Forward, hasIPv6Forward := read("net.ipv6.conf.all.forwarding")
+	if hasIPv4Forward && hasIPv6Forward && ipv4Forward.Value != ipv6Forward.Value {
+		ipv6Forward.Severity = severityInfo
+		ipv6Forward.Note = fmt.Sprintf("IPv6 forwarding (%s) differs from IPv4 forwarding (%s)", ipv6Forward.Value, ipv4Forward.Value)
+	}
+	add(ipv6Forward, hasIPv6Forward)
+	add(read("net.ipv6.conf.default.forwarding"))
+
+	// the effective rp_filter of an interface is the maximum of "all" and its own value
+	allRPFilter, _ := read("net.ipv4.conf.all.rp_filter")
+	interfaces, _ := sys.ReadDir(filepath.Join(procSysNet, "ipv4", "conf"))
+	for _, entry := range interfaces {
+		name := entry.Name()
+		v, ok := readPath("net.ipv4.conf."+name+".rp_filter", "ipv4", "conf", name, "rp_filter")
+		if !ok {
+			continue
+		}
+		if name != "all" && name != "default" && name != "lo" && max(v.Value, allRPFilter.Value) == "0" {
+			v.Severity = severityInfo
+			v.Note = "reverse path filtering is disabled, spoofed sources are only stopped by the ruleset (e.g. fib saddr . iif oif missing drop)"
+		}
+		values = append(values, v)
+	}
+
+	if v, ok := read("net.bridge.bridge-nf-call-iptables"); ok {
+		if v.Value == "1" {
+			v.Severity = severityInfo
+			v.Note = "bridged IPv4 traffic also traverses the ip/inet hooks; required by Docker and Kubernetes, surprising otherwise"
+		}
+		values = append(values, v)
+	}
+	add(read("net.bridge.bridge-nf-call-ip6tables"))
+
+	if v, ok := read("net.netfilter.nf_conntrack_tcp_loose"); ok {
+		if v.Value != "0" {
+			v.Severity = severityInfo
+			v.Note = "conntrack picks up TCP connections mid-stream, ct state established can match without a handshake"
+		}
+		values = append(values, v)
+	}
+	if v, ok := read("net.netfilter.nf_conntrack_helper"); ok {
+		if v.Value != "0" {
+			v.Severity = severityWarning
+			v.Note = "automatic conntrack helper assignment is insecure; set it to 0 and assign helpers with ct helper rules"
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func readUintFile(sys system.System, path string) (uint64, error) {
+	data, err := sys.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,47 @@
+package nftables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConntrackStat(t *testing.T) {
+	data := []byte(`entries  clashres found new invalid ignore delete delete_list insert insert_failed drop early_drop icmp_error expect_new expect_create expect_delete search_restart
+00000010  00000000 00000002 00000000 00000003 00000000 00000000 00000000 00000000 00000001 0000000a 00000000 00000000 00000000 00000000 00000000 00000000
+00000010  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000002 00000000 00000000 00000000 00000000 00000000
+`)
+	want := []conntrackCPUStat{
+		{CPU: 0, Found: 2, Invalid: 3, Drop: 10, InsertFailed: 1},
+		{CPU: 1, EarlyDrop: 2},
+	}
+	if got := parseConntrackStat(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseConntrackStat = %+v, want %+v", got, want)
+	}
+	if got := parseConntrackStat(nil); got != nil {
+		t.Errorf("parseConntrackStat(nil) = %+v", got)
+	}
+}
+
+func TestConntrackFindings(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ct   conntrackStatus
+		want []severity
+	}{
+		{"healthy", conntrackStatus{Count: 10, Max: 65536, Buckets: 16384, Usage: 0.1}, nil},
+		{"nearly full", conntrackStatus{Max: 100, Usage: 80}, []severity{severityWarning}},
+		{"full", conntrackStatus{Max: 100, Usage: 95}, []severity{severityCritical}},
+		{"long chains", conntrackStatus{Max: 1 << 20, Buckets: 1 << 10}, []severity{severityWarning}},
+		{"drops", conntrackStatus{Max: 100, EarlyDrop: 1, InsertFailed: 3}, []severity{severityWarning, severityWarning}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []severity
+			for _, f := range conntrackFindings(tt.ct) {
+				got = append(got, f.Severity)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findings = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package nftables
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+type collectMode string
+
+const (
+	// sourceAuto prefers the userspace tools and falls back to the kernel when a tool is missing
+	sourceAuto collectMode = "auto"
+	// sourceExec only asks the userspace tools
+	sourceExec collectMode = "exec"
+	// sourceKernel only reads /proc and /sys
+	sourceKernel collectMode = "kernel"
+)
+
+const (
+	procModules         = "/proc/modules"
+	procIPTablesNames   = "/proc/net/ip_tables_names"
+	procIP6TablesNames  = "/proc/net/ip6_tables_names"
+	procARPTablesNames  = "/proc/net/arp_tables_names"
+	procNetfilterSysctl = "/proc/sys/net/netfilter"
+	sysModule           = "/sys/module"
+)
+
+func (m collectMode) Valid() bool {
+	switch m {
+	case sourceAuto, sourceExec, sourceKernel:
+		return true
+	default:
+		return false
+	}
+}
+
+// useExec reports whether a section backed by the given commands is collected by running them.
+func (m collectMode) useExec(sys system.System, commands ...string) bool {
+	switch m {
+	case sourceExec:
+		return true
+	case sourceKernel:
+		return false
+	}
+	for _, name := range commands {
+		if checkCommand(sys, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func execSource(commands ...string) string {
+	return string(sourceExec) + ":" + strings.Join(commands, ",")
+}
+
+func kernelSource(paths ...string) string {
+	return string(sourceKernel) + ":" + strings.Join(paths, ",")
+}
+
+// fieldSources records where each field of a status section came from, by
+// the JSON name of the field.
+type fieldSources map[string]string
+
+func (s *fieldSources) set(source string, fields ...string) {
+	if *s == nil {
+		*s = make(fieldSources)
+	}
+	for _, field := range fields {
+		(*s)[field] = source
+	}
+}
+
+// getLoadedModules lists the modules in /proc/modules. It is read once per
+// collection and handed to everything asking whether a module is loaded.
+func getLoadedModules(sys system.System) ([]string, error) {
+	lines, err := readLines(sys, procModules)
+	if err != nil {
+		return nil, err
+	}
+	modules := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			modules = append(modules, fields[0])
+		}
+	}
+	return modules, nil
+}
+
+func getKernelNftablesInfo(sys system.System, loaded []string) (installed bool, backend string, source string) {
+	source = kernelSource(filepath.Join(sysModule, "nf_tables"), procModules)
+	if moduleLoaded(sys, loaded, "nf_tables") {
+		return true, "nf_tables", source
+	}
+	return false, "", source
+}
+
+// getKernelIptablesInfo reads the tables of both families, the type is
+// told by them and the modules.
+func getKernelIptablesInfo(sys system.System, loaded []string) (implType string, tables, ipv6Tables []string) {
+	tables, v4Err := readLines(sys, procIPTablesNames)
+	ipv6Tables, v6Err := readLines(sys, procIP6TablesNames)
+
+	switch {
+	case len(tables) > 0 || len(ipv6Tables) > 0:
+		// only the legacy x_tables backend registers its tables here
+		implType = "legacy backend (x_tables tables loaded)"
+	case moduleLoaded(sys, loaded, "nft_compat"):
+		implType = "nf_tables backend (nft_compat loaded)"
+	case v4Err == nil || v6Err == nil:
+		implType = "no tables loaded"
+	default:
+		implType = "not found"
+	}
+	return implType, tables, ipv6Tables
+}
+
+// getLegacyTables lists the tables registered by the legacy x_tables backend.
+func getLegacyTables(sys system.System) []string {
+	var tables []string
+	for family, path := range map[string]string{"ip": procIPTablesNames, "ip6": procIP6TablesNames} {
+		names, _ := readLines(sys, path)
+		for _, name := range names {
+			tables = append(tables, family+" "+name)
+		}
+	}
+	slices.Sort(tables)
+	return tables
+}
+
+func getKernelEbtablesInfo(sys system.System, loaded []string) (installed bool, implType string, source string) {
+	source = kernelSource(filepath.Join(sysModule, "ebtables"), procModules)
+	if moduleLoaded(sys, loaded, "ebtables") {
+		return true, "legacy", source
+	}
+	return false, "", source
+}
+
+func getKernelArptablesInfo(sys system.System, loaded []string) (installed bool, implType string, tables []string, source string) {
+	source = kernelSource(procARPTablesNames, filepath.Join(sysModule, "arp_tables"), procModules)
+	tables, err := readLines(sys, procARPTablesNames)
+	if err == nil || moduleLoaded(sys, loaded, "arp_tables") {
+		return true, "legacy", tables, source
+	}
+	return false, "", nil, source
+}
+
+// getKernelModules lists the netfilter modules that are loaded (/proc/modules)
+// or built into the kernel (/sys/module entries without an initstate).
+func getKernelModules(sys system.System, loaded []string, loadedErr error) ([]string, string) {
+	var sources []string
+	var modules []string
+	if loadedErr == nil {
+		sources = append(sources, procModules)
+	}
+	for _, name := range loaded {
+		if isNetfilterModule(name) {
+			modules = append(modules, name)
+		}
+	}
+
+	entries, err := sys.ReadDir(sysModule)
+	if err == nil {
+		sources = append(sources, sysModule)
+		var builtin []string
+		for _, entry := range entries {
+			name := entry.Name()
+			if !isNetfilterModule(name) || slices.Contains(modules, name) {
+				continue
+			}
+			if _, err := sys.Stat(filepath.Join(sysModule, name, "initstate")); E.Is(err, fs.ErrNotExist) {
+				builtin = append(builtin, name)
+			}
+		}
+		slices.Sort(builtin)
+		modules = append(modules, builtin...)
+	}
+
+	// conntrack without parameters does not show up in /sys/module when built in
+	if _, err := sys.Stat(filepath.Join(procNetfilterSysctl, "nf_conntrack_max")); err == nil {
+		sources = append(sources, procNetfilterSysctl)
+		if !slices.Contains(modules, "nf_conntrack") {
+			modules = append(modules, "nf_conntrack")
+		}
+	}
+	return modules, kernelSource(sources...)
+}
+
+// getFirewallTypeFromFiles detects firewalld and ufw by their configuration directories.
+func getFirewallTypeFromFiles(sys system.System) (string, string) {
+	firewalldPaths := []string{"/etc/firewalld", "/usr/lib/firewalld"}
+	ufwPaths := []string{"/etc/ufw/ufw.conf", "/lib/ufw", "/usr/lib/ufw"}
+
+	hasFirewalld := anyExists(sys, firewalldPaths...)
+	hasUfw := anyExists(sys, ufwPaths...)
+	source := "file:" + strings.Join(append(firewalldPaths, ufwPaths...), ",")
+	switch {
+	case hasFirewalld && hasUfw:
+		return "firewalld and ufw", source
+	case hasFirewalld:
+		return "firewalld", source
+	case hasUfw:
+		return "ufw", source
+	default:
+		return "not installed", source
+	}
+}
+
+// moduleLoaded reports whether a module is loaded or built in, loaded are
+// the modules of /proc/modules.
+func moduleLoaded(sys system.System, loaded []string, name string) bool {
+	if _, err := sys.Stat(filepath.Join(sysModule, name)); err == nil {
+		return true
+	}
+	return slices.Contains(loaded, name)
+}
+
+func anyExists(sys system.System, paths ...string) bool {
+	for _, path := range paths {
+		if _, err := sys.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func readLines(sys system.System, path string) ([]string, error) {
+	data, err := sys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
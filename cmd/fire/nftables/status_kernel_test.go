@@ -0,0 +1,151 @@
+package nftables
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+func TestCollectModeUseExec(t *testing.T) {
+	dir, _ := fakeNft(t)
+	t.Setenv("PATH", dir)
+	for _, tt := range []struct {
+		mode     collectMode
+		commands []string
+		want     bool
+	}{
+		{sourceAuto, []string{"nft"}, true},
+		{sourceAuto, []string{"iptables"}, false},
+		{sourceAuto, []string{"firewalld", "nft"}, true},
+		{sourceExec, []string{"iptables"}, true},
+		{sourceKernel, []string{"nft"}, false},
+	} {
+		if got := tt.mode.useExec(system.Local(), tt.commands...); got != tt.want {
+			t.Errorf("%s.useExec(%v) = %v", tt.mode, tt.commands, got)
+		}
+	}
+	if collectMode("test").Valid() {
+		t.Error("collect mode test is valid")
+	}
+}
+
+func TestCollectStatusSources(t *testing.T) {
+	dir, _ := fakeNft(t)
+	t.Setenv("PATH", dir)
+	// only nft is installed, auto falls back to the kernel for the rest
+	data := collectStatusData(system.Local(), sourceAuto)
+	if data.Nftables.Source != "exec:nft" {
+		t.Errorf("nftables source = %q", data.Nftables.Source)
+	}
+	for name, source := range map[string]string{
+		"iptables":  data.Iptables.Source,
+		"ebtables":  data.Ebtables.Source,
+		"arptables": data.Arptables.Source,
+	} {
+		if !strings.HasPrefix(source, "kernel:") {
+			t.Errorf("%s source = %q", name, source)
+		}
+	}
+	if !strings.HasPrefix(data.Firewall.Source, "file:") {
+		t.Errorf("firewall source = %q", data.Firewall.Source)
+	}
+
+	data = collectStatusData(system.Local(), sourceKernel)
+	if data.Nftables.Source != "kernel:/sys/module/nf_tables,/proc/modules" || data.Nftables.Version != "" {
+		t.Errorf("kernel nftables = %+v", data.Nftables)
+	}
+}
+
+func TestCollectStatusKernel(t *testing.T) {
+	var c system.Cassette
+	c.AddFile(procModules, "nf_tables 356352 0 - Live 0x0000000000000000\niptable_filter 16384 0 - Live 0x0000000000000000\n")
+	c.AddFile(procIPTablesNames, "filter\nnat\n")
+	c.AddFile(procNetfilterSysctl+"/nf_conntrack_max", "65536\n")
+	c.AddFile(procNetfilterSysctl+"/nf_conntrack_count", "100\n")
+	c.AddFile("/etc/ufw/ufw.conf", "ENABLED=yes\n")
+	recorder := system.Record(system.Replay(c))
+	data := collectStatusData(recorder, sourceKernel)
+	reads := 0
+	for _, entry := range recorder.Cassette().Entries {
+		if entry.Op == system.OpReadFile && entry.Path == procModules {
+			reads++
+		}
+	}
+	if reads != 1 {
+		t.Errorf("%s read %d times", procModules, reads)
+	}
+	if !data.Nftables.Installed || data.Nftables.Backend != "nf_tables" {
+		t.Errorf("nftables = %+v", data.Nftables)
+	}
+	if data.Iptables.Type != "legacy backend (x_tables tables loaded)" || !slices.Equal(data.Iptables.Tables, []string{"filter", "nat"}) {
+		t.Errorf("iptables = %+v", data.Iptables)
+	}
+	if !slices.Equal(data.Iptables.LegacyTables, []string{"ip filter", "ip nat"}) {
+		t.Errorf("legacy tables = %v", data.Iptables.LegacyTables)
+	}
+	if want := (fieldSources{
+		"type":          "kernel:/proc/net/ip_tables_names,/proc/net/ip6_tables_names,/sys/module/nft_compat,/proc/modules",
+		"tables":        "kernel:/proc/net/ip_tables_names",
+		"ipv6_tables":   "kernel:/proc/net/ip6_tables_names",
+		"legacy_tables": "kernel:/proc/net/ip_tables_names,/proc/net/ip6_tables_names",
+	}); !maps.Equal(data.Iptables.Sources, want) {
+		t.Errorf("iptables sources = %v", data.Iptables.Sources)
+	}
+	if data.Ebtables.Installed || data.Arptables.Installed {
+		t.Errorf("ebtables = %+v, arptables = %+v", data.Ebtables, data.Arptables)
+	}
+	if !slices.Equal(data.KernelModules, []string{"nf_tables", "iptable_filter", "nf_conntrack"}) {
+		t.Errorf("kernel modules = %v", data.KernelModules)
+	}
+	if data.Firewall.Type != "ufw" {
+		t.Errorf("firewall = %+v", data.Firewall)
+	}
+	if !data.Conntrack.Available || data.Conntrack.Count != 100 || data.Conntrack.Max != 65536 {
+		t.Errorf("conntrack = %+v", data.Conntrack)
+	}
+}
+
+func TestCollectStatusExec(t *testing.T) {
+	var c system.Cassette
+	c.AddFile(procIPTablesNames, "filter\nnat\n")
+	for _, command := range []string{"iptables", "iptables-save", "iptables-legacy-save", "ip6tables-legacy-save"} {
+		c.AddCommand(command, "/usr/sbin/"+command)
+	}
+	c.AddRun(system.Command("iptables-save", "-c"), "*filter\n:INPUT ACCEPT [0:0]\nCOMMIT\n")
+	c.AddRun(system.Command("iptables-legacy-save", "-c"), "*raw\n:PREROUTING ACCEPT [0:0]\nCOMMIT\n")
+	c.AddRun(system.Command("ip6tables-legacy-save", "-c"), "*mangle\n:PREROUTING ACCEPT [0:0]\nCOMMIT\n")
+	// the legacy tables come from the save commands, not from /proc
+	data := collectStatusData(system.Replay(c), sourceExec)
+	if !slices.Equal(data.Iptables.LegacyTables, []string{"ip raw", "ip6 mangle"}) {
+		t.Errorf("legacy tables = %v", data.Iptables.LegacyTables)
+	}
+	if want := (fieldSources{
+		"type":          "exec:iptables",
+		"version":       "exec:iptables",
+		"tables":        "exec:iptables-save",
+		"chains":        "exec:iptables-save",
+		"ipv4":          "exec:iptables-save",
+		"ipv6_tables":   "exec:ip6tables-save",
+		"ipv6":          "exec:ip6tables-save",
+		"legacy_tables": "exec:iptables-legacy-save,ip6tables-legacy-save",
+	}); !maps.Equal(data.Iptables.Sources, want) {
+		t.Errorf("iptables sources = %v", data.Iptables.Sources)
+	}
+	if data.Arptables.Sources["tables"] != "exec:arptables-save" || data.Ebtables.Sources["ruleset"] != "exec:ebtables-save" {
+		t.Errorf("arptables sources = %v, ebtables sources = %v", data.Arptables.Sources, data.Ebtables.Sources)
+	}
+}
+
+func TestIsNetfilterModule(t *testing.T) {
+	for name, want := range map[string]bool{
+		"nf_tables": true, "nft_compat": true, "ip6table_filter": true, "x_tables": false,
+		"xt_conntrack": false, "ebtable_nat": true, "nfnetlink": false, "br_netfilter": true,
+	} {
+		if isNetfilterModule(name) != want {
+			t.Errorf("isNetfilterModule(%s) = %v", name, !want)
+		}
+	}
+}
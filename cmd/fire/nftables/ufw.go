@@ -0,0 +1,221 @@
+package nftables
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/iptables"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/ufw"
+)
+
+var (
+	ufwDir      string
+	ufwDefaults string
+	ufwStatus   string
+	ufwTable    string
+	ufwApply    bool
+	ufwCheck    bool
+	ufwOutput   output.Options
+
+	ufwCommand = &cobra.Command{
+		Use:   "ufw",
+		Short: "Work with the configuration of ufw",
+	}
+	ufwImportCommand = &cobra.Command{
+		Use:   "import",
+		Short: "Translate the ufw rules to an nft table",
+		Long: `Read the rules of ufw and print an nft script that replaces the inet table
+` + ufw.DefaultTable + ` with their equivalent: the user rules of user.rules and user6.rules,
+the iptables rules of before.rules, after.rules and their IPv6 variants and
+the default policies of /etc/default/ufw. Addresses used by several rules
+become named sets.
+
+With --status the user rules are read from the output of "ufw status",
+"ufw status numbered" or "ufw status verbose" instead; application profiles
+are resolved with the files of applications.d.
+
+Rules that cannot be translated are reported as "# skipped:" comments (in
+the "skipped" list in structured formats). The script is printed unless
+--apply or --check is given.`,
+		Args: cobra.NoArgs,
+		RunE: ufwImport,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(ufwCommand)
+	ufwCommand.AddCommand(ufwImportCommand)
+	flags := ufwImportCommand.Flags()
+	flags.StringVar(&ufwDir, "dir", "/etc/ufw", "ufw configuration directory")
+	flags.StringVar(&ufwDefaults, "defaults", "/etc/default/ufw", "File with the default policies")
+	flags.StringVar(&ufwStatus, "status", "", "Read the user rules from ufw status output in this file, - for stdin")
+	flags.StringVar(&ufwTable, "table", ufw.DefaultTable, "Name of the inet table")
+	flags.BoolVar(&ufwApply, "apply", false, "Load the table with nft -f")
+	flags.BoolVar(&ufwCheck, "check", false, "Only check the script with nft -c")
+	ufwOutput.Bind(ufwImportCommand, output.FormatText)
+}
+
+// readUfwFile reads a file of the inspected system, a missing file is nil.
+func readUfwFile(name string) ([]byte, error) {
+	data, err := sys.ReadFile(name)
+	if E.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, E.When("read "+name, err)
+	}
+	return data, nil
+}
+
+func ufwImport(cmd *cobra.Command, args []string) error {
+	if ufwApply && ufwCheck {
+		return E.New("--apply and --check cannot be combined")
+	}
+	if err := ufwOutput.Validate(); err != nil {
+		return err
+	}
+	config := &ufw.Config{Table: ufwTable, Defaults: ufw.DefaultDefaults()}
+	data, err := readUfwFile(ufwDefaults)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		if config.Defaults, err = ufw.ParseDefaults(bytes.NewReader(data)); err != nil {
+			return E.When("parse "+ufwDefaults, err)
+		}
+	}
+
+	var skipped []string
+	if ufwStatus != "" {
+		if skipped, err = ufwStatusRules(cmd, config); err != nil {
+			return err
+		}
+	} else {
+		for _, file := range []struct {
+			name string
+			ipv6 bool
+		}{{"user.rules", false}, {"user6.rules", true}} {
+			name := path.Join(ufwDir, file.name)
+			data, err := readUfwFile(name)
+			if err != nil {
+				return err
+			}
+			rules, err := ufw.ParseUserRules(bytes.NewReader(data), file.ipv6)
+			if err != nil {
+				return E.When("parse "+name, err)
+			}
+			config.Rules = append(config.Rules, rules...)
+		}
+	}
+
+	for _, file := range []struct {
+		name string
+		ipv6 bool
+	}{{"before.rules", false}, {"before6.rules", true}, {"after.rules", false}, {"after6.rules", true}} {
+		name := path.Join(ufwDir, file.name)
+		data, err := readUfwFile(name)
+		if err != nil || data == nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		tables, err := iptables.Parse(bytes.NewReader(data))
+		if err != nil {
+			return E.When("parse "+name, err)
+		}
+		config.Files = append(config.Files, ufw.File{Name: file.name, IPv6: file.ipv6, Tables: tables})
+	}
+	if len(config.Rules) == 0 && len(config.Files) == 0 {
+		return E.New("no ufw rules in ", ufwDir)
+	}
+
+	translation, err := ufw.Translate(config)
+	if err != nil {
+		return err
+	}
+	translation.Skipped = append(skipped, translation.Skipped...)
+	if !ufwApply && !ufwCheck {
+		return ufwOutput.Print(cmd, ufwTranslation{translation})
+	}
+	for _, s := range translation.Skipped {
+		fmt.Fprintln(cmd.ErrOrStderr(), "skipped:", s)
+	}
+	if err = runNftScript([]byte(translation.Script), ufwCheck); err != nil {
+		return E.When("load table inet "+config.Table, err)
+	}
+	return nil
+}
+
+// ufwStatusRules reads the user rules, and the defaults if ufw status verbose
+// printed them, from the --status file.
+func ufwStatusRules(cmd *cobra.Command, config *ufw.Config) ([]string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if ufwStatus == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(ufwStatus)
+	}
+	if err != nil {
+		return nil, E.When("read ufw status", err)
+	}
+	status, err := ufw.ParseStatus(bytes.NewReader(data))
+	if err != nil {
+		return nil, E.When("parse ufw status", err)
+	}
+	if status.Defaults != nil {
+		config.Defaults = *status.Defaults
+	}
+
+	apps := make(ufw.Apps)
+	appDir := path.Join(ufwDir, "applications.d")
+	entries, err := sys.ReadDir(appDir)
+	if err != nil && !E.Is(err, fs.ErrNotExist) {
+		return nil, E.When("read "+appDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := path.Join(appDir, entry.Name())
+		data, err := sys.ReadFile(name)
+		if err != nil {
+			return nil, E.When("read "+name, err)
+		}
+		if err = ufw.ParseApps(bytes.NewReader(data), apps); err != nil {
+			return nil, E.When("parse "+name, err)
+		}
+	}
+	var skipped []string
+	config.Rules, skipped = ufw.ResolveApps(status.Rules, apps)
+	return skipped, nil
+}
+
+type ufwTranslation struct {
+	*ufw.Translation
+}
+
+// RenderText prints the script with the skipped rules as comments in front,
+// nft ignores them.
+func (t ufwTranslation) RenderText(w io.Writer) error {
+	var sb strings.Builder
+	for _, s := range t.Skipped {
+		sb.WriteString("# skipped: ")
+		sb.WriteString(strings.ReplaceAll(s, "\n", " "))
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(t.Script)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
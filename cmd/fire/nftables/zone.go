@@ -0,0 +1,138 @@
+package nftables
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables/zone"
+	"github.com/woshikedayaa/fire/common/output"
+)
+
+var (
+	zoneApply        bool
+	zoneCheck        bool
+	zoneImportDirs   []string
+	zoneImportAll    bool
+	zoneImportOutput output.Options
+
+	zoneCommand = &cobra.Command{
+		Use:   "zone",
+		Short: "Manage the firewall as zones",
+		Long: `Describe the firewall as zones, the way firewalld does: interfaces and source
+networks belong to zones, zones list the services reachable on the host and
+policies decide what is forwarded between zones. The zones compile to one
+inet table.`,
+	}
+	zoneCompileCommand = &cobra.Command{
+		Use:   "compile <config.json|->",
+		Short: "Print or apply the nft table of a zone config",
+		Long: `Compile a zone config to an nft script that atomically replaces its table
+(inet ` + zone.DefaultTable + ` unless "table" is set). The script is printed unless
+--apply or --check is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: zoneCompile,
+	}
+	zoneImportCommand = &cobra.Command{
+		Use:   "import-firewalld",
+		Short: "Convert the firewalld zones to a zone config",
+		Long: `Read the zone, service and policy XML of firewalld and print the equivalent
+zone config. Settings without an equivalent, like rich rules, are listed under
+"skipped" (on stderr in text mode). Both outputs are read by compile. Interfaces assigned to zones only at
+runtime, e.g. by NetworkManager, are not in the XML and have to be added.`,
+		Args: cobra.NoArgs,
+		RunE: zoneImport,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(zoneCommand)
+	zoneCommand.AddCommand(zoneCompileCommand, zoneImportCommand)
+	zoneCompileCommand.Flags().BoolVar(&zoneApply, "apply", false, "Load the table with nft -f")
+	zoneCompileCommand.Flags().BoolVar(&zoneCheck, "check", false, "Only check the script with nft -c")
+	zoneImportCommand.Flags().StringSliceVar(&zoneImportDirs, "dir", zone.FirewalldDirs, "firewalld configuration directories, later ones override earlier ones")
+	zoneImportCommand.Flags().BoolVar(&zoneImportAll, "all", false, "Import every zone, not only the default zone and zones with interfaces or sources")
+	zoneImportOutput.Bind(zoneImportCommand, output.FormatText)
+}
+
+func zoneCompile(cmd *cobra.Command, args []string) error {
+	if zoneApply && zoneCheck {
+		return E.New("--apply and --check cannot be combined")
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if args[0] == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return E.When("read zone config", err)
+	}
+	config, err := parseZoneConfig(data)
+	if err != nil {
+		return E.When("parse zone config", err)
+	}
+	script, err := zone.Compile(config)
+	if err != nil {
+		return err
+	}
+	if !zoneApply && !zoneCheck {
+		_, err = io.WriteString(cmd.OutOrStdout(), script)
+		return err
+	}
+	if err = runNftScript([]byte(script), zoneCheck); err != nil {
+		return E.When("load table inet "+config.Table, err)
+	}
+	return nil
+}
+
+// parseZoneConfig reads a zone config, or the output of import-firewalld
+// --format json holding one under "config".
+func parseZoneConfig(data []byte) (*zone.Config, error) {
+	var imp zone.Import
+	if err := json.Unmarshal(data, &imp); err == nil && imp.Config != nil {
+		return imp.Config, nil
+	}
+	var config zone.Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func zoneImport(cmd *cobra.Command, args []string) error {
+	if err := zoneImportOutput.Validate(); err != nil {
+		return err
+	}
+	imp, err := zone.ImportFirewalld(sys, zoneImportDirs, zoneImportAll)
+	if err != nil {
+		return err
+	}
+	if zoneImportOutput.Format == output.FormatText {
+		for _, skipped := range imp.Skipped {
+			fmt.Fprintln(cmd.ErrOrStderr(), "skipped:", skipped)
+		}
+	}
+	return zoneImportOutput.Print(cmd, zoneImportView{imp})
+}
+
+type zoneImportView struct {
+	*zone.Import
+}
+
+// RenderText prints the config, ready for "fire nftables zone compile". The
+// skipped settings go to stderr.
+func (v zoneImportView) RenderText(w io.Writer) error {
+	data, err := json.MarshalIndent(v.Config, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,22 @@
+package nftables
+
+import "testing"
+
+func TestParseZoneConfig(t *testing.T) {
+	for _, data := range []string{
+		`{"default_zone":"public","zones":[{"name":"public","interfaces":["eth0"]}]}`,
+		// the output of import-firewalld --format json
+		`{"config":{"default_zone":"public","zones":[{"name":"public","interfaces":["eth0"]}]},"skipped":["zone public: rich rule"]}`,
+	} {
+		config, err := parseZoneConfig([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.DefaultZone != "public" || len(config.Zones) != 1 || config.Zones[0].Interfaces[0] != "eth0" {
+			t.Errorf("%s: %+v", data, config)
+		}
+	}
+	if _, err := parseZoneConfig([]byte(`{"zones":`)); err == nil {
+		t.Error("parsed a truncated config")
+	}
+}
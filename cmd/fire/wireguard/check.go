@@ -0,0 +1,143 @@
+package wireguard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/wireguard"
+)
+
+var (
+	checkSubnets []string
+	checkOutput  output.Options
+
+	checkCommand = &cobra.Command{
+		Use:   "check <file>...",
+		Short: "Check that the configs of a network fit each other",
+		Long: `Check a set of configs that form one network: wg-quick configs, or the JSON
+printed by "fire wg generate". Every peer key has to belong to the private
+key of a checked config that lists the other side back with the same
+PresharedKey, no key may be used twice, the AllowedIPs of the peers of an
+interface must not overlap, the addresses must be unique and inside the
+subnets, and endpoints have to use the ListenPort of their peer.
+
+The subnets default to the networks of the addresses of the first config,
+list the hub first or give --subnet. The exit status is non-zero when an
+error is found.`,
+		Example: `  fire wg check /etc/wireguard/wg0.conf clients/*.conf
+  fire wg generate -c 3 > net.json && fire wg check net.json`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: check,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(checkCommand)
+	checkCommand.Flags().StringSliceVar(&checkSubnets, "subnet", nil, "Subnets the addresses must be in, default the networks of the first config")
+	checkOutput.Bind(checkCommand, output.FormatText)
+}
+
+func check(cmd *cobra.Command, args []string) error {
+	if err := checkOutput.Validate(); err != nil {
+		return err
+	}
+	var subnets []netip.Prefix
+	for _, s := range checkSubnets {
+		subnet, err := netip.ParsePrefix(s)
+		if err != nil {
+			return E.New("invalid subnet ", strconv.Quote(s))
+		}
+		subnets = append(subnets, subnet.Masked())
+	}
+
+	rep := checkReport{Findings: []wireguard.Finding{}}
+	var nodes []wireguard.Node
+	for _, name := range args {
+		_, data, err := readConfig(cmd, []string{name})
+		if err != nil {
+			return err
+		}
+		found, findings := loadNodes(name, data)
+		nodes = append(nodes, found...)
+		rep.Findings = append(rep.Findings, findings...)
+	}
+	for _, node := range nodes {
+		rep.Nodes = append(rep.Nodes, node.Name)
+	}
+	rep.Findings = append(rep.Findings, wireguard.CheckNetwork(nodes, subnets)...)
+	if err := checkOutput.Print(cmd, rep); err != nil {
+		return err
+	}
+	if errors := rep.count(wireguard.SeverityError); errors > 0 {
+		cmd.SilenceUsage = true
+		return E.New(errors, " errors found")
+	}
+	return nil
+}
+
+// loadNodes reads a wg-quick config, or every config of the JSON of wg
+// generate. Problems of the file become findings.
+func loadNodes(name string, data []byte) ([]wireguard.Node, []wireguard.Finding) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var mesh wireguard.MeshPair
+		if err := json.Unmarshal(data, &mesh); err != nil {
+			return nil, []wireguard.Finding{{Severity: wireguard.SeverityError, Node: name, Message: "decode: " + err.Error()}}
+		}
+		nodes := []wireguard.Node{{Name: name + " root", Interface: mesh.Root.Interface, Peers: mesh.Root.Peers}}
+		for i, peer := range mesh.Peers {
+			nodes = append(nodes, wireguard.Node{Name: fmt.Sprintf("%s peer %d", name, i+1), Interface: peer.Interface, Peers: peer.Peers})
+		}
+		return nodes, nil
+	}
+
+	iif, peers, warnings, err := wireguard.ParseWireguardConfWith(bytes.NewReader(data), wireguard.ParseOptions{File: name})
+	var findings []wireguard.Finding
+	for _, warning := range warnings {
+		findings = append(findings, wireguard.Finding{Severity: wireguard.SeverityWarning, Node: name, Message: warning.Error()})
+	}
+	if err != nil {
+		return nil, append(findings, wireguard.Finding{Severity: wireguard.SeverityError, Node: name, Message: err.Error()})
+	}
+	return []wireguard.Node{{Name: name, Interface: iif, Peers: peers}}, findings
+}
+
+type checkReport struct {
+	Nodes    []string            `json:"nodes"`
+	Findings []wireguard.Finding `json:"findings"`
+}
+
+func (rep checkReport) count(severity wireguard.Severity) int {
+	n := 0
+	for _, f := range rep.Findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// RenderText implements output.Text.
+func (rep checkReport) RenderText(w io.Writer) error {
+	for _, f := range rep.Findings {
+		fmt.Fprintf(w, "%s: %s: %s\n", f.Node, f.Severity, f.Message)
+	}
+	_, err := fmt.Fprintf(w, "%d configs checked, %d errors, %d warnings\n",
+		len(rep.Nodes), rep.count(wireguard.SeverityError), rep.count(wireguard.SeverityWarning))
+	return err
+}
+
+// Table implements output.Table.
+func (rep checkReport) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(rep.Findings))
+	for _, f := range rep.Findings {
+		rows = append(rows, []string{f.Node, string(f.Severity), f.Message})
+	}
+	return []string{"NODE", "SEVERITY", "MESSAGE"}, rows
+}
@@ -0,0 +1,62 @@
+package wireguard
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/wireguard"
+)
+
+var (
+	convertTo string
+
+	convertCommand = &cobra.Command{
+		Use:   "convert [file]",
+		Short: "Convert a config between wg-quick and wg setconf syntax",
+		Long: `Check a config and print it in another syntax. --to setconf strips the keys
+only wg-quick knows (Address, DNS, MTU, Table and the hooks), like
+"wg-quick strip", so the result can be loaded with "wg setconf" or
+"wg syncconf". --to quick keeps the config, wg-quick reads setconf configs
+as they are. Comments and unknown lines stay where they are.
+
+The config is read from file, or from stdin without one or with -.`,
+		Example: `  fire wg convert /etc/wireguard/wg0.conf --to setconf > /run/wg0.setconf`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE:    convert,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(convertCommand)
+	convertCommand.Flags().StringVar(&convertTo, "to", "setconf", "Syntax to convert to: quick or setconf")
+}
+
+// readConfig reads the config named by args, or stdin.
+func readConfig(cmd *cobra.Command, args []string) (name string, data []byte, err error) {
+	if len(args) == 0 || args[0] == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+		return "<stdin>", data, E.When("read config", err)
+	}
+	data, err = os.ReadFile(args[0])
+	return args[0], data, E.When("read config", err)
+}
+
+func convert(cmd *cobra.Command, args []string) error {
+	to, err := wireguard.ParseSyntax(convertTo)
+	if err != nil {
+		return err
+	}
+	name, data, err := readConfig(cmd, args)
+	if err != nil {
+		return err
+	}
+	out, err := wireguard.ConvertWireguardConf(bytes.NewReader(data), wireguard.ParseOptions{File: name, Mode: wireguard.Strict}, to)
+	if err != nil {
+		return err
+	}
+	_, err = cmd.OutOrStdout().Write(out)
+	return err
+}
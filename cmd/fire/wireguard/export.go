@@ -0,0 +1,214 @@
+package wireguard
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/wireguard"
+)
+
+var (
+	exportFormat         string
+	exportName           string
+	exportPrivateKeyFile string
+	exportDir            string
+
+	importFormat string
+	importName   string
+	importTo     string
+
+	exportCommand = &cobra.Command{
+		Use:   "export [file]",
+		Short: "Export a wg-quick config to systemd-networkd, NetworkManager or OpenWrt",
+		Long: `Translate a wg-quick config for another network stack:
+
+  networkd  a .netdev and a .network file for /etc/systemd/network
+  nm        a keyfile connection for /etc/NetworkManager/system-connections
+  uci       the interface and peer sections of /etc/config/network of OpenWrt
+  quick     wg-quick itself, setconf for wg setconf
+
+Settings the stack has no equivalent for, like the hooks of wg-quick, are
+left out with a warning. The files are printed, or written to --dir with
+the permissions the stack expects. The networkd files holding keys are
+given to the group systemd-network, which needs root.
+
+The config is read from file, or from stdin without one or with -.`,
+		Example: `  fire wg export /etc/wireguard/wg0.conf --format networkd --private-key-file /etc/systemd/network/wg0.key --dir /etc/systemd/network
+  fire wg export wg0.conf --format uci >> /etc/config/network`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: export,
+	}
+	importCommand = &cobra.Command{
+		Use:   "import <file>...",
+		Short: "Import a config of systemd-networkd, NetworkManager or OpenWrt as wg-quick config",
+		Long: `Read the wireguard interface of another network stack and print it as
+wg-quick config. systemd-networkd takes the .netdev and optionally the
+.network file, a PrivateKeyFile is read from the disk. Without --format
+it is guessed from the file names.`,
+		Example: `  fire wg import /etc/systemd/network/wg0.netdev /etc/systemd/network/wg0.network
+  fire wg import /etc/config/network --name wg0`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: importConfig,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(exportCommand, importCommand)
+
+	flags := exportCommand.Flags()
+	flags.StringVar(&exportFormat, "format", "", "Format to export to: networkd, nm, uci, quick or setconf")
+	flags.StringVar(&exportName, "name", "", "Interface name, default the name of the config file or "+wireguard.DefaultName)
+	flags.StringVar(&exportPrivateKeyFile, "private-key-file", "", "networkd: read the private key from this path instead of the .netdev")
+	flags.StringVar(&exportDir, "dir", "", "Write the files to this directory instead of stdout")
+	_ = exportCommand.MarkFlagRequired("format")
+
+	flags = importCommand.Flags()
+	flags.StringVar(&importFormat, "format", "", "Format to import from: networkd, nm, uci, quick or setconf, default guessed from the file names")
+	flags.StringVar(&importName, "name", "", "Interface to import when the file holds several")
+	flags.StringVar(&importTo, "to", "quick", "Syntax of the printed config: quick or setconf")
+}
+
+func export(cmd *cobra.Command, args []string) error {
+	format, err := wireguard.ParseFormat(exportFormat)
+	if err != nil {
+		return err
+	}
+	name, data, err := readConfig(cmd, args)
+	if err != nil {
+		return err
+	}
+	iif, peers, _, err := wireguard.ParseWireguardConfWith(bytes.NewReader(data), wireguard.ParseOptions{File: name, Mode: wireguard.Strict})
+	if err != nil {
+		return err
+	}
+	opts := wireguard.ExportOptions{Name: exportName, PrivateKeyFile: exportPrivateKeyFile}
+	if opts.Name == "" && len(args) > 0 && args[0] != "-" {
+		opts.Name = strings.TrimSuffix(filepath.Base(args[0]), ".conf")
+	}
+	files, warnings, err := wireguard.Export(iif, peers, format, opts)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(cmd.ErrOrStderr(), "warning:", warning)
+	}
+	if exportDir == "" {
+		for _, file := range files {
+			if file.Group != "" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: install %s with mode %o and group %s, which must read it\n", file.Name, file.Perm, file.Group)
+			}
+		}
+		return printFiles(cmd.OutOrStdout(), files)
+	}
+	for _, file := range files {
+		path := filepath.Join(exportDir, file.Name)
+		if err = writeFile(path, file.Data, file.Perm); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.ErrOrStderr(), "wrote", path)
+		if file.Group == "" {
+			continue
+		}
+		if err = chgrp(path, file.Group); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s, run chgrp %s %s\n", err, file.Group, path)
+		}
+	}
+	return nil
+}
+
+// chgrp gives the file to group, which needs root unless the user is in it.
+func chgrp(path, group string) error {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return E.When("chgrp "+path, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return E.When("chgrp "+path, err)
+	}
+	return E.When("chgrp "+path, os.Chown(path, -1, gid))
+}
+
+// printFiles prints one file as it is and several with a comment naming
+// each.
+func printFiles(w io.Writer, files []wireguard.File) error {
+	for i, file := range files {
+		if len(files) > 1 {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "# %s\n", file.Name)
+		}
+		if _, err := w.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// guessFormat tells the format of the files by their names.
+func guessFormat(names []string) (wireguard.Format, error) {
+	for _, name := range names {
+		switch ext := filepath.Ext(name); {
+		case ext == ".netdev" || ext == ".network":
+			return wireguard.FormatNetworkd, nil
+		case ext == ".nmconnection":
+			return wireguard.FormatNetworkManager, nil
+		case filepath.Base(name) == "network":
+			return wireguard.FormatUCI, nil
+		case ext == ".conf":
+			return wireguard.FormatQuick, nil
+		}
+	}
+	return 0, E.New("cannot tell the format of ", strings.Join(names, ", "), ", give --format")
+}
+
+func importConfig(cmd *cobra.Command, args []string) error {
+	to, err := wireguard.ParseSyntax(importTo)
+	if err != nil {
+		return err
+	}
+	var format wireguard.Format
+	if importFormat != "" {
+		format, err = wireguard.ParseFormat(importFormat)
+	} else {
+		format, err = guessFormat(args)
+	}
+	if err != nil {
+		return err
+	}
+	files := make([]wireguard.File, 0, len(args))
+	for _, path := range args {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return E.When("read config", err)
+		}
+		files = append(files, wireguard.File{Name: path, Data: data})
+	}
+	_, config, warnings, err := wireguard.Import(files, format, wireguard.ImportOptions{Name: importName, ReadFile: os.ReadFile})
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(cmd.ErrOrStderr(), "warning:", warning)
+	}
+	var conf []byte
+	if to == wireguard.SyntaxSetconf {
+		conf, err = wireguard.MarshalSetconf(config.Interface, config.Peers)
+	} else {
+		conf, err = wireguard.MarshalWireguardConf(config.Interface, config.Peers)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = cmd.OutOrStdout().Write(conf)
+	return err
+}
@@ -2,15 +2,15 @@ package wireguard
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
 	"github.com/woshikedayaa/fire/common/networks/ip"
+	"github.com/woshikedayaa/fire/common/output"
 	"github.com/woshikedayaa/fire/common/wireguard"
 	"io"
 	"iter"
-	"net"
 	"net/netip"
-	"os"
-	"strconv"
 )
 
 const (
@@ -35,7 +35,8 @@ type GenerateConfig struct {
 	PostUp              []string `json:"post_up"`
 	PreDown             []string `json:"pre_down"`
 	PostDown            []string `json:"post_down"`
-	Table               int      `json:"table"`
+	Table               string   `json:"table"`
+	FwMark              string   `json:"fwmark"`
 	MTU                 int      `json:"mtu"`
 
 	// Peer section
@@ -48,8 +49,12 @@ type GenerateConfig struct {
 	v4Prefix netip.Prefix
 	v6Prefix netip.Prefix
 
-	allowIPs []netip.Prefix
-	dns      []netip.Addr
+	allowIPs  []netip.Prefix
+	dns       []netip.Addr
+	dnsSearch []string
+	table     wireguard.Table
+	fwMark    uint32
+	endpoint  wireguard.Endpoint
 }
 
 func (c GenerateConfig) bindInterface(iif *wireguard.Interface) {
@@ -59,8 +64,10 @@ func (c GenerateConfig) bindInterface(iif *wireguard.Interface) {
 	iif.PostDown = c.PostDown
 	iif.MTU = c.MTU
 	iif.DNS = c.dns
+	iif.DNSSearch = c.dnsSearch
 	iif.ListenPort = c.InterfaceListenPort
-	iif.Table = c.Table
+	iif.Table = c.table
+	iif.FwMark = c.fwMark
 }
 
 func (c GenerateConfig) bindPeer(peer *wireguard.Peer) {
@@ -68,8 +75,8 @@ func (c GenerateConfig) bindPeer(peer *wireguard.Peer) {
 	if c.PersistentKeepalive > 0 {
 		peer.PersistentKeepalive = c.PersistentKeepalive
 	}
-	if peer.Endpoint != "" {
-		peer.Endpoint = net.JoinHostPort(c.Endpoint, strconv.FormatUint(uint64(c.EndpointPort), 10))
+	if c.endpoint.IsValid() {
+		peer.Endpoint = c.endpoint
 	}
 }
 
@@ -83,10 +90,28 @@ func (c GenerateConfig) Build() (GenerateConfig, error) {
 	if c.MTU <= 0 {
 		c.MTU = 1420
 	}
-	if c.Table <= 0 {
-		c.Table = 0
-	}
 	var err error
+	if c.Table != "" {
+		if c.table, err = wireguard.ParseTable(c.Table); err != nil {
+			return GenerateConfig{}, err
+		}
+	}
+	if c.FwMark != "" {
+		if c.fwMark, err = wireguard.ParseFwMark(c.FwMark); err != nil {
+			return GenerateConfig{}, err
+		}
+	}
+	if c.Endpoint != "" {
+		// a port in --endpoint wins over --endpoint-port, which defaults to
+		// the listen port of the interface
+		port := c.EndpointPort
+		if port == 0 {
+			port = c.InterfaceListenPort
+		}
+		if c.endpoint, err = wireguard.ParseEndpointDefault(c.Endpoint, port); err != nil {
+			return GenerateConfig{}, err
+		}
+	}
 	if c.IPv4 {
 		c.v4Prefix, err = netip.ParsePrefix(c.IPv4Cidr)
 		if err != nil {
@@ -120,16 +145,21 @@ func (c GenerateConfig) Build() (GenerateConfig, error) {
 		c.allowIPs = append(c.allowIPs, prefix)
 	}
 	for _, v := range c.DNS {
-		addr, err := netip.ParseAddr(v)
-		if err != nil {
-			return GenerateConfig{}, err
+		if addr, err := netip.ParseAddr(v); err == nil {
+			c.dns = append(c.dns, addr)
+		} else {
+			c.dnsSearch = append(c.dnsSearch, v)
 		}
-		c.dns = append(c.dns, addr)
 	}
 	return c, nil
 }
 
-var generateConfig GenerateConfig
+var (
+	generateConfig GenerateConfig
+	generateOutput output.Options
+	generateSyntax string
+	generateQR     qrOptions
+)
 
 var generateCommand = &cobra.Command{
 	Use:   "generate",
@@ -148,7 +178,7 @@ func init() {
 	generateCommand.Flags().BoolVarP(&generateConfig.IPv4, "enable-ipv4", "4", false, "Enable ipv4(See --ip4-cidr)")
 	generateCommand.Flags().BoolVarP(&generateConfig.IPv6, "enable-ipv6", "6", false, "Enable ipv6(See --ip6-cidr)")
 
-	generateCommand.Flags().StringSliceVar(&generateConfig.DNS, "dns", []string{}, "Configure DNS, multi dns split by comma")
+	generateCommand.Flags().StringSliceVar(&generateConfig.DNS, "dns", []string{}, "Configure DNS servers and search domains, multi dns split by comma")
 	generateCommand.Flags().BoolVarP(&generateConfig.EnablePreshared, "enable-preshard", "P", false, "Enable preshard key")
 	generateCommand.Flags().Uint16Var(&generateConfig.InterfaceListenPort, "interface-listen-port", 51820, "Set interface listen port")
 
@@ -156,25 +186,75 @@ func init() {
 	generateCommand.Flags().StringArrayVar(&generateConfig.PostUp, "post-up", []string{}, "Post up hook")
 	generateCommand.Flags().StringArrayVar(&generateConfig.PreDown, "pre-down", []string{}, "Pre down hook")
 	generateCommand.Flags().StringArrayVar(&generateConfig.PostDown, "post-down", []string{}, "Post down hook")
-	generateCommand.Flags().IntVar(&generateConfig.Table, "table", -1, "ip2route table number")
+	generateCommand.Flags().StringVar(&generateConfig.Table, "table", "", "Routing table of wg-quick: auto, off or a table number")
+	generateCommand.Flags().StringVar(&generateConfig.FwMark, "fwmark", "", "Firewall mark of the outgoing packets, decimal or 0x hex")
 
 	generateCommand.Flags().StringSliceVar(&generateConfig.AllowIPs, "allow-ips", []string{}, "Peer AllowIPs")
 	generateCommand.Flags().IntVarP(&generateConfig.PersistentKeepalive, "keep-alive", "k", -1, "PersistentKeepalive")
-	generateCommand.Flags().StringVarP(&generateConfig.Endpoint, "endpoint", "e", "", "Set peer endpoint address, use --endpoint-port to specified the endpoint port")
+	generateCommand.Flags().StringVarP(&generateConfig.Endpoint, "endpoint", "e", "", "Set peer endpoint, a host name or address with an optional port like vpn.example.com:51820 or [2001:db8::1]")
 	generateCommand.Flags().Uint16Var(&generateConfig.EndpointPort, "endpoint-port", 0, "Set Peer Endpoint Port, default == --interface-listen-port")
 	generateCommand.Flags().IntVar(&generateConfig.MTU, "mtu", 1420, "Set Interface MTU")
 
+	generateCommand.Flags().StringVar(&generateSyntax, "syntax", "quick", "Syntax of the configs in text format: quick, setconf, networkd, nm or uci")
+
+	generateOutput.Bind(generateCommand, output.FormatJSON)
+	// --format networkd is text output with --syntax networkd
+	format := generateCommand.Flags().Lookup("format")
+	format.Usage += ", or a config syntax of --syntax"
+	generateQR.Bind(generateCommand)
 }
 
 func Generate(cmd *cobra.Command, arg []string) error {
+	if format, err := wireguard.ParseFormat(string(generateOutput.Format)); err == nil {
+		if syntax, _ := wireguard.ParseFormat(generateSyntax); cmd.Flags().Changed("syntax") && syntax != format {
+			return E.New("--format ", generateOutput.Format, " conflicts with --syntax ", generateSyntax)
+		}
+		generateSyntax = string(generateOutput.Format)
+		generateOutput.Format = output.FormatText
+	}
+	if err := generateOutput.Validate(); err != nil {
+		return err
+	}
+	if err := generateQR.Validate(); err != nil {
+		return err
+	}
+	format, err := wireguard.ParseFormat(generateSyntax)
+	if err != nil {
+		return err
+	}
 	gc, err := generateConfig.Build()
 	if err != nil {
 		return err
 	}
-	return generateRandom(gc, os.Stdout)
+	result, err := generateRandom(gc)
+	if err != nil {
+		return err
+	}
+	if generateOutput.Format == output.FormatText {
+		for _, warning := range exportWarnings(result, format) {
+			fmt.Fprintln(cmd.ErrOrStderr(), "warning:", warning)
+		}
+	}
+	if err = generateOutput.Print(cmd, meshPairOutput{result, format}); err != nil {
+		return err
+	}
+	if !generateQR.Enabled() {
+		return nil
+	}
+	// the apps import wg-quick configs whatever --syntax says
+	for i, peer := range result.Peers {
+		conf, err := wireguard.MarshalWireguardConf(peer.Interface, peer.Peers)
+		if err != nil {
+			return err
+		}
+		if err = generateQR.Render(cmd, fmt.Sprintf("peer-%d", i+1), conf); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func generateRandom(gc GenerateConfig, out io.Writer) error {
+func generateRandom(gc GenerateConfig) (wireguard.MeshPair, error) {
 	var (
 		rootPriv = wireguard.GenPrivateKey()
 		result   = wireguard.MeshPair{Root: wireguard.InterfaceWithPeers{
@@ -191,7 +271,7 @@ func generateRandom(gc GenerateConfig, out io.Writer) error {
 		defer v4Stop()
 		addr, ok := v4Next()
 		if ok {
-			result.Root.Interface.Addresses = append(result.Root.Interface.Addresses, addr)
+			result.Root.Interface.Addresses = append(result.Root.Interface.Addresses, netip.PrefixFrom(addr, gc.v4Prefix.Bits()))
 		}
 	}
 	if gc.IPv6 {
@@ -199,24 +279,24 @@ func generateRandom(gc GenerateConfig, out io.Writer) error {
 		defer v6Stop()
 		addr, ok := v6Next()
 		if ok {
-			result.Root.Interface.Addresses = append(result.Root.Interface.Addresses, addr)
+			result.Root.Interface.Addresses = append(result.Root.Interface.Addresses, netip.PrefixFrom(addr, gc.v6Prefix.Bits()))
 		}
 	}
 	for i := 0; i < gc.Count; i++ {
 		extended, extendedIf, err := result.Root.Extend(gc.EnablePreshared)
 		if err != nil {
-			return err
+			return wireguard.MeshPair{}, err
 		}
 		// cofigure
 		if gc.IPv4 {
 			if addr, ok := v4Next(); ok {
-				extendedIf.Interface.Addresses = append(extendedIf.Interface.Addresses, addr)
+				extendedIf.Interface.Addresses = append(extendedIf.Interface.Addresses, netip.PrefixFrom(addr, gc.v4Prefix.Bits()))
 				extended.Peers[len(extended.Peers)-1].AllowedIPs = append(extended.Peers[len(extended.Peers)-1].AllowedIPs, netip.PrefixFrom(addr, addr.BitLen()))
 			}
 		}
 		if gc.IPv6 {
 			if addr, ok := v6Next(); ok {
-				extendedIf.Interface.Addresses = append(extendedIf.Interface.Addresses, addr)
+				extendedIf.Interface.Addresses = append(extendedIf.Interface.Addresses, netip.PrefixFrom(addr, gc.v6Prefix.Bits()))
 				extended.Peers[len(extended.Peers)-1].AllowedIPs = append(extended.Peers[len(extended.Peers)-1].AllowedIPs, netip.PrefixFrom(addr, addr.BitLen()))
 			}
 		}
@@ -229,5 +309,51 @@ func generateRandom(gc GenerateConfig, out io.Writer) error {
 		result.Peers = append(result.Peers, extendedIf)
 	}
 	gc.bindInterface(&result.Root.Interface)
-	return json.NewEncoder(out).Encode(result)
+	return result, nil
+}
+
+// exportWarnings collects the warnings of exporting every node of the
+// network, each once.
+func exportWarnings(m wireguard.MeshPair, format wireguard.Format) []string {
+	var warnings []string
+	seen := make(map[string]bool)
+	for _, node := range append([]wireguard.InterfaceWithPeers{m.Root}, m.Peers...) {
+		_, nodeWarnings, _ := wireguard.Export(node.Interface, node.Peers, format, wireguard.ExportOptions{})
+		for _, warning := range nodeWarnings {
+			if !seen[warning.Error()] {
+				seen[warning.Error()] = true
+				warnings = append(warnings, warning.Error())
+			}
+		}
+	}
+	return warnings
+}
+
+// meshPairOutput renders a generated network as configs of format in text
+// mode.
+type meshPairOutput struct {
+	wireguard.MeshPair
+	format wireguard.Format
+}
+
+func (m meshPairOutput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.MeshPair)
+}
+
+func (m meshPairOutput) RenderText(w io.Writer) error {
+	for i, node := range append([]wireguard.InterfaceWithPeers{m.Root}, m.Peers...) {
+		files, _, err := wireguard.Export(node.Interface, node.Peers, m.format, wireguard.ExportOptions{})
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			fmt.Fprintln(w, "# root")
+		} else {
+			fmt.Fprintf(w, "\n# peer %d\n", i)
+		}
+		if err = printFiles(w, files); err != nil {
+			return err
+		}
+	}
+	return nil
 }
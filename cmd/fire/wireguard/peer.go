@@ -0,0 +1,514 @@
+package wireguard
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/networks/ip"
+	"github.com/woshikedayaa/fire/common/output"
+	"github.com/woshikedayaa/fire/common/wireguard"
+)
+
+var (
+	peerConfig string
+
+	peerName       string
+	peerAddresses  []string
+	peerAllowedIPs []string
+	peerEndpoint   string
+	peerKeepalive  int
+	peerDNS        []string
+	peerPreshared  bool
+	peerOut        string
+	peerClients    []string
+	peerOutput     output.Options
+	peerQR         qrOptions
+
+	peerCommand = &cobra.Command{
+		Use:   "peer",
+		Short: "Manage the peers of an existing hub config",
+		Long: `Add, remove, change and list the peers of a wg-quick config, usually the one
+of a hub. The config is edited in place: comments, the "# Name = ..."
+annotations of the peers and keys fire does not know stay as they are.`,
+	}
+	peerAddCommand = &cobra.Command{
+		Use:   "add",
+		Short: "Add a peer and write its config",
+		Long: `Generate a key pair for a new peer, give it the next free address of every
+subnet of the hub, add it to the hub config and write the config of the
+peer to --out, or print it. --qr renders the peer config as a QR code for
+the mobile apps too.`,
+		Example: `  fire wg peer add -f /etc/wireguard/wg0.conf --name laptop --endpoint vpn.example.com --out laptop.conf
+  fire wg peer add -f /etc/wireguard/wg0.conf --name phone --endpoint vpn.example.com --qr terminal`,
+		Args: cobra.NoArgs,
+		RunE: peerAdd,
+	}
+	peerRemoveCommand = &cobra.Command{
+		Use:     "remove <public-key|name>...",
+		Aliases: []string{"rm", "del"},
+		Short:   "Remove peers from the hub config",
+		Long: `Remove peers from the hub config. The configs of the removed peers given
+with --client lose the hub as their peer too.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: peerRemove,
+	}
+	peerSetCommand = &cobra.Command{
+		Use:   "set <public-key|name>",
+		Short: "Change a peer in the hub config",
+		Args:  cobra.ExactArgs(1),
+		RunE:  peerSet,
+	}
+	peerListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "List the peers of the hub config",
+		Args:  cobra.NoArgs,
+		RunE:  peerList,
+	}
+)
+
+func init() {
+	MainCommand.AddCommand(peerCommand)
+	peerCommand.AddCommand(peerAddCommand, peerRemoveCommand, peerSetCommand, peerListCommand)
+	peerCommand.PersistentFlags().StringVarP(&peerConfig, "config", "f", "", "Hub config to edit, like /etc/wireguard/wg0.conf")
+	_ = peerCommand.MarkPersistentFlagRequired("config")
+
+	flags := peerAddCommand.Flags()
+	flags.StringVar(&peerName, "name", "", `Name of the peer, kept as a "# Name = ..." comment`)
+	flags.StringSliceVar(&peerAddresses, "address", nil, "Addresses of the peer, default the next free ones of the hub subnets")
+	flags.StringSliceVar(&peerAllowedIPs, "allowed-ips", nil, "AllowedIPs of the hub in the peer config, default the hub subnets")
+	flags.StringVarP(&peerEndpoint, "endpoint", "e", "", "Endpoint of the hub in the peer config, the port defaults to the ListenPort of the hub")
+	flags.IntVarP(&peerKeepalive, "keep-alive", "k", 0, "PersistentKeepalive of the hub in the peer config")
+	flags.StringSliceVar(&peerDNS, "dns", nil, "DNS servers and search domains of the peer")
+	flags.BoolVarP(&peerPreshared, "enable-preshared", "P", false, "Add a preshared key")
+	flags.StringVarP(&peerOut, "out", "o", "", "Write the peer config to this file instead of stdout")
+	peerQR.Bind(peerAddCommand)
+
+	peerRemoveCommand.Flags().StringSliceVar(&peerClients, "client", nil, "Configs of the removed peers to remove the hub from")
+
+	flags = peerSetCommand.Flags()
+	flags.StringVar(&peerName, "name", "", "New name of the peer")
+	flags.StringSliceVar(&peerAllowedIPs, "allowed-ips", nil, "AllowedIPs of the peer")
+	flags.StringVarP(&peerEndpoint, "endpoint", "e", "", "Endpoint of the peer, empty to remove it")
+	flags.IntVarP(&peerKeepalive, "keep-alive", "k", 0, "PersistentKeepalive of the peer, 0 to remove it")
+
+	peerOutput.Bind(peerListCommand, output.FormatTable)
+}
+
+// hub is the config edited by the peer commands.
+type hub struct {
+	path      string
+	doc       *wireguard.Document
+	Interface wireguard.Interface
+	Peers     []wireguard.Peer
+}
+
+func loadHub(cmd *cobra.Command, path string) (*hub, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, E.When("read config", err)
+	}
+	h := &hub{path: path}
+	if h.doc, err = wireguard.ParseDocument(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	// strict, as a skipped peer would be lost on save, but keys fire does
+	// not know stay in the document
+	var warnings []error
+	h.Interface, h.Peers, warnings, err = h.doc.Decode(wireguard.ParseOptions{File: path, Mode: wireguard.Strict, AllowUnknown: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(cmd.ErrOrStderr(), "warning:", warning)
+	}
+	return h, nil
+}
+
+// save updates the document to the peers and replaces the file.
+func (h *hub) save() error {
+	if err := h.doc.Update(h.Interface, h.Peers); err != nil {
+		return err
+	}
+	return writeConfig(h.path, h.doc.Bytes())
+}
+
+// find returns the index of the peer with the public key or the name.
+func (h *hub) find(ref string) (int, error) {
+	var key wireguard.PublicKey
+	if key.UnmarshalText([]byte(ref)) == nil {
+		for i, peer := range h.Peers {
+			if peer.PublicKey == key {
+				return i, nil
+			}
+		}
+		return -1, E.New("no peer with the public key ", ref, " in ", h.path)
+	}
+	found := -1
+	for i, peer := range h.Peers {
+		if name, _ := h.doc.Peer(peer.PublicKey).Annotation("Name"); name == ref {
+			if found >= 0 {
+				return -1, E.New("several peers are named ", ref, ", use the public key")
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, E.New("no peer named ", ref, " in ", h.path)
+	}
+	return found, nil
+}
+
+// writeConfig replaces a config atomically, readable by its owner only as
+// it holds a private key.
+func writeConfig(path string, data []byte) error {
+	return writeFile(path, data, 0o600)
+}
+
+// writeFile replaces a file atomically with one of mode perm.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return E.When("write "+path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(perm); err == nil {
+		_, err = tmp.Write(data)
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	return E.When("write "+path, err)
+}
+
+// checkOverlap fails when prefixes overlap the AllowedIPs of a peer other
+// than pub, wireguard would route the addresses to only one of them.
+func (h *hub) checkOverlap(prefixes []netip.Prefix, pub wireguard.PublicKey) error {
+	for _, peer := range h.Peers {
+		if peer.PublicKey == pub {
+			continue
+		}
+		for _, x := range peer.AllowedIPs {
+			for _, y := range prefixes {
+				if x.Overlaps(y) {
+					return E.New(y, " overlaps the AllowedIPs ", x, " of peer ", peer.PublicKey)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		prefix, err := wireguard.ParseAddress(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// nextAddress returns the first address of subnet that neither the hub nor
+// the AllowedIPs of its peers use. The IPv4 broadcast address is skipped.
+func (h *hub) nextAddress(subnet netip.Prefix) (netip.Addr, bool) {
+	used := func(addr netip.Addr) bool {
+		for _, own := range h.Interface.Addresses {
+			if own.Addr() == addr {
+				return true
+			}
+		}
+		for _, peer := range h.Peers {
+			for _, allowed := range peer.AllowedIPs {
+				if allowed.Bits() > subnet.Bits() && allowed.Contains(addr) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for addr := range ip.PrefixIter(subnet) {
+		if addr.Is4() && subnet.Bits() < 31 && !subnet.Contains(addr.Next()) {
+			break
+		}
+		if !used(addr) {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+func peerAdd(cmd *cobra.Command, args []string) error {
+	if err := peerQR.Validate(); err != nil {
+		return err
+	}
+	h, err := loadHub(cmd, peerConfig)
+	if err != nil {
+		return err
+	}
+	var addresses []netip.Prefix
+	if len(peerAddresses) > 0 {
+		if addresses, err = parsePrefixes(peerAddresses); err != nil {
+			return err
+		}
+	} else {
+		for _, own := range h.Interface.Addresses {
+			subnet := own.Masked()
+			addr, ok := h.nextAddress(subnet)
+			if !ok {
+				return E.New("no free address left in ", subnet)
+			}
+			addresses = append(addresses, netip.PrefixFrom(addr, subnet.Bits()))
+		}
+		if len(addresses) == 0 {
+			return E.New(h.path, " has no Address to allocate from, give --address")
+		}
+	}
+	allowedIPs, err := parsePrefixes(peerAllowedIPs)
+	if err != nil {
+		return err
+	}
+	if len(allowedIPs) == 0 {
+		for _, own := range h.Interface.Addresses {
+			allowedIPs = append(allowedIPs, own.Masked())
+		}
+	}
+
+	extended, client, err := wireguard.InterfaceWithPeers{Interface: h.Interface, Peers: h.Peers}.Extend(peerPreshared)
+	if err != nil {
+		return err
+	}
+	added := &extended.Peers[len(extended.Peers)-1]
+	for _, addr := range addresses {
+		added.AllowedIPs = append(added.AllowedIPs, netip.PrefixFrom(addr.Addr(), addr.Addr().BitLen()))
+	}
+	if err = h.checkOverlap(added.AllowedIPs, added.PublicKey); err != nil {
+		return err
+	}
+
+	client.Interface.Addresses = addresses
+	for _, dns := range peerDNS {
+		if addr, err := netip.ParseAddr(dns); err == nil {
+			client.Interface.DNS = append(client.Interface.DNS, addr)
+		} else {
+			client.Interface.DNSSearch = append(client.Interface.DNSSearch, dns)
+		}
+	}
+	toHub := &client.Peers[0]
+	toHub.AllowedIPs = allowedIPs
+	toHub.PersistentKeepalive = peerKeepalive
+	if peerEndpoint != "" {
+		if toHub.Endpoint, err = wireguard.ParseEndpointDefault(peerEndpoint, h.Interface.ListenPort); err != nil {
+			return err
+		}
+	}
+	conf, err := wireguard.MarshalWireguardConf(client.Interface, client.Peers)
+	if err != nil {
+		return err
+	}
+
+	// the peer config first, a hub knowing a peer whose key is lost is worse
+	if peerOut != "" {
+		if err = writeConfig(peerOut, conf); err != nil {
+			return err
+		}
+	}
+	h.Peers = extended.Peers
+	if err = h.doc.Update(h.Interface, h.Peers); err != nil {
+		return err
+	}
+	if peerName != "" {
+		h.doc.Peer(added.PublicKey).SetAnnotation("Name", peerName)
+	}
+	if err = writeConfig(h.path, h.doc.Bytes()); err != nil {
+		return err
+	}
+	if peerQR.Enabled() {
+		if err = peerQR.Render(cmd, qrName(peerName, peerOut), conf); err != nil {
+			return err
+		}
+	}
+	if peerOut == "" {
+		_, err = cmd.OutOrStdout().Write(conf)
+		return err
+	}
+	fmt.Fprintln(cmd.ErrOrStderr(), "added peer", added.PublicKey, "with", strings.Trim(fmt.Sprint(addresses), "[]"))
+	return nil
+}
+
+// qrName names the QR code of an added peer after its name or its config.
+func qrName(name, out string) string {
+	if name != "" {
+		return name
+	}
+	if out != "" {
+		return strings.TrimSuffix(filepath.Base(out), filepath.Ext(out))
+	}
+	return "peer"
+}
+
+func peerRemove(cmd *cobra.Command, args []string) error {
+	h, err := loadHub(cmd, peerConfig)
+	if err != nil {
+		return err
+	}
+	removed := make(map[wireguard.PublicKey]bool)
+	for _, ref := range args {
+		i, err := h.find(ref)
+		if err != nil {
+			return err
+		}
+		removed[h.Peers[i].PublicKey] = true
+		h.Peers = append(h.Peers[:i], h.Peers[i+1:]...)
+	}
+
+	hubKey, err := wireguard.GenPublicKey(h.Interface.PrivateKey)
+	if err != nil {
+		return err
+	}
+	type client struct {
+		path string
+		doc  *wireguard.Document
+	}
+	var clients []client
+	for _, path := range peerClients {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return E.When("read config", err)
+		}
+		doc, err := wireguard.ParseDocument(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		iif, _, _, err := doc.Decode(wireguard.ParseOptions{File: path})
+		if err != nil {
+			return err
+		}
+		if key, err := wireguard.GenPublicKey(iif.PrivateKey); err != nil || !removed[key] {
+			return E.New(path, " is not the config of a removed peer")
+		}
+		section := doc.Peer(hubKey)
+		if section == nil {
+			return E.New(path, " has no peer for the hub")
+		}
+		doc.RemoveSection(section)
+		clients = append(clients, client{path, doc})
+	}
+
+	if err = h.save(); err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if err = writeConfig(c.path, c.doc.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func peerSet(cmd *cobra.Command, args []string) error {
+	h, err := loadHub(cmd, peerConfig)
+	if err != nil {
+		return err
+	}
+	i, err := h.find(args[0])
+	if err != nil {
+		return err
+	}
+	peer := &h.Peers[i]
+	flags := cmd.Flags()
+	if flags.Changed("allowed-ips") {
+		if peer.AllowedIPs, err = parsePrefixes(peerAllowedIPs); err != nil {
+			return err
+		}
+		if len(peer.AllowedIPs) == 0 {
+			return E.New("a peer needs AllowedIPs")
+		}
+		if err = h.checkOverlap(peer.AllowedIPs, peer.PublicKey); err != nil {
+			return err
+		}
+	}
+	if flags.Changed("keep-alive") {
+		if peerKeepalive < 0 || peerKeepalive > 65535 {
+			return E.New("invalid keepalive ", strconv.Itoa(peerKeepalive))
+		}
+		peer.PersistentKeepalive = peerKeepalive
+	}
+	if flags.Changed("endpoint") {
+		peer.Endpoint = wireguard.Endpoint{}
+		if peerEndpoint != "" {
+			if peer.Endpoint, err = wireguard.ParseEndpoint(peerEndpoint); err != nil {
+				return err
+			}
+		}
+	}
+	if err = h.doc.Update(h.Interface, h.Peers); err != nil {
+		return err
+	}
+	if flags.Changed("name") {
+		h.doc.Peer(peer.PublicKey).SetAnnotation("Name", peerName)
+	}
+	return writeConfig(h.path, h.doc.Bytes())
+}
+
+func peerList(cmd *cobra.Command, args []string) error {
+	if err := peerOutput.Validate(); err != nil {
+		return err
+	}
+	h, err := loadHub(cmd, peerConfig)
+	if err != nil {
+		return err
+	}
+	list := make(peerTable, 0, len(h.Peers))
+	for _, peer := range h.Peers {
+		name, _ := h.doc.Peer(peer.PublicKey).Annotation("Name")
+		list = append(list, peerEntry{
+			Name:                name,
+			PublicKey:           peer.PublicKey,
+			AllowedIPs:          peer.AllowedIPs,
+			Endpoint:            peer.Endpoint,
+			PersistentKeepalive: peer.PersistentKeepalive,
+		})
+	}
+	return peerOutput.Print(cmd, list)
+}
+
+// peerEntry is a listed peer, without its preshared key.
+type peerEntry struct {
+	Name                string              `json:"name,omitempty"`
+	PublicKey           wireguard.PublicKey `json:"public_key"`
+	AllowedIPs          []netip.Prefix      `json:"allowed_ips"`
+	Endpoint            wireguard.Endpoint  `json:"endpoint,omitzero"`
+	PersistentKeepalive int                 `json:"persistent_keepalive,omitempty"`
+}
+
+type peerTable []peerEntry
+
+func (peers peerTable) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(peers))
+	for _, p := range peers {
+		allowed := make([]string, 0, len(p.AllowedIPs))
+		for _, prefix := range p.AllowedIPs {
+			allowed = append(allowed, prefix.String())
+		}
+		keepalive := ""
+		if p.PersistentKeepalive > 0 {
+			keepalive = strconv.Itoa(p.PersistentKeepalive)
+		}
+		rows = append(rows, []string{p.Name, p.PublicKey.String(), strings.Join(allowed, ","), p.Endpoint.String(), keepalive})
+	}
+	return []string{"NAME", "PUBLIC KEY", "ALLOWED IPS", "ENDPOINT", "KEEPALIVE"}, rows
+}
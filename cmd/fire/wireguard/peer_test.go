@@ -0,0 +1,199 @@
+package wireguard
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// copyHub copies testdata/hub.conf into a temporary directory and points
+// --config at it.
+func copyHub(t *testing.T) (path string, data []byte) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/hub.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(t.TempDir(), "wg0.conf")
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	peerConfig = path
+	return path, data
+}
+
+// runPeer runs a peer command with flags given as name=value and returns
+// what it printed. The flags are reset afterwards.
+func runPeer(t *testing.T, cmd *cobra.Command, args []string, flags ...string) (string, error) {
+	t.Helper()
+	for _, flag := range flags {
+		name, value, _ := strings.Cut(flag, "=")
+		if err := cmd.Flags().Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			f := cmd.Flags().Lookup(name)
+			if slice, ok := f.Value.(interface{ Replace([]string) error }); ok {
+				_ = slice.Replace(nil)
+			} else {
+				_ = f.Value.Set(f.DefValue)
+			}
+			f.Changed = false
+		}()
+	}
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	err := cmd.RunE(cmd, args)
+	return out.String(), err
+}
+
+func readHub(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPeerAdd(t *testing.T) {
+	path, orig := copyHub(t)
+	out := filepath.Join(filepath.Dir(path), "tablet.conf")
+	if _, err := runPeer(t, peerAddCommand, nil, "name=tablet", "endpoint=vpn.example.com", "keep-alive=25", "out="+out); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hub keeps every line and gets the peer at the end
+	hub := readHub(t, path)
+	added, ok := strings.CutPrefix(hub, string(orig))
+	if !ok {
+		t.Fatalf("hub config changed:\n%s", hub)
+	}
+	lines := strings.Split(strings.TrimSuffix(added, "\n"), "\n")
+	if len(lines) != 5 || lines[0] != "" || lines[1] != "[Peer]" || lines[2] != "# Name = tablet" ||
+		!strings.HasPrefix(lines[3], "PublicKey = ") || lines[4] != "AllowedIPs = 10.8.0.4/32, fd00:8::4/128" {
+		t.Errorf("added to the hub:\n%s", added)
+	}
+
+	conf := readHub(t, out)
+	for _, want := range []string{
+		"Address = 10.8.0.4/24,fd00:8::4/64\n",
+		"PublicKey = HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=\nAllowedIPs = 10.8.0.0/24, fd00:8::/64\nEndpoint = vpn.example.com:51820\nPersistentKeepalive = 25\n",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("peer config misses %q:\n%s", want, conf)
+		}
+	}
+	for _, file := range []string{path, out} {
+		if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("%s: %v, %v", file, info.Mode(), err)
+		}
+	}
+}
+
+func TestPeerAddOverlap(t *testing.T) {
+	path, orig := copyHub(t)
+	_, err := runPeer(t, peerAddCommand, nil, "address=10.8.0.2/24")
+	if err == nil || !strings.Contains(err.Error(), "10.8.0.2/32 overlaps the AllowedIPs 10.8.0.2/32") {
+		t.Errorf("error = %v", err)
+	}
+	_, err = runPeer(t, peerSetCommand, []string{"phone"}, "allowed-ips=10.8.0.0/24")
+	if err == nil || !strings.Contains(err.Error(), "10.8.0.0/24 overlaps the AllowedIPs 10.8.0.2/32") {
+		t.Errorf("error = %v", err)
+	}
+	// a peer may keep its own AllowedIPs
+	if _, err = runPeer(t, peerSetCommand, []string{"phone"}, "allowed-ips=10.8.0.3/32,fd00:8::3/128"); err != nil {
+		t.Error(err)
+	}
+	if hub := readHub(t, path); hub != string(orig) {
+		t.Errorf("hub config changed:\n%s", hub)
+	}
+}
+
+func TestPeerSet(t *testing.T) {
+	path, orig := copyHub(t)
+	if _, err := runPeer(t, peerSetCommand, []string{"laptop"}, "name=desktop", "endpoint=[2001:db8::2]:51820", "keep-alive=25"); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(string(orig),
+		"# Name = laptop\n[Peer]\nPublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\nAllowedIPs = 10.8.0.2/32, fd00:8::2/128\n",
+		"# Name = desktop\n[Peer]\nPublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\nAllowedIPs = 10.8.0.2/32, fd00:8::2/128\nEndpoint = [2001:db8::2]:51820\nPersistentKeepalive = 25\n", 1)
+	if hub := readHub(t, path); hub != want {
+		t.Errorf("hub config:\n%s\nwant:\n%s", hub, want)
+	}
+
+	// by public key, removing the keepalive
+	if _, err := runPeer(t, peerSetCommand, []string{"TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="}, "keep-alive=0"); err != nil {
+		t.Fatal(err)
+	}
+	want = strings.Replace(want, "fd00:8::3/128\nPersistentKeepalive = 25\n", "fd00:8::3/128\n", 1)
+	if hub := readHub(t, path); hub != want {
+		t.Errorf("hub config:\n%s\nwant:\n%s", hub, want)
+	}
+
+	if _, err := runPeer(t, peerSetCommand, []string{"tablet"}); err == nil {
+		t.Error("set an unknown peer")
+	}
+}
+
+func TestPeerUnknownKeys(t *testing.T) {
+	path, orig := copyHub(t)
+	// keys of AmneziaWG, which fire does not know
+	conf := strings.Replace(string(orig), "ListenPort = 51820\n", "ListenPort = 51820\nJc = 4\nJmin = 40\n", 1)
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runPeer(t, peerSetCommand, []string{"laptop"}, "keep-alive=25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "warning: "+path+":5:1: unknown key Jc in [Interface]\n") {
+		t.Errorf("warnings:\n%s", out)
+	}
+	want := strings.Replace(conf, "fd00:8::2/128\n", "fd00:8::2/128\nPersistentKeepalive = 25\n", 1)
+	if hub := readHub(t, path); hub != want {
+		t.Errorf("hub config:\n%s\nwant:\n%s", hub, want)
+	}
+
+	if _, err = runPeer(t, peerAddCommand, nil, "name=tablet", "out="+filepath.Join(t.TempDir(), "tablet.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if hub := readHub(t, path); !strings.HasPrefix(hub, want+"\n[Peer]\n# Name = tablet\n") {
+		t.Errorf("hub config:\n%s", hub)
+	}
+}
+
+func TestPeerRemove(t *testing.T) {
+	path, orig := copyHub(t)
+	if _, err := runPeer(t, peerRemoveCommand, []string{"phone"}); err != nil {
+		t.Fatal(err)
+	}
+	want, _, _ := strings.Cut(string(orig), "# Name = phone")
+	if hub := readHub(t, path); hub != want {
+		t.Errorf("hub config:\n%s\nwant:\n%s", hub, want)
+	}
+}
+
+func TestPeerList(t *testing.T) {
+	copyHub(t)
+	out, err := runPeer(t, peerListCommand, nil, "format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compact bytes.Buffer
+	if err = json.Compact(&compact, []byte(out)); err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"name":"laptop","public_key":"xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=","allowed_ips":["10.8.0.2/32","fd00:8::2/128"]},` +
+		`{"name":"phone","public_key":"TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=","allowed_ips":["10.8.0.3/32","fd00:8::3/128"],"persistent_keepalive":25}]`
+	if compact.String() != want {
+		t.Errorf("list:\n%s\nwant:\n%s", compact.String(), want)
+	}
+}
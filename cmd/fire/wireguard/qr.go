@@ -0,0 +1,87 @@
+package wireguard
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/qrcode"
+)
+
+const (
+	qrTerminal = "terminal"
+	qrPNG      = "png"
+	qrSVG      = "svg"
+)
+
+// qrOptions renders client configs as QR codes for the mobile apps of
+// wireguard. Terminal art goes to stderr, keeping stdout for the configs.
+type qrOptions struct {
+	Format string
+	Dir    string
+	Level  string
+	Scale  int
+	Invert bool
+
+	level qrcode.Level
+}
+
+func (o *qrOptions) Bind(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&o.Format, "qr", "", "Render the client configs as QR codes: terminal, png or svg")
+	flags.StringVar(&o.Dir, "qr-dir", ".", "Directory of the png and svg QR codes")
+	flags.StringVar(&o.Level, "qr-level", "L", "Error correction level of the QR codes: L, M, Q or H")
+	flags.IntVar(&o.Scale, "qr-scale", 8, "Pixels per module of the png QR codes")
+	flags.BoolVar(&o.Invert, "qr-invert", false, "Draw the dark modules of terminal QR codes, for terminals with a light background")
+}
+
+func (o *qrOptions) Validate() (err error) {
+	switch o.Format {
+	case "", qrTerminal, qrPNG, qrSVG:
+	default:
+		return E.New("unknown QR format ", o.Format, ", expected terminal, png or svg")
+	}
+	if o.Scale <= 0 {
+		return E.New("invalid QR scale ", o.Scale)
+	}
+	o.level, err = qrcode.ParseLevel(o.Level)
+	return err
+}
+
+func (o *qrOptions) Enabled() bool {
+	return o.Format != ""
+}
+
+// Render renders conf as a QR code named name, a file name for png and svg.
+func (o *qrOptions) Render(cmd *cobra.Command, name string, conf []byte) error {
+	code, err := qrcode.Encode(conf, o.level)
+	if err != nil {
+		return E.When("encode QR code of "+name, err)
+	}
+	if o.Format == qrTerminal {
+		fmt.Fprintf(cmd.ErrOrStderr(), "# %s\n%s", name, code.Terminal(o.Invert))
+		return nil
+	}
+	var data []byte
+	if o.Format == qrPNG {
+		if data, err = code.PNG(o.Scale); err != nil {
+			return err
+		}
+	} else {
+		data = code.SVG()
+	}
+	// the code holds a private key like the config itself
+	path := filepath.Join(o.Dir, qrFileName(name)+"."+o.Format)
+	if err = writeFile(path, data, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.ErrOrStderr(), "wrote", path)
+	return nil
+}
+
+// qrFileName keeps a peer name from escaping the QR directory.
+func qrFileName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(strings.TrimLeft(name, "."))
+}
@@ -0,0 +1,43 @@
+package wireguard
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestQRRender(t *testing.T) {
+	dir := t.TempDir()
+	o := qrOptions{Format: qrSVG, Dir: dir, Level: "L", Scale: 8}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// an old code readable by everyone becomes private
+	path := filepath.Join(dir, "laptop.svg")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &cobra.Command{}
+	cmd.SetErr(io.Discard)
+	if err := o.Render(cmd, "laptop", []byte("[Interface]\n")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("%s: %v, %v", path, info.Mode(), err)
+	}
+	if data, _ := os.ReadFile(path); !strings.HasPrefix(string(data), "<?xml") {
+		t.Errorf("QR code:\n%s", data)
+	}
+
+	if err = o.Render(cmd, "../phone", []byte("[Interface]\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "_phone.svg")); err != nil {
+		t.Error(err)
+	}
+}
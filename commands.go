@@ -1,12 +1,14 @@
 package main
 
 import (
+	"github.com/woshikedayaa/fire/cmd/fire/doctor"
 	"github.com/woshikedayaa/fire/cmd/fire/nftables"
 	"github.com/woshikedayaa/fire/cmd/fire/wireguard"
 )
 
 func init() {
 	mainCommand.AddCommand(
+		doctor.MainCommand,
 		nftables.MainCommand,
 		wireguard.MainCommand,
 	)
@@ -0,0 +1,47 @@
+package errors
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PositionError is an error at a position of a file, printed as
+// file:line:column like compilers do. Line and Column count from 1, a zero
+// Line or Column is left out.
+type PositionError struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *PositionError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	var position []string
+	if e.File != "" {
+		position = append(position, e.File)
+	}
+	if e.Line > 0 {
+		position = append(position, strconv.Itoa(e.Line))
+		if e.Column > 0 {
+			position = append(position, strconv.Itoa(e.Column))
+		}
+	}
+	if len(position) == 0 {
+		return e.Err.Error()
+	}
+	return strings.Join(position, ":") + ": " + e.Err.Error()
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+func At(file string, line, column int, e error) error {
+	if e == nil {
+		return nil
+	}
+	return &PositionError{File: file, Line: line, Column: column, Err: e}
+}
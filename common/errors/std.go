@@ -0,0 +1,15 @@
+package errors
+
+import "errors"
+
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+func As(err error, target any) bool {
+	return errors.As(err, target)
+}
+
+func Join(errs ...error) error {
+	return errors.Join(errs...)
+}
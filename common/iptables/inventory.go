@@ -0,0 +1,44 @@
+package iptables
+
+// TableInventory is a per-chain summary of a table.
+type TableInventory struct {
+	Name   string           `json:"name"`
+	Chains []ChainInventory `json:"chains"`
+}
+
+type ChainInventory struct {
+	Name    string   `json:"name"`
+	Policy  string   `json:"policy,omitempty"`
+	Packets uint64   `json:"packets"`
+	Bytes   uint64   `json:"bytes"`
+	Rules   int      `json:"rules"`
+	Targets []string `json:"targets,omitempty"`
+
+	// RulePackets and RuleBytes add up the counters of the rules.
+	RulePackets uint64 `json:"rule_packets"`
+	RuleBytes   uint64 `json:"rule_bytes"`
+}
+
+func Inventory(tables []*Table) []TableInventory {
+	inv := make([]TableInventory, 0, len(tables))
+	for _, table := range tables {
+		t := TableInventory{Name: table.Name, Chains: make([]ChainInventory, 0, len(table.Chains))}
+		for _, chain := range table.Chains {
+			c := ChainInventory{
+				Name:    chain.Name,
+				Policy:  chain.Policy,
+				Packets: chain.Packets,
+				Bytes:   chain.Bytes,
+				Rules:   len(chain.Rules),
+				Targets: chain.Targets(),
+			}
+			for _, rule := range chain.Rules {
+				c.RulePackets += rule.Packets
+				c.RuleBytes += rule.Bytes
+			}
+			t.Chains = append(t.Chains, c)
+		}
+		inv = append(inv, t)
+	}
+	return inv
+}
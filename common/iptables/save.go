@@ -0,0 +1,245 @@
+// Package iptables parses the output of iptables-save and its ip6tables,
+// ebtables and arptables siblings.
+package iptables
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+type Table struct {
+	Name   string   `json:"name"`
+	Chains []*Chain `json:"chains"`
+}
+
+type Chain struct {
+	Name string `json:"name"`
+	// Policy is empty for user-defined chains.
+	Policy  string  `json:"policy,omitempty"`
+	Packets uint64  `json:"packets"`
+	Bytes   uint64  `json:"bytes"`
+	Rules   []*Rule `json:"rules,omitempty"`
+}
+
+type Rule struct {
+	Args []string `json:"args"`
+	// Target is the chain or target of -j/--jump or -g/--goto.
+	Target  string `json:"target,omitempty"`
+	Goto    bool   `json:"goto,omitempty"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// Parse reads a dump in save format. Counters are understood in the forms
+// written with -c by iptables-save ("[p:b] -A ..."), ebtables-save
+// ("-A ... -c p b") and legacy arptables-save ("-A ... , pcnt=p -- bcnt=b").
+func Parse(r io.Reader) ([]*Table, error) {
+	var (
+		tables []*Table
+		table  *Table
+		lineNo int
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+		case line == "COMMIT":
+			table = nil
+		case line[0] == '*':
+			table = &Table{Name: line[1:]}
+			tables = append(tables, table)
+		case table == nil:
+			return nil, E.New("line ", lineNo, ": ", strconv.Quote(line), " outside of a table")
+		case line[0] == ':':
+			chain, err := parseChain(line[1:])
+			if err != nil {
+				return nil, E.New("line ", lineNo, ": ", err)
+			}
+			if c := table.Chain(chain.Name); c != nil {
+				c.Policy, c.Packets, c.Bytes = chain.Policy, chain.Packets, chain.Bytes
+			} else {
+				table.Chains = append(table.Chains, chain)
+			}
+		default:
+			name, rule, err := parseRule(line)
+			if err != nil {
+				return nil, E.New("line ", lineNo, ": ", err)
+			}
+			chain := table.Chain(name)
+			if chain == nil {
+				chain = &Chain{Name: name}
+				table.Chains = append(table.Chains, chain)
+			}
+			chain.Rules = append(chain.Rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+func (t *Table) Chain(name string) *Chain {
+	for _, chain := range t.Chains {
+		if chain.Name == name {
+			return chain
+		}
+	}
+	return nil
+}
+
+// Targets returns the distinct rule targets in order of first use.
+func (c *Chain) Targets() []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, rule := range c.Rules {
+		if rule.Target != "" && !seen[rule.Target] {
+			seen[rule.Target] = true
+			targets = append(targets, rule.Target)
+		}
+	}
+	return targets
+}
+
+// parseChain parses "NAME POLICY [packets:bytes]", the counters are optional.
+func parseChain(s string) (*Chain, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, E.New("empty chain declaration")
+	}
+	chain := &Chain{Name: fields[0]}
+	if len(fields) > 1 && fields[1] != "-" {
+		chain.Policy = fields[1]
+	}
+	if len(fields) > 2 {
+		var err error
+		if chain.Packets, chain.Bytes, err = parseCounters(fields[2]); err != nil {
+			return nil, err
+		}
+	}
+	return chain, nil
+}
+
+// parseCounters parses "[packets:bytes]".
+func parseCounters(s string) (uint64, uint64, error) {
+	inner, ok := strings.CutPrefix(s, "[")
+	if inner, ok = strings.CutSuffix(inner, "]"); !ok {
+		return 0, 0, E.New("invalid counters ", s)
+	}
+	p, b, ok := strings.Cut(inner, ":")
+	if !ok {
+		return 0, 0, E.New("invalid counters ", s)
+	}
+	return parseCounterPair(p, b)
+}
+
+func parseCounterPair(p, b string) (uint64, uint64, error) {
+	packets, err := strconv.ParseUint(p, 10, 64)
+	if err != nil {
+		return 0, 0, E.New("invalid packet counter ", p)
+	}
+	bytes, err := strconv.ParseUint(b, 10, 64)
+	if err != nil {
+		return 0, 0, E.New("invalid byte counter ", b)
+	}
+	return packets, bytes, nil
+}
+
+func parseRule(line string) (string, *Rule, error) {
+	rule := &Rule{}
+	if strings.HasPrefix(line, "[") {
+		counters, rest, _ := strings.Cut(line, " ")
+		var err error
+		if rule.Packets, rule.Bytes, err = parseCounters(counters); err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimSpace(rest)
+	}
+	// arptables-save
+	if spec, counters, ok := strings.Cut(line, " , pcnt="); ok {
+		p, b, _ := strings.Cut(counters, " -- bcnt=")
+		var err error
+		if rule.Packets, rule.Bytes, err = parseCounterPair(strings.TrimSpace(p), strings.TrimSpace(b)); err != nil {
+			return "", nil, err
+		}
+		line = spec
+	}
+
+	args, err := splitArgs(line)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(args) < 2 || (args[0] != "-A" && args[0] != "--append") {
+		return "", nil, E.New("unexpected ", strconv.Quote(line))
+	}
+	chain := args[1]
+	args = args[2:]
+	// ebtables-save
+	if n := len(args); n >= 3 && args[n-3] == "-c" {
+		if rule.Packets, rule.Bytes, err = parseCounterPair(args[n-2], args[n-1]); err != nil {
+			return "", nil, err
+		}
+		args = args[:n-3]
+	}
+	rule.Args = args
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-j", "--jump":
+			rule.Target = args[i+1]
+		case "-g", "--goto":
+			rule.Target, rule.Goto = args[i+1], true
+		}
+	}
+	return chain, rule, nil
+}
+
+// splitArgs splits a rule like a shell would, iptables-save quotes arguments
+// containing spaces, e.g. comments.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   byte
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' && i+1 < len(s) {
+				i++
+				current.WriteByte(s[i])
+			} else if c == quote {
+				quote = 0
+			} else {
+				current.WriteByte(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, E.New("unterminated quote in ", strconv.Quote(s))
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,112 @@
+package iptables
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseFile(t *testing.T, name string) []*Table {
+	t.Helper()
+	file, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tables, err := Parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tables
+}
+
+func TestParseIptablesSave(t *testing.T) {
+	tables := parseFile(t, "iptables.save")
+	if len(tables) != 2 || tables[0].Name != "filter" || tables[1].Name != "nat" {
+		t.Fatalf("tables = %+v", tables)
+	}
+	filter := tables[0]
+
+	input := filter.Chain("INPUT")
+	if input.Policy != "DROP" || input.Packets != 120 || input.Bytes != 9600 || len(input.Rules) != 2 {
+		t.Errorf("INPUT = %+v", input)
+	}
+	want := &Rule{
+		Args:    []string{"-p", "tcp", "-m", "tcp", "--dport", "22", "-m", "comment", "--comment", `ssh "admin" access`, "-j", "ACCEPT"},
+		Target:  "ACCEPT",
+		Packets: 4,
+		Bytes:   240,
+	}
+	if !reflect.DeepEqual(input.Rules[1], want) {
+		t.Errorf("INPUT rule 2 = %+v, want %+v", input.Rules[1], want)
+	}
+
+	if docker := filter.Chain("DOCKER"); docker.Policy != "" || len(docker.Rules) != 1 {
+		t.Errorf("DOCKER = %+v", docker)
+	}
+	if rule := filter.Chain("FORWARD").Rules[1]; rule.Target != "DOCKER" || !rule.Goto || rule.Packets != 2 {
+		t.Errorf("FORWARD goto rule = %+v", rule)
+	}
+	// chains without a declaration are created by their first rule, not by a jump
+	if docker := tables[1].Chain("DOCKER"); docker != nil {
+		t.Errorf("nat DOCKER = %+v", docker)
+	}
+	if postrouting := tables[1].Chain("POSTROUTING"); postrouting == nil || postrouting.Policy != "" || len(postrouting.Rules) != 1 {
+		t.Errorf("nat POSTROUTING = %+v", postrouting)
+	}
+	if filter.Chain("missing") != nil {
+		t.Error("Chain(missing) is not nil")
+	}
+}
+
+func TestParseEbtablesSave(t *testing.T) {
+	forward := parseFile(t, "ebtables.save")[0].Chain("FORWARD")
+	want := &Rule{Args: []string{"-p", "IPv4", "--ip-proto", "udp", "-j", "ACCEPT"}, Target: "ACCEPT", Packets: 5, Bytes: 400}
+	if forward.Policy != "DROP" || len(forward.Rules) != 1 || !reflect.DeepEqual(forward.Rules[0], want) {
+		t.Errorf("FORWARD = %+v", forward)
+	}
+}
+
+func TestParseArptablesSave(t *testing.T) {
+	input := parseFile(t, "arptables.save")[0].Chain("INPUT")
+	want := &Rule{Args: []string{"-j", "ACCEPT", "-i", "eth0"}, Target: "ACCEPT", Packets: 7, Bytes: 294}
+	if len(input.Rules) != 1 || !reflect.DeepEqual(input.Rules[0], want) {
+		t.Errorf("INPUT = %+v", input)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct{ dump, err string }{
+		{"-A INPUT -j ACCEPT\n", `line 1: "-A INPUT -j ACCEPT" outside of a table`},
+		{"*filter\n:INPUT ACCEPT [1:x]\n", "line 2: invalid byte counter x"},
+		{"*filter\n:INPUT ACCEPT 1:2\n", "line 2: invalid counters 1:2"},
+		{"*filter\n[1:2 -A INPUT\n", "line 2: invalid counters [1:2"},
+		{"*filter\n-I INPUT -j ACCEPT\n", `line 2: unexpected "-I INPUT -j ACCEPT"`},
+		{"*filter\n-A INPUT -m comment --comment \"open\n", `line 2: unterminated quote in "-A INPUT -m comment --comment \"open"`},
+		{"*filter\n-A FORWARD -j ACCEPT -c 1 x\n", "line 2: invalid byte counter x"},
+	} {
+		if _, err := Parse(strings.NewReader(tt.dump)); err == nil || err.Error() != tt.err {
+			t.Errorf("Parse(%q) error = %v, want %s", tt.dump, err, tt.err)
+		}
+	}
+}
+
+func TestInventory(t *testing.T) {
+	got, err := json.Marshal(Inventory(parseFile(t, "iptables.save")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"name":"filter","chains":[` +
+		`{"name":"INPUT","policy":"DROP","packets":120,"bytes":9600,"rules":2,"targets":["ACCEPT"],"rule_packets":84,"rule_bytes":6640},` +
+		`{"name":"FORWARD","policy":"ACCEPT","packets":0,"bytes":0,"rules":2,"targets":["DOCKER"],"rule_packets":2,"rule_bytes":120},` +
+		`{"name":"OUTPUT","policy":"ACCEPT","packets":300,"bytes":45000,"rules":0,"rule_packets":0,"rule_bytes":0},` +
+		`{"name":"DOCKER","packets":0,"bytes":0,"rules":1,"targets":["ACCEPT"],"rule_packets":1,"rule_bytes":60}]},` +
+		`{"name":"nat","chains":[` +
+		`{"name":"PREROUTING","policy":"ACCEPT","packets":10,"bytes":600,"rules":1,"targets":["DOCKER"],"rule_packets":3,"rule_bytes":180},` +
+		`{"name":"POSTROUTING","packets":0,"bytes":0,"rules":1,"targets":["MASQUERADE"],"rule_packets":0,"rule_bytes":0}]}]`
+	if string(got) != want {
+		t.Errorf("Inventory:\n%s\nwant:\n%s", got, want)
+	}
+}
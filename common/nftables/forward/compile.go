@@ -0,0 +1,117 @@
+package forward
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/woshikedayaa/fire/common/nftables/set"
+)
+
+// Compile renders the forwards as an nft script that atomically replaces
+// the table. Without forwards the script only deletes the table.
+//
+// A forward is a dnat rule in prerouting and an accept of the translated
+// connections in forward. The accept cannot override a drop of another
+// table, a firewall dropping forwarded traffic still has to allow it. With
+// hairpin, connections from other interfaces to an address of the host are
+// translated too and masqueraded, so the answers pass the host again.
+func (s *State) Compile() (string, error) {
+	var sb strings.Builder
+	line := func(depth int, format string, a ...any) {
+		if format == "" {
+			sb.WriteByte('\n')
+			return
+		}
+		sb.WriteString(strings.Repeat("\t", depth))
+		fmt.Fprintf(&sb, format, a...)
+		sb.WriteByte('\n')
+	}
+	// declaring the table first lets the delete succeed when it does not exist yet
+	line(0, "table inet %s", s.Table)
+	line(0, "delete table inet %s", s.Table)
+	if len(s.Forwards) == 0 {
+		return sb.String(), nil
+	}
+
+	var prerouting, forward, postrouting []string
+	line(0, "table inet %s {", s.Table)
+	for i := range s.Forwards {
+		f := &s.Forwards[i]
+		if err := f.Validate(); err != nil {
+			return "", err
+		}
+		ip, setType := "ip", set.TypeIpv4Addr
+		if f.To.Is6() {
+			ip, setType = "ip6", set.TypeIpv6Addr
+		}
+		service := func(port string) string {
+			if len(f.Protocols) == 1 {
+				return f.Protocols[0] + " dport " + port
+			}
+			return "meta l4proto " + list(f.Protocols) + " th dport " + port
+		}
+		to := f.To.String()
+		if f.To.Is6() {
+			to = "[" + to + "]"
+		}
+		toPort := f.Port
+		if f.ToPort != "" {
+			to += ":" + f.ToPort
+			toPort = f.ToPort
+		}
+		comment := ""
+		if f.Comment != "" {
+			comment = " comment " + strconv.Quote(f.Comment)
+		}
+
+		var match []string
+		if len(f.Sources) > 0 {
+			sources := set.Set{Name: f.Name + "_sources", Type: setType, Flag: []set.Flag{set.FlagInterval}, AutoMerge: true}
+			for _, source := range f.Sources {
+				sources.Elements = append(sources.Elements, source.String())
+			}
+			line(1, "%s", sources.AsNamed())
+			match = append(match, ip+" saddr @"+sources.Name)
+		}
+		dnat := strings.Join(append(match, service(f.Port), "dnat "+ip+" to "+to), " ") + comment
+		if f.Interface == "" {
+			prerouting = append(prerouting, "fib daddr type local "+dnat)
+		} else {
+			prerouting = append(prerouting, "iifname "+strconv.Quote(f.Interface)+" "+dnat)
+		}
+		target := ip + " daddr " + f.To.String() + " " + service(toPort)
+		forward = append(forward, "ct status dnat "+target+" accept"+comment)
+		if f.Hairpin {
+			notWAN := "iifname != " + strconv.Quote(f.Interface) + " "
+			prerouting = append(prerouting, notWAN+"fib daddr type local "+dnat)
+			postrouting = append(postrouting, notWAN+"ct status dnat "+target+" masquerade"+comment)
+		}
+	}
+
+	chain := func(name, hook string, rules []string) {
+		if len(rules) == 0 {
+			return
+		}
+		line(1, "")
+		line(1, "chain %s {", name)
+		line(2, "%s", hook)
+		for _, rule := range rules {
+			line(2, "%s", rule)
+		}
+		line(1, "}")
+	}
+	chain("prerouting", "type nat hook prerouting priority dstnat; policy accept;", prerouting)
+	chain("forward", "type filter hook forward priority filter; policy accept;", forward)
+	chain("postrouting", "type nat hook postrouting priority srcnat; policy accept;", postrouting)
+	line(0, "}")
+	return sb.String(), nil
+}
+
+// list prints one value as is and several as an anonymous set.
+func list(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return (&set.Set{Elements: values}).AsAnonymous()
+}
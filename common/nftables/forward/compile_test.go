@@ -0,0 +1,63 @@
+package forward
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	s := &State{Table: "fire_forward"}
+	for _, f := range []Forward{
+		{Port: "2222", To: netip.MustParseAddr("10.0.0.2"), ToPort: "22", Comment: "ssh of \"db\""},
+		{Protocols: []string{"tcp", "udp"}, Port: "27015-27030", To: netip.MustParseAddr("10.0.0.3"),
+			Sources: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, Interface: "eth0", Hairpin: true},
+		{Protocols: []string{"udp"}, Port: "51820", To: netip.MustParseAddr("2001:db8::2"), Interface: "eth0"},
+	} {
+		if err := s.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `table inet fire_forward
+delete table inet fire_forward
+table inet fire_forward {
+	set tcp_udp_27015_27030_sources{type ipv4_addr;flags interval;auto-merge;elements={192.0.2.0/24};}
+
+	chain prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+		fib daddr type local tcp dport 2222 dnat ip to 10.0.0.2:22 comment "ssh of \"db\""
+		iifname "eth0" ip saddr @tcp_udp_27015_27030_sources meta l4proto {tcp,udp} th dport 27015-27030 dnat ip to 10.0.0.3
+		iifname != "eth0" fib daddr type local ip saddr @tcp_udp_27015_27030_sources meta l4proto {tcp,udp} th dport 27015-27030 dnat ip to 10.0.0.3
+		iifname "eth0" udp dport 51820 dnat ip6 to [2001:db8::2]
+	}
+
+	chain forward {
+		type filter hook forward priority filter; policy accept;
+		ct status dnat ip daddr 10.0.0.2 tcp dport 22 accept comment "ssh of \"db\""
+		ct status dnat ip daddr 10.0.0.3 meta l4proto {tcp,udp} th dport 27015-27030 accept
+		ct status dnat ip6 daddr 2001:db8::2 udp dport 51820 accept
+	}
+
+	chain postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		iifname != "eth0" ct status dnat ip daddr 10.0.0.3 meta l4proto {tcp,udp} th dport 27015-27030 masquerade
+	}
+}
+`
+	if got != want {
+		t.Errorf("compiled:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCompileEmpty(t *testing.T) {
+	got, err := (&State{Table: "fire_forward"}).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "table inet fire_forward\ndelete table inet fire_forward\n" {
+		t.Errorf("compiled:\n%s", got)
+	}
+}
@@ -0,0 +1,223 @@
+// Package forward manages DNAT port forwards in an inet table owned by fire.
+// The forwards are kept in a state file and the table is regenerated from
+// it on every change, so applying it is idempotent and deleting the table
+// removes every trace.
+package forward
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+const (
+	DefaultTable = "fire_forward"
+	DefaultState = "/var/lib/fire/forwards.json"
+)
+
+var (
+	namePattern  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	ifacePattern = regexp.MustCompile(`^[^\s"/]{1,15}$`)
+)
+
+// Forward sends connections to Port (a port or a range like 8000-8010) of
+// the host to To. ToPort defaults to the same port; a range always keeps
+// the port of the connection.
+type Forward struct {
+	Name      string         `json:"name"`
+	Protocols []string       `json:"protocols"`
+	Port      string         `json:"port"`
+	To        netip.Addr     `json:"to"`
+	ToPort    string         `json:"to_port,omitempty"`
+	Sources   []netip.Prefix `json:"sources,omitempty"`
+	// Interface restricts the forward to connections arriving on the WAN
+	// interface, otherwise it applies to every address of the host.
+	Interface string `json:"interface,omitempty"`
+	// Hairpin lets hosts behind other interfaces reach the forward through
+	// the address of the host, it requires Interface.
+	Hairpin bool   `json:"hairpin,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// portRange parses a port or a range of ports.
+func portRange(s string) (uint16, uint16, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	from, err := strconv.ParseUint(first, 10, 16)
+	if err != nil || from == 0 {
+		return 0, 0, E.New("invalid port ", s)
+	}
+	to := from
+	if isRange {
+		if to, err = strconv.ParseUint(last, 10, 16); err != nil || to < from {
+			return 0, 0, E.New("invalid port range ", s)
+		}
+	}
+	return uint16(from), uint16(to), nil
+}
+
+// DefaultName names a forward after its protocols and port, e.g. tcp_8080.
+func (f *Forward) DefaultName() string {
+	return strings.Join(f.Protocols, "_") + "_" + strings.ReplaceAll(f.Port, "-", "_")
+}
+
+func (f *Forward) Validate() error {
+	if len(f.Protocols) == 0 {
+		f.Protocols = []string{"tcp"}
+	}
+	slices.Sort(f.Protocols)
+	f.Protocols = slices.Compact(f.Protocols)
+	for _, protocol := range f.Protocols {
+		if protocol != "tcp" && protocol != "udp" {
+			return E.New("unsupported protocol ", protocol, ", forwards are tcp or udp")
+		}
+	}
+	if f.Name == "" {
+		f.Name = f.DefaultName()
+	}
+	if !namePattern.MatchString(f.Name) {
+		return E.New("invalid forward name ", strconv.Quote(f.Name))
+	}
+	from, to, err := portRange(f.Port)
+	if err != nil {
+		return err
+	}
+	if f.ToPort != "" {
+		toFrom, toTo, err := portRange(f.ToPort)
+		if err != nil {
+			return err
+		}
+		switch {
+		case toFrom == from && toTo == to:
+			f.ToPort = ""
+		case from != to:
+			return E.New("a port range is forwarded to the same ports, omit the target port")
+		case toFrom != toTo:
+			return E.New("target port ", f.ToPort, " is a range")
+		}
+	}
+	if !f.To.IsValid() {
+		return E.New("forward ", f.Name, " has no target address")
+	}
+	f.To = f.To.Unmap()
+	for i, source := range f.Sources {
+		f.Sources[i] = source.Masked()
+		if source.Addr().Is4() != f.To.Is4() {
+			return E.New("source ", source, " and target ", f.To, " are of different families")
+		}
+	}
+	if f.Interface != "" && !ifacePattern.MatchString(f.Interface) {
+		return E.New("invalid interface name ", strconv.Quote(f.Interface))
+	}
+	if f.Hairpin && f.Interface == "" {
+		return E.New("hairpin needs the interface the forward applies to")
+	}
+	return nil
+}
+
+// overlaps reports whether both forwards would catch the same connections.
+func (f *Forward) overlaps(other *Forward) bool {
+	if f.Interface != "" && other.Interface != "" && f.Interface != other.Interface {
+		return false
+	}
+	if f.To.Is4() != other.To.Is4() {
+		return false
+	}
+	shared := false
+	for _, protocol := range f.Protocols {
+		shared = shared || slices.Contains(other.Protocols, protocol)
+	}
+	if !shared {
+		return false
+	}
+	from, to, _ := portRange(f.Port)
+	otherFrom, otherTo, _ := portRange(other.Port)
+	return from <= otherTo && otherFrom <= to
+}
+
+type State struct {
+	Table    string    `json:"table"`
+	Forwards []Forward `json:"forwards"`
+}
+
+// Load reads the state file, a missing file is an empty state.
+func Load(path string) (*State, error) {
+	state := &State{Table: DefaultTable}
+	data, err := os.ReadFile(path)
+	if E.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, E.When("read forward state", err)
+	}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, E.When("decode forward state "+path, err)
+	}
+	if state.Table == "" {
+		state.Table = DefaultTable
+	}
+	return state, nil
+}
+
+// Save replaces the state file atomically.
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return E.When("create state directory", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".forwards-*")
+	if err != nil {
+		return E.When("save forward state", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(data, '\n')); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	return E.When("save forward state", err)
+}
+
+// Add validates f and adds it, it must not overlap an existing forward.
+func (s *State) Add(f Forward) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	for i := range s.Forwards {
+		existing := &s.Forwards[i]
+		if existing.Name == f.Name {
+			return E.New("forward ", f.Name, " exists")
+		}
+		if existing.overlaps(&f) {
+			return E.New("forward ", f.Name, " overlaps ", existing.Name, " (", strings.Join(existing.Protocols, ","), " ", existing.Port, ")")
+		}
+	}
+	s.Forwards = append(s.Forwards, f)
+	return nil
+}
+
+// Remove deletes the named forwards, each of them must exist.
+func (s *State) Remove(names ...string) error {
+	for _, name := range names {
+		i := slices.IndexFunc(s.Forwards, func(f Forward) bool { return f.Name == name })
+		if i < 0 {
+			return E.New("no forward named ", name)
+		}
+		s.Forwards = slices.Delete(s.Forwards, i, i+1)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package forward
+
+import (
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	f := Forward{Protocols: []string{"udp", "tcp", "udp"}, Port: "8000-8010", To: netip.MustParseAddr("::ffff:10.0.0.2"), ToPort: "8000-8010",
+		Sources: []netip.Prefix{netip.MustParsePrefix("192.0.2.7/24")}}
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := Forward{Name: "tcp_udp_8000_8010", Protocols: []string{"tcp", "udp"}, Port: "8000-8010", To: netip.MustParseAddr("10.0.0.2"),
+		Sources: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("validated\n%+v\nwant\n%+v", f, want)
+	}
+
+	to := netip.MustParseAddr("10.0.0.2")
+	for _, tt := range []struct {
+		f   Forward
+		err string
+	}{
+		{Forward{Protocols: []string{"sctp"}, Port: "80", To: to}, "unsupported protocol sctp, forwards are tcp or udp"},
+		{Forward{Name: "1st", Port: "80", To: to}, `invalid forward name "1st"`},
+		{Forward{Port: "0", To: to}, "invalid port 0"},
+		{Forward{Port: "90-80", To: to}, "invalid port range 90-80"},
+		{Forward{Port: "80-90", ToPort: "8080", To: to}, "a port range is forwarded to the same ports, omit the target port"},
+		{Forward{Port: "80", ToPort: "8080-8081", To: to}, "target port 8080-8081 is a range"},
+		{Forward{Port: "80"}, "forward tcp_80 has no target address"},
+		{Forward{Port: "80", To: to, Sources: []netip.Prefix{netip.MustParsePrefix("2001:db8::/32")}},
+			"source 2001:db8::/32 and target 10.0.0.2 are of different families"},
+		{Forward{Port: "80", To: to, Interface: "eth0 wan"}, `invalid interface name "eth0 wan"`},
+		{Forward{Port: "80", To: to, Hairpin: true}, "hairpin needs the interface the forward applies to"},
+	} {
+		if err := tt.f.Validate(); err == nil || err.Error() != tt.err {
+			t.Errorf("Validate(%+v) error = %v, want %s", tt.f, err, tt.err)
+		}
+	}
+}
+
+func TestStateAddRemove(t *testing.T) {
+	s := &State{Table: DefaultTable}
+	to := netip.MustParseAddr("10.0.0.2")
+	for _, f := range []Forward{
+		{Port: "8000-8010", To: to, Interface: "eth0"},
+		{Protocols: []string{"udp"}, Port: "8005", To: to},
+		{Port: "8005", To: netip.MustParseAddr("2001:db8::2")},
+		{Name: "wan2", Port: "8005", To: to, Interface: "eth1"},
+	} {
+		if err := s.Add(f); err != nil {
+			t.Fatalf("Add(%+v): %s", f, err)
+		}
+	}
+	for _, tt := range []struct {
+		f   Forward
+		err string
+	}{
+		{Forward{Port: "8010-8020", To: to}, "forward tcp_8010_8020 overlaps tcp_8000_8010 (tcp 8000-8010)"},
+		{Forward{Protocols: []string{"udp"}, Port: "9000", To: to, Name: "udp_8005"}, "forward udp_8005 exists"},
+		{Forward{Name: "wan2b", Port: "8005", To: to, Interface: "eth1"}, "forward wan2b overlaps wan2 (tcp 8005)"},
+	} {
+		if err := s.Add(tt.f); err == nil || err.Error() != tt.err {
+			t.Errorf("Add(%+v) error = %v, want %s", tt.f, err, tt.err)
+		}
+	}
+
+	if err := s.Remove("udp_8005", "missing"); err == nil || err.Error() != "no forward named missing" {
+		t.Errorf("Remove error = %v", err)
+	}
+	if err := s.Remove("tcp_8000_8010"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range s.Forwards {
+		names = append(names, f.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"tcp_8005", "wan2"}) {
+		t.Errorf("forwards after Remove = %v", names)
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "forwards.json")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Table != DefaultTable || len(s.Forwards) != 0 {
+		t.Fatalf("missing state = %+v", s)
+	}
+	if err = s.Add(Forward{Port: "22", ToPort: "2222", To: netip.MustParseAddr("10.0.0.2"), Comment: "ssh"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, s) {
+		t.Errorf("loaded\n%+v\nsaved\n%+v", loaded, s)
+	}
+}
@@ -0,0 +1,339 @@
+// Package monitor decodes the event stream of `nft -j monitor` into typed events.
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+)
+
+type Action string
+
+const (
+	ActionAdd     Action = "add"
+	ActionDelete  Action = "delete"
+	ActionReplace Action = "replace"
+	ActionTrace   Action = "trace"
+)
+
+type Kind string
+
+const (
+	KindTable     Kind = "table"
+	KindChain     Kind = "chain"
+	KindRule      Kind = "rule"
+	KindSet       Kind = "set"
+	KindMap       Kind = "map"
+	KindElement   Kind = "element"
+	KindFlowtable Kind = "flowtable"
+	KindObject    Kind = "object"
+	KindTrace     Kind = "trace"
+)
+
+// Event is one line of the monitor output. Exactly one of the typed fields
+// matching Kind is set.
+type Event struct {
+	Action Action          `json:"action"`
+	Kind   Kind            `json:"kind"`
+	Family nftables.Family `json:"family,omitempty"`
+	Table  string          `json:"table,omitempty"`
+	// Chain is set for chain, rule and trace events.
+	Chain string `json:"chain,omitempty"`
+	// Name is the set, map, flowtable or object name.
+	Name string `json:"name,omitempty"`
+
+	TableInfo *ruleset.Table     `json:"table_info,omitempty"`
+	ChainInfo *ruleset.Chain     `json:"chain_info,omitempty"`
+	Rule      *ruleset.Rule      `json:"rule,omitempty"`
+	Set       *ruleset.Set       `json:"set,omitempty"`
+	Element   *Element           `json:"element,omitempty"`
+	Flowtable *ruleset.Flowtable `json:"flowtable,omitempty"`
+	Object    *ruleset.Object    `json:"object,omitempty"`
+	Trace     *Trace             `json:"trace,omitempty"`
+}
+
+// Element is the addition or removal of elements of a named set or map.
+type Element struct {
+	Family   nftables.Family   `json:"family"`
+	Table    string            `json:"table"`
+	Name     string            `json:"name"`
+	Elements []json.RawMessage `json:"elem"`
+}
+
+// UnmarshalJSON accepts the elements as a list or, like nft monitor prints
+// them, wrapped in {"set": [...]}.
+func (e *Element) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Family nftables.Family `json:"family"`
+		Table  string          `json:"table"`
+		Name   string          `json:"name"`
+		Elem   json.RawMessage `json:"elem"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = Element{Family: v.Family, Table: v.Table, Name: v.Name}
+	elem := bytes.TrimSpace(v.Elem)
+	if len(elem) > 0 && elem[0] == '{' {
+		var wrapped struct {
+			Set json.RawMessage `json:"set"`
+		}
+		if err := json.Unmarshal(elem, &wrapped); err != nil {
+			return err
+		}
+		elem = wrapped.Set
+	}
+	if len(elem) == 0 {
+		return nil
+	}
+	return json.Unmarshal(elem, &e.Elements)
+}
+
+type TraceType string
+
+const (
+	// TracePacket carries the packet headers, it starts the trace of a packet in a base chain.
+	TracePacket TraceType = "packet"
+	TraceRule   TraceType = "rule"
+	TracePolicy TraceType = "policy"
+	TraceReturn TraceType = "return"
+)
+
+// Trace is a step of a packet marked with meta nftrace set 1.
+// Events of the same packet share the ID.
+type Trace struct {
+	ID         uint32          `json:"id"`
+	Family     nftables.Family `json:"family"`
+	Table      string          `json:"table"`
+	Chain      string          `json:"chain"`
+	Type       TraceType       `json:"type"`
+	Handle     int             `json:"handle,omitempty"`
+	Verdict    string          `json:"verdict,omitempty"`
+	JumpTarget string          `json:"jump_target,omitempty"`
+	Policy     string          `json:"policy,omitempty"`
+	Mark       uint32          `json:"mark,omitempty"`
+	Iif        string          `json:"iif,omitempty"`
+	Oif        string          `json:"oif,omitempty"`
+	// Rule is the matching rule of a rule step.
+	Rule *ruleset.Rule `json:"rule,omitempty"`
+	// Packet holds the remaining fields, the decoded headers like ip_saddr or tcp_dport.
+	Packet map[string]any `json:"packet,omitempty"`
+}
+
+var traceFields = []string{
+	"id", "family", "table", "chain", "type", "handle", "verdict", "jump_target", "policy", "mark", "iif", "oif", "rule",
+}
+
+func (t *Trace) UnmarshalJSON(data []byte) error {
+	type plain Trace
+	if err := json.Unmarshal(data, (*plain)(t)); err != nil {
+		return err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for key, value := range all {
+		if slices.Contains(traceFields, key) {
+			continue
+		}
+		if t.Packet == nil {
+			t.Packet = make(map[string]any)
+		}
+		t.Packet[key] = value
+	}
+	return nil
+}
+
+type Decoder struct {
+	scanner *bufio.Scanner
+	line    int
+	pending []*Event
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	return &Decoder{scanner: scanner}
+}
+
+// Next returns the next event, or io.EOF at the end of the stream. Lines that
+// are not events, like metainfo, are skipped. A malformed line is reported as
+// an *errors.PositionError with its line number, decoding can go on with the
+// next one. Any other error ends the stream, Next keeps returning it.
+func (d *Decoder) Next() (*Event, error) {
+	for len(d.pending) == 0 && d.scanner.Scan() {
+		d.line++
+		line := bytes.TrimSpace(d.scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		events, err := Parse(line)
+		if err != nil {
+			return nil, E.At("", d.line, 0, err)
+		}
+		d.pending = events
+	}
+	if len(d.pending) > 0 {
+		event := d.pending[0]
+		d.pending = d.pending[1:]
+		return event, nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Parse decodes one line of monitor output. Some nft versions wrap the event
+// in {"nftables": [...]}, those may hold several.
+func Parse(line []byte) ([]*Event, error) {
+	var wrapped struct {
+		Nftables []json.RawMessage `json:"nftables"`
+	}
+	if err := json.Unmarshal(line, &wrapped); err == nil && wrapped.Nftables != nil {
+		var events []*Event
+		for _, item := range wrapped.Nftables {
+			event, err := parseEvent(item)
+			if err != nil {
+				return nil, err
+			}
+			if event != nil {
+				events = append(events, event)
+			}
+		}
+		return events, nil
+	}
+	event, err := parseEvent(line)
+	if err != nil || event == nil {
+		return nil, err
+	}
+	return []*Event{event}, nil
+}
+
+func parseEvent(data []byte) (*Event, error) {
+	var outer map[string]json.RawMessage
+	if err := json.Unmarshal(data, &outer); err != nil {
+		return nil, E.When("decode monitor event", err)
+	}
+	for key, raw := range outer {
+		switch Action(key) {
+		case ActionTrace:
+			var trace Trace
+			if err := json.Unmarshal(raw, &trace); err != nil {
+				return nil, E.When("decode trace", err)
+			}
+			return &Event{
+				Action: ActionTrace, Kind: KindTrace,
+				Family: trace.Family, Table: trace.Table, Chain: trace.Chain,
+				Trace: &trace,
+			}, nil
+		case ActionAdd, ActionDelete, ActionReplace:
+			var inner map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &inner); err != nil {
+				return nil, E.When("decode "+key, err)
+			}
+			for kind, object := range inner {
+				event, err := parseObject(Action(key), kind, object)
+				if err != nil {
+					return nil, E.When("decode "+key+" "+kind, err)
+				}
+				return event, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func parseObject(action Action, kind string, raw json.RawMessage) (*Event, error) {
+	event := &Event{Action: action, Kind: Kind(kind)}
+	var err error
+	switch Kind(kind) {
+	case KindTable:
+		event.TableInfo, err = decode[ruleset.Table](raw)
+		if err == nil {
+			event.Family, event.Table = event.TableInfo.Family, event.TableInfo.Name
+		}
+	case KindChain:
+		event.ChainInfo, err = decode[ruleset.Chain](raw)
+		if err == nil {
+			event.Family, event.Table, event.Chain = event.ChainInfo.Family, event.ChainInfo.Table, event.ChainInfo.Name
+		}
+	case KindRule:
+		event.Rule, err = decode[ruleset.Rule](raw)
+		if err == nil {
+			event.Family, event.Table, event.Chain = event.Rule.Family, event.Rule.Table, event.Rule.Chain
+		}
+	case KindSet, KindMap:
+		event.Set, err = decode[ruleset.Set](raw)
+		if err == nil {
+			event.Family, event.Table, event.Name = event.Set.Family, event.Set.Table, event.Set.Name
+		}
+	case KindElement:
+		event.Element, err = decode[Element](raw)
+		if err == nil {
+			event.Family, event.Table, event.Name = event.Element.Family, event.Element.Table, event.Element.Name
+		}
+	case KindFlowtable:
+		event.Flowtable, err = decode[ruleset.Flowtable](raw)
+		if err == nil {
+			event.Family, event.Table, event.Name = event.Flowtable.Family, event.Flowtable.Table, event.Flowtable.Name
+		}
+	default:
+		// stateful objects: "counter", "quota", "ct helper", ...
+		event.Kind = KindObject
+		event.Object, err = decode[ruleset.Object](raw)
+		if err == nil {
+			event.Object.Kind = kind
+			event.Family, event.Table, event.Name = event.Object.Family, event.Object.Table, event.Object.Name
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func decode[T any](raw json.RawMessage) (*T, error) {
+	v := new(T)
+	if err := json.Unmarshal(raw, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Filter selects events, empty fields match everything.
+type Filter struct {
+	Actions  []Action
+	Kinds    []Kind
+	Families []nftables.Family
+	Tables   []string
+	Chains   []string
+}
+
+func (f Filter) Match(e *Event) bool {
+	return matchAny(f.Actions, e.Action) &&
+		matchAny(f.Kinds, e.Kind) &&
+		matchAny(f.Families, e.Family) &&
+		matchAny(f.Tables, e.Table) &&
+		matchAny(f.Chains, e.Chain)
+}
+
+func matchAny[T ~string](allowed []T, v T) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(string(a), string(v)) {
+			return true
+		}
+	}
+	return false
+}
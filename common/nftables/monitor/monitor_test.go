@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want []Event
+	}{
+		{`{"add": {"table": {"family": "inet", "name": "filter", "handle": 3}}}`,
+			[]Event{{Action: ActionAdd, Kind: KindTable, Family: nftables.FamilyInet, Table: "filter"}}},
+		{`{"delete": {"chain": {"family": "ip", "table": "nat", "name": "postrouting", "handle": 2}}}`,
+			[]Event{{Action: ActionDelete, Kind: KindChain, Family: nftables.FamilyIPv4, Table: "nat", Chain: "postrouting"}}},
+		{`{"add": {"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 7, "expr": [{"accept": null}]}}}`,
+			[]Event{{Action: ActionAdd, Kind: KindRule, Family: nftables.FamilyInet, Table: "filter", Chain: "input"}}},
+		{`{"add": {"map": {"family": "inet", "table": "filter", "name": "ports", "handle": 4, "type": "inet_service", "map": "verdict"}}}`,
+			[]Event{{Action: ActionAdd, Kind: KindMap, Family: nftables.FamilyInet, Table: "filter", Name: "ports"}}},
+		{`{"add": {"counter": {"family": "inet", "table": "filter", "name": "dropped", "handle": 5}}}`,
+			[]Event{{Action: ActionAdd, Kind: KindObject, Family: nftables.FamilyInet, Table: "filter", Name: "dropped"}}},
+		// some nft versions wrap the events
+		{`{"nftables": [{"metainfo": {"version": "1.0.9"}}, {"add": {"table": {"family": "ip", "name": "a"}}}, {"delete": {"table": {"family": "ip", "name": "b"}}}]}`,
+			[]Event{
+				{Action: ActionAdd, Kind: KindTable, Family: nftables.FamilyIPv4, Table: "a"},
+				{Action: ActionDelete, Kind: KindTable, Family: nftables.FamilyIPv4, Table: "b"},
+			}},
+		{`{"metainfo": {"version": "1.0.9"}}`, nil},
+	} {
+		events, err := Parse([]byte(tt.line))
+		if err != nil {
+			t.Errorf("Parse(%s): %v", tt.line, err)
+			continue
+		}
+		if len(events) != len(tt.want) {
+			t.Errorf("Parse(%s) returned %d events, want %d", tt.line, len(events), len(tt.want))
+			continue
+		}
+		for i, event := range events {
+			want := tt.want[i]
+			if event.Action != want.Action || event.Kind != want.Kind || event.Family != want.Family ||
+				event.Table != want.Table || event.Chain != want.Chain || event.Name != want.Name {
+				t.Errorf("Parse(%s)[%d] = %+v, want %+v", tt.line, i, *event, want)
+			}
+		}
+	}
+}
+
+func TestParseElement(t *testing.T) {
+	for _, line := range []string{
+		`{"add": {"element": {"family": "inet", "table": "filter", "name": "blocked", "elem": {"set": ["192.0.2.1", "192.0.2.2"]}}}}`,
+		`{"add": {"element": {"family": "inet", "table": "filter", "name": "blocked", "elem": ["192.0.2.1", "192.0.2.2"]}}}`,
+	} {
+		events, err := Parse([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		element := events[0].Element
+		if element == nil || len(element.Elements) != 2 || string(element.Elements[1]) != `"192.0.2.2"` {
+			t.Errorf("Parse(%s) element = %+v", line, element)
+		}
+	}
+}
+
+func TestParseTrace(t *testing.T) {
+	line := `{"trace": {"id": 1234, "family": "inet", "table": "filter", "chain": "input", "type": "rule", "handle": 7, "verdict": "accept", "iif": "eth0", "ip_saddr": "192.0.2.1", "tcp_dport": 22}}`
+	events, err := Parse([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := events[0]
+	if event.Kind != KindTrace || event.Chain != "input" {
+		t.Fatalf("event = %+v", *event)
+	}
+	trace := event.Trace
+	if trace.ID != 1234 || trace.Type != TraceRule || trace.Handle != 7 || trace.Verdict != "accept" || trace.Iif != "eth0" {
+		t.Errorf("trace = %+v", *trace)
+	}
+	if len(trace.Packet) != 2 || trace.Packet["ip_saddr"] != "192.0.2.1" || trace.Packet["tcp_dport"] != 22.0 {
+		t.Errorf("trace packet = %v", trace.Packet)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"metainfo": {"version": "1.0.9"}}`,
+		`# comment`,
+		`{"add": {"table": {"family": "ip", "name": "a"}}}`,
+		`{"add": {"rule": {"family": "ip", "table": "a", "chain": "c", "expr": 3}}}`,
+		``,
+		`{"delete": {"table": {"family": "ip", "name": "a"}}}`,
+	}, "\n")
+	d := NewDecoder(strings.NewReader(stream))
+
+	event, err := d.Next()
+	if err != nil || event.Action != ActionAdd || event.Table != "a" {
+		t.Fatalf("Next = %v, %v", event, err)
+	}
+	// a malformed line is reported with its line number, decoding goes on
+	_, err = d.Next()
+	var lineErr *E.PositionError
+	if !E.As(err, &lineErr) || lineErr.Line != 4 {
+		t.Fatalf("Next error = %v, want one at line 4", err)
+	}
+	event, err = d.Next()
+	if err != nil || event.Action != ActionDelete {
+		t.Fatalf("Next = %v, %v", event, err)
+	}
+	if _, err = d.Next(); err != io.EOF {
+		t.Fatalf("Next at the end error = %v, want io.EOF", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	event := &Event{Action: ActionAdd, Kind: KindRule, Family: nftables.FamilyInet, Table: "filter", Chain: "input"}
+	for _, tt := range []struct {
+		filter Filter
+		want   bool
+	}{
+		{Filter{}, true},
+		{Filter{Actions: []Action{ActionDelete, ActionAdd}}, true},
+		{Filter{Kinds: []Kind{"RULE"}}, true},
+		{Filter{Kinds: []Kind{KindTable}}, false},
+		{Filter{Tables: []string{"filter"}, Chains: []string{"forward"}}, false},
+	} {
+		if got := tt.filter.Match(event); got != tt.want {
+			t.Errorf("%+v.Match = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
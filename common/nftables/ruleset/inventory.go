@@ -0,0 +1,126 @@
+package ruleset
+
+import (
+	"github.com/woshikedayaa/fire/common/nftables"
+)
+
+// Inventory is a per-table summary of a ruleset.
+type Inventory struct {
+	Version string           `json:"version,omitempty"`
+	Tables  []TableInventory `json:"tables"`
+}
+
+type TableInventory struct {
+	Family     nftables.Family      `json:"family"`
+	Name       string               `json:"name"`
+	Chains     []ChainInventory     `json:"chains,omitempty"`
+	Sets       []SetInventory       `json:"sets,omitempty"`
+	Flowtables []FlowtableInventory `json:"flowtables,omitempty"`
+	Objects    []ObjectInventory    `json:"objects,omitempty"`
+}
+
+type ChainInventory struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Hook     string `json:"hook,omitempty"`
+	Priority *int   `json:"priority,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+	Rules    int    `json:"rules"`
+}
+
+type SetInventory struct {
+	Name     string   `json:"name"`
+	Type     []string `json:"type"`
+	Map      []string `json:"map,omitempty"`
+	Flags    []string `json:"flags,omitempty"`
+	Elements int      `json:"elements"`
+}
+
+type FlowtableInventory struct {
+	Name     string   `json:"name"`
+	Hook     string   `json:"hook,omitempty"`
+	Priority *int     `json:"priority,omitempty"`
+	Devices  []string `json:"devices,omitempty"`
+}
+
+type ObjectInventory struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// Families returns the number of tables per family.
+func (inv *Inventory) Families() map[nftables.Family]int {
+	families := make(map[nftables.Family]int)
+	for _, table := range inv.Tables {
+		families[table.Family]++
+	}
+	return families
+}
+
+func (rs *Ruleset) Inventory() *Inventory {
+	inv := &Inventory{Version: rs.Metainfo.Version}
+	index := make(map[tableKey]int, len(rs.Tables))
+	table := func(family nftables.Family, name string) *TableInventory {
+		key := tableKey{family, name}
+		i, ok := index[key]
+		if !ok {
+			i = len(inv.Tables)
+			index[key] = i
+			inv.Tables = append(inv.Tables, TableInventory{Family: family, Name: name})
+		}
+		return &inv.Tables[i]
+	}
+
+	for _, t := range rs.Tables {
+		table(t.Family, t.Name)
+	}
+	rules := make(map[chainKey]int)
+	for _, r := range rs.Rules {
+		rules[chainKey{tableKey{r.Family, r.Table}, r.Chain}]++
+	}
+	for _, c := range rs.Chains {
+		t := table(c.Family, c.Table)
+		t.Chains = append(t.Chains, ChainInventory{
+			Name:     c.Name,
+			Type:     c.Type,
+			Hook:     c.Hook,
+			Priority: c.Priority,
+			Policy:   c.Policy,
+			Rules:    rules[chainKey{tableKey{c.Family, c.Table}, c.Name}],
+		})
+	}
+	for _, s := range rs.Sets {
+		t := table(s.Family, s.Table)
+		t.Sets = append(t.Sets, SetInventory{
+			Name:     s.Name,
+			Type:     s.Type,
+			Map:      s.Map,
+			Flags:    s.Flags,
+			Elements: len(s.Elements),
+		})
+	}
+	for _, f := range rs.Flowtables {
+		t := table(f.Family, f.Table)
+		t.Flowtables = append(t.Flowtables, FlowtableInventory{
+			Name:     f.Name,
+			Hook:     f.Hook,
+			Priority: f.Priority,
+			Devices:  f.Devices,
+		})
+	}
+	for _, o := range rs.Objects {
+		t := table(o.Family, o.Table)
+		t.Objects = append(t.Objects, ObjectInventory{Kind: o.Kind, Name: o.Name})
+	}
+	return inv
+}
+
+type tableKey struct {
+	family nftables.Family
+	name   string
+}
+
+type chainKey struct {
+	table tableKey
+	name  string
+}
@@ -0,0 +1,198 @@
+// Package ruleset models the output of `nft -j list ruleset`, see libnftables-json(5).
+package ruleset
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables"
+)
+
+type Ruleset struct {
+	Metainfo   Metainfo    `json:"metainfo"`
+	Tables     []Table     `json:"tables,omitempty"`
+	Chains     []Chain     `json:"chains,omitempty"`
+	Rules      []Rule      `json:"rules,omitempty"`
+	Sets       []Set       `json:"sets,omitempty"`
+	Flowtables []Flowtable `json:"flowtables,omitempty"`
+	Objects    []Object    `json:"objects,omitempty"`
+}
+
+type Metainfo struct {
+	Version           string `json:"version,omitempty"`
+	ReleaseName       string `json:"release_name,omitempty"`
+	JSONSchemaVersion int    `json:"json_schema_version,omitempty"`
+}
+
+type Table struct {
+	Family  nftables.Family `json:"family"`
+	Name    string          `json:"name"`
+	Handle  int             `json:"handle"`
+	Comment string          `json:"comment,omitempty"`
+}
+
+type Chain struct {
+	Family   nftables.Family `json:"family"`
+	Table    string          `json:"table"`
+	Name     string          `json:"name"`
+	Handle   int             `json:"handle"`
+	Type     string          `json:"type,omitempty"`
+	Hook     string          `json:"hook,omitempty"`
+	Priority *int            `json:"prio,omitempty"`
+	Policy   string          `json:"policy,omitempty"`
+	Device   Strings         `json:"dev,omitempty"`
+	Comment  string          `json:"comment,omitempty"`
+}
+
+// IsBase reports whether the chain is attached to a netfilter hook.
+func (c Chain) IsBase() bool {
+	return c.Hook != ""
+}
+
+type Rule struct {
+	Family  nftables.Family   `json:"family"`
+	Table   string            `json:"table"`
+	Chain   string            `json:"chain"`
+	Handle  int               `json:"handle"`
+	Index   *int              `json:"index,omitempty"`
+	Comment string            `json:"comment,omitempty"`
+	Expr    []json.RawMessage `json:"expr,omitempty"`
+}
+
+// Set is a named set, or a named map when Map is not empty.
+type Set struct {
+	Family   nftables.Family   `json:"family"`
+	Table    string            `json:"table"`
+	Name     string            `json:"name"`
+	Handle   int               `json:"handle"`
+	Type     Strings           `json:"type"`
+	Map      Strings           `json:"map,omitempty"`
+	Policy   string            `json:"policy,omitempty"`
+	Flags    Strings           `json:"flags,omitempty"`
+	Timeout  int               `json:"timeout,omitempty"`
+	Size     int               `json:"size,omitempty"`
+	Comment  string            `json:"comment,omitempty"`
+	Elements []json.RawMessage `json:"elem,omitempty"`
+}
+
+func (s Set) IsMap() bool {
+	return len(s.Map) > 0
+}
+
+type Flowtable struct {
+	Family   nftables.Family `json:"family"`
+	Table    string          `json:"table"`
+	Name     string          `json:"name"`
+	Handle   int             `json:"handle"`
+	Hook     string          `json:"hook,omitempty"`
+	Priority *int            `json:"prio,omitempty"`
+	Devices  Strings         `json:"dev,omitempty"`
+}
+
+// Object is a stateful object, Kind tells which one ("counter", "quota", "limit", "ct helper", ...).
+type Object struct {
+	Kind    string          `json:"kind"`
+	Family  nftables.Family `json:"family"`
+	Table   string          `json:"table"`
+	Name    string          `json:"name"`
+	Handle  int             `json:"handle"`
+	Comment string          `json:"comment,omitempty"`
+
+	// counter
+	Packets uint64 `json:"packets,omitempty"`
+	Bytes   uint64 `json:"bytes,omitempty"`
+	// quota
+	Used     uint64 `json:"used,omitempty"`
+	Inverted bool   `json:"inv,omitempty"`
+	// ct helper
+	Type     string `json:"type,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+var objectKinds = []string{
+	"counter", "quota", "limit", "secmark", "synproxy",
+	"ct helper", "ct timeout", "ct expectation",
+}
+
+// Strings decodes a JSON value that is either a single string or an array of strings,
+// nft uses both forms for set types, flags and devices.
+type Strings []string
+
+func (s *Strings) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var list []string
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*s = list
+		return nil
+	}
+	var one string
+	if err := json.Unmarshal(data, &one); err != nil {
+		return err
+	}
+	*s = Strings{one}
+	return nil
+}
+
+// Parse decodes the JSON printed by `nft -j list ruleset` (or any other `nft -j list` command).
+// Unknown statements are skipped.
+func Parse(in io.Reader) (*Ruleset, error) {
+	var doc struct {
+		Nftables []map[string]json.RawMessage `json:"nftables"`
+	}
+	if err := json.NewDecoder(in).Decode(&doc); err != nil {
+		return nil, E.When("decode nft json", err)
+	}
+
+	rs := new(Ruleset)
+	for _, item := range doc.Nftables {
+		for kind, raw := range item {
+			if err := rs.add(kind, raw); err != nil {
+				return nil, E.When("decode "+kind, err)
+			}
+		}
+	}
+	return rs, nil
+}
+
+func (rs *Ruleset) add(kind string, raw json.RawMessage) error {
+	switch kind {
+	case "metainfo":
+		return json.Unmarshal(raw, &rs.Metainfo)
+	case "table":
+		return appendDecoded(&rs.Tables, raw)
+	case "chain":
+		return appendDecoded(&rs.Chains, raw)
+	case "rule":
+		return appendDecoded(&rs.Rules, raw)
+	case "set", "map":
+		return appendDecoded(&rs.Sets, raw)
+	case "flowtable":
+		return appendDecoded(&rs.Flowtables, raw)
+	}
+	for _, objectKind := range objectKinds {
+		if kind == objectKind {
+			var obj Object
+			if err := json.Unmarshal(raw, &obj); err != nil {
+				return err
+			}
+			obj.Kind = kind
+			rs.Objects = append(rs.Objects, obj)
+			return nil
+		}
+	}
+	return nil
+}
+
+func appendDecoded[T any](list *[]T, raw json.RawMessage) error {
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	*list = append(*list, v)
+	return nil
+}
@@ -0,0 +1,85 @@
+package ruleset
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/woshikedayaa/fire/common/nftables"
+)
+
+func loadRuleset(t *testing.T, name string) *Ruleset {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rs, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestParse(t *testing.T) {
+	rs := loadRuleset(t, "ruleset.json")
+	if rs.Metainfo.Version != "1.0.9" || len(rs.Tables) != 2 || len(rs.Chains) != 3 || len(rs.Rules) != 3 {
+		t.Fatalf("parsed %+v", rs)
+	}
+	blocked, ports := rs.Sets[0], rs.Sets[1]
+	if !reflect.DeepEqual(blocked.Type, Strings{"ipv4_addr"}) || !reflect.DeepEqual(blocked.Flags, Strings{"interval"}) || blocked.IsMap() {
+		t.Errorf("set = %+v", blocked)
+	}
+	if !reflect.DeepEqual(ports.Type, Strings{"inet_proto", "inet_service"}) || !ports.IsMap() {
+		t.Errorf("map = %+v", ports)
+	}
+	if len(rs.Objects) != 2 || rs.Objects[0].Kind != "counter" || rs.Objects[0].Packets != 7 || rs.Objects[1].Used != 250 {
+		t.Errorf("objects = %+v", rs.Objects)
+	}
+	if !rs.Chains[0].IsBase() || rs.Chains[1].IsBase() || *rs.Chains[2].Priority != 100 {
+		t.Errorf("chains = %+v", rs.Chains)
+	}
+
+	if _, err := Parse(strings.NewReader(`{"nftables": [{"table": {"family": 4}}]}`)); err == nil {
+		t.Error("Parse accepted a malformed table")
+	}
+}
+
+func TestInventory(t *testing.T) {
+	inv := loadRuleset(t, "ruleset.json").Inventory()
+	zero, hundred := 0, 100
+	want := &Inventory{
+		Version: "1.0.9",
+		Tables: []TableInventory{
+			{
+				Family: nftables.FamilyInet, Name: "filter",
+				Chains: []ChainInventory{
+					{Name: "input", Type: "filter", Hook: "input", Priority: &zero, Policy: "drop", Rules: 2},
+					{Name: "services", Rules: 1},
+				},
+				Sets: []SetInventory{
+					{Name: "blocked", Type: []string{"ipv4_addr"}, Flags: []string{"interval"}, Elements: 2},
+					{Name: "ports", Type: []string{"inet_proto", "inet_service"}, Map: []string{"verdict"}, Elements: 1},
+				},
+				Flowtables: []FlowtableInventory{{Name: "ft", Hook: "ingress", Priority: &zero, Devices: []string{"eth0"}}},
+				Objects:    []ObjectInventory{{Kind: "counter", Name: "dropped"}, {Kind: "quota", Name: "monthly"}},
+			},
+			{
+				Family: nftables.FamilyIPv4, Name: "nat",
+				Chains: []ChainInventory{{Name: "postrouting", Type: "nat", Hook: "postrouting", Priority: &hundred, Policy: "accept"}},
+			},
+		},
+	}
+	got, _ := json.Marshal(inv)
+	wanted, _ := json.Marshal(want)
+	if string(got) != string(wanted) {
+		t.Errorf("inventory\n%s\nwant\n%s", got, wanted)
+	}
+	if families := inv.Families(); families[nftables.FamilyInet] != 1 || families[nftables.FamilyIPv4] != 1 {
+		t.Errorf("families = %v", families)
+	}
+}
@@ -24,6 +24,8 @@ const (
 	FlagConstant Flag = "constant"
 	FlagInterval Flag = "interval"
 	FlagTimeout  Flag = "timeout"
+	// FlagDynamic lets rules add elements with stateful expressions, e.g. meters.
+	FlagDynamic Flag = "dynamic"
 )
 
 type Policy string
@@ -56,7 +58,7 @@ func (s *Set) Valid() bool {
 
 	for _, v := range s.Flag {
 		switch v {
-		case FlagConstant, FlagInterval, FlagTimeout:
+		case FlagConstant, FlagInterval, FlagTimeout, FlagDynamic:
 		default:
 			return false
 		}
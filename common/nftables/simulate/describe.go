@@ -0,0 +1,159 @@
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// describeRule prints the expressions of a rule close to nft list ruleset.
+func describeRule(exprs []map[string]any) string {
+	parts := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		for key, arg := range expr {
+			if s := describeStatement(key, arg); s != "" {
+				parts = append(parts, s)
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func describeStatement(key string, arg any) string {
+	m, _ := arg.(map[string]any)
+	switch key {
+	case "match":
+		left, right := describeExpr(m["left"]), describeExpr(m["right"])
+		switch op, _ := m["op"].(string); op {
+		case "==", "in":
+			return left + " " + right
+		default:
+			return left + " " + op + " " + right
+		}
+	case "accept", "drop", "reject", "queue", "return", "continue", "masquerade", "notrack":
+		return key
+	case "jump", "goto":
+		return key + " " + describeExpr(m["target"])
+	case "vmap":
+		return describeExpr(m["key"]) + " vmap " + describeExpr(m["data"])
+	case "mangle":
+		return describeExpr(m["key"]) + " set " + describeExpr(m["value"])
+	case "dnat", "snat", "redirect":
+		s := key
+		if m["addr"] != nil {
+			s += " to " + describeExpr(m["addr"])
+		}
+		if m["port"] != nil {
+			if m["addr"] == nil {
+				s += " to"
+			}
+			s += ":" + describeExpr(m["port"])
+		}
+		return s
+	case "counter", "log", "limit", "quota":
+		return key
+	}
+	return key
+}
+
+// describeExpr prints an expression or constant of the JSON ruleset.
+func describeExpr(expr any) string {
+	switch expr := expr.(type) {
+	case nil:
+		return ""
+	case string:
+		return expr
+	case float64:
+		return fmt.Sprint(uint64(expr))
+	case bool:
+		if expr {
+			return "exists"
+		}
+		return "missing"
+	case []any:
+		items := make([]string, 0, len(expr))
+		for _, item := range expr {
+			items = append(items, describeExpr(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		return describeObject(expr)
+	}
+	data, _ := json.Marshal(expr)
+	return string(data)
+}
+
+func describeObject(m map[string]any) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		arg := m[key]
+		a, _ := arg.(map[string]any)
+		switch key {
+		case "payload":
+			if a["protocol"] == nil {
+				return fmt.Sprintf("@%s,%s,%s", describeExpr(a["base"]), describeExpr(a["offset"]), describeExpr(a["len"]))
+			}
+			return describeExpr(a["protocol"]) + " " + describeExpr(a["field"])
+		case "meta", "ct", "rt":
+			return key + " " + describeExpr(a["key"])
+		case "fib":
+			flags, _ := a["flags"].([]any)
+			parts := make([]string, 0, len(flags))
+			for _, flag := range flags {
+				parts = append(parts, describeExpr(flag))
+			}
+			if flag, ok := a["flags"].(string); ok {
+				parts = append(parts, flag)
+			}
+			return "fib " + strings.Join(parts, " . ") + " " + describeExpr(a["result"])
+		case "concat":
+			items, _ := arg.([]any)
+			parts := make([]string, 0, len(items))
+			for _, item := range items {
+				parts = append(parts, describeExpr(item))
+			}
+			return strings.Join(parts, " . ")
+		case "set":
+			items, ok := arg.([]any)
+			if !ok {
+				return "{ " + describeExpr(arg) + " }"
+			}
+			parts := make([]string, 0, len(items))
+			for _, item := range items {
+				if pair, ok := item.([]any); ok && len(pair) == 2 {
+					parts = append(parts, describeExpr(pair[0])+" : "+describeExpr(pair[1]))
+					continue
+				}
+				parts = append(parts, describeExpr(item))
+			}
+			return "{ " + strings.Join(parts, ", ") + " }"
+		case "prefix":
+			return describeExpr(a["addr"]) + "/" + describeExpr(a["len"])
+		case "range":
+			bounds, _ := arg.([]any)
+			if len(bounds) == 2 {
+				return describeExpr(bounds[0]) + "-" + describeExpr(bounds[1])
+			}
+		case "elem":
+			return describeExpr(a["val"])
+		case "map":
+			return describeExpr(a["key"]) + " map " + describeExpr(a["data"])
+		case "&", "|", "^":
+			operands, _ := arg.([]any)
+			if len(operands) == 2 {
+				return describeExpr(operands[0]) + " " + key + " " + describeExpr(operands[1])
+			}
+		case "accept", "drop", "continue", "return":
+			return key
+		case "jump", "goto":
+			return key + " " + describeExpr(a["target"])
+		}
+	}
+	data, _ := json.Marshal(m)
+	return string(data)
+}
@@ -0,0 +1,649 @@
+package simulate
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/woshikedayaa/fire/common/nftables"
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+)
+
+// maxDepth bounds jump and goto, the kernel allows 16 levels as well.
+const maxDepth = 16
+
+type StepKind string
+
+const (
+	StepChain  StepKind = "chain"
+	StepRule   StepKind = "rule"
+	StepPolicy StepKind = "policy"
+)
+
+// Step is one chain entered, rule matched or policy applied on the way.
+type Step struct {
+	Kind     StepKind        `json:"kind"`
+	Hook     string          `json:"hook"`
+	Depth    int             `json:"depth"`
+	Family   nftables.Family `json:"family"`
+	Table    string          `json:"table"`
+	Chain    string          `json:"chain"`
+	Priority int             `json:"priority,omitempty"`
+	Handle   int             `json:"handle,omitempty"`
+	Rule     string          `json:"rule,omitempty"`
+	Verdict  string          `json:"verdict,omitempty"`
+}
+
+type Result struct {
+	Packet    Packet    `json:"packet"`
+	Direction Direction `json:"direction"`
+	// Verdict is accept, drop or queue.
+	Verdict string `json:"verdict"`
+	// Decided is the rule or policy that gave the verdict, for an accepted
+	// packet the one of the last chain.
+	Decided string `json:"decided,omitempty"`
+	Steps   []Step `json:"steps"`
+	// Translated is the packet after NAT, nil when no NAT rule applied.
+	Translated *Packet `json:"translated,omitempty"`
+	// Notes lists what the simulator had to assume.
+	Notes []string `json:"notes,omitempty"`
+}
+
+type verdict int
+
+const (
+	verdictContinue verdict = iota
+	verdictAccept
+	verdictDrop
+	verdictQueue
+	verdictReturn
+)
+
+func (v verdict) String() string {
+	switch v {
+	case verdictAccept:
+		return "accept"
+	case verdictDrop:
+		return "drop"
+	case verdictQueue:
+		return "queue"
+	case verdictReturn:
+		return "return"
+	}
+	return "continue"
+}
+
+type simulator struct {
+	rs     *ruleset.Ruleset
+	packet Packet
+	hook   string
+	result *Result
+	// decided is the location of the last verdict
+	decided string
+	nat     bool
+}
+
+// Simulate walks p through the base chains of rs in the hooks of dir. The
+// packet must be normalized.
+func Simulate(rs *ruleset.Ruleset, p Packet, dir Direction) *Result {
+	s := &simulator{rs: rs, packet: p, result: &Result{Packet: p, Direction: dir, Verdict: "accept"}}
+	for _, hook := range hooks[dir] {
+		s.hook = hook
+		for _, chain := range s.baseChains(hook) {
+			v := s.chain(chain, 0)
+			if v == verdictContinue || v == verdictReturn {
+				v = verdictAccept
+				if chain.Policy == "drop" {
+					v = verdictDrop
+				}
+				s.decided = fmt.Sprintf("%s %s %s policy %s", chain.Family, chain.Table, chain.Name, v)
+				s.step(Step{Kind: StepPolicy, Family: chain.Family, Table: chain.Table, Chain: chain.Name, Verdict: v.String()})
+			}
+			switch v {
+			case verdictDrop, verdictQueue:
+				s.result.Verdict = v.String()
+				s.result.Decided = s.decided
+				s.finish()
+				return s.result
+			}
+		}
+	}
+	s.result.Decided = s.decided
+	s.finish()
+	return s.result
+}
+
+func (s *simulator) finish() {
+	if s.nat {
+		translated := s.packet
+		s.result.Translated = &translated
+	}
+}
+
+// baseChains returns the chains attached to hook for the packet in priority
+// order. NAT chains only see the first packet of a connection.
+func (s *simulator) baseChains(hook string) []ruleset.Chain {
+	var chains []ruleset.Chain
+	for _, chain := range s.rs.Chains {
+		if !chain.IsBase() || chain.Hook != hook ||
+			(chain.Family != s.packet.Family && chain.Family != nftables.FamilyInet) {
+			continue
+		}
+		if chain.Type == "nat" && s.packet.CtState != "new" {
+			continue
+		}
+		chains = append(chains, chain)
+	}
+	slices.SortStableFunc(chains, func(a, b ruleset.Chain) int {
+		return cmp.Compare(priority(a), priority(b))
+	})
+	return chains
+}
+
+func priority(c ruleset.Chain) int {
+	if c.Priority == nil {
+		return 0
+	}
+	return *c.Priority
+}
+
+func (s *simulator) note(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if !slices.Contains(s.result.Notes, msg) {
+		s.result.Notes = append(s.result.Notes, msg)
+	}
+}
+
+func (s *simulator) step(step Step) {
+	step.Hook = s.hook
+	s.result.Steps = append(s.result.Steps, step)
+}
+
+func (s *simulator) findChain(family nftables.Family, table, name string) (ruleset.Chain, bool) {
+	for _, chain := range s.rs.Chains {
+		if chain.Family == family && chain.Table == table && chain.Name == name {
+			return chain, true
+		}
+	}
+	return ruleset.Chain{}, false
+}
+
+// chain runs the rules of chain in order until one gives a verdict.
+func (s *simulator) chain(chain ruleset.Chain, depth int) verdict {
+	s.step(Step{Kind: StepChain, Depth: depth, Family: chain.Family, Table: chain.Table, Chain: chain.Name, Priority: priority(chain)})
+	if depth >= maxDepth {
+		s.note("%s %s %s: jumps nested deeper than %d, stopped", chain.Family, chain.Table, chain.Name, maxDepth)
+		return verdictContinue
+	}
+	for _, rule := range s.rs.Rules {
+		if rule.Family != chain.Family || rule.Table != chain.Table || rule.Chain != chain.Name {
+			continue
+		}
+		if v := s.rule(chain, rule, depth); v != verdictContinue {
+			return v
+		}
+	}
+	return verdictContinue
+}
+
+// rule evaluates the matches of rule and, when all of them hold, its statements.
+func (s *simulator) rule(chain ruleset.Chain, rule ruleset.Rule, depth int) verdict {
+	exprs := make([]map[string]any, 0, len(rule.Expr))
+	for _, raw := range rule.Expr {
+		var expr map[string]any
+		if json.Unmarshal(raw, &expr) == nil {
+			exprs = append(exprs, expr)
+		}
+	}
+	location := fmt.Sprintf("%s %s %s handle %d", rule.Family, rule.Table, rule.Chain, rule.Handle)
+	ctx := ruleContext{family: rule.Family, table: rule.Table, location: location}
+
+	// the statements run once every match before them held
+	stepIndex := -1
+	for _, expr := range exprs {
+		for key, arg := range expr {
+			if key == "match" {
+				if !s.match(ctx, arg) {
+					return verdictContinue
+				}
+				continue
+			}
+			if xt, ok := arg.(map[string]any); key == "xt" && ok && xt["type"] != "target" {
+				// iptables-nft compat matches cannot be evaluated
+				s.note("%s: xt %s is assumed not to match", location, describeExpr(xt["name"]))
+				return verdictContinue
+			}
+			if stepIndex < 0 {
+				s.step(Step{Kind: StepRule, Depth: depth, Family: rule.Family, Table: rule.Table, Chain: rule.Chain,
+					Handle: rule.Handle, Rule: describeRule(exprs)})
+				stepIndex = len(s.result.Steps) - 1
+			}
+			v, text := s.statement(ctx, chain, key, arg, depth)
+			if text != "" {
+				s.result.Steps[stepIndex].Verdict = text
+			}
+			if v != verdictContinue {
+				return v
+			}
+		}
+	}
+	return verdictContinue
+}
+
+type ruleContext struct {
+	family   nftables.Family
+	table    string
+	location string
+}
+
+// statement applies one statement, text describes its verdict for the step.
+func (s *simulator) statement(ctx ruleContext, chain ruleset.Chain, key string, arg any, depth int) (verdict, string) {
+	switch key {
+	case "accept":
+		s.decided = ctx.location
+		return verdictAccept, key
+	case "drop", "reject":
+		s.decided = ctx.location
+		return verdictDrop, key
+	case "queue":
+		s.decided = ctx.location
+		return verdictQueue, key
+	case "return":
+		return verdictReturn, key
+	case "continue":
+		return verdictContinue, key
+	case "jump", "goto":
+		target, _ := arg.(map[string]any)
+		name, _ := target["target"].(string)
+		return s.jump(ctx, key, name, depth)
+	case "vmap":
+		m, _ := arg.(map[string]any)
+		key, ok := s.eval(ctx, m["key"])
+		if !ok {
+			return verdictContinue, ""
+		}
+		data, found := s.mapLookup(ctx, key, m["data"])
+		if !found {
+			return verdictContinue, "vmap: no element"
+		}
+		verdictExpr, _ := data.(map[string]any)
+		for k, a := range verdictExpr {
+			return s.statement(ctx, chain, k, a, depth)
+		}
+		return verdictContinue, ""
+	case "mangle":
+		s.mangle(ctx, arg)
+		return verdictContinue, ""
+	case "dnat", "snat", "masquerade", "redirect":
+		if chain.Type != "nat" {
+			return verdictContinue, ""
+		}
+		text := s.translate(ctx, key, arg)
+		s.decided = ctx.location
+		return verdictAccept, text
+	case "counter", "log", "limit", "quota", "notrack", "flow", "ct helper", "ct timeout", "ct expectation", "set", "meta", "ct":
+		// side effects only, limit and quota are assumed not to be exceeded
+		if key == "limit" || key == "quota" {
+			s.note("%s: %s assumed not exceeded", ctx.location, key)
+		}
+		return verdictContinue, ""
+	}
+	s.note("%s: statement %q is not simulated", ctx.location, key)
+	return verdictContinue, ""
+}
+
+func (s *simulator) jump(ctx ruleContext, kind, name string, depth int) (verdict, string) {
+	target, ok := s.findChain(ctx.family, ctx.table, name)
+	if !ok {
+		s.note("%s: %s to missing chain %s", ctx.location, kind, name)
+		return verdictContinue, kind + " " + name
+	}
+	v := s.chain(target, depth+1)
+	switch {
+	case kind == "goto" && (v == verdictContinue || v == verdictReturn):
+		// a goto chain returns to the caller of the chain that issued the goto
+		v = verdictReturn
+	case v == verdictReturn:
+		v = verdictContinue
+	}
+	return v, kind + " " + name
+}
+
+func (s *simulator) mangle(ctx ruleContext, arg any) {
+	m, _ := arg.(map[string]any)
+	value, ok := s.eval(ctx, m["value"])
+	if !ok || !value.isNum {
+		s.note("%s: mangle value is not simulated", ctx.location)
+		return
+	}
+	key, _ := m["key"].(map[string]any)
+	switch {
+	case selector(key, "meta") == "mark":
+		s.packet.Mark = uint32(value.num)
+	case selector(key, "ct") == "mark":
+		s.packet.CtMark = uint32(value.num)
+	default:
+		s.note("%s: mangle of %s is not simulated", ctx.location, describeExpr(key))
+	}
+}
+
+// translate applies a NAT statement to the packet and describes it.
+func (s *simulator) translate(ctx ruleContext, kind string, arg any) string {
+	s.nat = true
+	m, _ := arg.(map[string]any)
+	var addr netip.Addr
+	if m["addr"] != nil {
+		if v, ok := s.eval(ctx, m["addr"]); ok && v.addr.IsValid() {
+			addr = v.addr
+		} else {
+			s.note("%s: %s address is not simulated", ctx.location, kind)
+		}
+	}
+	var port uint16
+	if m["port"] != nil {
+		if v, ok := s.eval(ctx, m["port"]); ok && v.isNum {
+			port = uint16(v.num)
+		}
+	}
+	text := kind
+	switch kind {
+	case "dnat", "redirect":
+		if addr.IsValid() {
+			s.packet.Dst = addr
+		}
+		if port != 0 {
+			s.packet.Dport = port
+		}
+		if kind == "dnat" {
+			text += " to " + endpoint(s.packet.Dst, port)
+		} else if port != 0 {
+			text += fmt.Sprintf(" to :%d", port)
+		}
+		s.note("routing is not re-evaluated after %s, the packet keeps its direction", kind)
+	case "snat":
+		if addr.IsValid() {
+			s.packet.Src = addr
+		}
+		if port != 0 {
+			s.packet.Sport = port
+		}
+		text += " to " + endpoint(s.packet.Src, port)
+	case "masquerade":
+		s.note("masquerade takes the address of %s, the source is left unchanged", cmp.Or(s.packet.Oif, "the output interface"))
+	}
+	return text
+}
+
+func endpoint(addr netip.Addr, port uint16) string {
+	if port == 0 {
+		return addr.String()
+	}
+	return netip.AddrPortFrom(addr, port).String()
+}
+
+// match evaluates {"op", "left", "right"}. An expression the simulator does
+// not know never matches.
+func (s *simulator) match(ctx ruleContext, arg any) bool {
+	m, _ := arg.(map[string]any)
+	op, _ := m["op"].(string)
+	left, ok := s.eval(ctx, m["left"])
+	if !ok {
+		return false
+	}
+	if left.absent {
+		// matching a header implies the protocol, "ip saddr" never matches IPv6
+		return false
+	}
+	right := m["right"]
+	sets := s.sets(ctx)
+	switch op {
+	case "==", "in", "":
+		return member(left, right, sets)
+	case "!=":
+		return !member(left, right, sets)
+	case "<", ">", "<=", ">=":
+		r, ok := literal(right)
+		if !ok || !comparable(left, r) {
+			s.note("%s: cannot compare %s %s", ctx.location, describeExpr(m["left"]), op)
+			return false
+		}
+		c := compareValues(left, r)
+		switch op {
+		case "<":
+			return c < 0
+		case ">":
+			return c > 0
+		case "<=":
+			return c <= 0
+		}
+		return c >= 0
+	}
+	s.note("%s: operator %q is not simulated", ctx.location, op)
+	return false
+}
+
+// sets resolves @name in the table of the rule.
+func (s *simulator) sets(ctx ruleContext) lookup {
+	return func(ref string) ([]any, bool) {
+		name := ref[1:]
+		for _, set := range s.rs.Sets {
+			if set.Family == ctx.family && set.Table == ctx.table && set.Name == name {
+				return decodeElements(set.Elements), true
+			}
+		}
+		s.note("%s: set %s does not exist", ctx.location, ref)
+		return nil, false
+	}
+}
+
+// mapLookup returns the data of the element of a map matching key.
+func (s *simulator) mapLookup(ctx ruleContext, key value, data any) (any, bool) {
+	var elements []any
+	switch data := data.(type) {
+	case string:
+		var ok bool
+		if elements, ok = s.sets(ctx)(data); !ok {
+			return nil, false
+		}
+	case map[string]any:
+		elements, _ = data["set"].([]any)
+	}
+	for _, element := range elements {
+		pair, ok := element.([]any)
+		if ok && len(pair) == 2 && member(key, pair[0], s.sets(ctx)) {
+			return pair[1], true
+		}
+	}
+	return nil, false
+}
+
+func selector(expr map[string]any, kind string) string {
+	m, _ := expr[kind].(map[string]any)
+	key, _ := m["key"].(string)
+	return key
+}
+
+// eval computes the value of an expression for the packet, ok is false for
+// expressions the simulator does not know.
+func (s *simulator) eval(ctx ruleContext, expr any) (value, bool) {
+	m, isMap := expr.(map[string]any)
+	if !isMap {
+		return literal(expr)
+	}
+	p := &s.packet
+	for kind, arg := range m {
+		switch kind {
+		case "payload":
+			return s.payload(ctx, arg)
+		case "meta":
+			switch key := selector(m, "meta"); key {
+			case "iif", "iifname":
+				return strValue(p.Iif), true
+			case "oif", "oifname":
+				return strValue(p.Oif), true
+			case "l4proto":
+				n, _ := p.protoNumber()
+				return numValue(n), true
+			case "nfproto":
+				if p.Family == nftables.FamilyIPv4 {
+					return numValue(2), true
+				}
+				return numValue(10), true
+			case "protocol":
+				if p.Family == nftables.FamilyIPv4 {
+					return numValue(0x0800), true
+				}
+				return numValue(0x86dd), true
+			case "mark":
+				return numValue(uint64(p.Mark)), true
+			default:
+				s.note("%s: meta %s is not simulated", ctx.location, key)
+				return value{}, false
+			}
+		case "ct":
+			switch key := selector(m, "ct"); key {
+			case "state":
+				return strValue(p.CtState), true
+			case "mark":
+				return numValue(uint64(p.CtMark)), true
+			case "direction":
+				return strValue("original"), true
+			default:
+				s.note("%s: ct %s is not simulated", ctx.location, key)
+				return value{}, false
+			}
+		case "fib":
+			return s.fib(ctx, arg)
+		case "concat":
+			items, _ := arg.([]any)
+			var t value
+			for _, item := range items {
+				v, ok := s.eval(ctx, item)
+				if !ok {
+					return value{}, false
+				}
+				t.absent = t.absent || v.absent
+				t.tuple = append(t.tuple, v)
+			}
+			return t, true
+		case "&", "|", "^":
+			operands, _ := arg.([]any)
+			if len(operands) != 2 {
+				return value{}, false
+			}
+			a, ok1 := s.eval(ctx, operands[0])
+			b, ok2 := s.eval(ctx, operands[1])
+			if !ok1 || !ok2 || !a.isNum || !b.isNum {
+				s.note("%s: %s of non-numeric values is not simulated", ctx.location, kind)
+				return value{}, false
+			}
+			switch kind {
+			case "&":
+				return numValue(a.num & b.num), true
+			case "|":
+				return numValue(a.num | b.num), true
+			}
+			return numValue(a.num ^ b.num), true
+		case "map":
+			mm, _ := arg.(map[string]any)
+			key, ok := s.eval(ctx, mm["key"])
+			if !ok {
+				return value{}, false
+			}
+			data, found := s.mapLookup(ctx, key, mm["data"])
+			if !found {
+				return value{absent: true}, true
+			}
+			return literal(data)
+		case "prefix", "range", "set", "elem":
+			return value{}, false
+		}
+		s.note("%s: expression %q is not simulated", ctx.location, kind)
+		return value{}, false
+	}
+	return value{}, false
+}
+
+func (s *simulator) payload(ctx ruleContext, arg any) (value, bool) {
+	m, _ := arg.(map[string]any)
+	protocol, _ := m["protocol"].(string)
+	field, _ := m["field"].(string)
+	p := &s.packet
+	absent := value{absent: true}
+	switch protocol {
+	case "ip", "ip6":
+		if (protocol == "ip") != (p.Family == nftables.FamilyIPv4) {
+			return absent, true
+		}
+		switch field {
+		case "saddr":
+			return addrValue(p.Src), true
+		case "daddr":
+			return addrValue(p.Dst), true
+		case "protocol", "nexthdr":
+			n, _ := p.protoNumber()
+			return numValue(n), true
+		case "version":
+			if protocol == "ip" {
+				return numValue(4), true
+			}
+			return numValue(6), true
+		}
+	case "th", "tcp", "udp", "udplite", "sctp", "dccp":
+		if protocol != "th" && protocol != p.Proto || !p.hasPorts() {
+			return absent, true
+		}
+		switch field {
+		case "sport":
+			return numValue(uint64(p.Sport)), true
+		case "dport":
+			return numValue(uint64(p.Dport)), true
+		}
+	case "icmp", "icmpv6", "igmp", "esp", "ah", "gre":
+		if protocol != p.Proto {
+			return absent, true
+		}
+	case "":
+		s.note("%s: raw payload expressions are not simulated", ctx.location)
+		return value{}, false
+	}
+	s.note("%s: %s %s is not simulated", ctx.location, protocol, field)
+	return value{}, false
+}
+
+// fib answers route lookups as if the routing table agreed with the packet:
+// the destination of an input packet is local, sources pass the reverse path
+// check through the interface they came in on.
+func (s *simulator) fib(ctx ruleContext, arg any) (value, bool) {
+	m, _ := arg.(map[string]any)
+	result, _ := m["result"].(string)
+	var flags []string
+	switch f := m["flags"].(type) {
+	case string:
+		flags = []string{f}
+	case []any:
+		for _, flag := range f {
+			if flag, ok := flag.(string); ok {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	s.note("fib lookups assume the routes agree with the packet")
+	switch result {
+	case "type":
+		if slices.Contains(flags, "daddr") && s.result.Direction == DirectionInput {
+			return strValue("local"), true
+		}
+		return strValue("unicast"), true
+	case "oif", "oifname":
+		iface := s.packet.Oif
+		if slices.Contains(flags, "saddr") {
+			iface = s.packet.Iif
+		}
+		return value{str: iface, b: true, isBool: true}, true
+	}
+	s.note("%s: fib %s is not simulated", ctx.location, result)
+	return value{}, false
+}
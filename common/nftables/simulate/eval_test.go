@@ -0,0 +1,131 @@
+package simulate
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/woshikedayaa/fire/common/nftables/ruleset"
+)
+
+func loadRuleset(t *testing.T, name string) *ruleset.Ruleset {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rs, err := ruleset.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func packet(iif, oif, src, dst, proto string, dport uint16, state string) Packet {
+	return Packet{
+		Iif: iif, Oif: oif,
+		Src: netip.MustParseAddr(src), Dst: netip.MustParseAddr(dst),
+		Proto: proto, Sport: 40000, Dport: dport, CtState: state,
+	}
+}
+
+type verdictTest struct {
+	name    string
+	packet  Packet
+	verdict string
+	decided string
+}
+
+func runVerdictTests(t *testing.T, rs *ruleset.Ruleset, tests []verdictTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.packet
+			if err := p.Normalize(); err != nil {
+				t.Fatal(err)
+			}
+			r := Simulate(rs, p, p.Direction())
+			if r.Verdict != tt.verdict || r.Decided != tt.decided {
+				t.Errorf("%s: %s by %s, want %s by %s", &p, r.Verdict, r.Decided, tt.verdict, tt.decided)
+			}
+		})
+	}
+}
+
+func TestSimulateHost(t *testing.T) {
+	rs := loadRuleset(t, "host.json")
+	runVerdictTests(t, rs, []verdictTest{
+		{"ssh", packet("eth0", "", "198.51.100.7", "192.0.2.1", "tcp", 22, ""), "accept", "inet filter services handle 12"},
+		{"smtp", packet("eth0", "", "198.51.100.7", "192.0.2.1", "tcp", 25, ""), "drop", "inet filter input policy drop"},
+		{"blocked source", packet("eth0", "", "203.0.113.9", "192.0.2.1", "tcp", 22, ""), "drop", "inet filter input handle 9"},
+		{"ipv6 skips ip saddr", packet("eth0", "", "2001:db8::9", "2001:db8::1", "tcp", 443, ""), "accept", "inet filter services handle 12"},
+		{"vmap", packet("eth0", "", "198.51.100.7", "192.0.2.1", "udp", 51820, ""), "accept", "inet filter services handle 13"},
+		{"vmap without element", packet("eth0", "", "198.51.100.7", "192.0.2.1", "udp", 123, ""), "drop", "inet filter input policy drop"},
+		{"established", packet("eth0", "", "198.51.100.7", "192.0.2.1", "tcp", 25, "established"), "accept", "inet filter input handle 6"},
+		{"invalid", packet("eth0", "", "198.51.100.7", "192.0.2.1", "tcp", 22, "invalid"), "drop", "inet filter input handle 8"},
+		{"range and wildcard interface", packet("wg0", "", "10.8.0.2", "10.8.0.1", "tcp", 8042, ""), "accept", "inet filter services handle 14"},
+		{"range on another interface", packet("eth0", "", "10.8.0.2", "10.8.0.1", "tcp", 8042, ""), "drop", "inet filter input policy drop"},
+		{"loopback", packet("lo", "", "127.0.0.1", "127.0.0.1", "tcp", 25, ""), "accept", "inet filter input handle 7"},
+		{"icmp", packet("eth0", "", "198.51.100.7", "192.0.2.1", "icmp", 0, ""), "accept", "inet filter input handle 11"},
+		{"forward out", packet("wg0", "eth0", "10.8.0.2", "198.51.100.7", "tcp", 443, ""), "accept", "ip nat postrouting handle 4"},
+		{"forward in", packet("eth0", "wg0", "198.51.100.7", "10.8.0.2", "tcp", 443, ""), "drop", "inet filter forward policy drop"},
+	})
+}
+
+func TestSimulateNAT(t *testing.T) {
+	rs := loadRuleset(t, "host.json")
+	p := packet("eth0", "eth1", "198.51.100.7", "192.0.2.1", "tcp", 8080, "")
+	if err := p.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	r := Simulate(rs, p, DirectionForward)
+	if r.Verdict != "accept" {
+		t.Fatalf("verdict %s by %s", r.Verdict, r.Decided)
+	}
+	if r.Translated == nil || r.Translated.Dst != netip.MustParseAddr("10.0.0.2") || r.Translated.Dport != 80 {
+		t.Fatalf("translated = %v", r.Translated)
+	}
+	// the forward chain sees the translated destination
+	accepted := false
+	for _, step := range r.Steps {
+		if step.Kind == StepRule && step.Chain == "forward" && step.Handle == 16 && step.Verdict == "accept" {
+			accepted = true
+		}
+	}
+	if !accepted {
+		t.Errorf("rule 16 of forward did not accept the translated packet, steps %+v", r.Steps)
+	}
+	if len(r.Notes) != 1 || !strings.Contains(r.Notes[0], "not re-evaluated after dnat") {
+		t.Errorf("notes = %q", r.Notes)
+	}
+
+	// an established connection skips the nat chains
+	p.CtState = "established"
+	if r = Simulate(rs, p, DirectionForward); r.Translated != nil {
+		t.Errorf("established packet translated to %v", r.Translated)
+	}
+}
+
+func TestSimulateJumps(t *testing.T) {
+	rs := loadRuleset(t, "jumps.json")
+	runVerdictTests(t, rs, []verdictTest{
+		{"jump and accept", packet("eth0", "", "192.0.2.1", "192.0.2.2", "tcp", 80, ""), "accept", "ip t tcp handle 10"},
+		{"return goes on after the jump", packet("eth0", "", "192.0.2.1", "192.0.2.2", "tcp", 22, ""), "drop", "ip t input handle 8"},
+		{"end of a jumped chain goes on", packet("eth0", "", "192.0.2.1", "192.0.2.2", "tcp", 25, ""), "drop", "ip t input handle 8"},
+		{"goto and accept", packet("eth0", "", "192.0.2.1", "192.0.2.2", "udp", 53, ""), "accept", "ip t udp handle 11"},
+		{"end of a goto chain returns", packet("eth0", "", "192.0.2.1", "192.0.2.2", "udp", 54, ""), "accept", "ip t input policy accept"},
+		{"return in a goto chain returns", packet("eth0", "", "192.0.2.1", "192.0.2.2", "udp", 5353, ""), "accept", "ip t input policy accept"},
+	})
+
+	p := packet("eth0", "", "192.0.2.1", "192.0.2.2", "icmp", 0, "")
+	if err := p.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	r := Simulate(rs, p, DirectionInput)
+	if r.Verdict != "drop" || len(r.Notes) != 1 || !strings.Contains(r.Notes[0], "nested deeper than 16") {
+		t.Errorf("endless jump: %s by %s, notes %q", r.Verdict, r.Decided, r.Notes)
+	}
+}
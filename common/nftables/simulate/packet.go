@@ -0,0 +1,136 @@
+// Package simulate evaluates a ruleset against a synthetic packet without a
+// kernel: it walks the base chains of every hook the packet passes, in priority
+// order, and reports the verdict together with the rules on the way.
+package simulate
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/nftables"
+)
+
+type Direction string
+
+const (
+	// DirectionInput is a packet for the host: prerouting, input.
+	DirectionInput Direction = "input"
+	// DirectionForward is a routed packet: prerouting, forward, postrouting.
+	DirectionForward Direction = "forward"
+	// DirectionOutput is a packet of the host: output, postrouting.
+	DirectionOutput Direction = "output"
+)
+
+var hooks = map[Direction][]string{
+	DirectionInput:   {"prerouting", "input"},
+	DirectionForward: {"prerouting", "forward", "postrouting"},
+	DirectionOutput:  {"output", "postrouting"},
+}
+
+var protocols = map[string]uint64{
+	"icmp": 1, "igmp": 2, "tcp": 6, "udp": 17, "gre": 47, "esp": 50, "ah": 51,
+	"icmpv6": 58, "sctp": 132, "udplite": 136,
+}
+
+type Packet struct {
+	Family  nftables.Family `json:"family"`
+	Iif     string          `json:"iif,omitempty"`
+	Oif     string          `json:"oif,omitempty"`
+	Src     netip.Addr      `json:"src"`
+	Dst     netip.Addr      `json:"dst"`
+	Proto   string          `json:"proto"`
+	Sport   uint16          `json:"sport,omitempty"`
+	Dport   uint16          `json:"dport,omitempty"`
+	CtState string          `json:"ct_state"`
+	Mark    uint32          `json:"mark,omitempty"`
+	CtMark  uint32          `json:"ct_mark,omitempty"`
+}
+
+// Normalize fills the family from the addresses and checks the packet is complete.
+func (p *Packet) Normalize() error {
+	if !p.Src.IsValid() || !p.Dst.IsValid() {
+		return E.New("source and destination address are required")
+	}
+	p.Src, p.Dst = p.Src.Unmap(), p.Dst.Unmap()
+	if p.Src.Is4() != p.Dst.Is4() {
+		return E.New("source and destination address are of different families")
+	}
+	family := nftables.FamilyIPv6
+	if p.Src.Is4() {
+		family = nftables.FamilyIPv4
+	}
+	if p.Family == nftables.FamilyUnspecified {
+		p.Family = family
+	} else if p.Family != family {
+		return E.New("family ", p.Family, " does not match the addresses")
+	}
+	p.Proto = strings.ToLower(p.Proto)
+	if p.Proto == "" {
+		p.Proto = "tcp"
+	}
+	if _, ok := p.protoNumber(); !ok {
+		return E.New("unknown protocol: ", p.Proto)
+	}
+	if p.CtState == "" {
+		p.CtState = "new"
+	}
+	switch p.CtState {
+	case "new", "established", "related", "invalid", "untracked":
+	default:
+		return E.New("unknown ct state: ", p.CtState)
+	}
+	return nil
+}
+
+// Direction guesses where the packet goes from its interfaces.
+func (p *Packet) Direction() Direction {
+	switch {
+	case p.Iif != "" && p.Oif != "":
+		return DirectionForward
+	case p.Oif != "":
+		return DirectionOutput
+	default:
+		return DirectionInput
+	}
+}
+
+func (p *Packet) protoNumber() (uint64, bool) {
+	if n, ok := protocols[p.Proto]; ok {
+		return n, true
+	}
+	n, err := strconv.ParseUint(p.Proto, 10, 8)
+	return n, err == nil
+}
+
+// hasPorts reports whether the transport header carries ports.
+func (p *Packet) hasPorts() bool {
+	switch p.Proto {
+	case "tcp", "udp", "udplite", "sctp", "dccp":
+		return true
+	}
+	return false
+}
+
+func (p *Packet) String() string {
+	endpoint := func(addr netip.Addr, port uint16) string {
+		if p.hasPorts() {
+			return netip.AddrPortFrom(addr, port).String()
+		}
+		return addr.String()
+	}
+	s := fmt.Sprintf("%s %s %s -> %s", p.Family, p.Proto, endpoint(p.Src, p.Sport), endpoint(p.Dst, p.Dport))
+	if p.Iif != "" {
+		s += " iif " + p.Iif
+	}
+	if p.Oif != "" {
+		s += " oif " + p.Oif
+	}
+	s += " ct state " + p.CtState
+	if p.Mark != 0 {
+		s += fmt.Sprintf(" mark %#x", p.Mark)
+	}
+	return s
+}
@@ -0,0 +1,234 @@
+package simulate
+
+import (
+	"encoding/json"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// value is the result of evaluating an expression against the packet.
+type value struct {
+	addr  netip.Addr
+	num   uint64
+	isNum bool
+	str   string
+	// boolean results, like fib ... oif missing
+	b      bool
+	isBool bool
+	tuple  []value
+	// absent is set for a header the packet does not carry
+	absent bool
+}
+
+// symbols are the names nft prints for protocol numbers, families and ethertypes.
+var symbols = map[string]uint64{"ipv4": 2, "ipv6": 10, "ip": 0x0800, "ip6": 0x86dd}
+
+func init() {
+	for name, n := range protocols {
+		symbols[name] = n
+	}
+}
+
+func numValue(n uint64) value {
+	return value{num: n, isNum: true}
+}
+
+func strValue(s string) value {
+	return value{str: s}
+}
+
+func addrValue(a netip.Addr) value {
+	return value{addr: a}
+}
+
+// literal turns a constant of the JSON ruleset into a value.
+func literal(v any) (value, bool) {
+	switch v := v.(type) {
+	case float64:
+		return numValue(uint64(v)), true
+	case bool:
+		return value{b: v, isBool: true}, true
+	case string:
+		if addr, err := netip.ParseAddr(v); err == nil {
+			return addrValue(addr), true
+		}
+		if n, ok := parseNumber(v); ok {
+			return numValue(n), true
+		}
+		return strValue(v), true
+	case map[string]any:
+		if items, ok := v["concat"].([]any); ok {
+			var t value
+			for _, item := range items {
+				iv, ok := literal(item)
+				if !ok {
+					return value{}, false
+				}
+				t.tuple = append(t.tuple, iv)
+			}
+			return t, true
+		}
+	}
+	return value{}, false
+}
+
+func parseNumber(s string) (uint64, bool) {
+	n, err := strconv.ParseUint(s, 0, 64)
+	return n, err == nil
+}
+
+// lookup resolves named sets for member.
+type lookup func(ref string) ([]any, bool)
+
+// member reports whether v is one of the values described by right: a
+// constant, a list of flags, an anonymous set, a prefix, a range, a
+// concatenation or a @named set.
+func member(v value, right any, sets lookup) bool {
+	switch right := right.(type) {
+	case []any:
+		for _, item := range right {
+			if member(v, item, sets) {
+				return true
+			}
+		}
+		return false
+	case string:
+		if strings.HasPrefix(right, "@") {
+			elements, ok := sets(right)
+			if !ok {
+				return false
+			}
+			for _, element := range elements {
+				// map elements are [key, data]
+				if pair, ok := element.([]any); ok && len(pair) == 2 {
+					element = pair[0]
+				}
+				if member(v, element, sets) {
+					return true
+				}
+			}
+			return false
+		}
+		return equalString(v, right)
+	case map[string]any:
+		switch {
+		case right["set"] != nil:
+			return member(v, right["set"], sets)
+		case right["elem"] != nil:
+			if elem, ok := right["elem"].(map[string]any); ok {
+				return member(v, elem["val"], sets)
+			}
+		case right["prefix"] != nil:
+			prefix, ok := right["prefix"].(map[string]any)
+			if !ok || !v.addr.IsValid() {
+				return false
+			}
+			addr, err := netip.ParseAddr(stringOf(prefix["addr"]))
+			length, _ := prefix["len"].(float64)
+			if err != nil {
+				return false
+			}
+			p, err := addr.Prefix(int(length))
+			return err == nil && p.Contains(v.addr)
+		case right["range"] != nil:
+			bounds, ok := right["range"].([]any)
+			if !ok || len(bounds) != 2 {
+				return false
+			}
+			low, ok1 := literal(bounds[0])
+			high, ok2 := literal(bounds[1])
+			return ok1 && ok2 && compareValues(v, low) >= 0 && compareValues(v, high) <= 0
+		case right["concat"] != nil:
+			items, ok := right["concat"].([]any)
+			if !ok || len(items) != len(v.tuple) {
+				return false
+			}
+			for i, item := range items {
+				if !member(v.tuple[i], item, sets) {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	default:
+		r, ok := literal(right)
+		return ok && compareValues(v, r) == 0 && comparable(v, r)
+	}
+}
+
+func stringOf(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func equalString(v value, s string) bool {
+	switch {
+	case v.addr.IsValid():
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			return prefix.Contains(v.addr)
+		}
+		addr, err := netip.ParseAddr(s)
+		return err == nil && addr.Unmap() == v.addr
+	case v.str != "" && !v.isNum:
+		// interface names may end in a wildcard, "eth*"
+		if prefix, ok := strings.CutSuffix(s, "*"); ok {
+			return strings.HasPrefix(v.str, prefix)
+		}
+		return v.str == s
+	case v.str != "" && v.str == s:
+		return true
+	case v.isNum:
+		if n, ok := symbols[s]; ok {
+			return v.num == n
+		}
+		n, ok := parseNumber(s)
+		return ok && v.num == n
+	}
+	return false
+}
+
+func comparable(a, b value) bool {
+	return a.isNum == b.isNum && a.isBool == b.isBool && a.addr.IsValid() == b.addr.IsValid()
+}
+
+// compareValues orders two values of the same kind, mismatched kinds compare as different.
+func compareValues(a, b value) int {
+	switch {
+	case a.isNum && b.isNum:
+		switch {
+		case a.num < b.num:
+			return -1
+		case a.num > b.num:
+			return 1
+		}
+		return 0
+	case a.addr.IsValid() && b.addr.IsValid():
+		return a.addr.Compare(b.addr)
+	case a.isBool && b.isBool:
+		if a.b == b.b {
+			return 0
+		}
+		return 1
+	case a.isNum && b.str != "":
+		if equalString(a, b.str) {
+			return 0
+		}
+		return 1
+	case a.str != "" && b.str != "":
+		return strings.Compare(a.str, b.str)
+	}
+	return 1
+}
+
+func decodeElements(raws []json.RawMessage) []any {
+	elements := make([]any, 0, len(raws))
+	for _, raw := range raws {
+		var v any
+		if json.Unmarshal(raw, &v) == nil {
+			elements = append(elements, v)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"time"
+)
+
+// Retention describes which snapshots survive a prune. A snapshot is kept
+// when any of the rules keeps it; a zero Retention keeps everything.
+type Retention struct {
+	// KeepLast keeps the newest n snapshots.
+	KeepLast int `json:"keep_last,omitempty"`
+	// KeepWithin keeps every snapshot younger than the duration.
+	KeepWithin time.Duration `json:"keep_within,omitempty"`
+	// KeepDaily keeps the newest snapshot of each of the last n days that have one.
+	KeepDaily int `json:"keep_daily,omitempty"`
+}
+
+func (r Retention) IsZero() bool {
+	return r.KeepLast <= 0 && r.KeepWithin <= 0 && r.KeepDaily <= 0
+}
+
+// Expired returns the snapshots that r does not keep. metas must be sorted oldest first.
+func (r Retention) Expired(metas []Meta, now time.Time) []Meta {
+	if r.IsZero() {
+		return nil
+	}
+	keep := make([]bool, len(metas))
+	for i := range metas {
+		if r.KeepLast > 0 && i >= len(metas)-r.KeepLast {
+			keep[i] = true
+		}
+		if r.KeepWithin > 0 && now.Sub(metas[i].Time) <= r.KeepWithin {
+			keep[i] = true
+		}
+	}
+	if r.KeepDaily > 0 {
+		days := 0
+		lastDay := ""
+		for i := len(metas) - 1; i >= 0 && days < r.KeepDaily; i-- {
+			day := metas[i].Time.Local().Format(time.DateOnly)
+			if day != lastDay {
+				keep[i] = true
+				lastDay = day
+				days++
+			}
+		}
+	}
+
+	var expired []Meta
+	for i, meta := range metas {
+		if !keep[i] {
+			expired = append(expired, meta)
+		}
+	}
+	return expired
+}
+
+// Prune removes the snapshots expired under r and returns them.
+// With dryRun the store is left untouched.
+func (s *Store) Prune(r Retention, now time.Time, dryRun bool) ([]Meta, error) {
+	metas, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	expired := r.Expired(metas, now)
+	if dryRun {
+		return expired, nil
+	}
+	for _, meta := range expired {
+		if err = s.Remove(meta.ID); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,71 @@
+package snapshot
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetentionExpired(t *testing.T) {
+	now := time.Date(2026, 10, 10, 12, 0, 0, 0, time.Local)
+	// two snapshots a day for the last five days, oldest first
+	var metas []Meta
+	for day := 4; day >= 0; day-- {
+		for _, hour := range []int{8, 10} {
+			at := time.Date(2026, 10, 10-day, hour, 0, 0, 0, time.Local)
+			metas = append(metas, Meta{ID: at.Format("01-02T15"), Sequence: uint64(len(metas) + 1), Time: at})
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		r    Retention
+		kept []string
+	}{
+		{"zero keeps everything", Retention{}, []string{"10-06T08", "10-06T10", "10-07T08", "10-07T10", "10-08T08", "10-08T10", "10-09T08", "10-09T10", "10-10T08", "10-10T10"}},
+		{"last", Retention{KeepLast: 3}, []string{"10-09T10", "10-10T08", "10-10T10"}},
+		{"within", Retention{KeepWithin: 26 * time.Hour}, []string{"10-09T10", "10-10T08", "10-10T10"}},
+		{"daily keeps the newest of a day", Retention{KeepDaily: 2}, []string{"10-09T10", "10-10T10"}},
+		{"rules add up", Retention{KeepLast: 1, KeepDaily: 3}, []string{"10-08T10", "10-09T10", "10-10T10"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			expired := tt.r.Expired(metas, now)
+			var kept []string
+			for _, meta := range metas {
+				if !slices.ContainsFunc(expired, func(m Meta) bool { return m.ID == meta.ID }) {
+					kept = append(kept, meta.ID)
+				}
+			}
+			if !slices.Equal(kept, tt.kept) {
+				t.Errorf("kept %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		saveAt(t, s, at.Add(time.Duration(i)*time.Hour), "")
+	}
+
+	removed, err := s.Prune(Retention{KeepLast: 1}, at, true)
+	if err != nil || len(removed) != 3 {
+		t.Fatalf("dry run Prune = %v, %v", removed, err)
+	}
+	if metas, _ := s.List(); len(metas) != 4 {
+		t.Errorf("dry run removed snapshots, %d left", len(metas))
+	}
+
+	if removed, err = s.Prune(Retention{KeepLast: 1}, at, false); err != nil || len(removed) != 3 {
+		t.Fatalf("Prune = %v, %v", removed, err)
+	}
+	metas, err := s.List()
+	if err != nil || len(metas) != 1 || metas[0].Sequence != 4 {
+		t.Errorf("after Prune List = %v, %v", metas, err)
+	}
+}
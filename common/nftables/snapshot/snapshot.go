@@ -0,0 +1,42 @@
+// Package snapshot implements a local, versioned history of the nftables ruleset.
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	metaFileName = "meta.json"
+	jsonFileName = "ruleset.json"
+	textFileName = "ruleset.nft"
+
+	timeLayout = "20060102T150405Z"
+)
+
+type Meta struct {
+	ID       string    `json:"id"`
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Version  string    `json:"version"`
+	Message  string    `json:"message,omitempty"`
+	Checksum string    `json:"checksum"` // sha256 of the text ruleset
+}
+
+type Snapshot struct {
+	Meta
+	JSON []byte `json:"-"`
+	Text []byte `json:"-"`
+}
+
+func formatID(seq uint64, t time.Time) string {
+	return fmt.Sprintf("%06d-%s", seq, t.UTC().Format(timeLayout))
+}
+
+func checksum(text []byte) string {
+	sum := sha256.Sum256(text)
+	return hex.EncodeToString(sum[:])
+}
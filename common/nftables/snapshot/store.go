@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Store keeps every snapshot in its own directory below dir:
+//
+//	<dir>/<sequence>-<time>/meta.json
+//	<dir>/<sequence>-<time>/ruleset.json
+//	<dir>/<sequence>-<time>/ruleset.nft
+type Store struct {
+	dir string
+}
+
+func Open(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, E.New("snapshot store directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, E.When("create snapshot store", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Save assigns the next sequence number to the snapshot and writes it to the store.
+// The snapshot becomes visible only once all of its files have been written.
+func (s *Store) Save(snap Snapshot) (Meta, error) {
+	metas, err := s.List()
+	if err != nil {
+		return Meta{}, err
+	}
+	var seq uint64 = 1
+	if len(metas) > 0 {
+		seq = metas[len(metas)-1].Sequence + 1
+	}
+	snap.Sequence = seq
+	snap.ID = formatID(seq, snap.Time)
+	snap.Checksum = checksum(snap.Text)
+
+	tmp, err := os.MkdirTemp(s.dir, ".tmp-")
+	if err != nil {
+		return Meta{}, E.When("create snapshot", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	metaData, err := json.MarshalIndent(snap.Meta, "", "  ")
+	if err != nil {
+		return Meta{}, err
+	}
+	files := map[string][]byte{
+		metaFileName: metaData,
+		jsonFileName: snap.JSON,
+		textFileName: snap.Text,
+	}
+	for name, data := range files {
+		if err = os.WriteFile(filepath.Join(tmp, name), data, 0o600); err != nil {
+			return Meta{}, E.When("write "+name, err)
+		}
+	}
+	if err = os.Rename(tmp, filepath.Join(s.dir, snap.ID)); err != nil {
+		return Meta{}, E.When("commit snapshot", err)
+	}
+	return snap.Meta, nil
+}
+
+// List returns the metadata of all snapshots, oldest first.
+func (s *Store) List() ([]Meta, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, E.When("read snapshot store", err)
+	}
+	var metas []Meta
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		meta, err := s.readMeta(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	slices.SortFunc(metas, func(a, b Meta) int {
+		return cmp.Compare(a.Sequence, b.Sequence)
+	})
+	return metas, nil
+}
+
+// Resolve finds a snapshot by full id, sequence number or "latest".
+func (s *Store) Resolve(ref string) (Meta, error) {
+	metas, err := s.List()
+	if err != nil {
+		return Meta{}, err
+	}
+	if len(metas) == 0 {
+		return Meta{}, E.New("snapshot store is empty")
+	}
+	if ref == "latest" {
+		return metas[len(metas)-1], nil
+	}
+	seq, seqErr := strconv.ParseUint(ref, 10, 64)
+	for _, meta := range metas {
+		if meta.ID == ref || (seqErr == nil && meta.Sequence == seq) {
+			return meta, nil
+		}
+	}
+	return Meta{}, E.New("snapshot not found: ", ref)
+}
+
+func (s *Store) Get(ref string) (Snapshot, error) {
+	meta, err := s.Resolve(ref)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snap := Snapshot{Meta: meta}
+	snap.JSON, err = os.ReadFile(filepath.Join(s.dir, meta.ID, jsonFileName))
+	if err != nil {
+		return Snapshot{}, E.When("read snapshot", err)
+	}
+	snap.Text, err = os.ReadFile(filepath.Join(s.dir, meta.ID, textFileName))
+	if err != nil {
+		return Snapshot{}, E.When("read snapshot", err)
+	}
+	if checksum(snap.Text) != meta.Checksum {
+		return Snapshot{}, E.New("snapshot ", meta.ID, " is corrupted: checksum mismatch")
+	}
+	return snap, nil
+}
+
+func (s *Store) Remove(id string) error {
+	if id == "" || strings.ContainsRune(id, filepath.Separator) {
+		return E.New("invalid snapshot id: ", id)
+	}
+	return os.RemoveAll(filepath.Join(s.dir, id))
+}
+
+func (s *Store) readMeta(id string) (Meta, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, id, metaFileName))
+	if err != nil {
+		return Meta{}, E.When("read snapshot "+id, err)
+	}
+	var meta Meta
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return Meta{}, E.When("decode snapshot "+id, err)
+	}
+	return meta, nil
+}
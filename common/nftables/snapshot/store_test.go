@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func saveAt(t *testing.T, s *Store, at time.Time, text string) Meta {
+	t.Helper()
+	meta, err := s.Save(Snapshot{
+		Meta: Meta{Time: at, Hostname: "fw1", Version: "test"},
+		JSON: []byte(`{"nftables":[]}`),
+		Text: []byte(text),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+func TestStore(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Resolve("latest"); err == nil {
+		t.Error("Resolve on an empty store succeeded")
+	}
+
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	first := saveAt(t, s, at, "table inet a {\n}\n")
+	second := saveAt(t, s, at.Add(time.Hour), "table inet b {\n}\n")
+	if first.ID != "000001-20261001T120000Z" || second.Sequence != 2 {
+		t.Errorf("saved %s and %s #%d", first.ID, second.ID, second.Sequence)
+	}
+
+	metas, err := s.List()
+	if err != nil || len(metas) != 2 || metas[0].ID != first.ID || metas[1].ID != second.ID {
+		t.Fatalf("List = %v, %v", metas, err)
+	}
+	for ref, want := range map[string]string{"latest": second.ID, "1": first.ID, second.ID: second.ID} {
+		if meta, err := s.Resolve(ref); err != nil || meta.ID != want {
+			t.Errorf("Resolve(%q) = %s, %v, want %s", ref, meta.ID, err, want)
+		}
+	}
+	if _, err = s.Resolve("3"); err == nil {
+		t.Error("Resolve(3) succeeded")
+	}
+
+	snap, err := s.Get("1")
+	if err != nil || string(snap.Text) != "table inet a {\n}\n" || string(snap.JSON) != `{"nftables":[]}` || snap.Hostname != "fw1" {
+		t.Fatalf("Get(1) = %+v, %v", snap, err)
+	}
+
+	// a changed ruleset no longer matches the checksum
+	if err = os.WriteFile(filepath.Join(s.Dir(), first.ID, textFileName), []byte("flush ruleset\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get("1"); err == nil {
+		t.Error("Get of a corrupted snapshot succeeded")
+	}
+
+	if err = s.Remove("../" + first.ID); err == nil {
+		t.Error("Remove accepted a path")
+	}
+	if err = s.Remove(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	// sequence numbers are not reused
+	if third := saveAt(t, s, at.Add(2*time.Hour), ""); third.Sequence != 3 {
+		t.Errorf("sequence after a removal = %d", third.Sequence)
+	}
+}
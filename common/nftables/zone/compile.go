@@ -0,0 +1,316 @@
+package zone
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/woshikedayaa/fire/common/nftables/set"
+)
+
+var portProtocols = []string{"tcp", "udp", "sctp", "dccp"}
+
+// Compile validates c and renders it as an nft script that atomically
+// replaces the inet table c.Table.
+func Compile(c *Config) (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	cc := &compiler{config: c}
+	return cc.compile(), nil
+}
+
+type compiler struct {
+	config *Config
+	sb     strings.Builder
+	// sets remembers which named sets have elements
+	sets map[string]bool
+}
+
+func (cc *compiler) line(depth int, format string, a ...any) {
+	if format == "" {
+		cc.sb.WriteByte('\n')
+		return
+	}
+	cc.sb.WriteString(strings.Repeat("\t", depth))
+	fmt.Fprintf(&cc.sb, format, a...)
+	cc.sb.WriteByte('\n')
+}
+
+func (cc *compiler) compile() string {
+	c := cc.config
+	cc.sets = make(map[string]bool)
+	// declaring the table first lets the delete succeed when it does not exist yet
+	cc.line(0, "table inet %s", c.Table)
+	cc.line(0, "delete table inet %s", c.Table)
+	cc.line(0, "table inet %s {", c.Table)
+	for _, zone := range c.Zones {
+		cc.zoneSets(zone)
+	}
+	cc.inputChain()
+	cc.forwardChain()
+	cc.postroutingChain()
+	for _, zone := range c.Zones {
+		cc.zoneInput(zone)
+	}
+	for _, zone := range c.Zones {
+		cc.zoneForward(zone)
+	}
+	cc.line(0, "}")
+	return cc.sb.String()
+}
+
+func setName(zone, kind string) string {
+	return "zone_" + zone + "_" + kind
+}
+
+func (cc *compiler) addSet(s set.Set) {
+	if len(s.Elements) == 0 {
+		return
+	}
+	cc.sets[s.Name] = true
+	cc.line(1, "%s", s.AsNamed())
+}
+
+// zoneSets declares the interfaces, sources and ports of zone as named sets.
+func (cc *compiler) zoneSets(zone Zone) {
+	ifaces := make([]string, 0, len(zone.Interfaces))
+	for _, iface := range zone.Interfaces {
+		ifaces = append(ifaces, strconv.Quote(iface))
+	}
+	cc.addSet(set.Set{Name: setName(zone.Name, "ifaces"), Type: set.TypeIfname, Elements: ifaces})
+
+	var v4, v6 []string
+	for _, source := range zone.Sources {
+		prefix, _ := parseSource(source)
+		if prefix.Addr().Is4() {
+			v4 = append(v4, prefix.String())
+		} else {
+			v6 = append(v6, prefix.String())
+		}
+	}
+	cc.addSet(set.Set{Name: setName(zone.Name, "src4"), Type: set.TypeIpv4Addr, Flag: []set.Flag{set.FlagInterval}, AutoMerge: true, Elements: v4})
+	cc.addSet(set.Set{Name: setName(zone.Name, "src6"), Type: set.TypeIpv6Addr, Flag: []set.Flag{set.FlagInterval}, AutoMerge: true, Elements: v6})
+
+	ports := cc.zonePorts(zone)
+	for _, protocol := range portProtocols {
+		s := set.Set{Name: setName(zone.Name, protocol), Type: set.TypeInetService}
+		for _, port := range ports {
+			if port.Protocol != protocol || slices.Contains(s.Elements, port.Port) {
+				continue
+			}
+			s.Elements = append(s.Elements, port.Port)
+			if strings.Contains(port.Port, "-") && len(s.Flag) == 0 {
+				s.Flag = []set.Flag{set.FlagInterval}
+				s.AutoMerge = true
+			}
+		}
+		cc.addSet(s)
+	}
+}
+
+// zonePorts returns the ports of the zone and of its services.
+func (cc *compiler) zonePorts(zone Zone) []Port {
+	var ports []Port
+	for _, name := range zone.Services {
+		service, _ := cc.config.Service(name)
+		ports = append(ports, service.Ports...)
+	}
+	return append(ports, zone.Ports...)
+}
+
+func (cc *compiler) zoneProtocols(zone Zone) []string {
+	var protocols []string
+	for _, name := range zone.Services {
+		service, _ := cc.config.Service(name)
+		protocols = append(protocols, service.Protocols...)
+	}
+	protocols = append(protocols, zone.Protocols...)
+	slices.Sort(protocols)
+	return slices.Compact(protocols)
+}
+
+// ingress sends the packet to the chain of the zone it comes from: sources
+// first, then interfaces, then the default zone.
+func (cc *compiler) ingress(kind string) {
+	for _, zone := range cc.config.Zones {
+		chain := setName(zone.Name, kind)
+		if cc.sets[setName(zone.Name, "src4")] {
+			cc.line(2, "ip saddr @%s jump %s", setName(zone.Name, "src4"), chain)
+		}
+		if cc.sets[setName(zone.Name, "src6")] {
+			cc.line(2, "ip6 saddr @%s jump %s", setName(zone.Name, "src6"), chain)
+		}
+	}
+	for _, zone := range cc.config.Zones {
+		if cc.sets[setName(zone.Name, "ifaces")] {
+			cc.line(2, "iifname @%s jump %s", setName(zone.Name, "ifaces"), setName(zone.Name, kind))
+		}
+	}
+	cc.line(2, "jump %s", setName(cc.config.DefaultZone, kind))
+}
+
+func (cc *compiler) inputChain() {
+	cc.line(1, "")
+	cc.line(1, "chain input {")
+	cc.line(2, "type filter hook input priority filter; policy drop;")
+	cc.line(2, "ct state established,related accept")
+	cc.line(2, "ct state invalid drop")
+	cc.line(2, `iifname "lo" accept`)
+	cc.ingress("input")
+	cc.line(1, "}")
+}
+
+func (cc *compiler) forwardChain() {
+	cc.line(1, "")
+	cc.line(1, "chain forward {")
+	cc.line(2, "type filter hook forward priority filter; policy drop;")
+	cc.line(2, "ct state established,related accept")
+	cc.line(2, "ct state invalid drop")
+	cc.ingress("forward")
+	cc.line(1, "}")
+}
+
+// postroutingChain masquerades traffic leaving through a zone with masquerade set.
+func (cc *compiler) postroutingChain() {
+	var claimed []string
+	for _, zone := range cc.config.Zones {
+		for _, iface := range zone.Interfaces {
+			claimed = append(claimed, strconv.Quote(iface))
+		}
+	}
+	var rules []string
+	for _, zone := range cc.config.Zones {
+		if !zone.Masquerade {
+			continue
+		}
+		if cc.sets[setName(zone.Name, "ifaces")] {
+			rules = append(rules, fmt.Sprintf("oifname @%s masquerade", setName(zone.Name, "ifaces")))
+		}
+		if zone.Name == cc.config.DefaultZone {
+			claimed := set.Set{Elements: append([]string{`"lo"`}, claimed...)}
+			rules = append(rules, fmt.Sprintf("oifname != %s masquerade", claimed.AsAnonymous()))
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+	cc.line(1, "")
+	cc.line(1, "chain postrouting {")
+	cc.line(2, "type nat hook postrouting priority srcnat; policy accept;")
+	for _, rule := range rules {
+		cc.line(2, "%s", rule)
+	}
+	cc.line(1, "}")
+}
+
+func (cc *compiler) zoneInput(zone Zone) {
+	chain := setName(zone.Name, "input")
+	cc.line(1, "")
+	if zone.Description != "" {
+		cc.line(1, "# %s", zone.Description)
+	}
+	cc.line(1, "chain %s {", chain)
+	for _, protocol := range portProtocols {
+		if name := setName(zone.Name, protocol); cc.sets[name] {
+			cc.line(2, "%s dport @%s accept", protocol, name)
+		}
+	}
+	if protocols := cc.zoneProtocols(zone); len(protocols) > 0 {
+		cc.line(2, "meta l4proto %s accept", (&set.Set{Elements: protocols}).AsAnonymous())
+	}
+	cc.icmp(zone)
+	cc.target(zone.Name, zone.Target)
+	cc.line(1, "}")
+}
+
+// icmp accepts every ICMP type but the blocked ones, or with Invert only the
+// listed ones. Blocked types are rejected like firewalld does.
+func (cc *compiler) icmp(zone Zone) {
+	var v4, v6 []string
+	for _, name := range zone.ICMP.Block {
+		types := icmpTypes[name]
+		if types[0] != "" {
+			v4 = append(v4, types[0])
+		}
+		if types[1] != "" {
+			v6 = append(v6, types[1])
+		}
+	}
+	verdict := "reject with icmpx admin-prohibited"
+	if zone.ICMP.Invert {
+		verdict = "accept"
+	}
+	if len(v4) > 0 {
+		cc.line(2, "icmp type %s %s", (&set.Set{Elements: v4}).AsAnonymous(), verdict)
+	}
+	if len(v6) > 0 {
+		cc.line(2, "icmpv6 type %s %s", (&set.Set{Elements: v6}).AsAnonymous(), verdict)
+	}
+	if zone.ICMP.Invert {
+		cc.line(2, "meta l4proto {icmp,ipv6-icmp} reject with icmpx admin-prohibited")
+	} else {
+		cc.line(2, "meta l4proto {icmp,ipv6-icmp} accept")
+	}
+}
+
+// target ends a zone chain with the verdict of target.
+func (cc *compiler) target(zone string, target Target) {
+	if cc.config.LogDenied && target != TargetAccept {
+		cc.line(2, `log prefix "fire %s denied: "`, zone)
+	}
+	cc.line(2, "%s", verdict(target))
+}
+
+func verdict(target Target) string {
+	switch target {
+	case TargetAccept:
+		return "accept"
+	case TargetDrop:
+		return "drop"
+	}
+	return "reject with icmpx admin-prohibited"
+}
+
+// zoneForward decides traffic forwarded from zone by the zone it leaves to.
+func (cc *compiler) zoneForward(from Zone) {
+	cc.line(1, "")
+	cc.line(1, "chain %s {", setName(from.Name, "forward"))
+	for _, to := range cc.config.Zones {
+		v := cc.forwardVerdict(from, to.Name)
+		if cc.sets[setName(to.Name, "src4")] {
+			cc.line(2, "ip daddr @%s %s", setName(to.Name, "src4"), v)
+		}
+		if cc.sets[setName(to.Name, "src6")] {
+			cc.line(2, "ip6 daddr @%s %s", setName(to.Name, "src6"), v)
+		}
+	}
+	for _, to := range cc.config.Zones {
+		if cc.sets[setName(to.Name, "ifaces")] {
+			cc.line(2, "oifname @%s %s", setName(to.Name, "ifaces"), cc.forwardVerdict(from, to.Name))
+		}
+	}
+	v := cc.forwardVerdict(from, cc.config.DefaultZone)
+	if cc.config.LogDenied && v != "accept" {
+		cc.line(2, `log prefix "fire %s denied: "`, from.Name)
+	}
+	cc.line(2, "%s", v)
+	cc.line(1, "}")
+}
+
+// forwardVerdict is the verdict for traffic from one zone to another: forward
+// within a zone, then the most specific policy, then the target of from.
+func (cc *compiler) forwardVerdict(from Zone, to string) string {
+	if from.Name == to && from.Forward {
+		return "accept"
+	}
+	for _, pair := range [][2]string{{from.Name, to}, {from.Name, AnyZone}, {AnyZone, to}, {AnyZone, AnyZone}} {
+		for _, policy := range cc.config.Policies {
+			if policy.From == pair[0] && policy.To == pair[1] {
+				return verdict(policy.Action)
+			}
+		}
+	}
+	return verdict(from.Target)
+}
@@ -0,0 +1,73 @@
+package zone
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func loadConfig(t *testing.T) *Config {
+	t.Helper()
+	data, err := os.ReadFile("testdata/zones.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c Config
+	if err = json.Unmarshal(data, &c); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func TestCompile(t *testing.T) {
+	got, err := Compile(loadConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/zones.nft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("compiled:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		edit func(c *Config)
+		err  string
+	}{
+		{func(c *Config) { c.Table = "bad name" }, "invalid table name: bad name"},
+		{func(c *Config) { c.Zones = nil }, "no zones"},
+		{func(c *Config) { c.DefaultZone = "home" }, "default zone home does not exist"},
+		{func(c *Config) { c.Zones[1].Name = "public" }, "zone public is defined twice"},
+		{func(c *Config) { c.Zones[1].Name = AnyZone }, "invalid zone name: ANY"},
+		{func(c *Config) { c.Zones[2].Interfaces = []string{"eth0"} }, "interface eth0 is in zone public and dmz"},
+		{func(c *Config) { c.Zones[2].Sources = []string{"fd00::1"} }, "source fd00::1 is in zone lan and dmz"},
+		{func(c *Config) { c.Zones[2].Sources = []string{"host"} }, "zone dmz: invalid source: host"},
+		{func(c *Config) { c.Zones[0].Services = []string{"gopher"} }, "zone public: unknown service: gopher"},
+		{func(c *Config) { c.Zones[0].Ports = []Port{{"0", "tcp"}} }, "zone public: port 0/tcp: invalid port"},
+		{func(c *Config) { c.Zones[0].Ports = []Port{{"80", "icmp"}} }, "zone public: port 80/icmp: unsupported protocol"},
+		{func(c *Config) { c.Zones[0].ICMP.Block = []string{"ping"} }, "zone public: unknown icmp type: ping"},
+		{func(c *Config) { c.Zones[0].Target = "allow" }, "zone public: invalid target: allow"},
+		{func(c *Config) { c.Policies[0].To = "wan" }, "policy lan to wan: zone wan does not exist"},
+		{func(c *Config) { c.Services[0].Protocols = []string{"E S P"} }, "service web: invalid protocol: E S P"},
+	} {
+		c := loadConfig(t)
+		tt.edit(c)
+		if err := c.Validate(); err == nil || err.Error() != tt.err {
+			t.Errorf("Validate error = %v, want %s", err, tt.err)
+		}
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+	c := &Config{Zones: []Zone{{Name: "a"}}, Policies: []Policy{{From: AnyZone, To: "a"}}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Table != DefaultTable || c.DefaultZone != "a" || c.Zones[0].Target != TargetDefault || c.Policies[0].Action != TargetReject {
+		t.Errorf("defaults = %+v", c)
+	}
+}
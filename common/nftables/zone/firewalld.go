@@ -0,0 +1,367 @@
+package zone
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+// FirewalldDirs are read in order, a file in a later directory replaces the
+// file of the same name in an earlier one.
+var FirewalldDirs = []string{"/usr/lib/firewalld", "/etc/firewalld"}
+
+type firewalldZone struct {
+	Target      string `xml:"target,attr"`
+	Short       string `xml:"short"`
+	Description string `xml:"description"`
+	Interfaces  []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"interface"`
+	Sources []struct {
+		Address string `xml:"address,attr"`
+		MAC     string `xml:"mac,attr"`
+		IPSet   string `xml:"ipset,attr"`
+	} `xml:"source"`
+	Services []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"service"`
+	Ports       []firewalldPort `xml:"port"`
+	SourcePorts []firewalldPort `xml:"source-port"`
+	Protocols   []struct {
+		Value string `xml:"value,attr"`
+	} `xml:"protocol"`
+	ICMPBlocks []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"icmp-block"`
+	ICMPBlockInversion *struct{} `xml:"icmp-block-inversion"`
+	Masquerade         *struct{} `xml:"masquerade"`
+	Forward            *struct{} `xml:"forward"`
+	ForwardPorts       []struct {
+		Port     string `xml:"port,attr"`
+		Protocol string `xml:"protocol,attr"`
+		ToPort   string `xml:"to-port,attr"`
+		ToAddr   string `xml:"to-addr,attr"`
+	} `xml:"forward-port"`
+	Rules []firewalldRule `xml:"rule"`
+}
+
+type firewalldPort struct {
+	Port     string `xml:"port,attr"`
+	Protocol string `xml:"protocol,attr"`
+}
+
+// firewalldRule is only decoded far enough to report it.
+type firewalldRule struct {
+	Family string `xml:"family,attr"`
+	Inner  []byte `xml:",innerxml"`
+}
+
+type firewalldService struct {
+	Short       string          `xml:"short"`
+	Ports       []firewalldPort `xml:"port"`
+	SourcePorts []firewalldPort `xml:"source-port"`
+	Protocols   []struct {
+		Value string `xml:"value,attr"`
+	} `xml:"protocol"`
+	Modules []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"module"`
+	Helpers []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"helper"`
+	Destination *struct {
+		IPv4 string `xml:"ipv4,attr"`
+		IPv6 string `xml:"ipv6,attr"`
+	} `xml:"destination"`
+	Includes []struct {
+		Service string `xml:"service,attr"`
+	} `xml:"include"`
+}
+
+type firewalldPolicy struct {
+	Target  string `xml:"target,attr"`
+	Ingress []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"ingress-zone"`
+	Egress []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"egress-zone"`
+	Services []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"service"`
+	Ports        []firewalldPort `xml:"port"`
+	Masquerade   *struct{}       `xml:"masquerade"`
+	ForwardPorts []struct{}      `xml:"forward-port"`
+	Rules        []firewalldRule `xml:"rule"`
+}
+
+// Import is the result of converting a firewalld configuration.
+type Import struct {
+	Config *Config `json:"config"`
+	// Skipped lists the settings that have no equivalent in the zone model.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// ImportFirewalld reads the zone, service and policy XML files below dirs
+// (FirewalldDirs when empty). Only zones with interfaces or sources and the
+// default zone are imported, unless all is set.
+func ImportFirewalld(fsys system.FS, dirs []string, all bool) (*Import, error) {
+	if len(dirs) == 0 {
+		dirs = FirewalldDirs
+	}
+	zones, err := readFirewalldFiles[firewalldZone](fsys, dirs, "zones")
+	if err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, E.New("no firewalld zones in ", strings.Join(dirs, ", "))
+	}
+	services, err := readFirewalldFiles[firewalldService](fsys, dirs, "services")
+	if err != nil {
+		return nil, err
+	}
+	policies, err := readFirewalldFiles[firewalldPolicy](fsys, dirs, "policies")
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &Import{Config: &Config{DefaultZone: firewalldDefaultZone(fsys, dirs)}}
+	skip := func(a ...any) {
+		imp.Skipped = append(imp.Skipped, fmt.Sprint(a...))
+	}
+	if _, ok := zones[imp.Config.DefaultZone]; !ok {
+		imp.Config.DefaultZone = ""
+	}
+
+	used := make(map[string]bool)
+	for _, name := range sortedKeys(zones) {
+		fz := zones[name]
+		if !all && name != imp.Config.DefaultZone && len(fz.Interfaces) == 0 && len(fz.Sources) == 0 {
+			continue
+		}
+		zone := Zone{
+			Name:        name,
+			Description: strings.TrimSpace(fz.Short),
+			Target:      firewalldTarget(fz.Target),
+			ICMP:        ICMP{Invert: fz.ICMPBlockInversion != nil},
+			Masquerade:  fz.Masquerade != nil,
+			Forward:     fz.Forward != nil,
+		}
+		for _, iface := range fz.Interfaces {
+			zone.Interfaces = append(zone.Interfaces, iface.Name)
+		}
+		for _, source := range fz.Sources {
+			switch {
+			case source.Address != "":
+				zone.Sources = append(zone.Sources, source.Address)
+			case source.IPSet != "":
+				skip("zone ", name, ": source ipset ", source.IPSet)
+			case source.MAC != "":
+				skip("zone ", name, ": source mac ", source.MAC)
+			}
+		}
+		for _, service := range fz.Services {
+			if _, ok := services[service.Name]; !ok {
+				skip("zone ", name, ": service ", service.Name, " is not defined")
+				continue
+			}
+			zone.Services = append(zone.Services, service.Name)
+			used[service.Name] = true
+		}
+		for _, port := range fz.Ports {
+			zone.Ports = append(zone.Ports, Port{Port: port.Port, Protocol: port.Protocol})
+		}
+		for _, protocol := range fz.Protocols {
+			zone.Protocols = append(zone.Protocols, protocol.Value)
+		}
+		for _, block := range fz.ICMPBlocks {
+			if _, ok := icmpTypes[block.Name]; !ok {
+				skip("zone ", name, ": icmp-block ", block.Name)
+				continue
+			}
+			zone.ICMP.Block = append(zone.ICMP.Block, block.Name)
+		}
+		for _, port := range fz.SourcePorts {
+			skip("zone ", name, ": source-port ", port.Port, "/", port.Protocol)
+		}
+		for _, fp := range fz.ForwardPorts {
+			skip("zone ", name, ": forward-port ", fp.Port, "/", fp.Protocol, " to ", fp.ToAddr, ":", fp.ToPort)
+		}
+		for _, rule := range fz.Rules {
+			skip("zone ", name, ": rich rule ", rule.summary())
+		}
+		imp.Config.Zones = append(imp.Config.Zones, zone)
+	}
+
+	// services include each other, resolve them all before copying the used ones
+	resolved := make(map[string]Service)
+	var resolve func(name string, seen []string) Service
+	resolve = func(name string, seen []string) Service {
+		if s, ok := resolved[name]; ok {
+			return s
+		}
+		fs := services[name]
+		s := Service{Name: name, Description: strings.TrimSpace(fs.Short)}
+		for _, port := range fs.Ports {
+			s.Ports = append(s.Ports, Port{Port: port.Port, Protocol: port.Protocol})
+		}
+		for _, protocol := range fs.Protocols {
+			s.Protocols = append(s.Protocols, protocol.Value)
+		}
+		for _, include := range fs.Includes {
+			if _, ok := services[include.Service]; !ok || slices.Contains(seen, include.Service) {
+				skip("service ", name, ": include ", include.Service)
+				continue
+			}
+			included := resolve(include.Service, append(seen, name))
+			s.Ports = append(s.Ports, included.Ports...)
+			s.Protocols = append(s.Protocols, included.Protocols...)
+		}
+		for _, port := range fs.SourcePorts {
+			skip("service ", name, ": source-port ", port.Port, "/", port.Protocol)
+		}
+		for _, module := range fs.Modules {
+			skip("service ", name, ": module ", module.Name)
+		}
+		for _, helper := range fs.Helpers {
+			skip("service ", name, ": helper ", helper.Name)
+		}
+		if fs.Destination != nil {
+			skip("service ", name, ": destination ", strings.TrimSpace(fs.Destination.IPv4+" "+fs.Destination.IPv6))
+		}
+		resolved[name] = s
+		return s
+	}
+	for _, name := range sortedKeys(services) {
+		if used[name] {
+			imp.Config.Services = append(imp.Config.Services, resolve(name, nil))
+		}
+	}
+
+	for _, name := range sortedKeys(policies) {
+		imp.addPolicy(name, policies[name], skip)
+	}
+	if err = imp.Config.Validate(); err != nil {
+		return nil, E.When("validate imported zones", err)
+	}
+	return imp, nil
+}
+
+func (imp *Import) addPolicy(name string, fp firewalldPolicy, skip func(...any)) {
+	var action Target
+	switch fp.Target {
+	case "ACCEPT":
+		action = TargetAccept
+	case "DROP":
+		action = TargetDrop
+	case "REJECT", "%%REJECT%%":
+		action = TargetReject
+	default:
+		skip("policy ", name, ": target ", fp.Target)
+		return
+	}
+	if len(fp.Services) > 0 || len(fp.Ports) > 0 || len(fp.Rules) > 0 || len(fp.ForwardPorts) > 0 || fp.Masquerade != nil {
+		skip("policy ", name, ": services, ports, rules, forward ports and masquerade of policies")
+	}
+	for _, ingress := range fp.Ingress {
+		for _, egress := range fp.Egress {
+			if ingress.Name == "HOST" || egress.Name == "HOST" {
+				skip("policy ", name, ": ", ingress.Name, " to ", egress.Name, ", only forwarding policies are imported")
+				continue
+			}
+			if !imp.hasZone(ingress.Name) || !imp.hasZone(egress.Name) {
+				continue
+			}
+			imp.Config.Policies = append(imp.Config.Policies, Policy{Name: name, From: ingress.Name, To: egress.Name, Action: action})
+		}
+	}
+}
+
+func (imp *Import) hasZone(name string) bool {
+	_, ok := imp.Config.Zone(name)
+	return ok || name == AnyZone
+}
+
+func (r firewalldRule) summary() string {
+	s := strings.Join(strings.Fields(string(r.Inner)), " ")
+	if r.Family != "" {
+		s = "family=" + r.Family + " " + s
+	}
+	return s
+}
+
+func firewalldTarget(target string) Target {
+	switch target {
+	case "ACCEPT":
+		return TargetAccept
+	case "DROP":
+		return TargetDrop
+	case "%%REJECT%%", "REJECT":
+		return TargetReject
+	}
+	return TargetDefault
+}
+
+// firewalldDefaultZone reads DefaultZone from firewalld.conf.
+func firewalldDefaultZone(fsys system.FS, dirs []string) string {
+	zone := "public"
+	for _, dir := range dirs {
+		data, err := fsys.ReadFile(path.Join(dir, "firewalld.conf"))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if ok && key == "DefaultZone" && value != "" {
+				zone = value
+			}
+		}
+	}
+	return zone
+}
+
+// readFirewalldFiles decodes the XML files in the kind directory below each
+// of dirs, keyed by the file name without .xml.
+func readFirewalldFiles[T any](fsys system.FS, dirs []string, kind string) (map[string]T, error) {
+	files := make(map[string]T)
+	for _, dir := range dirs {
+		entries, err := fsys.ReadDir(path.Join(dir, kind))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), ".xml")
+			if !ok || entry.IsDir() {
+				continue
+			}
+			file := path.Join(dir, kind, entry.Name())
+			data, err := fsys.ReadFile(file)
+			if err != nil {
+				return nil, E.When("read "+file, err)
+			}
+			var v T
+			if err = xml.Unmarshal(data, &v); err != nil {
+				return nil, E.When("parse "+file, err)
+			}
+			files[name] = v
+		}
+	}
+	return files, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+package zone
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/woshikedayaa/fire/common/system"
+)
+
+func TestImportFirewalld(t *testing.T) {
+	// the files in etc/firewalld replace those in usr/lib/firewalld
+	imp, err := ImportFirewalld(system.Chroot("testdata/firewalld"), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.MarshalIndent(imp, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/firewalld.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got)+"\n" != string(want) {
+		t.Errorf("import:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err = Compile(imp.Config); err != nil {
+		t.Errorf("compile the import: %s", err)
+	}
+}
+
+func TestImportFirewalldAll(t *testing.T) {
+	imp, err := ImportFirewalld(system.Chroot("testdata/firewalld"), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, zone := range imp.Config.Zones {
+		names = append(names, zone.Name)
+	}
+	if !slices.Equal(names, []string{"internal", "public", "trusted", "work"}) {
+		t.Errorf("zones = %v", names)
+	}
+	if trusted, _ := imp.Config.Zone("trusted"); trusted.Target != TargetAccept {
+		t.Errorf("trusted target = %s", trusted.Target)
+	}
+}
+
+func TestImportFirewalldWithoutZones(t *testing.T) {
+	if _, err := ImportFirewalld(system.Chroot(t.TempDir()), nil, false); err == nil {
+		t.Error("import without zones succeeded")
+	}
+}
@@ -0,0 +1,291 @@
+// Package zone models a firewall as zones, the way firewalld does: every
+// interface or source network belongs to a zone, a zone lists the services
+// reachable on the host, and policies decide what may be forwarded between
+// zones. Compile turns the model into an inet table.
+package zone
+
+import (
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// DefaultTable is the table Compile writes when Config.Table is empty.
+const DefaultTable = "fire_zones"
+
+type Target string
+
+const (
+	// TargetDefault rejects what the zone does not allow, like firewalld's default.
+	TargetDefault Target = "default"
+	TargetAccept  Target = "accept"
+	TargetDrop    Target = "drop"
+	TargetReject  Target = "reject"
+)
+
+func (t Target) Valid() bool {
+	switch t {
+	case TargetDefault, TargetAccept, TargetDrop, TargetReject:
+		return true
+	}
+	return false
+}
+
+type Config struct {
+	Table string `json:"table,omitempty"`
+	// DefaultZone takes the traffic of interfaces and sources no zone claims.
+	DefaultZone string    `json:"default_zone"`
+	Zones       []Zone    `json:"zones"`
+	Policies    []Policy  `json:"policies,omitempty"`
+	Services    []Service `json:"services,omitempty"`
+	LogDenied   bool      `json:"log_denied,omitempty"`
+}
+
+type Zone struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Target      Target   `json:"target,omitempty"`
+	Interfaces  []string `json:"interfaces,omitempty"`
+	// Sources are addresses or prefixes, they take precedence over interfaces.
+	Sources  []string `json:"sources,omitempty"`
+	Services []string `json:"services,omitempty"`
+	Ports    []Port   `json:"ports,omitempty"`
+	// Protocols are accepted whole, e.g. gre or esp.
+	Protocols []string `json:"protocols,omitempty"`
+	ICMP      ICMP     `json:"icmp,omitempty"`
+	// Masquerade rewrites the source of traffic forwarded out of this zone.
+	Masquerade bool `json:"masquerade,omitempty"`
+	// Forward allows forwarding between the interfaces and sources of the zone.
+	Forward bool `json:"forward,omitempty"`
+}
+
+// ICMP is the ICMP policy of a zone. Every ICMP type is accepted except the
+// blocked ones, with Invert only the listed ones are.
+type ICMP struct {
+	Block  []string `json:"block,omitempty"`
+	Invert bool     `json:"invert,omitempty"`
+}
+
+type Port struct {
+	// Port is a number or a range, e.g. 8000-8100.
+	Port     string `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+func (p Port) String() string {
+	return p.Port + "/" + p.Protocol
+}
+
+// Policy decides the fate of traffic forwarded from one zone to another.
+// Either side may be AnyZone.
+type Policy struct {
+	Name   string `json:"name,omitempty"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Action Target `json:"action"`
+}
+
+// AnyZone matches every zone in a policy.
+const AnyZone = "ANY"
+
+type Service struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Ports       []Port   `json:"ports,omitempty"`
+	Protocols   []string `json:"protocols,omitempty"`
+}
+
+// builtinServices are the services a config can use without defining them.
+var builtinServices = []Service{
+	{Name: "ssh", Ports: []Port{{"22", "tcp"}}},
+	{Name: "http", Ports: []Port{{"80", "tcp"}}},
+	{Name: "https", Ports: []Port{{"443", "tcp"}}},
+	{Name: "http3", Ports: []Port{{"443", "udp"}}},
+	{Name: "dns", Ports: []Port{{"53", "tcp"}, {"53", "udp"}}},
+	{Name: "dhcp", Ports: []Port{{"67", "udp"}}},
+	{Name: "dhcpv6", Ports: []Port{{"547", "udp"}}},
+	{Name: "dhcpv6-client", Ports: []Port{{"546", "udp"}}},
+	{Name: "mdns", Ports: []Port{{"5353", "udp"}}},
+	{Name: "ntp", Ports: []Port{{"123", "udp"}}},
+	{Name: "smtp", Ports: []Port{{"25", "tcp"}}},
+	{Name: "smtps", Ports: []Port{{"465", "tcp"}}},
+	{Name: "imaps", Ports: []Port{{"993", "tcp"}}},
+	{Name: "wireguard", Ports: []Port{{"51820", "udp"}}},
+	{Name: "samba", Ports: []Port{{"139", "tcp"}, {"445", "tcp"}, {"137", "udp"}, {"138", "udp"}}},
+	{Name: "cockpit", Ports: []Port{{"9090", "tcp"}}},
+}
+
+// Service returns the service called name, defined in the config or built in.
+func (c *Config) Service(name string) (Service, bool) {
+	for _, services := range [][]Service{c.Services, builtinServices} {
+		if i := slices.IndexFunc(services, func(s Service) bool { return s.Name == name }); i >= 0 {
+			return services[i], true
+		}
+	}
+	return Service{}, false
+}
+
+func (c *Config) Zone(name string) (*Zone, bool) {
+	for i := range c.Zones {
+		if c.Zones[i].Name == name {
+			return &c.Zones[i], true
+		}
+	}
+	return nil, false
+}
+
+var (
+	namePattern     = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+	protocolPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+)
+
+// Validate checks the config and fills in defaults.
+func (c *Config) Validate() error {
+	if c.Table == "" {
+		c.Table = DefaultTable
+	}
+	if !namePattern.MatchString(c.Table) {
+		return E.New("invalid table name: ", c.Table)
+	}
+	if len(c.Zones) == 0 {
+		return E.New("no zones")
+	}
+	if c.DefaultZone == "" {
+		c.DefaultZone = c.Zones[0].Name
+	}
+	if _, ok := c.Zone(c.DefaultZone); !ok {
+		return E.New("default zone ", c.DefaultZone, " does not exist")
+	}
+	for _, service := range c.Services {
+		for _, port := range service.Ports {
+			if err := port.validate(); err != nil {
+				return E.New("service ", service.Name, ": ", err)
+			}
+		}
+		for _, protocol := range service.Protocols {
+			if !protocolPattern.MatchString(protocol) {
+				return E.New("service ", service.Name, ": invalid protocol: ", protocol)
+			}
+		}
+	}
+	owners := make(map[string]string)
+	for i := range c.Zones {
+		zone := &c.Zones[i]
+		if !namePattern.MatchString(zone.Name) || zone.Name == AnyZone {
+			return E.New("invalid zone name: ", zone.Name)
+		}
+		if _, ok := owners["zone "+zone.Name]; ok {
+			return E.New("zone ", zone.Name, " is defined twice")
+		}
+		owners["zone "+zone.Name] = zone.Name
+		if zone.Target == "" {
+			zone.Target = TargetDefault
+		}
+		if !zone.Target.Valid() {
+			return E.New("zone ", zone.Name, ": invalid target: ", zone.Target)
+		}
+		for _, iface := range zone.Interfaces {
+			if owner, ok := owners["interface "+iface]; ok {
+				return E.New("interface ", iface, " is in zone ", owner, " and ", zone.Name)
+			}
+			owners["interface "+iface] = zone.Name
+		}
+		for _, source := range zone.Sources {
+			if _, err := parseSource(source); err != nil {
+				return E.New("zone ", zone.Name, ": invalid source: ", source)
+			}
+			if owner, ok := owners["source "+source]; ok {
+				return E.New("source ", source, " is in zone ", owner, " and ", zone.Name)
+			}
+			owners["source "+source] = zone.Name
+		}
+		for _, name := range zone.Services {
+			if _, ok := c.Service(name); !ok {
+				return E.New("zone ", zone.Name, ": unknown service: ", name)
+			}
+		}
+		for _, port := range zone.Ports {
+			if err := port.validate(); err != nil {
+				return E.New("zone ", zone.Name, ": ", err)
+			}
+		}
+		for _, protocol := range zone.Protocols {
+			if !protocolPattern.MatchString(protocol) {
+				return E.New("zone ", zone.Name, ": invalid protocol: ", protocol)
+			}
+		}
+		for _, name := range zone.ICMP.Block {
+			if _, ok := icmpTypes[name]; !ok {
+				return E.New("zone ", zone.Name, ": unknown icmp type: ", name)
+			}
+		}
+	}
+	for i := range c.Policies {
+		policy := &c.Policies[i]
+		for _, name := range []string{policy.From, policy.To} {
+			if _, ok := c.Zone(name); !ok && name != AnyZone {
+				return E.New("policy ", policy.From, " to ", policy.To, ": zone ", name, " does not exist")
+			}
+		}
+		if policy.Action == "" || policy.Action == TargetDefault {
+			policy.Action = TargetReject
+		}
+		if !policy.Action.Valid() {
+			return E.New("policy ", policy.From, " to ", policy.To, ": invalid action: ", policy.Action)
+		}
+	}
+	return nil
+}
+
+func (p Port) validate() error {
+	switch p.Protocol {
+	case "tcp", "udp", "sctp", "dccp":
+	default:
+		return E.New("port ", p, ": unsupported protocol")
+	}
+	low, high, isRange := strings.Cut(p.Port, "-")
+	bounds := []string{low}
+	if isRange {
+		bounds = append(bounds, high)
+	}
+	for _, s := range bounds {
+		if n, err := strconv.ParseUint(s, 10, 16); err != nil || n == 0 {
+			return E.New("port ", p, ": invalid port")
+		}
+	}
+	return nil
+}
+
+func parseSource(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// icmpTypes maps the firewalld names of ICMP types to the nft names for
+// ICMP and ICMPv6, empty when the type does not exist in the family.
+var icmpTypes = map[string][2]string{
+	"echo-request":            {"echo-request", "echo-request"},
+	"echo-reply":              {"echo-reply", "echo-reply"},
+	"destination-unreachable": {"destination-unreachable", "destination-unreachable"},
+	"time-exceeded":           {"time-exceeded", "time-exceeded"},
+	"parameter-problem":       {"parameter-problem", "parameter-problem"},
+	"packet-too-big":          {"", "packet-too-big"},
+	"redirect":                {"redirect", "nd-redirect"},
+	"router-advertisement":    {"router-advertisement", "nd-router-advert"},
+	"router-solicitation":     {"router-solicitation", "nd-router-solicit"},
+	"neighbour-advertisement": {"", "nd-neighbor-advert"},
+	"neighbour-solicitation":  {"", "nd-neighbor-solicit"},
+	"source-quench":           {"source-quench", ""},
+	"timestamp-request":       {"timestamp-request", ""},
+	"timestamp-reply":         {"timestamp-reply", ""},
+}
@@ -0,0 +1,453 @@
+package output
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// jsonPath is a kubectl style JSONPath template: text with {expressions}, e.g.
+//
+//	{range .tables[*]}{.family} {.name}{"\n"}{end}
+//
+// Supported expressions: $, .field, ['field'], [n], [start:end], [*], ..field,
+// [?(@.field == "value")] (also !=, <, >, <=, >= and plain existence), string
+// literals and range/end blocks.
+type jsonPath struct {
+	segments []jpSegment
+}
+
+type jpSegment struct {
+	text   string // literal text
+	path   []jpStep
+	isPath bool
+	isRng  bool
+	body   []jpSegment
+}
+
+type jpStepKind int
+
+const (
+	stepField jpStepKind = iota
+	stepRecursive
+	stepIndex
+	stepSlice
+	stepWildcard
+	stepFilter
+)
+
+type jpStep struct {
+	kind       jpStepKind
+	name       string
+	index      int
+	start, end *int
+	filter     *jpFilter
+}
+
+type jpFilter struct {
+	path  []jpStep
+	op    string
+	value string
+}
+
+func parseJSONPath(tmpl string) (*jsonPath, error) {
+	segments, _, err := parseJPSegments(tmpl, false)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonPath{segments: segments}, nil
+}
+
+// parseJPSegments parses s up to its end or, inside a range, up to the closing
+// {end}, and returns the input left after it.
+func parseJPSegments(s string, inRange bool) ([]jpSegment, string, error) {
+	var segments []jpSegment
+	for s != "" {
+		open := strings.IndexByte(s, '{')
+		if open < 0 {
+			segments = append(segments, jpSegment{text: s})
+			s = ""
+			break
+		}
+		if open > 0 {
+			segments = append(segments, jpSegment{text: s[:open]})
+		}
+		closing := matchingBrace(s, open)
+		if closing < 0 {
+			return nil, "", E.New("jsonpath: unclosed { in ", strconv.Quote(s))
+		}
+		expr := strings.TrimSpace(s[open+1 : closing])
+		s = s[closing+1:]
+
+		switch {
+		case expr == "end":
+			if !inRange {
+				return nil, "", E.New("jsonpath: {end} without {range}")
+			}
+			return segments, s, nil
+		case strings.HasPrefix(expr, "range "):
+			path, err := parseJPPath(strings.TrimSpace(strings.TrimPrefix(expr, "range ")))
+			if err != nil {
+				return nil, "", err
+			}
+			var body []jpSegment
+			if body, s, err = parseJPSegments(s, true); err != nil {
+				return nil, "", err
+			}
+			segments = append(segments, jpSegment{path: path, isRng: true, body: body})
+		case len(expr) >= 2 && (expr[0] == '"' || expr[0] == '\''):
+			text, err := unquoteLiteral(expr)
+			if err != nil {
+				return nil, "", err
+			}
+			segments = append(segments, jpSegment{text: text})
+		default:
+			path, err := parseJPPath(expr)
+			if err != nil {
+				return nil, "", err
+			}
+			segments = append(segments, jpSegment{path: path, isPath: true})
+		}
+	}
+	if inRange {
+		return nil, "", E.New("jsonpath: {range} without {end}")
+	}
+	return segments, s, nil
+}
+
+// matchingBrace finds the } closing the { at open, skipping quoted strings.
+func matchingBrace(s string, open int) int {
+	var quote byte
+	for i := open + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '}':
+			return i
+		}
+	}
+	return -1
+}
+
+func unquoteLiteral(s string) (string, error) {
+	if s[0] == '\'' {
+		s = `"` + strings.ReplaceAll(s[1:len(s)-1], `"`, `\"`) + `"`
+	}
+	text, err := strconv.Unquote(s)
+	if err != nil {
+		return "", E.New("jsonpath: invalid string literal ", s)
+	}
+	return text, nil
+}
+
+func parseJPPath(s string) ([]jpStep, error) {
+	orig := s
+	s = strings.TrimPrefix(s, "$")
+	var steps []jpStep
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, ".."):
+			s = s[2:]
+			name := fieldName(s)
+			if name == "" {
+				return nil, E.New("jsonpath: missing field after .. in ", orig)
+			}
+			steps = append(steps, jpStep{kind: stepRecursive, name: name})
+			s = s[len(name):]
+		case s[0] == '.':
+			s = s[1:]
+			name := fieldName(s)
+			if name == "" {
+				// a lone "." is the current value
+				continue
+			}
+			if name == "*" {
+				steps = append(steps, jpStep{kind: stepWildcard})
+			} else {
+				steps = append(steps, jpStep{kind: stepField, name: name})
+			}
+			s = s[len(name):]
+		case s[0] == '[':
+			closing := matchingBracket(s)
+			if closing < 0 {
+				return nil, E.New("jsonpath: unclosed [ in ", orig)
+			}
+			step, err := parseJPBracket(strings.TrimSpace(s[1:closing]))
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			s = s[closing+1:]
+		case s[0] == '@':
+			s = s[1:]
+		default:
+			return nil, E.New("jsonpath: unexpected ", strconv.Quote(s), " in ", orig)
+		}
+	}
+	return steps, nil
+}
+
+func fieldName(s string) string {
+	end := strings.IndexAny(s, ".[ ")
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
+
+func matchingBracket(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseJPBracket(s string) (jpStep, error) {
+	switch {
+	case s == "*":
+		return jpStep{kind: stepWildcard}, nil
+	case strings.HasPrefix(s, "?(") && strings.HasSuffix(s, ")"):
+		return parseJPFilter(strings.TrimSpace(s[2 : len(s)-1]))
+	case len(s) >= 2 && (s[0] == '\'' || s[0] == '"'):
+		name, err := unquoteLiteral(s)
+		return jpStep{kind: stepField, name: name}, err
+	case strings.Contains(s, ":"):
+		parts := strings.SplitN(s, ":", 2)
+		step := jpStep{kind: stepSlice}
+		for i, part := range parts {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return jpStep{}, E.New("jsonpath: invalid slice ", s)
+			}
+			if i == 0 {
+				step.start = &n
+			} else {
+				step.end = &n
+			}
+		}
+		return step, nil
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return jpStep{}, E.New("jsonpath: invalid index ", s)
+		}
+		return jpStep{kind: stepIndex, index: n}, nil
+	}
+}
+
+func parseJPFilter(s string) (jpStep, error) {
+	filter := &jpFilter{}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if i := strings.Index(s, op); i >= 0 {
+			filter.op = op
+			value := strings.TrimSpace(s[i+len(op):])
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+				var err error
+				if value, err = unquoteLiteral(value); err != nil {
+					return jpStep{}, err
+				}
+			}
+			filter.value = value
+			s = strings.TrimSpace(s[:i])
+			break
+		}
+	}
+	if !strings.HasPrefix(s, "@") {
+		return jpStep{}, E.New("jsonpath: filter must start with @: ", s)
+	}
+	path, err := parseJPPath(s[1:])
+	if err != nil {
+		return jpStep{}, err
+	}
+	filter.path = path
+	return jpStep{kind: stepFilter, filter: filter}, nil
+}
+
+func (p *jsonPath) execute(w io.Writer, root *node) error {
+	return executeJPSegments(w, p.segments, root)
+}
+
+func executeJPSegments(w io.Writer, segments []jpSegment, current *node) error {
+	for _, seg := range segments {
+		switch {
+		case seg.isRng:
+			for _, item := range rangeItems(evalJPPath(seg.path, current)) {
+				if err := executeJPSegments(w, seg.body, item); err != nil {
+					return err
+				}
+			}
+		case seg.isPath:
+			results := evalJPPath(seg.path, current)
+			parts := make([]string, 0, len(results))
+			for _, r := range results {
+				parts = append(parts, r.String())
+			}
+			if _, err := io.WriteString(w, strings.Join(parts, " ")); err != nil {
+				return err
+			}
+		default:
+			if _, err := io.WriteString(w, seg.text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rangeItems iterates the elements of a single array result, or the results themselves.
+func rangeItems(results []*node) []*node {
+	if len(results) == 1 && results[0].kind == kindArray {
+		return results[0].items
+	}
+	return results
+}
+
+func evalJPPath(steps []jpStep, current *node) []*node {
+	nodes := []*node{current}
+	for _, step := range steps {
+		var next []*node
+		for _, n := range nodes {
+			next = append(next, applyJPStep(step, n)...)
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func applyJPStep(step jpStep, n *node) []*node {
+	switch step.kind {
+	case stepField:
+		if v, ok := n.get(step.name); ok {
+			return []*node{v}
+		}
+	case stepWildcard:
+		return n.items
+	case stepIndex:
+		if n.kind == kindArray {
+			i := step.index
+			if i < 0 {
+				i += len(n.items)
+			}
+			if i >= 0 && i < len(n.items) {
+				return []*node{n.items[i]}
+			}
+		}
+	case stepSlice:
+		if n.kind == kindArray {
+			start, end := 0, len(n.items)
+			if step.start != nil {
+				start = clampIndex(*step.start, len(n.items))
+			}
+			if step.end != nil {
+				end = clampIndex(*step.end, len(n.items))
+			}
+			if start < end {
+				return n.items[start:end]
+			}
+		}
+	case stepRecursive:
+		var found []*node
+		var walk func(n *node)
+		walk = func(n *node) {
+			if v, ok := n.get(step.name); ok {
+				found = append(found, v)
+			}
+			for _, item := range n.items {
+				walk(item)
+			}
+		}
+		walk(n)
+		return found
+	case stepFilter:
+		var matched []*node
+		for _, item := range n.items {
+			if step.filter.match(item) {
+				matched = append(matched, item)
+			}
+		}
+		return matched
+	}
+	return nil
+}
+
+func clampIndex(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	return max(0, min(i, n))
+}
+
+func (f *jpFilter) match(n *node) bool {
+	results := evalJPPath(f.path, n)
+	if f.op == "" {
+		return len(results) > 0 && !(results[0].kind == kindBool && !results[0].bool) && results[0].kind != kindNull
+	}
+	if len(results) == 0 {
+		return f.op == "!="
+	}
+	left := results[0].String()
+	if lf, err := strconv.ParseFloat(left, 64); err == nil {
+		if rf, err := strconv.ParseFloat(f.value, 64); err == nil {
+			return compareOp(f.op, compareFloat(lf, rf))
+		}
+	}
+	return compareOp(f.op, strings.Compare(left, f.value))
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareOp(op string, c int) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+type nodeKind int
+
+const (
+	kindNull nodeKind = iota
+	kindBool
+	kindNumber
+	kindString
+	kindArray
+	kindObject
+)
+
+// node is a decoded JSON value that, unlike map[string]any, keeps the key
+// order of objects, so YAML and tables list fields in struct order.
+type node struct {
+	kind   nodeKind
+	scalar string // number literal or string value
+	bool   bool
+	keys   []string
+	items  []*node // array items, or object values in keys order
+}
+
+// toNode converts v to its JSON form, honouring json tags and marshalers.
+func toNode(v any) (*node, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decodeNode(decoder)
+}
+
+func decodeNode(decoder *json.Decoder) (*node, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil, E.New("unexpected end of JSON")
+		}
+		return nil, err
+	}
+	switch t := token.(type) {
+	case nil:
+		return &node{kind: kindNull}, nil
+	case bool:
+		return &node{kind: kindBool, bool: t}, nil
+	case json.Number:
+		return &node{kind: kindNumber, scalar: t.String()}, nil
+	case string:
+		return &node{kind: kindString, scalar: t}, nil
+	case json.Delim:
+		n := &node{kind: kindArray}
+		if t == '{' {
+			n.kind = kindObject
+		}
+		for decoder.More() {
+			if n.kind == kindObject {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+			item, err := decodeNode(decoder)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+		}
+		// closing delimiter
+		if _, err = decoder.Token(); err != nil {
+			return nil, err
+		}
+		return n, nil
+	default:
+		return nil, E.New("unexpected JSON token ", token)
+	}
+}
+
+func (n *node) get(key string) (*node, bool) {
+	if n.kind != kindObject {
+		return nil, false
+	}
+	for i, k := range n.keys {
+		if k == key {
+			return n.items[i], true
+		}
+	}
+	return nil, false
+}
+
+func (n *node) isScalar() bool {
+	return n.kind != kindArray && n.kind != kindObject
+}
+
+// String returns scalars as plain text and containers as compact JSON.
+func (n *node) String() string {
+	switch n.kind {
+	case kindNull:
+		return ""
+	case kindBool:
+		if n.bool {
+			return "true"
+		}
+		return "false"
+	case kindNumber, kindString:
+		return n.scalar
+	default:
+		var buf bytes.Buffer
+		n.writeJSON(&buf)
+		return buf.String()
+	}
+}
+
+func (n *node) writeJSON(buf *bytes.Buffer) {
+	switch n.kind {
+	case kindNull:
+		buf.WriteString("null")
+	case kindString:
+		data, _ := json.Marshal(n.scalar)
+		buf.Write(data)
+	case kindBool, kindNumber:
+		buf.WriteString(n.String())
+	case kindArray, kindObject:
+		open, closing := byte('['), byte(']')
+		if n.kind == kindObject {
+			open, closing = '{', '}'
+		}
+		buf.WriteByte(open)
+		for i, item := range n.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if n.kind == kindObject {
+				key, _ := json.Marshal(n.keys[i])
+				buf.Write(key)
+				buf.WriteByte(':')
+			}
+			item.writeJSON(buf)
+		}
+		buf.WriteByte(closing)
+	}
+}
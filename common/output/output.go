@@ -0,0 +1,206 @@
+// Package output renders command results in the formats shared by every fire command.
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+type Format string
+
+const (
+	FormatText     Format = "text"
+	FormatJSON     Format = "json"
+	FormatYAML     Format = "yaml"
+	FormatTable    Format = "table"
+	FormatTemplate Format = "go-template"
+	FormatJSONPath Format = "jsonpath"
+)
+
+var formats = []Format{FormatText, FormatJSON, FormatYAML, FormatTable, FormatTemplate, FormatJSONPath}
+
+func (f Format) Valid() bool {
+	for _, v := range formats {
+		if f == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Text is implemented by values with a hand-written human readable form.
+// Values without it are printed as a table when they implement Table and as YAML otherwise.
+type Text interface {
+	RenderText(w io.Writer) error
+}
+
+type Options struct {
+	Format Format
+	// Template is the go-template or jsonpath expression
+	Template string
+	// File receives the output instead of the command's standard output
+	File string
+}
+
+// Bind registers --format, --template and --output-file on cmd.
+func (o *Options) Bind(cmd *cobra.Command, defaultFormat Format) {
+	names := make([]string, 0, len(formats))
+	for _, f := range formats {
+		names = append(names, string(f))
+	}
+	cmd.Flags().StringVar((*string)(&o.Format), "format", string(defaultFormat), "Output format: "+strings.Join(names, ", "))
+	cmd.Flags().StringVar(&o.Template, "template", "", "Template for --format go-template or jsonpath")
+	cmd.Flags().StringVar(&o.File, "output-file", "", "Write the output to a file instead of stdout")
+}
+
+func (o Options) Validate() error {
+	if !o.Format.Valid() {
+		return E.New("unsupported format: ", o.Format)
+	}
+	if (o.Format == FormatTemplate || o.Format == FormatJSONPath) && o.Template == "" {
+		return E.New("--template is required with --format ", o.Format)
+	}
+	return nil
+}
+
+// Print writes v to --output-file, created readable by its owner only, or
+// to the standard output of cmd.
+func (o Options) Print(cmd *cobra.Command, v any) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if o.File == "" {
+		return o.Write(cmd.OutOrStdout(), v)
+	}
+	file, err := o.create()
+	if err != nil {
+		return err
+	}
+	if err = o.Write(file, v); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (o Options) create() (*os.File, error) {
+	// the output may hold keys, like the configs of fire wg generate
+	file, err := os.OpenFile(o.File, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, E.When("create output file", err)
+	}
+	return file, nil
+}
+
+// Stream prints the values of a command that prints one at a time, like the
+// events of a monitor. JSON values go one per line and YAML values as
+// separate documents.
+type Stream struct {
+	options Options
+	w       io.Writer
+	file    *os.File
+}
+
+// Stream opens --output-file once for all values, or takes the standard
+// output of cmd. The table format is refused as every value would repeat the
+// header.
+func (o Options) Stream(cmd *cobra.Command) (*Stream, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if o.Format == FormatTable {
+		return nil, E.New("unsupported format for a stream: ", o.Format)
+	}
+	if o.File == "" {
+		return &Stream{options: o, w: cmd.OutOrStdout()}, nil
+	}
+	file, err := o.create()
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{options: o, w: file, file: file}, nil
+}
+
+func (s *Stream) Write(v any) error {
+	if s.options.Format == FormatYAML {
+		if _, err := io.WriteString(s.w, "---\n"); err != nil {
+			return err
+		}
+	}
+	return s.options.Write(s.w, v)
+}
+
+// Close closes --output-file.
+func (s *Stream) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+func (o Options) Write(w io.Writer, v any) error {
+	switch o.Format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(v)
+	case FormatTemplate:
+		tmpl, err := template.New("output").Funcs(templateFuncs).Parse(o.Template)
+		if err != nil {
+			return E.When("parse template", err)
+		}
+		return tmpl.Execute(w, v)
+	case FormatText:
+		if text, ok := v.(Text); ok {
+			return text.RenderText(w)
+		}
+		if table, ok := v.(Table); ok {
+			header, rows := table.Table()
+			return writeTable(w, header, rows)
+		}
+		return o.writeNode(w, v, FormatYAML)
+	case FormatTable:
+		if table, ok := v.(Table); ok {
+			header, rows := table.Table()
+			return writeTable(w, header, rows)
+		}
+		return o.writeNode(w, v, FormatTable)
+	case FormatYAML, FormatJSONPath:
+		return o.writeNode(w, v, o.Format)
+	default:
+		return E.New("unsupported format: ", o.Format)
+	}
+}
+
+// writeNode renders the formats that work on the JSON form of v.
+func (o Options) writeNode(w io.Writer, v any, format Format) error {
+	n, err := toNode(v)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatYAML:
+		return writeYAML(w, n)
+	case FormatTable:
+		header, rows := nodeTable(n)
+		return writeTable(w, header, rows)
+	default:
+		path, err := parseJSONPath(o.Template)
+		if err != nil {
+			return err
+		}
+		return path.execute(w, n)
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
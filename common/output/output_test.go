@@ -0,0 +1,138 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+type service struct {
+	Name  string   `json:"name"`
+	Port  int      `json:"port"`
+	Tags  []string `json:"tags,omitempty"`
+	Valid bool     `json:"valid"`
+}
+
+var services = []service{{"ssh", 22, []string{"admin", "a: b"}, true}, {"http", 80, nil, false}}
+
+const servicesYAML = `- name: ssh
+  port: 22
+  tags:
+    - admin
+    - "a: b"
+  valid: true
+- name: http
+  port: 80
+  valid: false
+`
+
+type text struct{}
+
+func (text) RenderText(w io.Writer) error {
+	_, err := io.WriteString(w, "hand written\n")
+	return err
+}
+
+type table struct{}
+
+func (table) Table() ([]string, [][]string) {
+	return []string{"A", "LONGER"}, [][]string{{"value", "x"}, {"v", "y"}}
+}
+
+func TestWrite(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts Options
+		v    any
+		want string
+	}{
+		{"json", Options{Format: FormatJSON}, services,
+			`[{"name":"ssh","port":22,"tags":["admin","a: b"],"valid":true},{"name":"http","port":80,"valid":false}]` + "\n"},
+		{"yaml", Options{Format: FormatYAML}, services, servicesYAML},
+		{"table", Options{Format: FormatTable}, services,
+			"NAME  PORT  TAGS              VALID\nssh   22    [\"admin\",\"a: b\"]  true\nhttp  80                      false\n"},
+		{"text falls back to yaml", Options{Format: FormatText}, services, servicesYAML},
+		{"text", Options{Format: FormatText}, text{}, "hand written\n"},
+		{"text of a table", Options{Format: FormatText}, table{}, "A      LONGER\nvalue  x\nv      y\n"},
+		{"go-template", Options{Format: FormatTemplate, Template: "{{range .}}{{.Name}}={{.Port}} {{join .Tags \",\"}}\n{{end}}"}, services,
+			"ssh=22 admin,a: b\nhttp=80 \n"},
+		{"jsonpath range", Options{Format: FormatJSONPath, Template: `{range [*]}{.name}{"\t"}{.port}{"\n"}{end}`}, services,
+			"ssh\t22\nhttp\t80\n"},
+		{"jsonpath filter", Options{Format: FormatJSONPath, Template: `{[?(@.port > 50)].name}`}, services, "http"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.opts.Write(&buf, tt.v); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, opts := range []Options{
+		{Format: "xml"},
+		{Format: FormatTemplate},
+		{Format: FormatJSONPath},
+	} {
+		if opts.Validate() == nil {
+			t.Errorf("%+v is valid", opts)
+		}
+	}
+}
+
+func TestPrintToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	opts := Options{Format: FormatJSON, File: path}
+	if err := opts.Print(&cobra.Command{}, services[1]); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("output file mode = %o, want 600", perm)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != `{"name":"http","port":80,"valid":false}`+"\n" {
+		t.Errorf("output file holds %q", data)
+	}
+}
+
+func TestStream(t *testing.T) {
+	for _, tt := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{Format: FormatJSON}, `{"name":"ssh","port":22,"tags":["admin","a: b"],"valid":true}` + "\n" + `{"name":"http","port":80,"valid":false}` + "\n"},
+		{Options{Format: FormatYAML}, "---\nname: ssh\nport: 22\ntags:\n  - admin\n  - \"a: b\"\nvalid: true\n---\nname: http\nport: 80\nvalid: false\n"},
+		{Options{Format: FormatTemplate, Template: "{{.Name}}\n"}, "ssh\nhttp\n"},
+	} {
+		var buf bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.SetOut(&buf)
+		stream, err := tt.opts.Stream(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range services {
+			if err = stream.Write(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = stream.Close(); err != nil || buf.String() != tt.want {
+			t.Errorf("%s stream:\n%s\nwant:\n%s", tt.opts.Format, buf.String(), tt.want)
+		}
+	}
+	if _, err := (Options{Format: FormatTable}).Stream(&cobra.Command{}); err == nil {
+		t.Error("streamed a table")
+	}
+}
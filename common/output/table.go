@@ -0,0 +1,91 @@
+package output
+
+import (
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Table is implemented by values that know their own column layout.
+type Table interface {
+	Table() (header []string, rows [][]string)
+}
+
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	write := func(cells []string) {
+		for i, cell := range cells {
+			if i > 0 {
+				io.WriteString(tw, "\t")
+			}
+			// a tab or newline inside a cell would break the alignment
+			io.WriteString(tw, strings.NewReplacer("\t", " ", "\n", " ").Replace(cell))
+		}
+		io.WriteString(tw, "\n")
+	}
+	if len(header) > 0 {
+		write(header)
+	}
+	for _, row := range rows {
+		write(row)
+	}
+	return tw.Flush()
+}
+
+// nodeTable lays out values without a Table method: a list of objects gets one
+// column per key, anything else becomes KEY/VALUE rows of its leaf values.
+func nodeTable(n *node) (header []string, rows [][]string) {
+	if n.kind == kindArray && len(n.items) > 0 && allObjects(n.items) {
+		for _, item := range n.items {
+			for _, key := range item.keys {
+				if !slices.Contains(header, key) {
+					header = append(header, key)
+				}
+			}
+		}
+		for _, item := range n.items {
+			row := make([]string, len(header))
+			for i, key := range header {
+				if v, ok := item.get(key); ok {
+					row[i] = v.String()
+				}
+			}
+			rows = append(rows, row)
+		}
+		for i := range header {
+			header[i] = strings.ToUpper(header[i])
+		}
+		return header, rows
+	}
+
+	var walk func(prefix string, n *node)
+	walk = func(prefix string, n *node) {
+		if n.isScalar() || len(n.items) == 0 {
+			rows = append(rows, []string{prefix, n.String()})
+			return
+		}
+		for i, item := range n.items {
+			switch {
+			case n.kind == kindArray:
+				walk(prefix+"["+strconv.Itoa(i)+"]", item)
+			case prefix == "":
+				walk(n.keys[i], item)
+			default:
+				walk(prefix+"."+n.keys[i], item)
+			}
+		}
+	}
+	walk("", n)
+	return []string{"KEY", "VALUE"}, rows
+}
+
+func allObjects(items []*node) bool {
+	for _, item := range items {
+		if item.kind != kindObject {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// writeYAML emits n as block-style YAML.
+func writeYAML(w io.Writer, n *node) error {
+	bw := bufio.NewWriter(w)
+	if n.isScalar() || len(n.items) == 0 {
+		bw.WriteString(yamlScalar(n))
+		bw.WriteByte('\n')
+	} else {
+		writeYAMLBlock(bw, n, 0, "")
+	}
+	return bw.Flush()
+}
+
+// writeYAMLBlock writes the items of a container at indent. When lead is not
+// empty it replaces the indentation of the first line, so a container nested
+// in a sequence starts on the "-" line of its parent.
+func writeYAMLBlock(w *bufio.Writer, n *node, indent int, lead string) {
+	pad := strings.Repeat("  ", indent)
+	for i, item := range n.items {
+		prefix := pad
+		if i == 0 && lead != "" {
+			prefix = lead
+		}
+		if n.kind == kindObject {
+			w.WriteString(prefix + yamlKey(n.keys[i]) + ":")
+		} else {
+			w.WriteString(prefix + "-")
+		}
+		switch {
+		case item.isScalar() || len(item.items) == 0:
+			w.WriteString(" " + yamlScalar(item) + "\n")
+		case n.kind == kindArray:
+			writeYAMLBlock(w, item, indent+1, " ")
+		default:
+			w.WriteString("\n")
+			writeYAMLBlock(w, item, indent+1, "")
+		}
+	}
+}
+
+func yamlKey(key string) string {
+	if key == "" || needsQuote(key) {
+		return quote(key)
+	}
+	return key
+}
+
+func yamlScalar(n *node) string {
+	switch n.kind {
+	case kindNull:
+		return "null"
+	case kindArray:
+		return "[]"
+	case kindObject:
+		return "{}"
+	case kindString:
+		if needsQuote(n.scalar) {
+			return quote(n.scalar)
+		}
+		return n.scalar
+	default:
+		return n.String()
+	}
+}
+
+// needsQuote reports whether s would not be read back as the same plain string.
+func needsQuote(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~", ".inf", "-.inf", ".nan":
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return true
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// quote uses JSON string syntax, which is valid double-quoted YAML.
+func quote(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,517 @@
+// Ported from the QR Code generator library of Project Nayuki,
+// https://www.nayuki.io/page/qr-code-generator-library.
+// Copyright (c) Project Nayuki. (MIT License), see the LICENSE file of this
+// directory.
+
+// Package qrcode encodes data as QR codes (ISO/IEC 18004) in byte mode and
+// renders them for terminals, as PNG and as SVG.
+package qrcode
+
+import (
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Level is the error correction level, the share of the code that may be
+// damaged: about 7, 15, 25 and 30 percent.
+type Level int
+
+const (
+	Low Level = iota
+	Medium
+	Quartile
+	High
+)
+
+const (
+	MinVersion = 1
+	MaxVersion = 40
+)
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L", "LOW":
+		return Low, nil
+	case "M", "MEDIUM":
+		return Medium, nil
+	case "Q", "QUARTILE":
+		return Quartile, nil
+	case "H", "HIGH":
+		return High, nil
+	}
+	return 0, E.New("unknown error correction level ", s, ", expected L, M, Q or H")
+}
+
+func (l Level) String() string {
+	return [...]string{"L", "M", "Q", "H"}[l]
+}
+
+// formatBits are the bits of the level in the format information.
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// Code is an encoded QR code, a square of Size modules.
+type Code struct {
+	Version int
+	Level   Level
+	Mask    int
+	Size    int
+
+	modules    []bool
+	isFunction []bool
+}
+
+// Dark reports whether the module at x, y is dark, modules outside of the
+// code are light like the quiet zone.
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y*c.Size+x]
+}
+
+// Encode encodes data in the smallest version that holds it at level.
+func Encode(data []byte, level Level) (*Code, error) {
+	return EncodeVersion(data, level, MinVersion, MaxVersion)
+}
+
+// EncodeVersion encodes data in the smallest version from minVersion to
+// maxVersion that holds it at level.
+func EncodeVersion(data []byte, level Level, minVersion, maxVersion int) (*Code, error) {
+	return encode(data, level, minVersion, maxVersion, -1)
+}
+
+// encode is EncodeVersion with the mask forced, the one with the lowest
+// penalty is picked when mask is -1.
+func encode(data []byte, level Level, minVersion, maxVersion, mask int) (*Code, error) {
+	if level < Low || level > High {
+		return nil, E.New("invalid error correction level")
+	}
+	if minVersion < MinVersion || maxVersion > MaxVersion || minVersion > maxVersion {
+		return nil, E.New("invalid version range ", minVersion, "-", maxVersion)
+	}
+	version := minVersion
+	for ; ; version++ {
+		if dataBits(len(data), version) <= numDataCodewords(version, level)*8 {
+			break
+		}
+		if version == maxVersion {
+			return nil, E.New(len(data), " bytes do not fit in a QR code of version ", maxVersion, " at level ", level)
+		}
+	}
+
+	// mode indicator, character count, data, terminator and padding
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := numDataCodewords(version, level) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	c := &Code{Version: version, Level: level, Size: version*4 + 17}
+	c.modules = make([]bool, c.Size*c.Size)
+	c.isFunction = make([]bool, c.Size*c.Size)
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addECCAndInterleave(codewords))
+
+	// the mask with the lowest penalty wins
+	if mask < 0 {
+		bestPenalty := -1
+		for try := 0; try < 8; try++ {
+			c.applyMask(try)
+			c.drawFormatBits(try)
+			if penalty := c.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+				mask, bestPenalty = try, penalty
+			}
+			c.applyMask(try) // masking twice undoes it
+		}
+	}
+	c.Mask = mask
+	c.applyMask(mask)
+	c.drawFormatBits(mask)
+	c.isFunction = nil
+	return c, nil
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 != 0)
+	}
+}
+
+// countBits is the length of the character count of byte mode.
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+func dataBits(n, version int) int {
+	if n >= 1<<countBits(version) {
+		return 1 << 30
+	}
+	return 4 + countBits(version) + n*8
+}
+
+// numRawDataModules is the number of modules left for codewords once the
+// function patterns are drawn, remainder bits included.
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*errorCorrectionBlocks[level][version]
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.isFunction[y*c.Size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	positions := alignmentPositions(c.Version)
+	n := len(positions)
+	for i := range positions {
+		for j := range positions {
+			// the corners of the finder patterns
+			if i == 0 && j == 0 || i == 0 && j == n-1 || i == n-1 && j == 0 {
+				continue
+			}
+			c.drawAlignment(positions[i], positions[j])
+		}
+	}
+	// reserve the format bits, they are drawn with the mask
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFinder draws a finder pattern with its separator around x, y.
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			dist := max(abs(dx), abs(dy))
+			if xx, yy := x+dx, y+dy; xx >= 0 && xx < c.Size && yy >= 0 && yy < c.Size {
+				c.set(xx, yy, dist != 2 && dist != 4)
+			}
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions are the centers of the alignment patterns on both axes.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// drawFormatBits draws both copies of the level and the mask, protected by
+// a BCH(15,5) code, and the dark module.
+func (c *Code) drawFormatBits(mask int) {
+	data := c.Level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true)
+}
+
+// drawVersion draws both copies of the version, protected by a BCH(18,6)
+// code, from version 7 on.
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	rem := c.Version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := c.Version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.set(a, b, dark)
+		c.set(b, a, dark)
+	}
+}
+
+// addECCAndInterleave splits the data codewords into blocks, appends the
+// Reed-Solomon codewords of each and interleaves them.
+func (c *Code) addECCAndInterleave(data []byte) []byte {
+	numBlocks := errorCorrectionBlocks[c.Level][c.Version]
+	blockECCLen := eccCodewordsPerBlock[c.Level][c.Version]
+	rawCodewords := numRawDataModules(c.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockECCLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		dataLen := shortBlockLen - blockECCLen
+		if i >= numShortBlocks {
+			dataLen++
+		}
+		// short blocks get a placeholder so that all blocks line up
+		block := make([]byte, shortBlockLen+1)
+		copy(block, data[k:k+dataLen])
+		copy(block[len(block)-blockECCLen:], reedSolomonRemainder(data[k:k+dataLen], divisor))
+		k += dataLen
+		blocks[i] = block
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortBlockLen; i++ {
+		for j, block := range blocks {
+			if i != shortBlockLen-blockECCLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// drawCodewords places the codewords in the zigzag of two columns wide
+// strips from the bottom right, skipping the function patterns.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // the vertical timing pattern
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y*c.Size+x] && i < len(data)*8 {
+					c.modules[y*c.Size+x] = data[i>>3]>>(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.isFunction[y*c.Size+x] {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// penalty scores the code by the rules of the standard to pick the mask:
+// long runs, 2x2 blocks, patterns looking like finders and an unbalanced
+// share of dark modules.
+func (c *Code) penalty() int {
+	const (
+		n1 = 3
+		n2 = 3
+		n3 = 40
+		n4 = 10
+	)
+	result := 0
+	line := make([]bool, c.Size)
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < c.Size; a++ {
+			for b := 0; b < c.Size; b++ {
+				if vertical {
+					line[b] = c.Dark(a, b)
+				} else {
+					line[b] = c.Dark(b, a)
+				}
+			}
+			run := 1
+			for b := 1; b <= c.Size; b++ {
+				if b < c.Size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += n1 + run - 5
+				}
+				run = 1
+			}
+			result += n3 * finderLike(line)
+		}
+	}
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				d := c.Dark(x, y)
+				if d == c.Dark(x-1, y) && d == c.Dark(x, y-1) && d == c.Dark(x-1, y-1) {
+					result += n2
+				}
+			}
+		}
+	}
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*n4
+}
+
+// finderLike counts the 1:1:3:1:1 patterns with four light modules on one
+// side in line, outside of the code is light.
+func finderLike(line []bool) int {
+	pattern := []bool{true, false, true, true, true, false, true}
+	light := func(from, to int) bool {
+		for i := from; i < to; i++ {
+			if i >= 0 && i < len(line) && line[i] {
+				return false
+			}
+		}
+		return true
+	}
+	count := 0
+	for i := 0; i+len(pattern) <= len(line); i++ {
+		match := true
+		for j, dark := range pattern {
+			if line[i+j] != dark {
+				match = false
+				break
+			}
+		}
+		if match && (light(i-4, i) || light(i+len(pattern), i+len(pattern)+4)) {
+			count++
+		}
+	}
+	return count
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// reedSolomonDivisor returns the generator polynomial of degree, without
+// its leading 1.
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,133 @@
+package qrcode
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// matrix draws the code row by row, # for the dark modules.
+func matrix(c *Code) string {
+	var sb strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				sb.WriteByte('#')
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// The golden matrices in testdata, named level-version-mask.txt, are from
+// the reference encoder of Kazuhiko Arase with the version and the mask
+// forced. Together they cover every level and mask, the capacity of
+// version 1, the version information from version 7 on and the longer
+// character count from version 10 on.
+var goldenCodes = []struct {
+	data    string
+	level   Level
+	version int
+	mask    int
+}{
+	{"fire wg peer add", Low, 1, 0},
+	{"WireGuard", Medium, 1, 1},
+	{"10.8.0.2/32", Quartile, 1, 2},
+	{"51820", High, 1, 3},
+	{"abcdefghijklmnopq", Low, 1, 4},
+	{"abcdefghijklmnopqr", Low, 2, 5},
+	{strings.Repeat("fire ", 24), Medium, 7, 6},
+	{strings.Repeat("0123456789", 14), Quartile, 10, 7},
+	{strings.Repeat("[Peer]\n", 20), High, 12, 0},
+}
+
+func TestEncodeGolden(t *testing.T) {
+	for _, tt := range goldenCodes {
+		name := tt.level.String() + "-" + strconv.Itoa(tt.version) + "-" + strconv.Itoa(tt.mask)
+		want, err := os.ReadFile("testdata/" + name + ".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := encode([]byte(tt.data), tt.level, tt.version, tt.version, tt.mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Size != tt.version*4+17 {
+			t.Errorf("%s: size %d", name, c.Size)
+		}
+		if got := matrix(c); got != string(want) {
+			t.Errorf("%s:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+}
+
+func TestEncodeAuto(t *testing.T) {
+	// the masks picked by the penalty for the golden codes
+	masks := []int{2, 3, 7, 2, 2, 4, 4, 2, 1}
+	for i, tt := range goldenCodes {
+		c, err := Encode([]byte(tt.data), tt.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Version != tt.version || c.Mask != masks[i] || c.Level != tt.level {
+			t.Errorf("%q at %s: version %d mask %d, want version %d mask %d", tt.data, tt.level, c.Version, c.Mask, tt.version, masks[i])
+		}
+		forced, _ := encode([]byte(tt.data), tt.level, tt.version, tt.version, c.Mask)
+		if matrix(c) != matrix(forced) {
+			t.Errorf("%q at %s: the picked mask differs from the forced one", tt.data, tt.level)
+		}
+	}
+}
+
+func TestEncodeCapacity(t *testing.T) {
+	for _, tt := range []struct {
+		level   Level
+		n       int
+		version int
+	}{
+		{Low, 17, 1},
+		{Low, 18, 2},
+		{Medium, 14, 1},
+		{Medium, 15, 2},
+		{Quartile, 11, 1},
+		{Quartile, 12, 2},
+		{High, 7, 1},
+		{High, 8, 2},
+		{Low, 2953, 40},
+		{High, 1273, 40},
+	} {
+		c, err := Encode([]byte(strings.Repeat("a", tt.n)), tt.level)
+		if err != nil || c.Version != tt.version {
+			t.Errorf("%d bytes at %s: %v, want version %d", tt.n, tt.level, err, tt.version)
+		}
+	}
+	if _, err := Encode([]byte(strings.Repeat("a", 2954)), Low); err == nil {
+		t.Error("2954 bytes fit at L")
+	}
+	if _, err := EncodeVersion([]byte(strings.Repeat("a", 18)), Low, 1, 1); err == nil {
+		t.Error("18 bytes fit in version 1 at L")
+	}
+	if c, err := EncodeVersion([]byte("a"), Low, 5, 10); err != nil || c.Version != 5 {
+		t.Errorf("EncodeVersion from version 5 = %v, %v", c, err)
+	}
+	for _, versions := range [][2]int{{0, 1}, {1, 41}, {3, 2}} {
+		if _, err := EncodeVersion([]byte("a"), Low, versions[0], versions[1]); err == nil {
+			t.Errorf("EncodeVersion accepted the versions %v", versions)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"L": Low, "m": Medium, "quartile": Quartile, "HIGH": High} {
+		if l, err := ParseLevel(s); err != nil || l != want {
+			t.Errorf("ParseLevel(%q) = %v, %v", s, l, err)
+		}
+	}
+	if _, err := ParseLevel("X"); err == nil {
+		t.Error("ParseLevel accepted X")
+	}
+}
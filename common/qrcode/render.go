@@ -0,0 +1,87 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// QuietZone is the light border around the code scanners need, in modules.
+const QuietZone = 4
+
+// Terminal renders the code with UTF-8 half blocks, two rows of modules per
+// line. The blocks draw the light modules, which suits terminals printing
+// light text on a dark background; invert draws the dark ones instead.
+func (c *Code) Terminal(invert bool) string {
+	var sb strings.Builder
+	lit := func(x, y int) bool { return c.Dark(x, y) == invert }
+	for y := -QuietZone; y < c.Size+QuietZone; y += 2 {
+		for x := -QuietZone; x < c.Size+QuietZone; x++ {
+			top, bottom := lit(x, y), y+1 < c.Size+QuietZone && lit(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Image renders the code black on white with scale pixels per module.
+func (c *Code) Image(scale int) image.Image {
+	scale = max(scale, 1)
+	size := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Dark(x/scale-QuietZone, y/scale-QuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// PNG encodes Image as PNG.
+func (c *Code) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG renders the code as a scalable image, one unit per module and the dark
+// modules as a single path.
+func (c *Code) SVG() []byte {
+	size := strconv.Itoa(c.Size + 2*QuietZone)
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	buf.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" version="1.1" viewBox="0 0 ` + size + " " + size + `" stroke="none" shape-rendering="crispEdges">` + "\n")
+	buf.WriteString(`<rect width="100%" height="100%" fill="#FFFFFF"/>` + "\n")
+	buf.WriteString(`<path fill="#000000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			if buf.Bytes()[buf.Len()-1] != '"' {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString("M" + strconv.Itoa(x+QuietZone) + "," + strconv.Itoa(y+QuietZone) + "h1v1h-1z")
+		}
+	}
+	buf.WriteString(`"/>` + "\n")
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,102 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func testCode(t *testing.T) *Code {
+	t.Helper()
+	c, err := encode([]byte("WireGuard"), Medium, 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTerminal(t *testing.T) {
+	c := testCode(t)
+	for _, invert := range []bool{false, true} {
+		lines := strings.Split(strings.TrimSuffix(c.Terminal(invert), "\n"), "\n")
+		// 29 rows of modules, two per line
+		if len(lines) != 15 {
+			t.Fatalf("%d lines", len(lines))
+		}
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); n != 29 {
+				t.Fatalf("line of %d runes: %q", n, line)
+			}
+		}
+		// the quiet zone around the finder in the top left corner, the last
+		// line has a single row of the quiet zone
+		quiet, finder, last := "█", "████ ▄▄▄▄▄ █", "▀"
+		if invert {
+			quiet, finder, last = " ", "    █▀▀▀▀▀█ ", " "
+		}
+		if lines[0] != strings.Repeat(quiet, 29) || !strings.HasPrefix(lines[2], finder) || lines[14] != strings.Repeat(last, 29) {
+			t.Errorf("invert %v:\n%s", invert, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestPNG(t *testing.T) {
+	c := testCode(t)
+	data, err := c.PNG(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 232 || size.Y != 232 {
+		t.Fatalf("size %v, want 232x232", size)
+	}
+	dark := func(x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r == 0
+	}
+	for y := 0; y < 232; y++ {
+		for x := 0; x < 232; x++ {
+			if dark(x, y) != c.Dark(x/8-QuietZone, y/8-QuietZone) {
+				t.Fatalf("pixel %d,%d", x, y)
+			}
+		}
+	}
+	if img, _ := png.Decode(bytes.NewReader(must(c.PNG(0)))); img.Bounds().Dx() != 29 {
+		t.Errorf("scale 0 gives %v", img.Bounds())
+	}
+}
+
+func must(data []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func TestSVG(t *testing.T) {
+	c := testCode(t)
+	svg := string(c.SVG())
+	if !strings.Contains(svg, `viewBox="0 0 29 29"`) {
+		t.Errorf("svg:\n%s", svg)
+	}
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				dark++
+			}
+		}
+	}
+	// one square per dark module, the first is the corner of the finder
+	if n := strings.Count(svg, "h1v1h-1z"); n != dark {
+		t.Errorf("%d squares, want %d", n, dark)
+	}
+	if !strings.Contains(svg, `d="M4,4h1v1h-1z M5,4h1v1h-1z`) {
+		t.Errorf("svg:\n%s", svg)
+	}
+}
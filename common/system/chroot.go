@@ -0,0 +1,71 @@
+package system
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// chrootPath is the PATH searched inside a root filesystem.
+var chrootPath = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+type chroot struct {
+	root string
+}
+
+// Chroot targets a root filesystem mounted at root, e.g. /proc/<pid>/root of a
+// container. Commands run through chroot(8), files are read below root.
+// Absolute symlinks inside root are resolved against the host, and so is
+// /proc/self: /proc/net and /proc/sys/net below root show the network
+// namespace of the caller, not the container's. Use Netns for network state.
+func Chroot(root string) System {
+	return chroot{root: root}
+}
+
+func (c chroot) Run(cmd Cmd) ([]byte, []byte, error) {
+	return run(cmd, "chroot", append([]string{c.root, cmd.Name}, cmd.Args...)...)
+}
+
+func (c chroot) Stream(ctx context.Context, cmd Cmd, stdout io.Writer) error {
+	return stream(ctx, cmd, stdout, "chroot", append([]string{c.root, cmd.Name}, cmd.Args...)...)
+}
+
+func (c chroot) LookPath(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		if isExecutable(c.path(name)) {
+			return name, nil
+		}
+		return "", &fs.PathError{Op: "lookpath", Path: name, Err: ErrNotFound}
+	}
+	for _, dir := range chrootPath {
+		path := filepath.Join(dir, name)
+		if isExecutable(c.path(path)) {
+			return path, nil
+		}
+	}
+	return "", &fs.PathError{Op: "lookpath", Path: name, Err: ErrNotFound}
+}
+
+func (c chroot) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(c.path(name))
+}
+
+func (c chroot) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(c.path(name))
+}
+
+func (c chroot) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(c.path(name))
+}
+
+// path maps name into the root, ".." cannot climb above it.
+func (c chroot) path(name string) string {
+	return filepath.Join(c.root, filepath.Clean("/"+name))
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0o111 != 0
+}
@@ -0,0 +1,70 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+)
+
+type local struct{}
+
+// Local is the machine fire runs on.
+func Local() System {
+	return local{}
+}
+
+func (local) Run(cmd Cmd) ([]byte, []byte, error) {
+	return run(cmd, cmd.Name, cmd.Args...)
+}
+
+func (local) Stream(ctx context.Context, cmd Cmd, stdout io.Writer) error {
+	return stream(ctx, cmd, stdout, cmd.Name, cmd.Args...)
+}
+
+func (local) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+func (local) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (local) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (local) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// run executes name with args on the local machine on behalf of cmd,
+// name and args differ from cmd when cmd is wrapped by chroot or nsenter.
+func run(cmd Cmd, name string, args ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(name, args...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if cmd.Stdin != nil {
+		c.Stdin = bytes.NewReader(cmd.Stdin)
+	}
+	err := c.Run()
+	return stdout.Bytes(), stderr.Bytes(), runError(cmd, err, stderr.Bytes())
+}
+
+func stream(ctx context.Context, cmd Cmd, stdout io.Writer, name string, args ...string) error {
+	var stderr bytes.Buffer
+	c := exec.CommandContext(ctx, name, args...)
+	c.Stdout = stdout
+	c.Stderr = &stderr
+	if cmd.Stdin != nil {
+		c.Stdin = bytes.NewReader(cmd.Stdin)
+	}
+	err := c.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return runError(cmd, err, stderr.Bytes())
+}
@@ -0,0 +1,134 @@
+package system
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// netnsPaths are the files whose content depends on the reading network namespace.
+var netnsPaths = []string{"/proc/net/", "/proc/sys/net/", "/proc/self/net/"}
+
+type netns struct {
+	path string
+	host System
+}
+
+// Netns targets the network namespace at path, e.g. /run/netns/NAME or
+// /proc/<pid>/ns/net. Commands and the per-namespace files under /proc run
+// through nsenter(1), everything else is served by host.
+func Netns(path string, host System) System {
+	return netns{path: path, host: host}
+}
+
+func (n netns) Run(cmd Cmd) ([]byte, []byte, error) {
+	stdout, stderr, err := n.host.Run(n.wrap(cmd))
+	return stdout, stderr, n.unwrapError(cmd, err)
+}
+
+func (n netns) Stream(ctx context.Context, cmd Cmd, stdout io.Writer) error {
+	return n.unwrapError(cmd, n.host.Stream(ctx, n.wrap(cmd), stdout))
+}
+
+func (n netns) wrap(cmd Cmd) Cmd {
+	wrapped := Command("nsenter", append([]string{"--net=" + n.path, "--", cmd.Name}, cmd.Args...)...)
+	wrapped.Stdin = cmd.Stdin
+	return wrapped
+}
+
+// unwrapError reports a failure of the command run by nsenter as its own.
+func (n netns) unwrapError(cmd Cmd, err error) error {
+	var exitErr *ExitError
+	if E.As(err, &exitErr) {
+		exitErr.Cmd = cmd
+	}
+	return err
+}
+
+func (n netns) LookPath(name string) (string, error) {
+	return n.host.LookPath(name)
+}
+
+func (n netns) ReadFile(name string) ([]byte, error) {
+	if !inNetns(name) {
+		return n.host.ReadFile(name)
+	}
+	stdout, _, err := n.Run(Command("cat", name))
+	if err != nil {
+		return nil, n.pathError("open", name, err)
+	}
+	return stdout, nil
+}
+
+func (n netns) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !inNetns(name) {
+		return n.host.ReadDir(name)
+	}
+	stdout, _, err := n.Run(Command("ls", "-1Ap", name))
+	if err != nil {
+		return nil, n.pathError("open", name, err)
+	}
+	var entries []fs.DirEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+		if line == "" {
+			continue
+		}
+		info := fileInfo{name: strings.TrimSuffix(line, "/")}
+		if strings.HasSuffix(line, "/") {
+			info.mode = fs.ModeDir | 0o555
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+func (n netns) Stat(name string) (fs.FileInfo, error) {
+	if !inNetns(name) {
+		return n.host.Stat(name)
+	}
+	info := fileInfo{name: name[strings.LastIndexByte(name, '/')+1:]}
+	if _, _, err := n.Run(Command("test", "-d", name)); err == nil {
+		info.mode = fs.ModeDir | 0o555
+		return info, nil
+	}
+	if _, _, err := n.Run(Command("test", "-e", name)); err != nil {
+		return nil, n.pathError("stat", name, err)
+	}
+	return info, nil
+}
+
+// pathError maps a failed helper command to the error os would have returned.
+func (n netns) pathError(op, name string, err error) error {
+	var exitErr *ExitError
+	if E.As(err, &exitErr) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func inNetns(name string) bool {
+	for _, prefix := range netnsPaths {
+		if strings.HasPrefix(name+"/", prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileInfo is a fs.FileInfo built from recorded or remote metadata.
+type fileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() fs.FileMode  { return f.mode }
+func (f fileInfo) ModTime() time.Time { return time.Time{} }
+func (f fileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fileInfo) Sys() any           { return nil }
@@ -0,0 +1,311 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	pathpkg "path"
+	"slices"
+	"sync"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Cassette is a recorded session: every command run and file read, in order.
+// It is written by Record and served by Replay, or assembled by hand as a fake.
+type Cassette struct {
+	Entries []Entry `json:"entries"`
+}
+
+type Op string
+
+const (
+	OpRun      Op = "run"
+	OpStream   Op = "stream"
+	OpLookPath Op = "lookpath"
+	OpReadFile Op = "readfile"
+	OpReadDir  Op = "readdir"
+	OpStat     Op = "stat"
+)
+
+type Entry struct {
+	Op   Op     `json:"op"`
+	Cmd  *Cmd   `json:"cmd,omitempty"`
+	Path string `json:"path,omitempty"`
+
+	// Stdout is also the content of a read file and the result of LookPath.
+	Stdout   []byte `json:"stdout,omitempty"`
+	Stderr   []byte `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	// Error is one of "exit", "not-exist", "not-found" or any other message.
+	Error string `json:"error,omitempty"`
+
+	Dir  []RecordedInfo `json:"dir,omitempty"`
+	Info *RecordedInfo  `json:"info,omitempty"`
+}
+
+type RecordedInfo struct {
+	Name string      `json:"name"`
+	Size int64       `json:"size,omitempty"`
+	Mode fs.FileMode `json:"mode"`
+}
+
+const (
+	errorExit     = "exit"
+	errorNotExist = "not-exist"
+	errorNotFound = "not-found"
+)
+
+func LoadCassette(r io.Reader) (Cassette, error) {
+	var c Cassette
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return Cassette{}, E.When("decode cassette", err)
+	}
+	return c, nil
+}
+
+func (c Cassette) Save(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
+
+// AddRun records a command that succeeded with stdout.
+func (c *Cassette) AddRun(cmd Cmd, stdout string) {
+	c.Entries = append(c.Entries, Entry{Op: OpRun, Cmd: &cmd, Stdout: []byte(stdout)})
+}
+
+// AddCommand makes name available to LookPath at path.
+func (c *Cassette) AddCommand(name, path string) {
+	c.Entries = append(c.Entries, Entry{Op: OpLookPath, Path: name, Stdout: []byte(path)})
+}
+
+// AddFile records a regular file and its content.
+func (c *Cassette) AddFile(path, content string) {
+	c.Entries = append(c.Entries,
+		Entry{Op: OpReadFile, Path: path, Stdout: []byte(content)},
+		Entry{Op: OpStat, Path: path, Info: &RecordedInfo{Name: pathpkg.Base(path), Size: int64(len(content)), Mode: 0o644}},
+	)
+}
+
+// Recorder runs everything against a real System and keeps a Cassette of it.
+type Recorder struct {
+	sys      System
+	access   sync.Mutex
+	cassette Cassette
+}
+
+func Record(sys System) *Recorder {
+	return &Recorder{sys: sys}
+}
+
+func (r *Recorder) Cassette() Cassette {
+	r.access.Lock()
+	defer r.access.Unlock()
+	return Cassette{Entries: slices.Clone(r.cassette.Entries)}
+}
+
+func (r *Recorder) add(entry Entry, err error) {
+	entry.Error, entry.ExitCode = encodeError(err)
+	var exitErr *ExitError
+	if E.As(err, &exitErr) {
+		entry.Stderr = exitErr.Stderr
+	}
+	r.access.Lock()
+	r.cassette.Entries = append(r.cassette.Entries, entry)
+	r.access.Unlock()
+}
+
+func (r *Recorder) Run(cmd Cmd) ([]byte, []byte, error) {
+	stdout, stderr, err := r.sys.Run(cmd)
+	r.add(Entry{Op: OpRun, Cmd: &cmd, Stdout: stdout, Stderr: stderr}, err)
+	return stdout, stderr, err
+}
+
+func (r *Recorder) Stream(ctx context.Context, cmd Cmd, stdout io.Writer) error {
+	var buf bytes.Buffer
+	err := r.sys.Stream(ctx, cmd, io.MultiWriter(stdout, &buf))
+	if E.Is(err, ctx.Err()) {
+		// a stream stopped by the caller replays as one that ended by itself
+		r.add(Entry{Op: OpStream, Cmd: &cmd, Stdout: buf.Bytes()}, nil)
+	} else {
+		r.add(Entry{Op: OpStream, Cmd: &cmd, Stdout: buf.Bytes()}, err)
+	}
+	return err
+}
+
+func (r *Recorder) LookPath(name string) (string, error) {
+	path, err := r.sys.LookPath(name)
+	r.add(Entry{Op: OpLookPath, Path: name, Stdout: []byte(path)}, err)
+	return path, err
+}
+
+func (r *Recorder) ReadFile(name string) ([]byte, error) {
+	data, err := r.sys.ReadFile(name)
+	r.add(Entry{Op: OpReadFile, Path: name, Stdout: data}, err)
+	return data, err
+}
+
+func (r *Recorder) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := r.sys.ReadDir(name)
+	entry := Entry{Op: OpReadDir, Path: name}
+	for _, e := range entries {
+		info := RecordedInfo{Name: e.Name(), Mode: e.Type()}
+		if fi, err := e.Info(); err == nil {
+			info.Size, info.Mode = fi.Size(), fi.Mode()
+		}
+		entry.Dir = append(entry.Dir, info)
+	}
+	r.add(entry, err)
+	return entries, err
+}
+
+func (r *Recorder) Stat(name string) (fs.FileInfo, error) {
+	info, err := r.sys.Stat(name)
+	entry := Entry{Op: OpStat, Path: name}
+	if err == nil {
+		entry.Info = &RecordedInfo{Name: info.Name(), Size: info.Size(), Mode: info.Mode()}
+	}
+	r.add(entry, err)
+	return info, err
+}
+
+type replay struct {
+	access  sync.Mutex
+	entries map[string][]Entry
+}
+
+// Replay serves a Cassette. Repeated calls get the recorded answers in order,
+// the last one is repeated once they run out. Files that were not recorded do
+// not exist, commands that were not recorded are not found.
+func Replay(c Cassette) System {
+	r := &replay{entries: make(map[string][]Entry)}
+	for _, entry := range c.Entries {
+		key := entryKey(entry.Op, entry.Path, entry.Cmd)
+		r.entries[key] = append(r.entries[key], entry)
+	}
+	return r
+}
+
+func (r *replay) next(op Op, path string, cmd *Cmd) (Entry, bool) {
+	r.access.Lock()
+	defer r.access.Unlock()
+	key := entryKey(op, path, cmd)
+	entries := r.entries[key]
+	if len(entries) == 0 {
+		return Entry{}, false
+	}
+	if len(entries) > 1 {
+		r.entries[key] = entries[1:]
+	}
+	return entries[0], true
+}
+
+func (r *replay) Run(cmd Cmd) ([]byte, []byte, error) {
+	entry, ok := r.next(OpRun, "", &cmd)
+	if !ok {
+		return nil, nil, E.When("run "+cmd.Name, ErrNotFound)
+	}
+	return entry.Stdout, entry.Stderr, decodeError(entry, &cmd, "exec", cmd.Name)
+}
+
+func (r *replay) Stream(ctx context.Context, cmd Cmd, stdout io.Writer) error {
+	entry, ok := r.next(OpStream, "", &cmd)
+	if !ok {
+		return E.When("run "+cmd.Name, ErrNotFound)
+	}
+	if _, err := stdout.Write(entry.Stdout); err != nil {
+		return err
+	}
+	return decodeError(entry, &cmd, "exec", cmd.Name)
+}
+
+func (r *replay) LookPath(name string) (string, error) {
+	entry, ok := r.next(OpLookPath, name, nil)
+	if !ok {
+		return "", &fs.PathError{Op: "lookpath", Path: name, Err: ErrNotFound}
+	}
+	return string(entry.Stdout), decodeError(entry, nil, "lookpath", name)
+}
+
+func (r *replay) ReadFile(name string) ([]byte, error) {
+	entry, ok := r.next(OpReadFile, name, nil)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return entry.Stdout, decodeError(entry, nil, "open", name)
+}
+
+func (r *replay) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, ok := r.next(OpReadDir, name, nil)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, 0, len(entry.Dir))
+	for _, info := range entry.Dir {
+		entries = append(entries, fs.FileInfoToDirEntry(info.fileInfo()))
+	}
+	return entries, decodeError(entry, nil, "open", name)
+}
+
+func (r *replay) Stat(name string) (fs.FileInfo, error) {
+	entry, ok := r.next(OpStat, name, nil)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	if err := decodeError(entry, nil, "stat", name); err != nil {
+		return nil, err
+	}
+	if entry.Info == nil {
+		return fileInfo{name: pathpkg.Base(name)}, nil
+	}
+	return entry.Info.fileInfo(), nil
+}
+
+func (i RecordedInfo) fileInfo() fs.FileInfo {
+	return fileInfo{name: i.Name, size: i.Size, mode: i.Mode}
+}
+
+func entryKey(op Op, path string, cmd *Cmd) string {
+	if cmd == nil {
+		return string(op) + "\x00" + path
+	}
+	data, _ := json.Marshal(cmd)
+	return string(op) + "\x00" + string(data)
+}
+
+func encodeError(err error) (string, int) {
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return "", 0
+	case E.As(err, &exitErr):
+		return errorExit, exitErr.ExitCode
+	case E.Is(err, fs.ErrNotExist):
+		return errorNotExist, 0
+	case E.Is(err, ErrNotFound):
+		return errorNotFound, 0
+	default:
+		return err.Error(), 0
+	}
+}
+
+func decodeError(entry Entry, cmd *Cmd, op, path string) error {
+	switch entry.Error {
+	case "":
+		return nil
+	case errorExit:
+		if cmd == nil {
+			cmd = &Cmd{Name: path}
+		}
+		return &ExitError{Cmd: *cmd, ExitCode: entry.ExitCode, Stderr: entry.Stderr}
+	case errorNotExist:
+		return &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	case errorNotFound:
+		return &fs.PathError{Op: op, Path: path, Err: ErrNotFound}
+	default:
+		return E.New(entry.Error)
+	}
+}
@@ -0,0 +1,140 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+func fakeSystem() System {
+	var c Cassette
+	c.AddCommand("nft", "/usr/sbin/nft")
+	c.AddRun(Command("nft", "-j", "list", "ruleset"), `{"nftables":[]}`)
+	c.AddRun(Command("nft", "-j", "list", "ruleset"), `{"nftables":[{}]}`)
+	c.AddFile("/proc/sys/net/ipv4/ip_forward", "1\n")
+	c.Entries = append(c.Entries, Entry{
+		Op:       OpRun,
+		Cmd:      &Cmd{Name: "nft", Args: []string{"-f", "-"}, Stdin: []byte("flush ruleset\n")},
+		Stderr:   []byte("Operation not permitted\n"),
+		Error:    errorExit,
+		ExitCode: 1,
+	})
+	return Replay(c)
+}
+
+func TestReplay(t *testing.T) {
+	sys := fakeSystem()
+
+	path, err := sys.LookPath("nft")
+	if err != nil || path != "/usr/sbin/nft" {
+		t.Fatalf("LookPath(nft) = %q, %v", path, err)
+	}
+	if _, err = sys.LookPath("iptables"); !E.Is(err, ErrNotFound) {
+		t.Errorf("LookPath(iptables) error = %v, want ErrNotFound", err)
+	}
+
+	// recorded answers come in order, the last one repeats
+	for _, want := range []string{`{"nftables":[]}`, `{"nftables":[{}]}`, `{"nftables":[{}]}`} {
+		out, err := Output(sys, "nft", "-j", "list", "ruleset")
+		if err != nil || string(out) != want {
+			t.Fatalf("Output = %q, %v, want %q", out, err, want)
+		}
+	}
+
+	_, _, err = sys.Run(Command("nft", "-f", "-").WithStdin([]byte("flush ruleset\n")))
+	var exitErr *ExitError
+	if !E.As(err, &exitErr) || exitErr.ExitCode != 1 || string(exitErr.Stderr) != "Operation not permitted\n" {
+		t.Errorf("Run(nft -f -) error = %v, want exit status 1", err)
+	}
+	// the stdin is part of the command
+	if _, _, err = sys.Run(Command("nft", "-f", "-")); !E.Is(err, ErrNotFound) {
+		t.Errorf("Run(nft -f -) without stdin error = %v, want ErrNotFound", err)
+	}
+
+	data, err := sys.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	if err != nil || string(data) != "1\n" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	if _, err = sys.ReadFile("/etc/nftables.conf"); !E.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile of a missing file error = %v, want fs.ErrNotExist", err)
+	}
+	info, err := sys.Stat("/proc/sys/net/ipv4/ip_forward")
+	if err != nil || info.Name() != "ip_forward" || info.Size() != 2 {
+		t.Errorf("Stat = %v, %v", info, err)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	recorder := Record(fakeSystem())
+	run := func(sys System) (results []string) {
+		add := func(out []byte, err error) {
+			code, exit := encodeError(err)
+			results = append(results, fmt.Sprintf("%s|%s %d", out, code, exit))
+		}
+		path, err := sys.LookPath("nft")
+		add([]byte(path), err)
+		add(Output(sys, "nft", "-j", "list", "ruleset"))
+		add(sys.ReadFile("/proc/sys/net/ipv4/ip_forward"))
+		add(sys.ReadFile("/etc/nftables.conf"))
+		add(CombinedOutput(sys, "nft", "-f", "-"))
+		_, _, err = sys.Run(Command("nft", "-f", "-").WithStdin([]byte("flush ruleset\n")))
+		add(nil, err)
+		return results
+	}
+	want := run(recorder)
+
+	var buf bytes.Buffer
+	if err := recorder.Cassette().Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	cassette, err := LoadCassette(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := run(Replay(cassette))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("call %d replayed as %q, recorded %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRecordStreamStoppedByCaller(t *testing.T) {
+	var c Cassette
+	c.Entries = append(c.Entries, Entry{Op: OpStream, Cmd: &Cmd{Name: "nft", Args: []string{"monitor"}}, Stdout: []byte("add table ip t\n")})
+	recorder := Record(&cancelling{System: Replay(c)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	if err := recorder.Stream(ctx, Command("nft", "monitor"), &out); !E.Is(err, context.Canceled) {
+		t.Fatalf("Stream error = %v, want context.Canceled", err)
+	}
+
+	// the replayed stream ends by itself
+	out.Reset()
+	if err := Replay(recorder.Cassette()).Stream(context.Background(), Command("nft", "monitor"), &out); err != nil {
+		t.Fatalf("replayed Stream error = %v", err)
+	}
+	if out.String() != "add table ip t\n" {
+		t.Errorf("replayed Stream wrote %q", out.String())
+	}
+}
+
+// cancelling ends a stream with the error of its context, like a real one
+// stopped by the caller.
+type cancelling struct {
+	System
+}
+
+func (c *cancelling) Stream(ctx context.Context, cmd Cmd, stdout io.Writer) error {
+	if err := c.System.Stream(ctx, cmd, stdout); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,98 @@
+// Package system abstracts the machine fire inspects: the commands it runs and
+// the files it reads. Besides the local host it can target a chroot/rootfs or
+// another network namespace, and record or replay a session for tests.
+package system
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os/exec"
+	"strconv"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+type Cmd struct {
+	Name  string   `json:"name"`
+	Args  []string `json:"args,omitempty"`
+	Stdin []byte   `json:"stdin,omitempty"`
+}
+
+func Command(name string, args ...string) Cmd {
+	return Cmd{Name: name, Args: args}
+}
+
+func (c Cmd) WithStdin(stdin []byte) Cmd {
+	c.Stdin = stdin
+	return c
+}
+
+type Runner interface {
+	// Run runs the command to completion. A command exiting non-zero
+	// returns its output together with an *ExitError.
+	Run(cmd Cmd) (stdout, stderr []byte, err error)
+	// Stream runs a long-lived command, like nft monitor, copying its output
+	// to stdout as it is written. It returns ctx.Err() once ctx is done.
+	Stream(ctx context.Context, cmd Cmd, stdout io.Writer) error
+	LookPath(name string) (string, error)
+}
+
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+}
+
+type System interface {
+	Runner
+	FS
+}
+
+// ExitError is returned for commands that ran but failed.
+type ExitError struct {
+	Cmd      Cmd
+	ExitCode int
+	Stderr   []byte
+}
+
+func (e *ExitError) Error() string {
+	msg := e.Cmd.Name + ": exit status " + strconv.Itoa(e.ExitCode)
+	if stderr := bytes.TrimSpace(e.Stderr); len(stderr) > 0 {
+		msg += ": " + string(stderr)
+	}
+	return msg
+}
+
+// ErrNotFound is returned by LookPath when the command does not exist.
+var ErrNotFound = exec.ErrNotFound
+
+// Output runs a command and returns its standard output.
+func Output(r Runner, name string, args ...string) ([]byte, error) {
+	stdout, _, err := r.Run(Command(name, args...))
+	return stdout, err
+}
+
+// CombinedOutput runs a command and returns its standard output followed by its standard error.
+func CombinedOutput(r Runner, name string, args ...string) ([]byte, error) {
+	stdout, stderr, err := r.Run(Command(name, args...))
+	return append(stdout, stderr...), err
+}
+
+// Exists reports whether a command is found by LookPath.
+func Exists(r Runner, name string) bool {
+	_, err := r.LookPath(name)
+	return err == nil
+}
+
+func runError(cmd Cmd, err error, stderr []byte) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if E.As(err, &exitErr) {
+		return &ExitError{Cmd: cmd, ExitCode: exitErr.ExitCode(), Stderr: stderr}
+	}
+	return E.When("run "+cmd.Name, err)
+}
@@ -0,0 +1,98 @@
+package ufw
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// AppPorts is one "|" separated entry of the ports of an application
+// profile, e.g. 80,443/tcp. Protocol is "any" when the entry names none.
+type AppPorts struct {
+	Ports    string `json:"ports"`
+	Protocol string `json:"protocol"`
+}
+
+// Apps maps application profile names to their ports.
+type Apps map[string][]AppPorts
+
+// ParseApps reads an application profile file of /etc/ufw/applications.d
+// into apps, they are INI files with one section per application.
+func ParseApps(r io.Reader, apps Apps) error {
+	var name string
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && strings.HasSuffix(line, "]"):
+			name = line[1 : len(line)-1]
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok || strings.TrimSpace(key) != "ports" {
+				continue
+			}
+			if name == "" {
+				return E.New("line ", lineNo, ": ports outside of a profile")
+			}
+			var ports []AppPorts
+			for _, entry := range strings.Split(strings.TrimSpace(value), "|") {
+				p, proto, ok := strings.Cut(entry, "/")
+				if !ok {
+					proto = Any
+				}
+				ports = append(ports, AppPorts{Ports: p, Protocol: proto})
+			}
+			apps[name] = ports
+		}
+	}
+	return scanner.Err()
+}
+
+// ResolveApps replaces the application names of rules, as printed by ufw
+// status, with their ports. A rule naming an unknown profile is dropped and
+// reported.
+func ResolveApps(rules []Rule, apps Apps) ([]Rule, []string) {
+	var (
+		resolved []Rule
+		skipped  []string
+	)
+	for _, rule := range rules {
+		expanded := []Rule{rule}
+		for _, side := range []string{"dst", "src"} {
+			app := rule.DstApp
+			if side == "src" {
+				app = rule.SrcApp
+			}
+			if app == "" {
+				continue
+			}
+			ports, ok := apps[app]
+			if !ok {
+				skipped = append(skipped, "rule "+rule.String()+": unknown application profile "+app)
+				expanded = nil
+				break
+			}
+			var next []Rule
+			for _, r := range expanded {
+				for _, p := range ports {
+					r := r
+					if side == "dst" {
+						r.Dport = p.Ports
+					} else {
+						r.Sport = p.Ports
+					}
+					if p.Protocol != Any {
+						r.Protocol = p.Protocol
+					}
+					next = append(next, r)
+				}
+			}
+			expanded = next
+		}
+		resolved = append(resolved, expanded...)
+	}
+	return resolved, skipped
+}
@@ -0,0 +1,196 @@
+// Package ufw reads the configuration of ufw, the Uncomplicated Firewall:
+// the rules it keeps in user.rules and user6.rules, the iptables rules of
+// before.rules and after.rules, its default policies and the output of
+// ufw status. Translate turns all of it into one nft table.
+package ufw
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+type Action string
+
+const (
+	ActionAllow  Action = "allow"
+	ActionDeny   Action = "deny"
+	ActionReject Action = "reject"
+	// ActionLimit allows a source at most 6 new connections in 30 seconds.
+	ActionLimit Action = "limit"
+)
+
+type Direction string
+
+const (
+	DirectionIn  Direction = "in"
+	DirectionOut Direction = "out"
+	// DirectionRoute is a forwarded packet, ufw route rules.
+	DirectionRoute Direction = "route"
+)
+
+// Any is the address, port and protocol that matches everything.
+const Any = "any"
+
+// Rule is a ufw rule. Addresses are "any", an address or a prefix; ports are
+// "any", a port, a range like 6000:6007 or a comma separated list of both.
+type Rule struct {
+	// Number is the position in ufw status numbered, zero for rules read from user.rules.
+	Number    int       `json:"number,omitempty"`
+	Action    Action    `json:"action"`
+	Log       string    `json:"log,omitempty"` // "", "log" or "log-all"
+	Direction Direction `json:"direction"`
+	Protocol  string    `json:"protocol"`
+	Src       string    `json:"src"`
+	Sport     string    `json:"sport"`
+	Dst       string    `json:"dst"`
+	Dport     string    `json:"dport"`
+	SrcApp    string    `json:"src_app,omitempty"`
+	DstApp    string    `json:"dst_app,omitempty"`
+	In        string    `json:"in,omitempty"`
+	Out       string    `json:"out,omitempty"`
+	IPv6      bool      `json:"ipv6"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+func (r Rule) String() string {
+	s := string(r.Action)
+	if r.Log != "" {
+		s += " " + r.Log
+	}
+	s += " " + string(r.Direction)
+	for _, iface := range []struct{ dir, name string }{{"in", r.In}, {"out", r.Out}} {
+		if iface.name != "" {
+			s += " on " + iface.name + " (" + iface.dir + ")"
+		}
+	}
+	s += " proto " + r.Protocol + " from " + r.Src
+	if r.Sport != Any {
+		s += " port " + r.Sport
+	}
+	if r.SrcApp != "" {
+		s += " app " + strconv.Quote(r.SrcApp)
+	}
+	s += " to " + r.Dst
+	if r.Dport != Any {
+		s += " port " + r.Dport
+	}
+	if r.DstApp != "" {
+		s += " app " + strconv.Quote(r.DstApp)
+	}
+	return s
+}
+
+// isAnyAddr reports whether addr matches every address of its family.
+func isAnyAddr(addr string) bool {
+	return addr == Any || addr == "0.0.0.0/0" || addr == "::/0"
+}
+
+// ParseUserRules reads the "### tuple ###" lines ufw writes to user.rules, or
+// user6.rules when ipv6 is set. They describe every rule the user added.
+func ParseUserRules(r io.Reader, ipv6 bool) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		tuple, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "### tuple ###")
+		if !ok {
+			continue
+		}
+		rule, err := parseTuple(strings.Fields(tuple))
+		if err != nil {
+			return nil, E.New("line ", lineNo, ": ", err)
+		}
+		rule.IPv6 = ipv6
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// parseTuple parses "ACTION PROTO DPORT DST SPORT SRC [DAPP SAPP] DIRECTION [comment=HEX]".
+func parseTuple(fields []string) (Rule, error) {
+	var rule Rule
+	if n := len(fields); n > 0 && strings.HasPrefix(fields[n-1], "comment=") {
+		comment, err := hex.DecodeString(strings.TrimPrefix(fields[n-1], "comment="))
+		if err != nil {
+			return rule, E.New("invalid comment ", fields[n-1])
+		}
+		rule.Comment = string(comment)
+		fields = fields[:n-1]
+	}
+	if len(fields) != 7 && len(fields) != 9 {
+		return rule, E.New("unexpected tuple ", strconv.Quote(strings.Join(fields, " ")))
+	}
+
+	action := fields[0]
+	rule.Direction = DirectionIn
+	if rest, ok := strings.CutPrefix(action, "route:"); ok {
+		action, rule.Direction = rest, DirectionRoute
+	}
+	action, rule.Log, _ = strings.Cut(action, "_")
+	rule.Action = Action(action)
+	switch rule.Action {
+	case ActionAllow, ActionDeny, ActionReject, ActionLimit:
+	default:
+		return rule, E.New("unknown action ", fields[0])
+	}
+	if rule.Log != "" && rule.Log != "log" && rule.Log != "log-all" {
+		return rule, E.New("unknown action ", fields[0])
+	}
+	rule.Protocol, rule.Dport, rule.Dst, rule.Sport, rule.Src = fields[1], fields[2], fields[3], fields[4], fields[5]
+	direction := fields[6]
+	if len(fields) == 9 {
+		rule.DstApp, rule.SrcApp = appName(fields[6]), appName(fields[7])
+		direction = fields[8]
+	}
+
+	// in, out, in_eth0, out_eth0 or, for route rules, in_eth0!out_eth1
+	for _, part := range strings.Split(direction, "!") {
+		dir, iface, _ := strings.Cut(part, "_")
+		switch dir {
+		case "in":
+			rule.In = iface
+		case "out":
+			rule.Out = iface
+			if rule.Direction != DirectionRoute {
+				rule.Direction = DirectionOut
+			}
+		default:
+			return rule, E.New("unknown direction ", direction)
+		}
+	}
+	// ufw writes 0.0.0.0/0 and ::/0 for rules without an address
+	for _, addr := range []*string{&rule.Src, &rule.Dst} {
+		if !validAddr(*addr) {
+			return rule, E.New("invalid address ", *addr)
+		}
+		if isAnyAddr(*addr) {
+			*addr = Any
+		}
+	}
+	return rule, nil
+}
+
+// appName decodes the application field of a tuple, "-" is none and spaces
+// are written as %20.
+func appName(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return strings.ReplaceAll(s, "%20", " ")
+}
+
+func validAddr(s string) bool {
+	if s == Any {
+		return true
+	}
+	if _, err := netip.ParsePrefix(s); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
@@ -0,0 +1,252 @@
+package ufw
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Policy is a default policy: accept, drop or reject.
+type Policy string
+
+const (
+	PolicyAccept Policy = "accept"
+	PolicyDrop   Policy = "drop"
+	PolicyReject Policy = "reject"
+)
+
+// Defaults are the default policies, ufw starts out denying incoming and
+// routed packets and allowing outgoing ones.
+type Defaults struct {
+	Input   Policy `json:"input"`
+	Output  Policy `json:"output"`
+	Forward Policy `json:"forward"`
+}
+
+func DefaultDefaults() Defaults {
+	return Defaults{Input: PolicyDrop, Output: PolicyAccept, Forward: PolicyDrop}
+}
+
+// ParseDefaults reads the DEFAULT_*_POLICY settings of /etc/default/ufw.
+func ParseDefaults(r io.Reader) (Defaults, error) {
+	defaults := DefaultDefaults()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		var policy *Policy
+		switch key {
+		case "DEFAULT_INPUT_POLICY":
+			policy = &defaults.Input
+		case "DEFAULT_OUTPUT_POLICY":
+			policy = &defaults.Output
+		case "DEFAULT_FORWARD_POLICY":
+			policy = &defaults.Forward
+		default:
+			continue
+		}
+		switch strings.ToUpper(strings.Trim(value, `"'`)) {
+		case "ACCEPT":
+			*policy = PolicyAccept
+		case "DROP":
+			*policy = PolicyDrop
+		case "REJECT":
+			*policy = PolicyReject
+		default:
+			return defaults, E.New("invalid ", key, ": ", value)
+		}
+	}
+	return defaults, scanner.Err()
+}
+
+type Status struct {
+	Active bool `json:"active"`
+	// Defaults is only printed by ufw status verbose.
+	Defaults *Defaults `json:"defaults,omitempty"`
+	Rules    []Rule    `json:"rules"`
+}
+
+var (
+	statusNumber  = regexp.MustCompile(`^\[\s*(\d+)\]\s*`)
+	statusAction  = regexp.MustCompile(`\s(ALLOW|DENY|REJECT|LIMIT)(?:\s+(IN|OUT|FWD))?(?:\s+\((log|log-all)\))?\s`)
+	statusPort    = regexp.MustCompile(`^[0-9][0-9,:]*(/[a-z0-9]+)?$`)
+	statusAttribs = regexp.MustCompile(`\s*\(((?:log|log-all|out)(?:, (?:log|log-all|out))*)\)$`)
+)
+
+// ParseStatus reads the output of ufw status, ufw status numbered or ufw
+// status verbose. Rules naming an application profile keep it in SrcApp or
+// DstApp with any ports, see ResolveApps.
+func ParseStatus(r io.Reader) (*Status, error) {
+	status := new(Status)
+	scanner := bufio.NewScanner(r)
+	inRules := false
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), " ")
+		switch {
+		case strings.HasPrefix(line, "Status:"):
+			status.Active = strings.TrimSpace(strings.TrimPrefix(line, "Status:")) == "active"
+		case strings.HasPrefix(line, "Default:"):
+			defaults, err := parseStatusDefaults(strings.TrimPrefix(line, "Default:"))
+			if err != nil {
+				return nil, E.New("line ", lineNo, ": ", err)
+			}
+			status.Defaults = &defaults
+		case strings.HasPrefix(strings.TrimSpace(line), "--"):
+			inRules = true
+		case inRules && strings.TrimSpace(line) != "":
+			rule, err := parseStatusRule(line)
+			if err != nil {
+				return nil, E.New("line ", lineNo, ": ", err)
+			}
+			status.Rules = append(status.Rules, rule)
+		}
+	}
+	return status, scanner.Err()
+}
+
+// parseStatusDefaults parses "deny (incoming), allow (outgoing), disabled (routed)".
+func parseStatusDefaults(s string) (Defaults, error) {
+	defaults := DefaultDefaults()
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		if len(fields) != 2 {
+			return defaults, E.New("invalid defaults ", strconv.Quote(s))
+		}
+		var policy Policy
+		switch fields[0] {
+		case "allow":
+			policy = PolicyAccept
+		case "deny", "disabled":
+			policy = PolicyDrop
+		case "reject":
+			policy = PolicyReject
+		default:
+			return defaults, E.New("invalid default ", fields[0])
+		}
+		switch fields[1] {
+		case "(incoming)":
+			defaults.Input = policy
+		case "(outgoing)":
+			defaults.Output = policy
+		case "(routed)":
+			defaults.Forward = policy
+		}
+	}
+	return defaults, nil
+}
+
+// parseStatusRule parses a line like
+//
+//	[ 3] 3306/tcp on eth0             DENY IN     10.0.0.5                   # db
+func parseStatusRule(line string) (Rule, error) {
+	rule := Rule{Protocol: Any}
+	if m := statusNumber.FindStringSubmatch(line); m != nil {
+		rule.Number, _ = strconv.Atoi(m[1])
+		line = line[len(m[0]):]
+	}
+	if to, comment, ok := strings.Cut(line, " # "); ok {
+		line, rule.Comment = to, strings.TrimSpace(comment)
+	}
+	loc := statusAction.FindStringSubmatchIndex(line + " ")
+	if loc == nil {
+		return rule, E.New("no action in ", strconv.Quote(line))
+	}
+	m := statusAction.FindStringSubmatch(line + " ")
+	rule.Action = Action(strings.ToLower(m[1]))
+	rule.Log = m[3]
+	switch m[2] {
+	case "", "IN":
+		rule.Direction = DirectionIn
+	case "OUT":
+		rule.Direction = DirectionOut
+	case "FWD":
+		rule.Direction = DirectionRoute
+	}
+	to := strings.TrimSpace(line[:loc[0]])
+	from := strings.TrimSpace(line[min(loc[1], len(line)):])
+	// ufw status appends attributes like (out) or (log, out) to the source
+	if m := statusAttribs.FindStringSubmatch(from); m != nil {
+		from = strings.TrimSpace(from[:len(from)-len(m[0])])
+		for _, attrib := range strings.Split(m[1], ", ") {
+			if attrib == "out" {
+				rule.Direction = DirectionOut
+			} else {
+				rule.Log = attrib
+			}
+		}
+	}
+
+	dst, err := parseStatusEndpoint(to)
+	if err != nil {
+		return rule, err
+	}
+	src, err := parseStatusEndpoint(from)
+	if err != nil {
+		return rule, err
+	}
+	rule.Dst, rule.Dport, rule.DstApp = dst.addr, dst.port, dst.app
+	rule.Src, rule.Sport, rule.SrcApp = src.addr, src.port, src.app
+	rule.IPv6 = dst.ipv6 || src.ipv6 || strings.Contains(rule.Dst, ":") || strings.Contains(rule.Src, ":")
+	for _, proto := range []string{dst.proto, src.proto} {
+		if proto != "" {
+			rule.Protocol = proto
+		}
+	}
+	switch rule.Direction {
+	case DirectionIn:
+		rule.In = dst.iface
+	case DirectionOut:
+		rule.Out = dst.iface
+	case DirectionRoute:
+		rule.Out, rule.In = dst.iface, src.iface
+	}
+	return rule, nil
+}
+
+type statusEndpoint struct {
+	addr, port, proto, app, iface string
+	ipv6                          bool
+}
+
+// parseStatusEndpoint parses one side of a status rule: "Anywhere",
+// "22/tcp", "10.0.0.5 22/tcp", "Anywhere on eth0", "OpenSSH (v6)", ...
+func parseStatusEndpoint(s string) (statusEndpoint, error) {
+	e := statusEndpoint{addr: Any, port: Any}
+	if rest, ok := strings.CutSuffix(s, "(v6)"); ok {
+		s, e.ipv6 = strings.TrimSpace(rest), true
+	}
+	if i := strings.LastIndex(s, " on "); i >= 0 {
+		s, e.iface = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(" on "):])
+	}
+	fields := strings.Fields(s)
+	switch {
+	case len(fields) == 0:
+		return e, E.New("empty rule endpoint")
+	case fields[0] == "Anywhere" || validAddr(fields[0]):
+		if fields[0] != "Anywhere" {
+			e.addr = fields[0]
+			if _, err := netip.ParseAddr(e.addr); err == nil && strings.Contains(e.addr, ":") {
+				e.ipv6 = true
+			}
+		}
+		switch {
+		case len(fields) == 2 && statusPort.MatchString(fields[1]):
+			e.port, e.proto, _ = strings.Cut(fields[1], "/")
+		case len(fields) > 1:
+			// an address followed by an application name
+			e.app = strings.Join(fields[1:], " ")
+		}
+	case len(fields) == 1 && statusPort.MatchString(fields[0]):
+		e.port, e.proto, _ = strings.Cut(fields[0], "/")
+	default:
+		e.app = strings.Join(fields, " ")
+	}
+	return e, nil
+}
@@ -0,0 +1,571 @@
+package ufw
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/iptables"
+	"github.com/woshikedayaa/fire/common/nftables/set"
+)
+
+// DefaultTable is the inet table Translate writes unless Config.Table is set.
+const DefaultTable = "fire_ufw"
+
+// File is an iptables-restore file ufw loads around the user rules, like
+// before.rules or after6.rules.
+type File struct {
+	Name   string
+	IPv6   bool
+	Tables []*iptables.Table
+}
+
+type Config struct {
+	Table    string
+	Defaults Defaults
+	// Rules are the user rules of both families, from user.rules and
+	// user6.rules or from ufw status.
+	Rules []Rule
+	Files []File
+}
+
+type Translation struct {
+	Script string `json:"script"`
+	// Skipped lists the rules and tables that have no translation.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+var (
+	tableName    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	protocolName = regexp.MustCompile(`^[a-z0-9-]+$`)
+	chainInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// filterHooks are the base chains of the filter table, in the order they are
+// written.
+var filterHooks = []string{"input", "forward", "output"}
+
+var natHooks = map[string]string{
+	"PREROUTING":  "type nat hook prerouting priority dstnat;",
+	"INPUT":       "type nat hook input priority srcnat;",
+	"OUTPUT":      "type nat hook output priority dstnat;",
+	"POSTROUTING": "type nat hook postrouting priority srcnat;",
+}
+
+var logPrefixes = map[Action]string{
+	ActionAllow:  "[UFW ALLOW] ",
+	ActionDeny:   "[UFW BLOCK] ",
+	ActionReject: "[UFW REJECT] ",
+	ActionLimit:  "[UFW LIMIT BLOCK] ",
+}
+
+// Translate renders the ufw configuration as an nft script that atomically
+// replaces one inet table. The base chains input, forward and output carry
+// the default policies and jump to the translated before, user and after
+// chains. Rules of both families share a chain; rules that exist in only one
+// family are guarded by meta nfproto unless they already match addresses of
+// that family. Addresses used by more than one rule become named sets.
+func Translate(c *Config) (*Translation, error) {
+	if c.Table == "" {
+		c.Table = DefaultTable
+	}
+	if !tableName.MatchString(c.Table) {
+		return nil, E.New("invalid table name ", c.Table)
+	}
+	t := &translator{
+		config:  c,
+		chains:  make(map[string]*chain),
+		logging: make(map[string]bool),
+		sets:    make(map[string]string),
+	}
+	for _, file := range c.Files {
+		t.file(file)
+	}
+	t.userRules(c.Rules)
+	return &Translation{Script: t.render(), Skipped: t.skipped}, nil
+}
+
+type chain struct {
+	name string
+	// hook is the "type ... hook ..." declaration of nat base chains.
+	hook string
+	// rules of IPv4 and IPv6
+	rules [2][]nftRule
+}
+
+type translator struct {
+	config  *Config
+	skipped []string
+	chains  map[string]*chain
+	order   []string
+	logging map[string]bool
+	// sets maps the family and elements of an address list to its named set
+	sets     map[string]string
+	setDecls []set.Set
+	addrUses map[string]int
+	limits   [2]bool
+}
+
+func (t *translator) skip(a ...any) {
+	t.skipped = append(t.skipped, fmt.Sprint(a...))
+}
+
+func (t *translator) chain(name string) *chain {
+	c, ok := t.chains[name]
+	if !ok {
+		c = &chain{name: name}
+		t.chains[name] = c
+		t.order = append(t.order, name)
+	}
+	return c
+}
+
+func familyIndex(ipv6 bool) int {
+	if ipv6 {
+		return 1
+	}
+	return 0
+}
+
+// chainName turns ufw-before-input and ufw6-before-input into before_input.
+func chainName(name string) string {
+	for _, prefix := range []string{"ufw6-", "ufw-"} {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			name = rest
+			break
+		}
+	}
+	return chainInvalid.ReplaceAllString(name, "_")
+}
+
+// xtChainName returns the nft chain of an iptables chain. The built-in
+// chains of the filter table belong to ufw and are not translated.
+func xtChainName(table, name string) (string, bool) {
+	if table == "nat" {
+		if _, ok := natHooks[name]; ok {
+			return "nat_" + strings.ToLower(name), true
+		}
+		return "nat_" + chainName(name), true
+	}
+	switch name {
+	case "INPUT", "OUTPUT", "FORWARD":
+		return "", false
+	}
+	return chainName(name), true
+}
+
+func (t *translator) file(f File) {
+	family := familyIndex(f.IPv6)
+	for _, table := range f.Tables {
+		if table.Name != "filter" && table.Name != "nat" {
+			t.skip(f.Name, ": table ", table.Name, " is not translated")
+			continue
+		}
+		ctx := xtContext{
+			ipv6:  f.IPv6,
+			table: table.Name,
+			chain: func(target string) (string, bool) {
+				return t.target(table, target)
+			},
+			verdict: t.policyVerdict,
+		}
+		for _, xc := range table.Chains {
+			name, ok := xtChainName(table.Name, xc.Name)
+			if !ok {
+				t.skip(f.Name, ": rules of the built-in chain ", xc.Name, " are not translated")
+				continue
+			}
+			c := t.chain(name)
+			if table.Name == "nat" {
+				c.hook = natHooks[xc.Name]
+			}
+			for _, xr := range xc.Rules {
+				rule, err := translateXtables(xr, ctx)
+				if err != nil {
+					t.skip(f.Name, ": -A ", xc.Name, " ", strings.Join(xr.Args, " "), ": ", err)
+					continue
+				}
+				c.rules[family] = append(c.rules[family], rule)
+			}
+		}
+	}
+}
+
+// target resolves the chain a rule of table jumps to.
+func (t *translator) target(table *iptables.Table, target string) (string, bool) {
+	if table.Name == "filter" {
+		switch name := chainName(target); name {
+		case "logging_deny", "logging_allow":
+			t.logging[name] = true
+			return name, true
+		case "user_input", "user_output", "user_forward":
+			t.chain(name)
+			return name, true
+		}
+	}
+	if table.Chain(target) == nil {
+		return "", false
+	}
+	return xtChainName(table.Name, target)
+}
+
+// policyVerdict resolves the ufw-skip-to-policy-* chains of after.rules.
+func (t *translator) policyVerdict(target string) (string, bool) {
+	defaults := t.config.Defaults
+	switch chainName(target) {
+	case "skip_to_policy_input":
+		return policyVerdict(defaults.Input), true
+	case "skip_to_policy_output":
+		return policyVerdict(defaults.Output), true
+	case "skip_to_policy_forward":
+		return policyVerdict(defaults.Forward), true
+	}
+	return "", false
+}
+
+func policyVerdict(p Policy) string {
+	if p == PolicyReject {
+		return "reject"
+	}
+	return string(p)
+}
+
+// userRule is a user rule whose sources may have been merged with the ones
+// of the rules following it.
+type userRule struct {
+	Rule
+	srcs []string
+}
+
+// mergeSources merges consecutive rules that differ only in their source.
+func mergeSources(rules []Rule) []userRule {
+	var merged []userRule
+	key := func(r Rule) Rule {
+		r.Src, r.Number = "", 0
+		return r
+	}
+	for _, r := range rules {
+		if n := len(merged); n > 0 && r.Src != Any && merged[n-1].Src != Any && key(merged[n-1].Rule) == key(r) {
+			merged[n-1].srcs = append(merged[n-1].srcs, r.Src)
+			continue
+		}
+		merged = append(merged, userRule{Rule: r, srcs: []string{r.Src}})
+	}
+	return merged
+}
+
+func addrKey(ipv6 bool, addrs []string) string {
+	return strconv.FormatBool(ipv6) + " " + strings.Join(addrs, ",")
+}
+
+func (t *translator) userRules(rules []Rule) {
+	merged := mergeSources(rules)
+	t.addrUses = make(map[string]int)
+	for _, r := range merged {
+		if r.Src != Any {
+			t.addrUses[addrKey(r.IPv6, r.srcs)]++
+		}
+		if r.Dst != Any {
+			t.addrUses[addrKey(r.IPv6, []string{r.Dst})]++
+		}
+	}
+	for _, r := range merged {
+		name := map[Direction]string{DirectionIn: "user_input", DirectionOut: "user_output", DirectionRoute: "user_forward"}[r.Direction]
+		if name == "" {
+			t.skip("rule ", r.String(), ": unknown direction")
+			continue
+		}
+		translated, err := t.userRule(r)
+		if err != nil {
+			t.skip("rule ", r.String(), ": ", err)
+			continue
+		}
+		c := t.chain(name)
+		family := familyIndex(r.IPv6)
+		c.rules[family] = append(c.rules[family], translated...)
+	}
+}
+
+// addr returns a named set for address lists used by more than one rule and
+// the addresses themselves otherwise.
+func (t *translator) addr(ipv6 bool, addrs []string) string {
+	key := addrKey(ipv6, addrs)
+	if t.addrUses[key] < 2 {
+		return list(addrs)
+	}
+	if name, ok := t.sets[key]; ok {
+		return "@" + name
+	}
+	s := set.Set{Type: set.TypeIpv4Addr, Elements: addrs}
+	family := "4"
+	if ipv6 {
+		s.Type, family = set.TypeIpv6Addr, "6"
+	}
+	for _, addr := range addrs {
+		if strings.Contains(addr, "/") {
+			s.Flag, s.AutoMerge = []set.Flag{set.FlagInterval}, true
+			break
+		}
+	}
+	n := 1
+	for _, decl := range t.setDecls {
+		if decl.Type == s.Type {
+			n++
+		}
+	}
+	s.Name = "addr" + family + "_" + strconv.Itoa(n)
+	t.sets[key] = s.Name
+	t.setDecls = append(t.setDecls, s)
+	return "@" + s.Name
+}
+
+func (t *translator) userRule(r userRule) ([]nftRule, error) {
+	var (
+		matches    []string
+		restricted bool
+	)
+	ip := "ip"
+	if r.IPv6 {
+		ip = "ip6"
+	}
+	if r.In != "" {
+		matches = append(matches, "iifname "+strconv.Quote(r.In))
+	}
+	if r.Out != "" {
+		matches = append(matches, "oifname "+strconv.Quote(r.Out))
+	}
+	for _, addr := range append(append([]string{}, r.srcs...), r.Dst) {
+		if !validAddr(addr) {
+			return nil, E.New("invalid address ", addr)
+		}
+	}
+	if r.Src != Any {
+		matches = append(matches, ip+" saddr "+t.addr(r.IPv6, r.srcs))
+		restricted = true
+	}
+	if r.Dst != Any {
+		matches = append(matches, ip+" daddr "+t.addr(r.IPv6, []string{r.Dst}))
+		restricted = true
+	}
+
+	if !protocolName.MatchString(r.Protocol) {
+		return nil, E.New("invalid protocol ", r.Protocol)
+	}
+	ports := func(proto string) {
+		for _, p := range []struct{ field, ports string }{{"sport", r.Sport}, {"dport", r.Dport}} {
+			if p.ports != Any {
+				matches = append(matches, proto+" "+p.field+" "+list(strings.Split(strings.ReplaceAll(p.ports, ":", "-"), ",")))
+			}
+		}
+	}
+	switch {
+	case r.Sport == Any && r.Dport == Any:
+		if r.Protocol != Any {
+			matches = append(matches, "meta l4proto "+r.Protocol)
+		}
+	case r.Protocol == Any:
+		matches = append(matches, "meta l4proto {tcp,udp}")
+		ports("th")
+	case r.Protocol == "tcp" || r.Protocol == "udp":
+		ports(r.Protocol)
+	default:
+		return nil, E.New("ports with protocol ", r.Protocol)
+	}
+
+	var verdict string
+	switch r.Action {
+	case ActionAllow, ActionLimit:
+		verdict = "accept"
+	case ActionDeny:
+		verdict = "drop"
+	case ActionReject:
+		verdict = "reject"
+		if r.Protocol == "tcp" {
+			verdict = "reject with tcp reset"
+		}
+	default:
+		return nil, E.New("unknown action ", r.Action)
+	}
+	logPrefix := "log prefix " + strconv.Quote(logPrefixes[r.Action])
+	comment := ""
+	if r.Comment != "" {
+		comment = "comment " + strconv.Quote(r.Comment)
+	}
+	rule := func(parts ...string) nftRule {
+		all := append(append([]string{}, matches...), parts...)
+		if comment != "" {
+			all = append(all, comment)
+		}
+		return nftRule{text: strings.Join(all, " "), restricted: restricted}
+	}
+
+	var rules []nftRule
+	if r.Action == ActionLimit {
+		// ufw rejects a source after 6 new connections within 30 seconds
+		limitSet := "limit4"
+		if r.IPv6 {
+			limitSet = "limit6"
+		}
+		t.limits[familyIndex(r.IPv6)] = true
+		parts := []string{"ct state new", "add @" + limitSet + " { " + ip + " saddr limit rate over 12/minute burst 6 packets }"}
+		if r.Log != "" {
+			parts = append(parts, logPrefix)
+		}
+		limited := rule(append(parts, "reject")...)
+		// the meter key already limits the rule to one family
+		limited.restricted = true
+		rules = append(rules, limited)
+		logPrefix = "log prefix " + strconv.Quote(logPrefixes[ActionAllow])
+	}
+	switch r.Log {
+	case "log":
+		rules = append(rules, rule("ct state new", logPrefix))
+		rules = append(rules, rule(verdict))
+	case "log-all":
+		rules = append(rules, rule(logPrefix, verdict))
+	default:
+		rules = append(rules, rule(verdict))
+	}
+	return rules, nil
+}
+
+// mergeFamilies interleaves the rules of both families along their longest
+// common subsequence, so rules present in both are written once.
+func mergeFamilies(v4, v6 []nftRule) []string {
+	n, m := len(v4), len(v6)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if v4[i].text == v6[j].text {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	only := func(r nftRule, family string) string {
+		if r.restricted {
+			return r.text
+		}
+		return "meta nfproto " + family + " " + r.text
+	}
+	var merged []string
+	for i, j := 0, 0; i < n || j < m; {
+		switch {
+		case i < n && j < m && v4[i].text == v6[j].text:
+			merged = append(merged, v4[i].text)
+			i, j = i+1, j+1
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			merged = append(merged, only(v4[i], "ipv4"))
+			i++
+		default:
+			merged = append(merged, only(v6[j], "ipv6"))
+			j++
+		}
+	}
+	return merged
+}
+
+type writer struct {
+	strings.Builder
+}
+
+func (w *writer) line(depth int, format string, a ...any) {
+	if format == "" {
+		w.WriteByte('\n')
+		return
+	}
+	w.WriteString(strings.Repeat("\t", depth))
+	fmt.Fprintf(w, format, a...)
+	w.WriteByte('\n')
+}
+
+func (t *translator) render() string {
+	var w writer
+	table := t.config.Table
+	// declaring the table first lets the delete succeed when it does not exist yet
+	w.line(0, "table inet %s", table)
+	w.line(0, "delete table inet %s", table)
+	w.line(0, "table inet %s {", table)
+	for _, s := range t.setDecls {
+		w.line(1, "%s", s.AsNamed())
+	}
+	for i, used := range t.limits {
+		if used {
+			s := set.Set{Name: "limit4", Type: set.TypeIpv4Addr, Flag: []set.Flag{set.FlagDynamic, set.FlagTimeout}, Timeout: "1m"}
+			if i == 1 {
+				s.Name, s.Type = "limit6", set.TypeIpv6Addr
+			}
+			w.line(1, "%s", s.AsNamed())
+		}
+	}
+
+	defaults := map[string]Policy{"input": t.config.Defaults.Input, "forward": t.config.Defaults.Forward, "output": t.config.Defaults.Output}
+	for _, hook := range filterHooks {
+		policy := defaults[hook]
+		w.line(1, "")
+		w.line(1, "chain %s {", hook)
+		if policy == PolicyReject {
+			w.line(2, "type filter hook %s priority filter; policy drop;", hook)
+		} else {
+			w.line(2, "type filter hook %s priority filter; policy %s;", hook, policy)
+		}
+		if _, ok := t.chains["before_"+hook]; !ok {
+			// what the default before.rules of ufw do first
+			w.line(2, "ct state established,related accept")
+			if hook == "input" {
+				w.line(2, "ct state invalid drop")
+				w.line(2, `iifname "lo" accept`)
+			} else if hook == "output" {
+				w.line(2, `oifname "lo" accept`)
+			}
+		}
+		for _, stage := range []string{"before_", "user_", "after_"} {
+			if _, ok := t.chains[stage+hook]; ok {
+				w.line(2, "jump %s", stage+hook)
+			}
+		}
+		if policy == PolicyReject {
+			w.line(2, "reject")
+		}
+		w.line(1, "}")
+	}
+
+	for _, base := range []bool{true, false} {
+		for _, name := range t.order {
+			c := t.chains[name]
+			if (c.hook != "") != base {
+				continue
+			}
+			w.line(1, "")
+			w.line(1, "chain %s {", name)
+			if c.hook != "" {
+				w.line(2, "%s policy accept;", c.hook)
+			}
+			for _, rule := range mergeFamilies(c.rules[0], c.rules[1]) {
+				w.line(2, "%s", rule)
+			}
+			w.line(1, "}")
+		}
+	}
+	for _, name := range []string{"logging_deny", "logging_allow"} {
+		if !t.logging[name] {
+			continue
+		}
+		prefix := logPrefixes[ActionDeny]
+		if name == "logging_allow" {
+			prefix = logPrefixes[ActionAllow]
+		}
+		w.line(1, "")
+		w.line(1, "chain %s {", name)
+		w.line(2, "limit rate 3/minute burst 10 packets log prefix %s", strconv.Quote(prefix))
+		w.line(1, "}")
+	}
+	w.line(0, "}")
+	return w.String()
+}
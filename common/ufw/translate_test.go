@@ -0,0 +1,73 @@
+package ufw
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func readUserRules(t *testing.T, name string, ipv6 bool) []Rule {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rules, err := ParseUserRules(f, ipv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestTranslate(t *testing.T) {
+	rules := append(readUserRules(t, "user.rules", false), readUserRules(t, "user6.rules", true)...)
+	tr, err := Translate(&Config{Defaults: DefaultDefaults(), Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "user.nft"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Script != string(want) {
+		t.Errorf("script:\n%s\nwant:\n%s", tr.Script, want)
+	}
+	if len(tr.Skipped) > 0 {
+		t.Errorf("skipped %q", tr.Skipped)
+	}
+}
+
+func TestTranslateSkipped(t *testing.T) {
+	rules, err := ParseUserRules(strings.NewReader(
+		"### tuple ### allow icmp 8 0.0.0.0/0 any 0.0.0.0/0 in\n"+
+			"### tuple ### allow tcp 22 0.0.0.0/0 any 0.0.0.0/0 in\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultDefaults()
+	defaults.Input = PolicyReject
+	tr, err := Translate(&Config{Table: "ufw", Defaults: defaults, Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tr.Skipped, []string{"rule allow in proto icmp from any to any port 8: ports with protocol icmp"}) {
+		t.Errorf("skipped %q", tr.Skipped)
+	}
+	for _, want := range []string{
+		"delete table inet ufw\n",
+		"\t\ttype filter hook input priority filter; policy drop;\n",
+		"\t\tjump user_input\n\t\treject\n",
+		"\t\tmeta nfproto ipv4 tcp dport 22 accept\n",
+	} {
+		if !strings.Contains(tr.Script, want) {
+			t.Errorf("script misses %q:\n%s", want, tr.Script)
+		}
+	}
+
+	if _, err = Translate(&Config{Table: "fire-ufw"}); err == nil {
+		t.Error("Translate accepted the table name fire-ufw")
+	}
+}
@@ -0,0 +1,314 @@
+package ufw
+
+import (
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+	"github.com/woshikedayaa/fire/common/iptables"
+	"github.com/woshikedayaa/fire/common/nftables/set"
+)
+
+// nftRule is a translated rule. Restricted is set when the rule only matches
+// one family already, e.g. through an address or an ICMP type.
+type nftRule struct {
+	text       string
+	restricted bool
+}
+
+// xtContext tells translateXtables how to resolve jump targets.
+type xtContext struct {
+	ipv6  bool
+	table string
+	// chain maps an iptables chain of the same table to its nft chain
+	chain func(name string) (string, bool)
+	// verdict returns the verdict of the ufw-skip-to-policy-* chains
+	verdict func(name string) (string, bool)
+}
+
+var xtModules = map[string]bool{
+	"tcp": true, "udp": true, "icmp": true, "icmp6": true, "icmpv6": true, "conntrack": true, "state": true,
+	"multiport": true, "comment": true, "limit": true, "addrtype": true, "pkttype": true,
+}
+
+// xtFlags are the options without a value.
+var xtFlags = map[string]bool{
+	"-f": true, "--fragment": true, "--log-uid": true, "--log-tcp-options": true, "--log-ip-options": true, "--log-tcp-sequence": true,
+}
+
+var icmpv6Types = map[string]string{
+	"router-solicitation":     "nd-router-solicit",
+	"router-advertisement":    "nd-router-advert",
+	"neighbour-solicitation":  "nd-neighbor-solicit",
+	"neighbor-solicitation":   "nd-neighbor-solicit",
+	"neighbour-advertisement": "nd-neighbor-advert",
+	"neighbor-advertisement":  "nd-neighbor-advert",
+	"redirect":                "nd-redirect",
+}
+
+var limitUnits = map[string]string{
+	"s": "second", "sec": "second", "second": "second",
+	"m": "minute", "min": "minute", "minute": "minute",
+	"h": "hour", "hour": "hour", "d": "day", "day": "day",
+}
+
+var logFlags = map[string]string{
+	"--log-tcp-sequence": "tcp sequence",
+	"--log-tcp-options":  "tcp options",
+	"--log-ip-options":   "ip options",
+	"--log-uid":          "skuid",
+}
+
+// translateXtables translates an iptables rule of before.rules or after.rules.
+func translateXtables(xt *iptables.Rule, ctx xtContext) (nftRule, error) {
+	var (
+		args      = xt.Args
+		rule      nftRule
+		flags     []string
+		matches   []string
+		limit     []string
+		log       []string
+		verdict   string
+		comment   string
+		proto     string
+		protoAt   = -1
+		negate    bool
+		target    string
+		targetOpt = make(map[string]string)
+	)
+	ip := "ip"
+	if ctx.ipv6 {
+		ip = "ip6"
+	}
+	not := func() string {
+		if negate {
+			return "!= "
+		}
+		return ""
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "!" {
+			negate = true
+			continue
+		}
+		var value string
+		if strings.HasPrefix(arg, "-") && !xtFlags[arg] {
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				value = args[i]
+			}
+			if value == "!" && i+1 < len(args) {
+				// iptables-save writes "-m conntrack ! --ctstate", older files "--ctstate ! NEW"
+				i++
+				value, negate = args[i], true
+			}
+		}
+		if value == "" && !xtFlags[arg] {
+			return rule, E.New("missing value of ", arg)
+		}
+		switch arg {
+		case "-p", "--protocol":
+			proto = strings.ToLower(value)
+			switch proto {
+			case "all":
+				proto = ""
+			case "icmpv6", "ipv6-icmp", "icmp6":
+				proto = "ipv6-icmp"
+			}
+			if proto != "" {
+				protoAt = len(matches)
+				if negate {
+					protoAt = -1
+				}
+				matches = append(matches, "meta l4proto "+not()+proto)
+			}
+		case "-s", "--source", "-d", "--destination":
+			field := "saddr"
+			if arg == "-d" || arg == "--destination" {
+				field = "daddr"
+			}
+			if isAnyAddr(value) {
+				break
+			}
+			matches = append(matches, ip+" "+field+" "+not()+list(strings.Split(value, ",")))
+			rule.restricted = true
+		case "-i", "--in-interface", "-o", "--out-interface":
+			key := "iifname"
+			if arg == "-o" || arg == "--out-interface" {
+				key = "oifname"
+			}
+			if name, ok := strings.CutSuffix(value, "+"); ok {
+				value = name + "*"
+			}
+			matches = append(matches, key+" "+not()+strconv.Quote(value))
+		case "-m", "--match":
+			if !xtModules[value] {
+				return rule, E.New("unsupported match ", value)
+			}
+		case "--sport", "--source-port", "--dport", "--destination-port", "--sports", "--source-ports", "--dports", "--destination-ports":
+			switch proto {
+			case "tcp", "udp", "udplite", "sctp", "dccp":
+			default:
+				return rule, E.New(arg, " without a port protocol")
+			}
+			field := "sport"
+			if strings.HasPrefix(arg, "--d") {
+				field = "dport"
+			}
+			ports := strings.Split(strings.ReplaceAll(value, ":", "-"), ",")
+			matches = append(matches, proto+" "+field+" "+not()+list(ports))
+			if protoAt >= 0 {
+				matches[protoAt] = ""
+			}
+		case "--ctstate", "--state":
+			matches = append(matches, "ct state "+not()+strings.ToLower(value))
+		case "--icmp-type", "--icmpv6-type":
+			if strings.Contains(value, "/") {
+				return rule, E.New("icmp type and code ", value)
+			}
+			family := "icmp"
+			if ctx.ipv6 {
+				family = "icmpv6"
+				if name, ok := icmpv6Types[value]; ok {
+					value = name
+				}
+			}
+			if value != "any" {
+				matches = append(matches, family+" type "+not()+value)
+				if protoAt >= 0 {
+					matches[protoAt] = ""
+				}
+			}
+			rule.restricted = true
+		case "--dst-type", "--src-type":
+			field := "daddr"
+			if arg == "--src-type" {
+				field = "saddr"
+			}
+			matches = append(matches, "fib "+field+" type "+not()+strings.ToLower(value))
+		case "--pkt-type":
+			matches = append(matches, "meta pkttype "+not()+strings.ToLower(value))
+		case "--limit":
+			rate, unit, _ := strings.Cut(value, "/")
+			if unit = limitUnits[unit]; unit == "" {
+				return rule, E.New("invalid limit ", value)
+			}
+			limit = append([]string{"limit rate " + rate + "/" + unit}, limit...)
+		case "--limit-burst":
+			limit = append(limit, "burst "+value+" packets")
+		case "--comment":
+			comment = value
+		case "-j", "--jump", "-g", "--goto":
+			target = value
+		case "--log-uid", "--log-tcp-options", "--log-ip-options", "--log-tcp-sequence":
+			flags = append(flags, logFlags[arg])
+		case "--log-prefix", "--log-level", "--reject-with", "--to-source", "--to-destination", "--to-ports":
+			targetOpt[arg] = value
+		default:
+			return rule, E.New("unsupported option ", arg)
+		}
+		negate = false
+	}
+
+	switch target {
+	case "":
+	case "ACCEPT", "DROP", "RETURN":
+		verdict = strings.ToLower(target)
+	case "REJECT":
+		verdict = rejectWith(targetOpt["--reject-with"])
+	case "LOG":
+		if prefix, ok := targetOpt["--log-prefix"]; ok {
+			log = append(log, "log prefix "+strconv.Quote(prefix))
+		} else {
+			log = append(log, "log")
+		}
+		if level, ok := targetOpt["--log-level"]; ok {
+			log = append(log, "level "+logLevel(level))
+		}
+		if len(flags) > 0 {
+			log = append(log, "flags "+strings.Join(flags, ","))
+		}
+	case "MASQUERADE", "SNAT", "DNAT", "REDIRECT":
+		if ctx.table != "nat" {
+			return rule, E.New(target, " outside of the nat table")
+		}
+		verdict = natStatement(target, targetOpt, ip)
+	default:
+		if v, ok := ctx.verdict(target); ok {
+			verdict = v
+		} else if chain, ok := ctx.chain(target); ok {
+			verdict = "jump " + chain
+			if xt.Goto {
+				verdict = "goto " + chain
+			}
+		} else {
+			return rule, E.New("unknown target ", target)
+		}
+	}
+
+	parts := make([]string, 0, len(matches)+4)
+	for _, m := range matches {
+		if m != "" {
+			parts = append(parts, m)
+		}
+	}
+	parts = append(parts, limit...)
+	parts = append(parts, log...)
+	if verdict != "" {
+		parts = append(parts, verdict)
+	}
+	if comment != "" {
+		parts = append(parts, "comment "+strconv.Quote(comment))
+	}
+	if len(parts) == 0 {
+		return rule, E.New("empty rule")
+	}
+	rule.text = strings.Join(parts, " ")
+	return rule, nil
+}
+
+// list prints one value as is and several as an anonymous set.
+func list(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return (&set.Set{Elements: values}).AsAnonymous()
+}
+
+func rejectWith(with string) string {
+	switch with {
+	case "tcp-reset":
+		return "reject with tcp reset"
+	case "icmp-host-prohibited":
+		return "reject with icmp type host-prohibited"
+	case "icmp-admin-prohibited", "icmp-adm-prohibited":
+		return "reject with icmp type admin-prohibited"
+	case "icmp6-adm-prohibited", "adm-prohibited":
+		return "reject with icmpv6 type admin-prohibited"
+	}
+	return "reject"
+}
+
+func logLevel(level string) string {
+	levels := []string{"emerg", "alert", "crit", "err", "warn", "notice", "info", "debug"}
+	if n, err := strconv.Atoi(level); err == nil && n >= 0 && n < len(levels) {
+		return levels[n]
+	}
+	return level
+}
+
+func natStatement(target string, opt map[string]string, ip string) string {
+	switch target {
+	case "MASQUERADE":
+		if ports, ok := opt["--to-ports"]; ok {
+			return "masquerade to :" + ports
+		}
+		return "masquerade"
+	case "SNAT":
+		return "snat " + ip + " to " + opt["--to-source"]
+	case "DNAT":
+		return "dnat " + ip + " to " + opt["--to-destination"]
+	}
+	return "redirect to :" + opt["--to-ports"]
+}
@@ -0,0 +1,202 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// Node is one config of a network, Name tells the user which.
+type Node struct {
+	Name      string
+	Interface Interface
+	Peers     []Peer
+}
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Node     string   `json:"node"`
+	Message  string   `json:"message"`
+}
+
+// CheckNetwork checks that the configs of a network fit each other: the
+// keys of every pair of peers match, preshared keys are the same on both
+// sides, no key is used twice, the AllowedIPs of the peers of an interface
+// do not overlap, the addresses are unique and inside the subnets, and
+// endpoints use the ListenPort of the peer they point at.
+//
+// Without subnets they are taken from the addresses of the first node,
+// usually the hub.
+func CheckNetwork(nodes []Node, subnets []netip.Prefix) []Finding {
+	c := &checker{nodes: nodes, publicKeys: make([]PublicKey, len(nodes))}
+	for i := range nodes {
+		// an invalid private key is reported by the parser, its public key
+		// stays zero and matches no peer
+		c.publicKeys[i], _ = GenPublicKey(nodes[i].Interface.PrivateKey)
+	}
+	if len(subnets) == 0 && len(nodes) > 0 {
+		for _, addr := range nodes[0].Interface.Addresses {
+			subnets = append(subnets, addr.Masked())
+		}
+	}
+	c.keys()
+	c.addresses(subnets)
+	for i := range nodes {
+		c.allowedIPs(i)
+		c.pairs(i)
+	}
+	return c.findings
+}
+
+type checker struct {
+	nodes      []Node
+	publicKeys []PublicKey
+	findings   []Finding
+}
+
+func (c *checker) add(severity Severity, node int, format string, a ...any) {
+	c.findings = append(c.findings, Finding{Severity: severity, Node: c.nodes[node].Name, Message: fmt.Sprintf(format, a...)})
+}
+
+// node returns the node with the public key, -1 if none has it.
+func (c *checker) node(key PublicKey) int {
+	return slices.Index(c.publicKeys, key)
+}
+
+func (c *checker) keys() {
+	for i, pub := range c.publicKeys {
+		if j := slices.Index(c.publicKeys, pub); j < i && pub.IsValid() {
+			c.add(SeverityError, i, "uses the private key of %s", c.nodes[j].Name)
+		}
+		seen := make(map[PublicKey]bool)
+		for _, peer := range c.nodes[i].Peers {
+			if peer.PublicKey == pub {
+				c.add(SeverityError, i, "lists its own public key %s as a peer", pub)
+			}
+			if seen[peer.PublicKey] {
+				c.add(SeverityError, i, "lists peer %s twice", peer.PublicKey)
+			}
+			seen[peer.PublicKey] = true
+		}
+	}
+}
+
+func (c *checker) addresses(subnets []netip.Prefix) {
+	owner := make(map[netip.Addr]int)
+	for i, node := range c.nodes {
+		for _, addr := range node.Interface.Addresses {
+			if j, ok := owner[addr.Addr()]; ok && j != i {
+				c.add(SeverityError, i, "address %s is used by %s too", addr.Addr(), c.nodes[j].Name)
+			}
+			owner[addr.Addr()] = i
+			var family []netip.Prefix
+			for _, subnet := range subnets {
+				if subnet.Addr().Is4() == addr.Addr().Is4() {
+					family = append(family, subnet)
+				}
+			}
+			if len(family) == 0 {
+				continue
+			}
+			inside := slices.IndexFunc(family, func(subnet netip.Prefix) bool { return subnet.Contains(addr.Addr()) })
+			switch {
+			case inside < 0:
+				c.add(SeverityError, i, "address %s is outside of %v", addr, family)
+			case addr.Bits() != family[inside].Bits():
+				c.add(SeverityWarning, i, "address %s has another prefix length than the subnet %s", addr, family[inside])
+			case addr.Addr().Is4() && family[inside].Bits() < 31 &&
+				(addr.Addr() == family[inside].Addr() || !family[inside].Contains(addr.Addr().Next())):
+				c.add(SeverityError, i, "address %s is the network or broadcast address of %s", addr.Addr(), family[inside])
+			}
+		}
+	}
+}
+
+// allowedIPs reports peers of one interface with overlapping AllowedIPs,
+// wg routes the overlap to one of them only.
+func (c *checker) allowedIPs(i int) {
+	peers := c.nodes[i].Peers
+	for a := range peers {
+		for b := a + 1; b < len(peers); b++ {
+			for _, x := range peers[a].AllowedIPs {
+				for _, y := range peers[b].AllowedIPs {
+					if x.Overlaps(y) {
+						c.add(SeverityError, i, "AllowedIPs %s of peer %s overlaps %s of peer %s",
+							x, c.peerName(peers[a].PublicKey), y, c.peerName(peers[b].PublicKey))
+					}
+				}
+			}
+		}
+	}
+}
+
+// peerName names a peer after its node, or after its key when it is not
+// one of the checked configs.
+func (c *checker) peerName(key PublicKey) string {
+	if j := c.node(key); j >= 0 {
+		return c.nodes[j].Name
+	}
+	return key.String()
+}
+
+// pairs checks the peers of node i against the nodes they stand for.
+func (c *checker) pairs(i int) {
+	node := c.nodes[i]
+	for _, peer := range node.Peers {
+		j := c.node(peer.PublicKey)
+		if j < 0 {
+			c.misplacedKey(i, peer)
+			continue
+		}
+		if j == i {
+			continue
+		}
+		other := c.nodes[j]
+		back := slices.IndexFunc(other.Peers, func(p Peer) bool { return p.PublicKey == c.publicKeys[i] })
+		if back < 0 {
+			c.add(SeverityError, i, "has %s as a peer, but %s does not list %s", other.Name, other.Name, node.Name)
+		} else if other.Peers[back].PresharedKey != peer.PresharedKey && i < j {
+			// reported once for both sides
+			c.add(SeverityError, i, "the PresharedKey for %s does not match the one %s has for it", other.Name, other.Name)
+		}
+		for _, addr := range other.Interface.Addresses {
+			if !slices.ContainsFunc(peer.AllowedIPs, func(p netip.Prefix) bool { return p.Contains(addr.Addr()) }) {
+				c.add(SeverityWarning, i, "AllowedIPs of %s do not cover its address %s", other.Name, addr.Addr())
+			}
+		}
+		if peer.Endpoint.IsValid() {
+			switch port := other.Interface.ListenPort; {
+			case port == 0:
+				c.add(SeverityError, i, "endpoint %s points at %s, which has no ListenPort", peer.Endpoint, other.Name)
+			case port != peer.Endpoint.Port:
+				c.add(SeverityError, i, "endpoint %s of %s does not use its ListenPort %d", peer.Endpoint, other.Name, port)
+			}
+		}
+	}
+}
+
+// misplacedKey reports a peer whose key belongs to no node although its
+// AllowedIPs cover the address of one, the usual trace of a key pasted from
+// the wrong config.
+func (c *checker) misplacedKey(i int, peer Peer) {
+	for j, other := range c.nodes {
+		if j == i {
+			continue
+		}
+		for _, addr := range other.Interface.Addresses {
+			if slices.ContainsFunc(peer.AllowedIPs, func(p netip.Prefix) bool { return p.Bits() == p.Addr().BitLen() && p.Addr() == addr.Addr() }) {
+				c.add(SeverityError, i, "peer %s routes the address %s of %s, but the private key of %s gives %s",
+					peer.PublicKey, addr.Addr(), other.Name, other.Name, c.publicKeys[j])
+				return
+			}
+		}
+	}
+	c.add(SeverityWarning, i, "peer %s is none of the checked configs", peer.PublicKey)
+}
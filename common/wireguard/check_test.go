@@ -0,0 +1,169 @@
+package wireguard
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testNetwork returns a hub at 10.8.0.1 with two clients that fit each
+// other.
+func testNetwork() []Node {
+	psk := GenPresharedKey()
+	hubPriv, hubPub := GenKeyPair()
+	aPriv, aPub := GenKeyPair()
+	bPriv, bPub := GenKeyPair()
+	hubPeer := Peer{
+		PublicKey:    hubPub,
+		AllowedIPs:   []netip.Prefix{netip.MustParsePrefix("10.8.0.0/24")},
+		Endpoint:     Endpoint{Host: "vpn.example.com", Port: 51820},
+		PresharedKey: psk,
+	}
+	return []Node{
+		{
+			Name:      "hub",
+			Interface: Interface{PrivateKey: hubPriv, ListenPort: 51820, Addresses: []netip.Prefix{netip.MustParsePrefix("10.8.0.1/24")}},
+			Peers: []Peer{
+				{PublicKey: aPub, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.8.0.2/32")}, PresharedKey: psk},
+				{PublicKey: bPub, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.8.0.3/32")}},
+			},
+		},
+		{
+			Name:      "a",
+			Interface: Interface{PrivateKey: aPriv, Addresses: []netip.Prefix{netip.MustParsePrefix("10.8.0.2/24")}},
+			Peers:     []Peer{hubPeer},
+		},
+		{
+			Name:      "b",
+			Interface: Interface{PrivateKey: bPriv, Addresses: []netip.Prefix{netip.MustParsePrefix("10.8.0.3/24")}},
+			Peers:     []Peer{{PublicKey: hubPub, AllowedIPs: hubPeer.AllowedIPs, Endpoint: hubPeer.Endpoint}},
+		},
+	}
+}
+
+func TestCheckNetwork(t *testing.T) {
+	checkFindings(t, CheckNetwork(testNetwork(), nil), nil)
+
+	for _, tt := range []struct {
+		name   string
+		change func(nodes []Node)
+		want   []string
+	}{
+		{
+			name:   "shared private key",
+			change: func(nodes []Node) { nodes[2].Interface.PrivateKey = nodes[1].Interface.PrivateKey },
+			want: []string{
+				"b: error: uses the private key of a",
+				"hub: error: peer <b> routes the address 10.8.0.3 of b, but the private key of b gives <a>",
+			},
+		},
+		{
+			name:   "missing peer",
+			change: func(nodes []Node) { nodes[0].Peers = nodes[0].Peers[:1] },
+			want:   []string{"b: error: has hub as a peer, but hub does not list b"},
+		},
+		{
+			name:   "preshared key",
+			change: func(nodes []Node) { nodes[2].Peers[0].PresharedKey = GenPresharedKey() },
+			want:   []string{"hub: error: the PresharedKey for b does not match the one b has for it"},
+		},
+		{
+			name: "overlapping AllowedIPs",
+			change: func(nodes []Node) {
+				nodes[0].Peers[1].AllowedIPs = append(nodes[0].Peers[1].AllowedIPs, netip.MustParsePrefix("10.8.0.0/30"))
+			},
+			want: []string{"hub: error: AllowedIPs 10.8.0.2/32 of peer a overlaps 10.8.0.0/30 of peer b"},
+		},
+		{
+			name:   "duplicate address",
+			change: func(nodes []Node) { nodes[2].Interface.Addresses[0] = netip.MustParsePrefix("10.8.0.2/24") },
+			want: []string{
+				"b: error: address 10.8.0.2 is used by a too",
+				"hub: warning: AllowedIPs of b do not cover its address 10.8.0.2",
+			},
+		},
+		{
+			name:   "outside of the subnet",
+			change: func(nodes []Node) { nodes[1].Interface.Addresses[0] = netip.MustParsePrefix("10.9.0.2/24") },
+			want: []string{
+				"a: error: address 10.9.0.2/24 is outside of [10.8.0.0/24]",
+				"hub: warning: AllowedIPs of a do not cover its address 10.9.0.2",
+			},
+		},
+		{
+			name:   "broadcast address",
+			change: func(nodes []Node) { nodes[1].Interface.Addresses[0] = netip.MustParsePrefix("10.8.0.255/24") },
+			want: []string{
+				"a: error: address 10.8.0.255 is the network or broadcast address of 10.8.0.0/24",
+				"hub: warning: AllowedIPs of a do not cover its address 10.8.0.255",
+			},
+		},
+		{
+			name:   "endpoint port",
+			change: func(nodes []Node) { nodes[1].Peers[0].Endpoint.Port = 51821 },
+			want:   []string{"a: error: endpoint vpn.example.com:51821 of hub does not use its ListenPort 51820"},
+		},
+		{
+			name: "own key",
+			change: func(nodes []Node) {
+				nodes[1].Peers = append(nodes[1].Peers, Peer{PublicKey: nodes[0].Peers[0].PublicKey, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.8.1.0/24")}})
+			},
+			want: []string{"a: error: lists its own public key <a> as a peer"},
+		},
+		{
+			name: "unknown peer",
+			change: func(nodes []Node) {
+				_, pub := GenKeyPair()
+				nodes[0].Peers[1].PublicKey = pub
+			},
+			want: []string{
+				"hub: error: peer <listed> routes the address 10.8.0.3 of b, but the private key of b gives <b>",
+				"b: error: has hub as a peer, but hub does not list b",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := testNetwork()
+			// <a> and <b> are the public keys of the clients, <listed> is
+			// the one the hub lists for b after the change
+			a, _ := GenPublicKey(nodes[1].Interface.PrivateKey)
+			b, _ := GenPublicKey(nodes[2].Interface.PrivateKey)
+			tt.change(nodes)
+			keys := strings.NewReplacer("<a>", a.String(), "<b>", b.String(), "<listed>", nodes[0].Peers[len(nodes[0].Peers)-1].PublicKey.String())
+			var want []string
+			for _, w := range tt.want {
+				want = append(want, keys.Replace(w))
+			}
+			checkFindings(t, CheckNetwork(nodes, nil), want)
+		})
+	}
+}
+
+func TestCheckNetworkSubnets(t *testing.T) {
+	nodes := testNetwork()
+	nodes[1].Interface.Addresses = append(nodes[1].Interface.Addresses, netip.MustParsePrefix("fd00:8::2/64"))
+	findings := CheckNetwork(nodes, []netip.Prefix{netip.MustParsePrefix("10.8.0.0/16"), netip.MustParsePrefix("fd00:9::/64")})
+	checkFindings(t, findings, []string{
+		"a: error: address fd00:8::2/64 is outside of [fd00:9::/64]",
+		"a: warning: address 10.8.0.2/24 has another prefix length than the subnet 10.8.0.0/16",
+		"b: warning: address 10.8.0.3/24 has another prefix length than the subnet 10.8.0.0/16",
+		"hub: warning: AllowedIPs of a do not cover its address fd00:8::2",
+		"hub: warning: address 10.8.0.1/24 has another prefix length than the subnet 10.8.0.0/16",
+	})
+}
+
+// checkFindings compares the findings as "node: severity: message" in any
+// order.
+func checkFindings(t *testing.T, findings []Finding, want []string) {
+	t.Helper()
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.Node+": "+string(f.Severity)+": "+f.Message)
+	}
+	slices.Sort(got)
+	want = slices.Sorted(slices.Values(want))
+	if !slices.Equal(got, want) {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -12,14 +12,19 @@ import (
 )
 
 type Interface struct {
-	PrivateKey PrivateKey   `json:"private_key,omitzero"`
-	Addresses  []netip.Addr `json:"addresses,omitempty"`
-	ListenPort uint16       `json:"listen_port,omitempty"`
+	PrivateKey PrivateKey `json:"private_key,omitzero"`
+	// Addresses keep the prefix length of the tunnel network, a bare address
+	// in a config is a single-address prefix.
+	Addresses  []netip.Prefix `json:"addresses,omitempty"`
+	ListenPort uint16         `json:"listen_port,omitempty"`
+	FwMark     uint32         `json:"fwmark,omitempty"`
 
 	// Optional
-	DNS   []netip.Addr `json:"dns,omitempty"`
-	MTU   int          `json:"mtu,omitempty"`
-	Table int          `json:"table,omitempty"`
+	DNS []netip.Addr `json:"dns,omitempty"`
+	// DNSSearch are the search domains given among the DNS servers.
+	DNSSearch []string `json:"dns_search,omitempty"`
+	MTU       int      `json:"mtu,omitempty"`
+	Table     Table    `json:"table,omitempty"`
 
 	// Hook
 	PreUp    []string `json:"pre_up,omitempty"`
@@ -39,22 +44,60 @@ func (c *Interface) MarshalJSON() (data []byte, err error) {
 }
 
 func (c *Interface) UnmarshalJSON(data []byte) error {
+	type jsonAble Interface
 	dupI := *c
+	// addresses are decoded by hand to accept bare addresses too
+	aux := struct {
+		*jsonAble
+		Addresses []string `json:"addresses,omitempty"`
+	}{jsonAble: (*jsonAble)(&dupI)}
 	de := json.NewDecoder(bytes.NewReader(data))
 	de.DisallowUnknownFields()
-	e := de.Decode(&dupI)
+	e := de.Decode(&aux)
 	if e != nil {
 		return e
 	}
+	dupI.Addresses = nil
+	for _, s := range aux.Addresses {
+		addr, err := ParseAddress(s)
+		if err != nil {
+			return err
+		}
+		dupI.Addresses = append(dupI.Addresses, addr)
+	}
 	*c = dupI
 	return nil
 }
 
+// ParseAddress parses an interface address like 10.0.0.2/24, a bare address
+// becomes a prefix of its full length.
+func ParseAddress(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, E.New("invalid address ", strconv.Quote(s))
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
 func (c *Interface) MarshalText() (text []byte, err error) {
-	var buf bytes.Buffer
+	keyValues, err := c.keyValues()
+	if err != nil {
+		return nil, err
+	}
+	return marshalKeyValues(keyValues), nil
+}
+
+// keyValues lists the keys of the section in the order they are written, a
+// key given several times like PostUp appears once per value.
+func (c *Interface) keyValues() ([]keyValue, error) {
+	var kvs []keyValue
+	add := func(key, value string) { kvs = append(kvs, keyValue{key, value}) }
 
 	if c.PrivateKey.IsValid() {
-		buf.WriteString("PrivateKey = " + c.PrivateKey.String() + "\n")
+		add("PrivateKey", c.PrivateKey.String())
 	} else {
 		return nil, E.New("PrivateKey is required")
 	}
@@ -64,47 +107,52 @@ func (c *Interface) MarshalText() (text []byte, err error) {
 		for _, addr := range c.Addresses {
 			addresses = append(addresses, addr.String())
 		}
-		buf.WriteString("Address = " + strings.Join(addresses, ",") + "\n")
+		add("Address", strings.Join(addresses, ","))
 	}
 
 	if c.ListenPort > 0 {
-		buf.WriteString("ListenPort = " + strconv.FormatUint(uint64(c.ListenPort), 10) + "\n")
+		add("ListenPort", strconv.FormatUint(uint64(c.ListenPort), 10))
+	}
+
+	if c.FwMark != 0 {
+		add("FwMark", FormatFwMark(c.FwMark))
 	}
 
-	if len(c.DNS) > 0 {
-		dnsAddresses := make([]string, 0, len(c.DNS))
+	if len(c.DNS) > 0 || len(c.DNSSearch) > 0 {
+		dnsAddresses := make([]string, 0, len(c.DNS)+len(c.DNSSearch))
 		for _, dns := range c.DNS {
 			dnsAddresses = append(dnsAddresses, dns.String())
 		}
-		buf.WriteString("DNS = " + strings.Join(dnsAddresses, ", ") + "\n")
+		dnsAddresses = append(dnsAddresses, c.DNSSearch...)
+		add("DNS", strings.Join(dnsAddresses, ", "))
 	}
 
 	if c.MTU > 0 {
-		buf.WriteString("MTU = " + strconv.Itoa(c.MTU) + "\n")
+		add("MTU", strconv.Itoa(c.MTU))
 	}
 
-	if c.Table != 0 {
-		buf.WriteString("Table = " + strconv.Itoa(c.Table) + "\n")
+	if c.Table != "" {
+		add("Table", string(c.Table))
 	}
 
 	for _, hook := range c.PreUp {
-		buf.WriteString("PreUp = " + hook + "\n")
+		add("PreUp", hook)
 	}
 	for _, hook := range c.PostUp {
-		buf.WriteString("PostUp = " + hook + "\n")
+		add("PostUp", hook)
 	}
 	for _, hook := range c.PreDown {
-		buf.WriteString("PreDown = " + hook + "\n")
+		add("PreDown", hook)
 	}
 	for _, hook := range c.PostDown {
-		buf.WriteString("PostDown = " + hook + "\n")
+		add("PostDown", hook)
 	}
 
 	if c.SaveConfig {
-		buf.WriteString("SaveConfig = true\n")
+		add("SaveConfig", "true")
 	}
 
-	return buf.Bytes(), nil
+	return kvs, nil
 }
 
 func (c *Interface) UnmarshalText(text []byte) error {
@@ -126,7 +174,7 @@ func (c *Interface) UnmarshalText(text []byte) error {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
-		if e := c.parseInterfaceKeyValue(key, value); e != nil {
+		if e := newInterface.parseInterfaceKeyValue(key, value); e != nil {
 			return e
 		}
 	}
@@ -153,7 +201,7 @@ func (c *Interface) parseInterfaceKeyValue(key, value string) error {
 		addresses := strings.Split(value, ",")
 		for _, addrStr := range addresses {
 			addrStr = strings.TrimSpace(addrStr)
-			addr, parseErr := netip.ParseAddr(addrStr)
+			addr, parseErr := ParseAddress(addrStr)
 			if parseErr != nil {
 				return parseErr
 			}
@@ -165,15 +213,25 @@ func (c *Interface) parseInterfaceKeyValue(key, value string) error {
 			return parseErr
 		}
 		c.ListenPort = uint16(port)
+	case "FwMark":
+		mark, err := ParseFwMark(value)
+		if err != nil {
+			return err
+		}
+		c.FwMark = mark
 	case "DNS":
+		// like wg-quick, whatever is not an address is a search domain
 		dnsAddresses := strings.Split(value, ",")
 		for _, dnsStr := range dnsAddresses {
 			dnsStr = strings.TrimSpace(dnsStr)
-			dns, parseErr := netip.ParseAddr(dnsStr)
-			if parseErr != nil {
-				return parseErr
+			if dnsStr == "" {
+				return E.New("empty DNS entry")
+			}
+			if dns, parseErr := netip.ParseAddr(dnsStr); parseErr == nil {
+				c.DNS = append(c.DNS, dns)
+			} else {
+				c.DNSSearch = append(c.DNSSearch, dnsStr)
 			}
-			c.DNS = append(c.DNS, dns)
 		}
 	case "MTU":
 		mtu, parseErr := strconv.Atoi(value)
@@ -182,11 +240,11 @@ func (c *Interface) parseInterfaceKeyValue(key, value string) error {
 		}
 		c.MTU = mtu
 	case "Table":
-		table, err := strconv.ParseInt(value, 10, 64)
+		table, err := ParseTable(value)
 		if err != nil {
 			return err
 		}
-		c.Table = int(table)
+		c.Table = table
 	case "PreUp":
 		c.PreUp = append(c.PreUp, value)
 	case "PostUp":
@@ -196,8 +254,13 @@ func (c *Interface) parseInterfaceKeyValue(key, value string) error {
 	case "PostDown":
 		c.PostDown = append(c.PostDown, value)
 	case "SaveConfig":
-		if strings.ToLower(value) == "true" {
+		switch value {
+		case "true":
 			c.SaveConfig = true
+		case "false":
+			c.SaveConfig = false
+		default:
+			return E.New("invalid SaveConfig ", strconv.Quote(value), ", expected true or false")
 		}
 	}
 	return nil
@@ -207,7 +270,7 @@ type Peer struct {
 	PublicKey           PublicKey      `json:"public_key,omitzero"`
 	PresharedKey        PresharedKey   `json:"preshared_key,omitzero"`
 	AllowedIPs          []netip.Prefix `json:"allowed_ips,omitempty"`
-	Endpoint            string         `json:"endpoint,omitempty"`
+	Endpoint            Endpoint       `json:"endpoint,omitzero"`
 	PersistentKeepalive int            `json:"persistent_keepalive,omitempty"`
 
 	ready bool
@@ -219,10 +282,11 @@ func (p *Peer) MarshalJSON() (data []byte, err error) {
 }
 
 func (p *Peer) UnmarshalJSON(data []byte) error {
+	type jsonAble Peer
 	dupP := *p
 	decoder := json.NewDecoder(bytes.NewReader(data))
 	decoder.DisallowUnknownFields()
-	err := decoder.Decode(&dupP)
+	err := decoder.Decode((*jsonAble)(&dupP))
 	if err != nil {
 		return err
 	}
@@ -231,16 +295,25 @@ func (p *Peer) UnmarshalJSON(data []byte) error {
 }
 
 func (p *Peer) MarshalText() (text []byte, err error) {
-	var buf bytes.Buffer
+	keyValues, err := p.keyValues()
+	if err != nil {
+		return nil, err
+	}
+	return marshalKeyValues(keyValues), nil
+}
+
+func (p *Peer) keyValues() ([]keyValue, error) {
+	var kvs []keyValue
+	add := func(key, value string) { kvs = append(kvs, keyValue{key, value}) }
 
 	if p.PublicKey.IsValid() {
-		buf.WriteString("PublicKey = " + p.PublicKey.String() + "\n")
+		add("PublicKey", p.PublicKey.String())
 	} else {
 		return nil, E.New("PublicKey is required")
 	}
 
 	if p.PresharedKey.IsValid() {
-		buf.WriteString("PresharedKey = " + p.PresharedKey.String() + "\n")
+		add("PresharedKey", p.PresharedKey.String())
 	}
 
 	if len(p.AllowedIPs) > 0 {
@@ -248,20 +321,20 @@ func (p *Peer) MarshalText() (text []byte, err error) {
 		for _, prefix := range p.AllowedIPs {
 			allowedIPs = append(allowedIPs, prefix.String())
 		}
-		buf.WriteString("AllowedIPs = " + strings.Join(allowedIPs, ", ") + "\n")
+		add("AllowedIPs", strings.Join(allowedIPs, ", "))
 	} else {
 		return nil, E.New("AllowedIPs is required")
 	}
 
-	if p.Endpoint != "" {
-		buf.WriteString("Endpoint = " + p.Endpoint + "\n")
+	if p.Endpoint.IsValid() {
+		add("Endpoint", p.Endpoint.String())
 	}
 
 	if p.PersistentKeepalive != 0 {
-		buf.WriteString("PersistentKeepalive = " + strconv.FormatInt(int64(p.PersistentKeepalive), 10) + "\n")
+		add("PersistentKeepalive", strconv.FormatInt(int64(p.PersistentKeepalive), 10))
 	}
 
-	return buf.Bytes(), nil
+	return kvs, nil
 }
 
 func (p *Peer) UnmarshalText(text []byte) error {
@@ -284,7 +357,7 @@ func (p *Peer) UnmarshalText(text []byte) error {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
-		if e := p.parsePeerKeyValue(key, value); e != nil {
+		if e := newPeer.parsePeerKeyValue(key, value); e != nil {
 			return e
 		}
 	}
@@ -323,9 +396,17 @@ func (p *Peer) parsePeerKeyValue(key, value string) error {
 			p.AllowedIPs = append(p.AllowedIPs, prefix)
 		}
 	case "Endpoint":
-		p.Endpoint = value
+		endpoint, err := ParseEndpoint(value)
+		if err != nil {
+			return err
+		}
+		p.Endpoint = endpoint
 	case "PersistentKeepalive":
-		keepalive, err := strconv.ParseInt(value, 10, 64)
+		if value == "off" {
+			p.PersistentKeepalive = 0
+			return nil
+		}
+		keepalive, err := strconv.ParseUint(value, 10, 16)
 		if err != nil {
 			return err
 		}
@@ -334,95 +415,27 @@ func (p *Peer) parsePeerKeyValue(key, value string) error {
 	return nil
 }
 
-func ParseWireguardConf(in io.Reader) (iif Interface, peers []Peer, err error) {
-	scanner := bufio.NewScanner(in)
-
-	var currentSection string
-	var currentPeerText bytes.Buffer
-	var currentInterfaceText bytes.Buffer
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		tl := strings.TrimSpace(line)
-
-		if tl == "" || strings.HasPrefix(tl, "#") || strings.HasPrefix(tl, ";") {
-			continue
-		}
-		if strings.HasPrefix(tl, "[") && strings.HasSuffix(tl, "]") {
-			sectionName := strings.TrimSuffix(strings.TrimPrefix(tl, "["), "]")
-
-			if currentSection == "Peer" && currentPeerText.Len() > 0 {
-				var peer Peer
-				if err := peer.UnmarshalText(currentPeerText.Bytes()); err == nil && peer.ready {
-					peers = append(peers, peer)
-				}
-				currentPeerText.Reset()
-			}
-
-			if sectionName == "Interface" {
-				if currentSection == "Interface" {
-					return Interface{}, nil, E.New("multiple [Interface] sections found")
-				}
-				currentSection = "Interface"
-			} else if sectionName == "Peer" {
-				currentSection = "Peer"
-			} else {
-				return Interface{}, nil, E.New("unknown section: ", sectionName)
-			}
-			continue
-		}
-
-		if currentSection == "Interface" {
-			currentInterfaceText.WriteString(line + "\n")
-		} else if currentSection == "Peer" {
-			currentPeerText.WriteString(line + "\n")
-		}
-	}
-
-	if err = scanner.Err(); err != nil {
-		return Interface{}, nil, err
-	}
-
-	if currentSection == "Peer" && currentPeerText.Len() > 0 {
-		var peer Peer
-		if err := peer.UnmarshalText(currentPeerText.Bytes()); err == nil && peer.ready {
-			peers = append(peers, peer)
-		}
-	}
-
-	if currentInterfaceText.Len() > 0 {
-		if err := iif.UnmarshalText(currentInterfaceText.Bytes()); err != nil {
-			return Interface{}, nil, err
-		}
-	} else {
-		return Interface{}, nil, E.New("missing Interface section")
-	}
-
-	return iif, peers, nil
+type keyValue struct {
+	key, value string
 }
 
-func MarshalWireguardConf(iface Interface, peers []Peer) ([]byte, error) {
+func marshalKeyValues(kvs []keyValue) []byte {
 	var buf bytes.Buffer
-
-	// [Interface]
-	buf.WriteString("[Interface]\n")
-	ifaceText, err := iface.MarshalText()
-	if err != nil {
-		return nil, err
+	for _, kv := range kvs {
+		buf.WriteString(kv.key + " = " + kv.value + "\n")
 	}
-	buf.Write(ifaceText)
-	buf.WriteString("\n")
+	return buf.Bytes()
+}
 
-	// [Peer]
-	for _, peer := range peers {
-		buf.WriteString("[Peer]\n")
-		peerText, err := peer.MarshalText()
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(peerText)
-		buf.WriteString("\n")
-	}
+// ParseWireguardConf parses a config leniently and drops the warnings, see
+// ParseWireguardConfWith for them and the strict mode.
+func ParseWireguardConf(in io.Reader) (iif Interface, peers []Peer, err error) {
+	iif, peers, _, err = ParseWireguardConfWith(in, ParseOptions{})
+	return iif, peers, err
+}
 
-	return buf.Bytes(), nil
+// MarshalWireguardConf writes the config for wg-quick, see MarshalSetconf
+// for wg setconf.
+func MarshalWireguardConf(iface Interface, peers []Peer) ([]byte, error) {
+	return marshalConf(iface, peers, SyntaxQuick)
 }
@@ -0,0 +1,177 @@
+package wireguard
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func readConf(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// wg0Canonical is testdata/wg0.conf as MarshalWireguardConf writes it.
+const wg0Canonical = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.8.0.1/24,fd00:8::1/64,10.9.0.1/24
+ListenPort = 51820
+FwMark = 0x1234
+DNS = 10.8.0.53, fd00:8::53, lan
+MTU = 1420
+Table = off
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PostDown = iptables -D FORWARD -i %i -j ACCEPT
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.8.0.2/32, fd00:8::2/128, 192.168.2.0/24
+Endpoint = [2001:db8::2]:51820
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.8.0.3/32
+Endpoint = vpn.example.com:51821
+`
+
+func TestConfRoundTrip(t *testing.T) {
+	iif, peers, err := ParseWireguardConf(bytes.NewReader(readConf(t, "wg0.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(iif.DNS, []netip.Addr{netip.MustParseAddr("10.8.0.53"), netip.MustParseAddr("fd00:8::53")}) ||
+		!slices.Equal(iif.DNSSearch, []string{"lan"}) {
+		t.Errorf("DNS = %v, search %v", iif.DNS, iif.DNSSearch)
+	}
+	if len(iif.Addresses) != 3 || iif.FwMark != 0x1234 || iif.Table != TableOff || iif.SaveConfig {
+		t.Errorf("interface = %+v", iif)
+	}
+	if len(peers) != 2 || len(peers[0].AllowedIPs) != 3 || peers[1].Endpoint.Host != "vpn.example.com" {
+		t.Errorf("peers = %+v", peers)
+	}
+
+	out, err := MarshalWireguardConf(iif, peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != wg0Canonical {
+		t.Errorf("marshalled:\n%s\nwant:\n%s", out, wg0Canonical)
+	}
+	// the canonical form round trips exactly
+	iif, peers, err = ParseWireguardConf(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := MarshalWireguardConf(iif, peers); !bytes.Equal(again, out) {
+		t.Errorf("round trip:\n%s\nwant:\n%s", again, out)
+	}
+}
+
+func TestConfKeys(t *testing.T) {
+	for _, tt := range []struct {
+		line  string
+		check func(iif Interface) bool
+	}{
+		{"FwMark = 4660", func(iif Interface) bool { return iif.FwMark == 0x1234 }},
+		{"FwMark = off", func(iif Interface) bool { return iif.FwMark == 0 }},
+		{"Table = auto", func(iif Interface) bool { return iif.Table == TableAuto }},
+		{"Table = 1234", func(iif Interface) bool { n, ok := iif.Table.Number(); return ok && n == 1234 }},
+		{"DNS = corp.example, 1.1.1.1", func(iif Interface) bool {
+			return slices.Equal(iif.DNSSearch, []string{"corp.example"}) && len(iif.DNS) == 1
+		}},
+		{"SaveConfig = true", func(iif Interface) bool { return iif.SaveConfig }},
+	} {
+		conf := "[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\n" + tt.line + "\n"
+		iif, _, err := ParseWireguardConf(bytes.NewReader([]byte(conf)))
+		if err != nil || !tt.check(iif) {
+			t.Errorf("%s: %+v, %v", tt.line, iif, err)
+		}
+	}
+	for _, line := range []string{"FwMark = mark", "Table = main"} {
+		conf := "[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\n" + line + "\n"
+		if _, _, err := ParseWireguardConf(bytes.NewReader([]byte(conf))); err == nil {
+			t.Errorf("%s parsed", line)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	for s, want := range map[string]string{
+		"10.8.0.1/24":  "10.8.0.1/24",
+		"10.8.0.1":     "10.8.0.1/32",
+		"fd00:8::1":    "fd00:8::1/128",
+		"fd00:8::1/64": "fd00:8::1/64",
+	} {
+		got, err := ParseAddress(s)
+		if err != nil || got.String() != want {
+			t.Errorf("ParseAddress(%s) = %s, %v, want %s", s, got, err, want)
+		}
+	}
+	if _, err := ParseAddress("10.8.0.1/33"); err == nil || err.Error() != `invalid address "10.8.0.1/33"` {
+		t.Errorf("ParseAddress error = %v", err)
+	}
+}
+
+func TestInterfaceJSON(t *testing.T) {
+	priv := GenPrivateKey()
+	var iif Interface
+	data := `{"private_key":"` + priv.String() + `","addresses":["10.8.0.1/24","fd00:8::1"],"listen_port":51820}`
+	if err := json.Unmarshal([]byte(data), &iif); err != nil {
+		t.Fatal(err)
+	}
+	if iif.PrivateKey != priv || iif.ListenPort != 51820 ||
+		!slices.Equal(iif.Addresses, []netip.Prefix{netip.MustParsePrefix("10.8.0.1/24"), netip.MustParsePrefix("fd00:8::1/128")}) {
+		t.Errorf("decoded %+v", iif)
+	}
+	out, err := json.Marshal(&iif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"private_key":"` + priv.String() + `","addresses":["10.8.0.1/24","fd00:8::1/128"],"listen_port":51820}`; string(out) != want {
+		t.Errorf("encoded %s\nwant %s", out, want)
+	}
+	if err = json.Unmarshal([]byte(`{"listen_port":1,"mtu_size":1420}`), &iif); err == nil {
+		t.Error("unknown field decoded")
+	}
+}
+
+func TestPeerJSON(t *testing.T) {
+	_, pub := GenKeyPair()
+	var peer Peer
+	data := `{"public_key":"` + pub.String() + `","allowed_ips":["10.8.0.2/32"],"persistent_keepalive":25}`
+	if err := json.Unmarshal([]byte(data), &peer); err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(&peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the zero preshared key is left out
+	if string(out) != data {
+		t.Errorf("encoded %s\nwant %s", out, data)
+	}
+}
+
+func TestKeyIsZero(t *testing.T) {
+	var (
+		pub PublicKey
+		psk PresharedKey
+	)
+	if !pub.IsZero() || !psk.IsZero() {
+		t.Error("zero keys are not zero")
+	}
+	_, pub = GenKeyPair()
+	psk = GenPresharedKey()
+	if pub.IsZero() || psk.IsZero() {
+		t.Error("generated keys are zero")
+	}
+}
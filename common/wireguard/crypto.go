@@ -85,7 +85,7 @@ func (k PublicKey) IsValid() bool {
 	return !zeroKey((*[32]byte)(&k))
 }
 func (k PublicKey) IsZero() bool {
-	return k.IsValid()
+	return !k.IsValid()
 }
 
 type PresharedKey [32]byte
@@ -124,7 +124,7 @@ func (k PresharedKey) IsValid() bool {
 }
 
 func (k PresharedKey) IsZero() bool {
-	return k.IsValid()
+	return !k.IsValid()
 }
 
 func GenPrivateKey() (priv PrivateKey) {
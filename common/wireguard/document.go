@@ -0,0 +1,449 @@
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Document is a wireguard config as it is written. Every line is kept:
+// comments, blank lines, the order of the keys and keys fire does not know,
+// so a document written back differs from its source only where it was
+// edited.
+type Document struct {
+	// Preamble are the lines in front of the first section.
+	Preamble []*Line
+	Sections []*Section
+
+	crlf bool
+	// noEOL is set when the last line of the source has no newline.
+	noEOL bool
+}
+
+// Section is an [Interface] or a [Peer] section. Leading are the comments
+// right above the header, like the "# Name = laptop" annotations of peers,
+// they belong to the section and go with it.
+type Section struct {
+	Name    string
+	Leading []*Line
+	Header  *Line
+	Lines   []*Line
+}
+
+// Line is a line of a document. Key and Value are set for key lines, Raw is
+// the text of the line and changes with the value only.
+type Line struct {
+	// Number is the line in the source, 0 for lines added by an edit.
+	Number int
+	Raw    string
+	Key    string
+	Value  string
+	// comment is the inline comment behind the value, it stays when the
+	// value changes.
+	comment string
+}
+
+var annotationPattern = regexp.MustCompile(`^[#;]\s*([A-Za-z][A-Za-z0-9_-]*)\s*=\s*(.*?)\s*$`)
+
+func (l *Line) IsBlank() bool {
+	return strings.TrimSpace(l.Raw) == ""
+}
+
+func (l *Line) IsComment() bool {
+	t := strings.TrimSpace(l.Raw)
+	return strings.HasPrefix(t, "#") || strings.HasPrefix(t, ";")
+}
+
+func (l *Line) isHeader() bool {
+	t := strings.TrimSpace(l.Raw)
+	return strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]")
+}
+
+func (l *Line) setValue(value string) {
+	l.Value = value
+	l.Raw = l.Key + " = " + value
+	if l.comment != "" {
+		l.Raw += " " + l.comment
+	}
+}
+
+// keyColumn is the column of the first character of the line, the key of a
+// key line.
+func (l *Line) keyColumn() int {
+	return len(l.Raw) - len(strings.TrimLeft(l.Raw, " \t")) + 1
+}
+
+// valueColumn is the column of the value of a key line.
+func (l *Line) valueColumn() int {
+	i := strings.IndexByte(l.Raw, '=') + 1
+	return i + len(l.Raw[i:]) - len(strings.TrimLeft(l.Raw[i:], " \t")) + 1
+}
+
+// parseLine splits a line like wg(8) and wg-quick(8): everything behind a #
+// is a comment.
+func parseLine(number int, raw string) *Line {
+	l := &Line{Number: number, Raw: raw}
+	if l.IsBlank() || l.IsComment() || l.isHeader() {
+		return l
+	}
+	key, value, ok := strings.Cut(raw, "=")
+	if !ok {
+		return l
+	}
+	if i := strings.IndexByte(value, '#'); i >= 0 {
+		value, l.comment = value[:i], strings.TrimSpace(value[i:])
+	}
+	l.Key, l.Value = strings.TrimSpace(key), strings.TrimSpace(value)
+	return l
+}
+
+func ParseDocument(in io.Reader) (*Document, error) {
+	d := &Document{}
+	reader := bufio.NewReader(in)
+	lines := &d.Preamble
+	var section *Section
+	for number := 1; ; number++ {
+		raw, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, E.When("read config", err)
+		}
+		if raw == "" && err == io.EOF {
+			break
+		}
+		if strings.HasSuffix(raw, "\n") {
+			raw = raw[:len(raw)-1]
+			if strings.HasSuffix(raw, "\r") {
+				raw, d.crlf = raw[:len(raw)-1], true
+			}
+		} else {
+			d.noEOL = true
+		}
+
+		line := parseLine(number, raw)
+		if line.isHeader() {
+			t := strings.TrimSpace(raw)
+			section = &Section{Name: strings.TrimSpace(t[1 : len(t)-1]), Header: line}
+			// the comments right above the header belong to the section
+			start := len(*lines)
+			for start > 0 && (*lines)[start-1].IsComment() {
+				start--
+			}
+			section.Leading = slices.Clone((*lines)[start:])
+			*lines = (*lines)[:start]
+			d.Sections = append(d.Sections, section)
+			lines = &section.Lines
+		} else {
+			*lines = append(*lines, line)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return d, nil
+}
+
+func (d *Document) Bytes() []byte {
+	eol := "\n"
+	if d.crlf {
+		eol = "\r\n"
+	}
+	var buf bytes.Buffer
+	write := func(lines []*Line) {
+		for _, l := range lines {
+			buf.WriteString(l.Raw + eol)
+		}
+	}
+	write(d.Preamble)
+	for _, s := range d.Sections {
+		write(s.Leading)
+		write([]*Line{s.Header})
+		write(s.Lines)
+	}
+	if d.noEOL && buf.Len() > 0 {
+		buf.Truncate(buf.Len() - len(eol))
+	}
+	return buf.Bytes()
+}
+
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(d.Bytes())
+	return int64(n), err
+}
+
+// Interface returns the [Interface] section, nil if there is none.
+func (d *Document) Interface() *Section {
+	for _, s := range d.Sections {
+		if s.Name == "Interface" {
+			return s
+		}
+	}
+	return nil
+}
+
+func (d *Document) Peers() []*Section {
+	var peers []*Section
+	for _, s := range d.Sections {
+		if s.Name == "Peer" {
+			peers = append(peers, s)
+		}
+	}
+	return peers
+}
+
+// Peer returns the [Peer] section of the public key, nil if there is none.
+func (d *Document) Peer(key PublicKey) *Section {
+	for _, s := range d.Peers() {
+		var pub PublicKey
+		if value, ok := s.Get("PublicKey"); ok && pub.UnmarshalText([]byte(value)) == nil && pub == key {
+			return s
+		}
+	}
+	return nil
+}
+
+// AddSection appends a section, separated from the one before by a blank
+// line.
+func (d *Document) AddSection(name string) *Section {
+	lines := &d.Preamble
+	if len(d.Sections) > 0 {
+		lines = &d.Sections[len(d.Sections)-1].Lines
+	}
+	if len(*lines) > 0 && !(*lines)[len(*lines)-1].IsBlank() {
+		*lines = append(*lines, &Line{})
+	}
+	s := &Section{Name: name, Header: &Line{Raw: "[" + name + "]"}}
+	d.Sections = append(d.Sections, s)
+	return s
+}
+
+// RemoveSection removes s with its leading comments.
+func (d *Document) RemoveSection(s *Section) {
+	d.Sections = slices.DeleteFunc(d.Sections, func(other *Section) bool { return other == s })
+}
+
+// Get returns the first value of key.
+func (s *Section) Get(key string) (string, bool) {
+	for _, l := range s.Lines {
+		if l.Key == key {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+// Values returns every value of key in order, keys like PostUp or Address
+// may be given several times.
+func (s *Section) Values(key string) []string {
+	var values []string
+	for _, l := range s.Lines {
+		if l.Key == key {
+			values = append(values, l.Value)
+		}
+	}
+	return values
+}
+
+// Set gives key the values. The lines of key are changed in place, surplus
+// lines are removed and missing ones follow the last line of key, or the
+// last key of the section if key is new. Set without values deletes key.
+func (s *Section) Set(key string, values ...string) {
+	last := -1
+	var kept []*Line
+	for _, l := range s.Lines {
+		if l.Key != key {
+			kept = append(kept, l)
+			continue
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if l.Value != values[0] {
+			l.setValue(values[0])
+		}
+		values = values[1:]
+		kept = append(kept, l)
+		last = len(kept) - 1
+	}
+	s.Lines = kept
+	if len(values) == 0 {
+		return
+	}
+	if last < 0 {
+		for i, l := range s.Lines {
+			if l.Key != "" {
+				last = i
+			}
+		}
+	}
+	added := make([]*Line, 0, len(values))
+	for _, value := range values {
+		l := &Line{Key: key}
+		l.setValue(value)
+		added = append(added, l)
+	}
+	s.Lines = slices.Insert(s.Lines, last+1, added...)
+}
+
+func (s *Section) Delete(key string) {
+	s.Set(key)
+}
+
+// Annotation returns the value of a comment like "# Name = laptop" in front
+// of or inside the section.
+func (s *Section) Annotation(key string) (string, bool) {
+	for _, l := range slices.Concat(s.Leading, s.Lines) {
+		if l.IsComment() {
+			if m := annotationPattern.FindStringSubmatch(strings.TrimSpace(l.Raw)); m != nil && m[1] == key {
+				return m[2], true
+			}
+		}
+	}
+	return "", false
+}
+
+// SetAnnotation changes the annotation comment of key or adds it below the
+// header.
+func (s *Section) SetAnnotation(key, value string) {
+	raw := "# " + key + " = " + value
+	for _, l := range slices.Concat(s.Leading, s.Lines) {
+		if l.IsComment() {
+			if m := annotationPattern.FindStringSubmatch(strings.TrimSpace(l.Raw)); m != nil && m[1] == key {
+				if m[2] != value {
+					l.Raw = raw
+				}
+				return
+			}
+		}
+	}
+	s.Lines = slices.Insert(s.Lines, 0, &Line{Raw: raw})
+}
+
+func (s *Section) keyValues() []keyValue {
+	var kvs []keyValue
+	for _, l := range s.Lines {
+		if l.Key != "" {
+			kvs = append(kvs, keyValue{l.Key, l.Value})
+		}
+	}
+	return kvs
+}
+
+func (s *Section) decodeInterface() (Interface, error) {
+	var iif Interface
+	for _, kv := range s.keyValues() {
+		if err := iif.parseInterfaceKeyValue(kv.key, kv.value); err != nil {
+			return Interface{}, err
+		}
+	}
+	if !iif.PrivateKey.IsValid() {
+		return Interface{}, E.New("PrivateKey is not valid")
+	}
+	iif.ready = true
+	return iif, nil
+}
+
+func (s *Section) decodePeer() (Peer, error) {
+	var peer Peer
+	for _, kv := range s.keyValues() {
+		if err := peer.parsePeerKeyValue(kv.key, kv.value); err != nil {
+			return Peer{}, err
+		}
+	}
+	if !peer.PublicKey.IsValid() {
+		return Peer{}, E.New("PublicKey is not valid")
+	}
+	if len(peer.AllowedIPs) == 0 {
+		return Peer{}, E.New("AllowedIPs is required")
+	}
+	peer.ready = true
+	return peer, nil
+}
+
+// Update changes the document to the interface and the peers, touching only
+// the keys whose values changed. Peers are matched by their public key: the
+// sections of peers not in peers are removed, new peers are appended.
+// Comments and keys fire does not know stay as they are.
+func (d *Document) Update(iif Interface, peers []Peer) error {
+	section := d.Interface()
+	if section == nil {
+		section = &Section{Name: "Interface", Header: &Line{Raw: "[Interface]"}}
+		d.Sections = slices.Insert(d.Sections, 0, section)
+		if len(d.Sections) > 1 {
+			section.Lines = append(section.Lines, &Line{})
+		}
+	}
+	var old []keyValue
+	if oldIif, err := section.decodeInterface(); err == nil {
+		old, _ = oldIif.keyValues()
+	}
+	kvs, err := iif.keyValues()
+	if err != nil {
+		return err
+	}
+	section.update(old, kvs)
+
+	wanted := make(map[PublicKey]bool, len(peers))
+	for _, peer := range peers {
+		wanted[peer.PublicKey] = true
+	}
+	existing := make(map[PublicKey]*Section)
+	for _, s := range d.Peers() {
+		var pub PublicKey
+		value, _ := s.Get("PublicKey")
+		if pub.UnmarshalText([]byte(value)) != nil || !wanted[pub] {
+			d.RemoveSection(s)
+			continue
+		}
+		existing[pub] = s
+	}
+	for _, peer := range peers {
+		kvs, err := peer.keyValues()
+		if err != nil {
+			return err
+		}
+		s, ok := existing[peer.PublicKey]
+		if !ok {
+			s = d.AddSection("Peer")
+			existing[peer.PublicKey] = s
+		}
+		var old []keyValue
+		if oldPeer, err := s.decodePeer(); err == nil {
+			old, _ = oldPeer.keyValues()
+		}
+		s.update(old, kvs)
+	}
+	return nil
+}
+
+// update sets the keys of kvs whose values differ from old and deletes the
+// keys of old that are gone. Keys fire parses but writes differently, like
+// an Address given in two lines, are compared by the values they stand for.
+func (s *Section) update(old, kvs []keyValue) {
+	values := func(kvs []keyValue) (keys []string, m map[string][]string) {
+		m = make(map[string][]string)
+		for _, kv := range kvs {
+			if _, ok := m[kv.key]; !ok {
+				keys = append(keys, kv.key)
+			}
+			m[kv.key] = append(m[kv.key], kv.value)
+		}
+		return keys, m
+	}
+	oldKeys, oldValues := values(old)
+	keys, newValues := values(kvs)
+	for _, key := range keys {
+		if oldValue, ok := oldValues[key]; !ok || !slices.Equal(oldValue, newValues[key]) {
+			s.Set(key, newValues[key]...)
+		}
+	}
+	for _, key := range oldKeys {
+		if _, ok := newValues[key]; !ok {
+			s.Delete(key)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package wireguard
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+func TestDocumentRoundTrip(t *testing.T) {
+	for _, data := range [][]byte{
+		readConf(t, "document.conf"),
+		readConf(t, "wg0.conf"),
+		bytes.ReplaceAll(readConf(t, "wg0.conf"), []byte("\n"), []byte("\r\n")),
+	} {
+		d, err := ParseDocument(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := d.Bytes(); !bytes.Equal(out, data) {
+			t.Errorf("round trip:\n%q\nwant:\n%q", out, data)
+		}
+	}
+}
+
+func TestDocumentDecode(t *testing.T) {
+	d, err := ParseDocument(bytes.NewReader(readConf(t, "document.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iif, peers, warnings, err := d.Decode(ParseOptions{File: "wg0.conf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0].Error() != "wg0.conf:7:1: unknown key Jc in [Interface]" {
+		t.Errorf("warnings = %v", warnings)
+	}
+	if iif.ListenPort != 51820 || len(peers) != 2 {
+		t.Errorf("decoded %+v, %d peers", iif, len(peers))
+	}
+	if name, _ := d.Peer(peers[1].PublicKey).Annotation("Name"); name != "phone" {
+		t.Errorf("Name of the second peer = %q", name)
+	}
+	if jc, _ := d.Interface().Get("Jc"); jc != "4" {
+		t.Errorf("Jc = %q", jc)
+	}
+
+	_, _, _, err = d.Decode(ParseOptions{File: "wg0.conf", Mode: Strict})
+	var positionErr *E.PositionError
+	if !E.As(err, &positionErr) || positionErr.Line != 7 {
+		t.Errorf("strict Decode error = %v", err)
+	}
+}
+
+func TestDocumentUpdate(t *testing.T) {
+	d, err := ParseDocument(bytes.NewReader(readConf(t, "document.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iif, peers, _, err := d.Decode(ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added PublicKey
+	if err = added.UnmarshalText([]byte("HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=")); err != nil {
+		t.Fatal(err)
+	}
+	iif.ListenPort = 51821
+	peers[0].AllowedIPs = append(peers[0].AllowedIPs, netip.MustParsePrefix("192.168.2.0/24"))
+	peers = append(peers[:1], Peer{PublicKey: added, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.8.0.4/32")}})
+	if err = d.Update(iif, peers); err != nil {
+		t.Fatal(err)
+	}
+
+	// the comments, the spacing and the unknown key stay, the phone is gone
+	want := strings.Join([]string{
+		"# wg0, managed by hand",
+		"",
+		"[Interface]",
+		"  PrivateKey=yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+		"ListenPort = 51821 # the default",
+		"Address = 10.8.0.1/24",
+		"Jc = 4",
+		"",
+		"# Name = laptop",
+		"[Peer]",
+		"PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		"AllowedIPs = 10.8.0.2/32, 192.168.2.0/24",
+		"",
+		"[Peer]",
+		"PublicKey = HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=",
+		"AllowedIPs = 10.8.0.4/32",
+	}, "\n")
+	if out := string(d.Bytes()); out != want {
+		t.Errorf("updated document:\n%s\nwant:\n%s", out, want)
+	}
+
+	// updating to what the document holds changes nothing
+	d, _ = ParseDocument(bytes.NewReader(readConf(t, "wg0.conf")))
+	iif, peers, _, _ = d.Decode(ParseOptions{})
+	if err = d.Update(iif, peers); err != nil {
+		t.Fatal(err)
+	}
+	if out := d.Bytes(); !bytes.Equal(out, readConf(t, "wg0.conf")) {
+		t.Errorf("unchanged update:\n%s", out)
+	}
+}
+
+func TestSectionEdit(t *testing.T) {
+	d, err := ParseDocument(bytes.NewReader(readConf(t, "document.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := d.Interface()
+	iface.Set("PostUp", "ip rule add fwmark 1 table 100", "ip route add default dev %i table 100")
+	iface.Set("Address", "10.8.0.1/24", "fd00:8::1/64")
+	iface.Delete("Jc")
+	d.Peers()[0].SetAnnotation("Name", "desktop")
+	d.RemoveSection(d.Peers()[1])
+	peer := d.AddSection("Peer")
+	peer.Set("PublicKey", "HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=")
+	peer.SetAnnotation("Name", "tablet")
+
+	want := strings.Join([]string{
+		"# wg0, managed by hand",
+		"",
+		"[Interface]",
+		"  PrivateKey=yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+		"ListenPort = 51820   # the default",
+		"Address = 10.8.0.1/24",
+		"Address = fd00:8::1/64",
+		"PostUp = ip rule add fwmark 1 table 100",
+		"PostUp = ip route add default dev %i table 100",
+		"",
+		"# Name = desktop",
+		"[Peer]",
+		"PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		"AllowedIPs = 10.8.0.2/32",
+		"",
+		"[Peer]",
+		"# Name = tablet",
+		"PublicKey = HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=",
+	}, "\n")
+	if out := string(d.Bytes()); out != want {
+		t.Errorf("edited document:\n%s\nwant:\n%s", out, want)
+	}
+	if got := iface.Values("PostUp"); len(got) != 2 {
+		t.Errorf("PostUp = %q", got)
+	}
+}
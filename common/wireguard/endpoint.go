@@ -0,0 +1,129 @@
+package wireguard
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Endpoint is where a peer is reached: an IP address or a host name with a
+// port. IPv6 addresses are written in brackets, like [fd00::1]:51820.
+type Endpoint struct {
+	// Host is set for host names, Addr for addresses.
+	Host string
+	Addr netip.Addr
+	Port uint16
+}
+
+// ParseEndpoint parses an endpoint with a port, like wg(8) wants it.
+func ParseEndpoint(s string) (Endpoint, error) {
+	return ParseEndpointDefault(s, 0)
+}
+
+// ParseEndpointDefault parses an endpoint whose port may be left out, the
+// port is then defaultPort. A bare IPv6 address without brackets is taken
+// as an address without port.
+func ParseEndpointDefault(s string, defaultPort uint16) (Endpoint, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		host, port = s, ""
+		if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+			host = s[1 : len(s)-1]
+		} else if _, addrErr := netip.ParseAddr(s); addrErr != nil && strings.Count(s, ":") > 1 {
+			return Endpoint{}, E.New("invalid endpoint ", strconv.Quote(s), ", IPv6 addresses are written like [fd00::1]:51820")
+		}
+	}
+	bracketed := strings.HasPrefix(s, "[")
+	var e Endpoint
+	if port == "" {
+		if defaultPort == 0 {
+			return Endpoint{}, E.New("endpoint ", strconv.Quote(s), " has no port")
+		}
+		e.Port = defaultPort
+	} else {
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return Endpoint{}, E.New("invalid endpoint port ", strconv.Quote(port))
+		}
+		e.Port = uint16(n)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && (!bracketed || addr.Is6()) {
+		e.Addr = addr.Unmap()
+		return e, nil
+	}
+	if bracketed || !validHostname(host) {
+		return Endpoint{}, E.New("invalid endpoint host ", strconv.Quote(host))
+	}
+	e.Host = host
+	return e, nil
+}
+
+// validHostname checks host against RFC 1123.
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (e Endpoint) IsZero() bool {
+	return e == Endpoint{}
+}
+
+func (e Endpoint) IsValid() bool {
+	return e.Port != 0 && (e.Addr.IsValid() || e.Host != "")
+}
+
+// Is6 reports whether the endpoint is an IPv6 address, a host name may be
+// either.
+func (e Endpoint) Is6() bool {
+	return e.Addr.Is6()
+}
+
+func (e Endpoint) String() string {
+	if !e.IsValid() {
+		return ""
+	}
+	host := e.Host
+	if e.Addr.IsValid() {
+		host = e.Addr.String()
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(uint64(e.Port), 10))
+}
+
+func (e Endpoint) MarshalText() ([]byte, error) {
+	if e.IsZero() {
+		return []byte{}, nil
+	}
+	if !e.IsValid() {
+		return nil, E.New("invalid endpoint")
+	}
+	return []byte(e.String()), nil
+}
+
+func (e *Endpoint) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*e = Endpoint{}
+		return nil
+	}
+	endpoint, err := ParseEndpoint(string(text))
+	if err != nil {
+		return err
+	}
+	*e = endpoint
+	return nil
+}
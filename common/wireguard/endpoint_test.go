@@ -0,0 +1,64 @@
+package wireguard
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		in          string
+		defaultPort uint16
+		want        Endpoint
+		str         string
+		err         bool
+	}{
+		{in: "192.0.2.1:51820", want: Endpoint{Addr: netip.MustParseAddr("192.0.2.1"), Port: 51820}, str: "192.0.2.1:51820"},
+		{in: "[2001:db8::1]:51820", want: Endpoint{Addr: netip.MustParseAddr("2001:db8::1"), Port: 51820}, str: "[2001:db8::1]:51820"},
+		{in: "[::ffff:192.0.2.1]:1", want: Endpoint{Addr: netip.MustParseAddr("192.0.2.1"), Port: 1}, str: "192.0.2.1:1"},
+		{in: "vpn.example.com:443", want: Endpoint{Host: "vpn.example.com", Port: 443}, str: "vpn.example.com:443"},
+		{in: "vpn.example.com", defaultPort: 51820, want: Endpoint{Host: "vpn.example.com", Port: 51820}, str: "vpn.example.com:51820"},
+		{in: "2001:db8::1", defaultPort: 51820, want: Endpoint{Addr: netip.MustParseAddr("2001:db8::1"), Port: 51820}, str: "[2001:db8::1]:51820"},
+		{in: "[2001:db8::1]", defaultPort: 51820, want: Endpoint{Addr: netip.MustParseAddr("2001:db8::1"), Port: 51820}, str: "[2001:db8::1]:51820"},
+		{in: "192.0.2.1", err: true},
+		{in: "2001:db8::1:51820", err: true},
+		{in: "2001:db8::zz", defaultPort: 51820, err: true},
+		{in: "[192.0.2.1]:51820", err: true},
+		{in: "[vpn.example.com]:51820", err: true},
+		{in: "192.0.2.1:0", err: true},
+		{in: "192.0.2.1:65536", err: true},
+		{in: "-vpn.example.com:51820", err: true},
+		{in: "vpn_example.com:51820", err: true},
+	} {
+		e, err := ParseEndpointDefault(tt.in, tt.defaultPort)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseEndpointDefault(%q, %d) = %v, want an error", tt.in, tt.defaultPort, e)
+			}
+			continue
+		}
+		if err != nil || e != tt.want || e.String() != tt.str {
+			t.Errorf("ParseEndpointDefault(%q, %d) = %#v %q, %v, want %#v %q", tt.in, tt.defaultPort, e, e, err, tt.want, tt.str)
+		}
+	}
+}
+
+func TestEndpointText(t *testing.T) {
+	var e Endpoint
+	if err := e.UnmarshalText([]byte("[2001:db8::1]:51820")); err != nil || !e.Is6() {
+		t.Fatalf("UnmarshalText = %v, %v", e, err)
+	}
+	if text, err := e.MarshalText(); err != nil || string(text) != "[2001:db8::1]:51820" {
+		t.Errorf("MarshalText = %q, %v", text, err)
+	}
+	// the empty endpoint of a peer without one
+	if err := e.UnmarshalText(nil); err != nil || !e.IsZero() {
+		t.Errorf("UnmarshalText(nil) = %v, %v", e, err)
+	}
+	if text, err := e.MarshalText(); err != nil || len(text) != 0 {
+		t.Errorf("MarshalText of the zero endpoint = %q, %v", text, err)
+	}
+	if _, err := (Endpoint{Host: "vpn.example.com"}).MarshalText(); err == nil {
+		t.Error("MarshalText accepted an endpoint without port")
+	}
+}
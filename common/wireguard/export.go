@@ -0,0 +1,255 @@
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"net/netip"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Format is a network stack a config is exported to or imported from.
+type Format uint8
+
+const (
+	FormatQuick Format = iota
+	FormatSetconf
+	// FormatNetworkd is a .netdev and a .network file of systemd-networkd.
+	FormatNetworkd
+	// FormatNetworkManager is a keyfile connection of NetworkManager.
+	FormatNetworkManager
+	// FormatUCI is the interface and peer sections of /etc/config/network of
+	// OpenWrt.
+	FormatUCI
+)
+
+// DefaultName is the interface name of exported configs without one.
+const DefaultName = "wg0"
+
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "quick", "wg-quick":
+		return FormatQuick, nil
+	case "setconf", "showconf":
+		return FormatSetconf, nil
+	case "networkd", "systemd-networkd":
+		return FormatNetworkd, nil
+	case "nm", "networkmanager", "nmconnection":
+		return FormatNetworkManager, nil
+	case "uci", "openwrt":
+		return FormatUCI, nil
+	}
+	return 0, E.New("unknown config format ", strconv.Quote(s), ", expected quick, setconf, networkd, nm or uci")
+}
+
+func (f Format) String() string {
+	return [...]string{"quick", "setconf", "networkd", "nm", "uci"}[f]
+}
+
+// File is an exported file. Perm is what the stack wants for it, files
+// holding keys are not world readable.
+type File struct {
+	Name string
+	Data []byte
+	Perm fs.FileMode
+	// Group is the group the stack reads the file as, Perm lets it read.
+	Group string
+}
+
+type ExportOptions struct {
+	// Name is the interface, DefaultName without one.
+	Name string
+	// PrivateKeyFile makes systemd-networkd read the private key from this
+	// path instead of the .netdev, the key is exported as a file of its own.
+	PrivateKeyFile string
+}
+
+type ImportOptions struct {
+	// Name picks the interface when the files hold several.
+	Name string
+	// ReadFile reads the key files a config refers to, like PrivateKeyFile of
+	// systemd-networkd. Without it such configs cannot be imported.
+	ReadFile func(name string) ([]byte, error)
+}
+
+// Export writes the config for the network stack of format. The warnings
+// are the settings the stack has no equivalent for, like the hooks of
+// wg-quick, which are left out.
+func Export(iface Interface, peers []Peer, format Format, opts ExportOptions) (files []File, warnings []error, err error) {
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	if !iface.PrivateKey.IsValid() {
+		return nil, nil, E.New("PrivateKey is required")
+	}
+	switch format {
+	case FormatQuick, FormatSetconf:
+		syntax := SyntaxQuick
+		if format == FormatSetconf {
+			syntax = SyntaxSetconf
+		}
+		data, err := marshalConf(iface, peers, syntax)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []File{{Name: opts.Name + ".conf", Data: data, Perm: 0o600}}, nil, nil
+	case FormatNetworkd:
+		files, err = exportNetworkd(iface, peers, opts)
+	case FormatNetworkManager:
+		files, err = exportNetworkManager(iface, peers, opts)
+	case FormatUCI:
+		files, err = exportUCI(iface, peers, opts)
+	default:
+		return nil, nil, E.New("unknown config format")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return files, unsupported(iface, peers, format), nil
+}
+
+// unsupported lists what wg-quick does and the other stacks do not.
+func unsupported(iface Interface, peers []Peer, format Format) []error {
+	var warnings []error
+	for _, hook := range []struct {
+		key   string
+		hooks []string
+	}{{"PreUp", iface.PreUp}, {"PostUp", iface.PostUp}, {"PreDown", iface.PreDown}, {"PostDown", iface.PostDown}} {
+		if len(hook.hooks) > 0 {
+			warnings = append(warnings, E.New(hook.key, " hooks have no equivalent in ", format, " and are left out"))
+		}
+	}
+	if iface.SaveConfig {
+		warnings = append(warnings, E.New("SaveConfig has no equivalent in ", format, " and is left out"))
+	}
+	if format == FormatNetworkd && iface.Table != TableOff && slices.ContainsFunc(peers, routesDefault) {
+		warnings = append(warnings, E.New("a default route in AllowedIPs needs the policy routing wg-quick sets up and systemd-networkd does not, add a RoutingPolicyRule and a RouteTable of its own"))
+	}
+	return warnings
+}
+
+func routesDefault(peer Peer) bool {
+	return slices.ContainsFunc(peer.AllowedIPs, func(p netip.Prefix) bool { return p.Bits() == 0 })
+}
+
+// Import reads a config of the network stack of format. Networkd wants the
+// .netdev and optionally the .network file, the other formats one file.
+// The warnings are settings that do not map onto wg-quick exactly.
+func Import(files []File, format Format, opts ImportOptions) (name string, config InterfaceWithPeers, warnings []error, err error) {
+	switch format {
+	case FormatQuick, FormatSetconf:
+		if len(files) != 1 {
+			return "", InterfaceWithPeers{}, nil, E.New(format, " configs are imported from one file")
+		}
+		syntax := SyntaxQuick
+		if format == FormatSetconf {
+			syntax = SyntaxSetconf
+		}
+		config.Interface, config.Peers, _, err = ParseWireguardConfWith(bytes.NewReader(files[0].Data), ParseOptions{File: files[0].Name, Mode: Strict, Syntax: syntax})
+		name = strings.TrimSuffix(path.Base(files[0].Name), ".conf")
+		return name, config, nil, err
+	case FormatNetworkd:
+		return importNetworkd(files, opts)
+	case FormatNetworkManager:
+		if len(files) != 1 {
+			return "", InterfaceWithPeers{}, nil, E.New("NetworkManager connections are imported from one file")
+		}
+		return importNetworkManager(files[0])
+	case FormatUCI:
+		if len(files) != 1 {
+			return "", InterfaceWithPeers{}, nil, E.New("UCI configs are imported from one file")
+		}
+		return importUCI(files[0], opts)
+	}
+	return "", InterfaceWithPeers{}, nil, E.New("unknown config format")
+}
+
+// iniSection is a section of the ini files of systemd and NetworkManager,
+// a name may be given several times.
+type iniSection struct {
+	name   string
+	line   int
+	values []iniValue
+}
+
+type iniValue struct {
+	key, value string
+	line       int
+}
+
+func parseINI(file File) ([]*iniSection, error) {
+	var (
+		sections []*iniSection
+		current  *iniSection
+		scanner  = bufio.NewScanner(bytes.NewReader(file.Data))
+	)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			if !strings.HasSuffix(line, "]") {
+				return nil, E.At(file.Name, number, 1, E.New("unclosed section header"))
+			}
+			current = &iniSection{name: line[1 : len(line)-1], line: number}
+			sections = append(sections, current)
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, E.At(file.Name, number, 1, E.New("expected key=value"))
+			}
+			if current == nil {
+				return nil, E.At(file.Name, number, 1, E.New("key outside of a section"))
+			}
+			current.values = append(current.values, iniValue{strings.TrimSpace(key), strings.TrimSpace(value), number})
+		}
+	}
+	return sections, E.When("read "+file.Name, scanner.Err())
+}
+
+// get returns the last value of key, the one that counts.
+func (s *iniSection) get(key string) (iniValue, bool) {
+	for i := len(s.values) - 1; i >= 0; i-- {
+		if s.values[i].key == key {
+			return s.values[i], true
+		}
+	}
+	return iniValue{}, false
+}
+
+func (s *iniSection) all(key string) []iniValue {
+	var values []iniValue
+	for _, v := range s.values {
+		if v.key == key {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func writeINISection(buf *bytes.Buffer, name string, kvs []keyValue) {
+	if buf.Len() > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("[" + name + "]\n")
+	for _, kv := range kvs {
+		buf.WriteString(kv.key + "=" + kv.value + "\n")
+	}
+}
+
+// readKeyFile reads a key a config refers to by path.
+func readKeyFile(opts ImportOptions, name string) ([]byte, error) {
+	if opts.ReadFile == nil {
+		return nil, E.New("cannot read the key file ", name)
+	}
+	data, err := opts.ReadFile(name)
+	if err != nil {
+		return nil, E.When("read key file", err)
+	}
+	return bytes.TrimSpace(data), nil
+}
@@ -0,0 +1,121 @@
+package wireguard
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// configJSON is what the config stands for, without how it was written.
+func configJSON(t *testing.T, config InterfaceWithPeers) string {
+	t.Helper()
+	config.Interface.Addresses = slices.Clone(config.Interface.Addresses)
+	slices.SortFunc(config.Interface.Addresses, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExportImport(t *testing.T) {
+	iif, peers, err := ParseWireguardConf(bytes.NewReader(readConf(t, "wg0.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the other stacks have no equivalent of the hooks and leave them out
+	unhooked := InterfaceWithPeers{Interface: iif, Peers: peers}
+	unhooked.Interface.PostUp, unhooked.Interface.PostDown = nil, nil
+
+	for _, format := range []Format{FormatQuick, FormatSetconf, FormatNetworkd, FormatNetworkManager, FormatUCI} {
+		t.Run(format.String(), func(t *testing.T) {
+			files, warnings, err := Export(iif, peers, format, ExportOptions{Name: "wg1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format == FormatQuick || format == FormatSetconf {
+				if len(warnings) != 0 {
+					t.Errorf("warnings = %v", warnings)
+				}
+			} else if len(warnings) != 2 || !strings.HasPrefix(warnings[0].Error(), "PostUp hooks") {
+				t.Errorf("warnings = %v", warnings)
+			}
+			for _, file := range files {
+				if file.Perm&0o007 != 0 && bytes.Contains(file.Data, []byte(iif.PrivateKey.String())) {
+					t.Errorf("%s holds the private key with mode %o", file.Name, file.Perm)
+				}
+			}
+
+			name, config, warnings, err := Import(files, format, ImportOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := unhooked
+			switch format {
+			case FormatQuick:
+				want.Interface = iif
+			case FormatSetconf:
+				// setconf knows the keys of wg(8) only
+				want.Interface = Interface{PrivateKey: iif.PrivateKey, ListenPort: iif.ListenPort, FwMark: iif.FwMark}
+			}
+			if name != "wg1" || len(warnings) != 0 {
+				t.Errorf("imported %s, warnings %v", name, warnings)
+			}
+			if got := configJSON(t, config); got != configJSON(t, want) {
+				t.Errorf("imported\n%s\nwant\n%s", got, configJSON(t, want))
+			}
+		})
+	}
+}
+
+func TestExportNetworkdKeyFile(t *testing.T) {
+	iif, peers, err := ParseWireguardConf(bytes.NewReader(readConf(t, "wg0.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := Export(iif, peers, FormatNetworkd, ExportOptions{PrivateKeyFile: "/etc/systemd/network/wg0.key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+		// systemd-networkd reads the files holding keys as its group
+		wantGroup, wantPerm := "", fs.FileMode(0o644)
+		if file.Name != "wg0.network" {
+			wantGroup, wantPerm = networkdGroup, 0o640
+		}
+		if file.Group != wantGroup || file.Perm != wantPerm {
+			t.Errorf("%s: mode %o group %q", file.Name, file.Perm, file.Group)
+		}
+	}
+	if !slices.Equal(names, []string{"wg0.netdev", "wg0.network", "wg0.key"}) {
+		t.Fatalf("files = %v", names)
+	}
+	if bytes.Contains(files[0].Data, []byte(iif.PrivateKey.String())) ||
+		!bytes.Contains(files[0].Data, []byte("PrivateKeyFile=/etc/systemd/network/wg0.key\n")) {
+		t.Errorf("wg0.netdev:\n%s", files[0].Data)
+	}
+
+	// the key file is read from the disk
+	readFile := func(name string) ([]byte, error) {
+		if name != "/etc/systemd/network/wg0.key" {
+			return nil, fs.ErrNotExist
+		}
+		return files[2].Data, nil
+	}
+	_, config, _, err := Import(files[:2], FormatNetworkd, ImportOptions{ReadFile: readFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Interface.PrivateKey != iif.PrivateKey {
+		t.Errorf("imported private key %s", config.Interface.PrivateKey)
+	}
+	if _, _, _, err = Import(files[:2], FormatNetworkd, ImportOptions{}); err == nil {
+		t.Error("Import read the key file without ReadFile")
+	}
+}
@@ -0,0 +1,292 @@
+package wireguard
+
+import (
+	"bytes"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// networkdGroup is the group systemd-networkd runs as.
+const networkdGroup = "systemd-network"
+
+// exportNetworkd writes the .netdev with the keys and the peers and the
+// .network with the addresses and DNS. The routes of the AllowedIPs go to
+// the table of RouteTable=, which networkd only adds with it.
+func exportNetworkd(iface Interface, peers []Peer, opts ExportOptions) ([]File, error) {
+	var netdev bytes.Buffer
+	kvs := []keyValue{{"Name", opts.Name}, {"Kind", "wireguard"}}
+	if iface.MTU > 0 {
+		kvs = append(kvs, keyValue{"MTUBytes", strconv.Itoa(iface.MTU)})
+	}
+	writeINISection(&netdev, "NetDev", kvs)
+
+	var files []File
+	if opts.PrivateKeyFile != "" {
+		kvs = []keyValue{{"PrivateKeyFile", opts.PrivateKeyFile}}
+		files = append(files, File{Name: path.Base(opts.PrivateKeyFile), Data: []byte(iface.PrivateKey.String() + "\n"), Perm: 0o640, Group: networkdGroup})
+	} else {
+		kvs = []keyValue{{"PrivateKey", iface.PrivateKey.String()}}
+	}
+	if iface.ListenPort > 0 {
+		kvs = append(kvs, keyValue{"ListenPort", strconv.FormatUint(uint64(iface.ListenPort), 10)})
+	}
+	if iface.FwMark != 0 {
+		kvs = append(kvs, keyValue{"FirewallMark", strconv.FormatUint(uint64(iface.FwMark), 10)})
+	}
+	switch iface.Table {
+	case "", TableAuto:
+		kvs = append(kvs, keyValue{"RouteTable", "main"})
+	case TableOff:
+	default:
+		kvs = append(kvs, keyValue{"RouteTable", string(iface.Table)})
+	}
+	writeINISection(&netdev, "WireGuard", kvs)
+
+	for _, peer := range peers {
+		kvs, err := peer.keyValues()
+		if err != nil {
+			return nil, err
+		}
+		for i, kv := range kvs {
+			if kv.key == "AllowedIPs" {
+				kvs[i].value = strings.ReplaceAll(kv.value, " ", "")
+			}
+		}
+		writeINISection(&netdev, "WireGuardPeer", kvs)
+	}
+
+	var network bytes.Buffer
+	writeINISection(&network, "Match", []keyValue{{"Name", opts.Name}})
+	kvs = nil
+	for _, addr := range iface.Addresses {
+		kvs = append(kvs, keyValue{"Address", addr.String()})
+	}
+	for _, dns := range iface.DNS {
+		kvs = append(kvs, keyValue{"DNS", dns.String()})
+	}
+	if len(iface.DNSSearch) > 0 {
+		kvs = append(kvs, keyValue{"Domains", strings.Join(iface.DNSSearch, " ")})
+	}
+	writeINISection(&network, "Network", kvs)
+
+	// networkd reads the .netdev as systemd-network, it holds the keys
+	return append([]File{
+		{Name: opts.Name + ".netdev", Data: netdev.Bytes(), Perm: 0o640, Group: networkdGroup},
+		{Name: opts.Name + ".network", Data: network.Bytes(), Perm: 0o644},
+	}, files...), nil
+}
+
+func importNetworkd(files []File, opts ImportOptions) (name string, config InterfaceWithPeers, warnings []error, err error) {
+	var netdev, network *File
+	for i := range files {
+		switch path.Ext(files[i].Name) {
+		case ".netdev":
+			netdev = &files[i]
+		case ".network":
+			network = &files[i]
+		default:
+			return "", InterfaceWithPeers{}, nil, E.New("expected a .netdev and a .network file, got ", files[i].Name)
+		}
+	}
+	if netdev == nil {
+		return "", InterfaceWithPeers{}, nil, E.New("the .netdev file of the interface is required")
+	}
+	sections, err := parseINI(*netdev)
+	if err != nil {
+		return "", InterfaceWithPeers{}, nil, err
+	}
+	at := func(v iniValue, err error) error {
+		return E.At(netdev.Name, v.line, 0, err)
+	}
+
+	iface := &config.Interface
+	// RouteTable is off unless given
+	iface.Table = TableOff
+	for _, s := range sections {
+		switch s.name {
+		case "NetDev":
+			if v, ok := s.get("Kind"); ok && v.value != "wireguard" {
+				return "", InterfaceWithPeers{}, nil, at(v, E.New("not a wireguard netdev but ", v.value))
+			}
+			if v, ok := s.get("Name"); ok {
+				name = v.value
+			}
+			if v, ok := s.get("MTUBytes"); ok {
+				if iface.MTU, err = strconv.Atoi(v.value); err != nil {
+					return "", InterfaceWithPeers{}, nil, at(v, E.New("invalid MTUBytes ", strconv.Quote(v.value)))
+				}
+			}
+		case "WireGuard":
+			for _, v := range s.values {
+				if err = networkdInterfaceValue(iface, v, opts); err != nil {
+					return "", InterfaceWithPeers{}, nil, at(v, err)
+				}
+			}
+		case "WireGuardPeer":
+			var peer Peer
+			for _, v := range s.values {
+				if err = networkdPeerValue(&peer, v, opts); err != nil {
+					return "", InterfaceWithPeers{}, nil, at(v, err)
+				}
+			}
+			if !peer.PublicKey.IsValid() {
+				return "", InterfaceWithPeers{}, nil, E.At(netdev.Name, s.line, 0, E.New("peer without PublicKey"))
+			}
+			config.Peers = append(config.Peers, peer)
+		}
+	}
+	if name == "" {
+		name = strings.TrimSuffix(path.Base(netdev.Name), ".netdev")
+	}
+	if !iface.PrivateKey.IsValid() {
+		return "", InterfaceWithPeers{}, nil, E.New(netdev.Name, ": PrivateKey or PrivateKeyFile is required")
+	}
+	if network != nil {
+		if warnings, err = importNetworkdNetwork(iface, *network); err != nil {
+			return "", InterfaceWithPeers{}, nil, err
+		}
+	}
+	return name, config, warnings, nil
+}
+
+func networkdInterfaceValue(iface *Interface, v iniValue, opts ImportOptions) (err error) {
+	switch v.key {
+	case "PrivateKey":
+		return iface.PrivateKey.UnmarshalText([]byte(v.value))
+	case "PrivateKeyFile":
+		key, err := readKeyFile(opts, v.value)
+		if err != nil {
+			return err
+		}
+		return iface.PrivateKey.UnmarshalText(key)
+	case "ListenPort":
+		if v.value == "auto" || v.value == "" {
+			iface.ListenPort = 0
+			return nil
+		}
+		port, err := strconv.ParseUint(v.value, 10, 16)
+		if err != nil {
+			return E.New("invalid ListenPort ", strconv.Quote(v.value))
+		}
+		iface.ListenPort = uint16(port)
+	case "FirewallMark":
+		iface.FwMark, err = ParseFwMark(v.value)
+	case "RouteTable":
+		iface.Table, err = networkdTable(v.value)
+	}
+	return err
+}
+
+// networkdTable maps RouteTable= onto Table, the main table is what
+// wg-quick uses by default.
+func networkdTable(s string) (Table, error) {
+	switch s {
+	case "main":
+		return "", nil
+	case "", "off", "false", "no", "0":
+		return TableOff, nil
+	case "default":
+		return "253", nil
+	case "local":
+		return "255", nil
+	}
+	table, err := ParseTable(s)
+	if err != nil || table == TableAuto || table == TableOff {
+		return "", E.New("invalid RouteTable ", strconv.Quote(s))
+	}
+	return table, nil
+}
+
+func networkdPeerValue(peer *Peer, v iniValue, opts ImportOptions) error {
+	switch v.key {
+	case "PublicKey":
+		return peer.PublicKey.UnmarshalText([]byte(v.value))
+	case "PresharedKey":
+		return peer.PresharedKey.UnmarshalText([]byte(v.value))
+	case "PresharedKeyFile":
+		key, err := readKeyFile(opts, v.value)
+		if err != nil {
+			return err
+		}
+		return peer.PresharedKey.UnmarshalText(key)
+	case "AllowedIPs":
+		// an empty assignment resets the list
+		if v.value == "" {
+			peer.AllowedIPs = nil
+		}
+		for _, s := range strings.FieldsFunc(v.value, func(r rune) bool { return r == ',' || r == ' ' }) {
+			prefix, err := ParseAddress(s)
+			if err != nil {
+				return err
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, prefix)
+		}
+	case "Endpoint":
+		endpoint, err := ParseEndpoint(v.value)
+		if err != nil {
+			return err
+		}
+		peer.Endpoint = endpoint
+	case "PersistentKeepalive":
+		if v.value == "off" {
+			peer.PersistentKeepalive = 0
+			return nil
+		}
+		keepalive, err := strconv.ParseUint(v.value, 10, 16)
+		if err != nil {
+			return E.New("invalid PersistentKeepalive ", strconv.Quote(v.value))
+		}
+		peer.PersistentKeepalive = int(keepalive)
+	}
+	return nil
+}
+
+// importNetworkdNetwork reads the addresses and DNS of the .network file.
+// Routing domains, starting with ~, are no search domains and are skipped.
+func importNetworkdNetwork(iface *Interface, file File) (warnings []error, err error) {
+	sections, err := parseINI(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sections {
+		switch s.name {
+		case "Network", "Address":
+			for _, v := range s.all("Address") {
+				addr, err := ParseAddress(v.value)
+				if err != nil {
+					return nil, E.At(file.Name, v.line, 0, err)
+				}
+				iface.Addresses = append(iface.Addresses, addr)
+			}
+			for _, v := range s.all("DNS") {
+				for _, f := range strings.Fields(v.value) {
+					dns, err := netip.ParseAddr(f)
+					if err != nil {
+						return nil, E.At(file.Name, v.line, 0, E.New("invalid DNS ", strconv.Quote(f)))
+					}
+					iface.DNS = append(iface.DNS, dns)
+				}
+			}
+			for _, v := range s.all("Domains") {
+				for _, f := range strings.Fields(v.value) {
+					if strings.HasPrefix(f, "~") {
+						warnings = append(warnings, E.At(file.Name, v.line, 0, E.New("routing domain ", f, " is skipped")))
+						continue
+					}
+					iface.DNSSearch = append(iface.DNSSearch, f)
+				}
+			}
+		case "Link":
+			if v, ok := s.get("MTUBytes"); ok {
+				if iface.MTU, err = strconv.Atoi(v.value); err != nil {
+					return nil, E.At(file.Name, v.line, 0, E.New("invalid MTUBytes ", strconv.Quote(v.value)))
+				}
+			}
+		}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,293 @@
+package wireguard
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+const nmPeerPrefix = "wireguard-peer."
+
+// exportNetworkManager writes a keyfile connection for
+// /etc/NetworkManager/system-connections. NetworkManager routes the
+// AllowedIPs of the peers itself, default routes included, like wg-quick.
+func exportNetworkManager(iface Interface, peers []Peer, opts ExportOptions) ([]File, error) {
+	pub, err := GenPublicKey(iface.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeINISection(&buf, "connection", []keyValue{
+		{"id", opts.Name},
+		{"uuid", nmUUID(opts.Name, pub)},
+		{"type", "wireguard"},
+		{"interface-name", opts.Name},
+	})
+
+	kvs := []keyValue{{"private-key", iface.PrivateKey.String()}}
+	if iface.ListenPort > 0 {
+		kvs = append(kvs, keyValue{"listen-port", strconv.FormatUint(uint64(iface.ListenPort), 10)})
+	}
+	if iface.FwMark != 0 {
+		kvs = append(kvs, keyValue{"fwmark", strconv.FormatUint(uint64(iface.FwMark), 10)})
+	}
+	if iface.MTU > 0 {
+		kvs = append(kvs, keyValue{"mtu", strconv.Itoa(iface.MTU)})
+	}
+	if iface.Table == TableOff {
+		kvs = append(kvs, keyValue{"peer-routes", "false"})
+	}
+	writeINISection(&buf, "wireguard", kvs)
+
+	for _, peer := range peers {
+		if !peer.PublicKey.IsValid() {
+			return nil, E.New("PublicKey is required")
+		}
+		if len(peer.AllowedIPs) == 0 {
+			return nil, E.New("AllowedIPs is required")
+		}
+		var kvs []keyValue
+		if peer.Endpoint.IsValid() {
+			kvs = append(kvs, keyValue{"endpoint", peer.Endpoint.String()})
+		}
+		if peer.PresharedKey.IsValid() {
+			kvs = append(kvs, keyValue{"preshared-key", peer.PresharedKey.String()}, keyValue{"preshared-key-flags", "0"})
+		}
+		if peer.PersistentKeepalive > 0 {
+			kvs = append(kvs, keyValue{"persistent-keepalive", strconv.Itoa(peer.PersistentKeepalive)})
+		}
+		kvs = append(kvs, keyValue{"allowed-ips", nmList(peer.AllowedIPs)})
+		writeINISection(&buf, nmPeerPrefix+peer.PublicKey.String(), kvs)
+	}
+
+	var v4, v6 []netip.Prefix
+	for _, addr := range iface.Addresses {
+		if addr.Addr().Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	var dns4, dns6 []netip.Addr
+	for _, dns := range iface.DNS {
+		if dns.Is4() {
+			dns4 = append(dns4, dns)
+		} else {
+			dns6 = append(dns6, dns)
+		}
+	}
+	// the search domains go with the first family in use
+	searchFamily := "ipv4"
+	if len(v4) == 0 && len(v6) > 0 {
+		searchFamily = "ipv6"
+	}
+	for _, family := range []struct {
+		name      string
+		addresses []netip.Prefix
+		dns       []netip.Addr
+	}{{"ipv4", v4, dns4}, {"ipv6", v6, dns6}} {
+		kvs := []keyValue{{"method", "disabled"}}
+		if len(family.addresses) > 0 {
+			kvs[0].value = "manual"
+		}
+		for n, addr := range family.addresses {
+			kvs = append(kvs, keyValue{"address" + strconv.Itoa(n+1), addr.String()})
+		}
+		if len(family.dns) > 0 {
+			kvs = append(kvs, keyValue{"dns", nmList(family.dns)})
+		}
+		if len(iface.DNSSearch) > 0 && family.name == searchFamily {
+			kvs = append(kvs, keyValue{"dns-search", strings.Join(iface.DNSSearch, ";") + ";"})
+		}
+		if n, ok := iface.Table.Number(); ok {
+			kvs = append(kvs, keyValue{"route-table", strconv.FormatUint(uint64(n), 10)})
+		}
+		writeINISection(&buf, family.name, kvs)
+	}
+
+	// NetworkManager ignores keyfiles others can read
+	return []File{{Name: opts.Name + ".nmconnection", Data: buf.Bytes(), Perm: 0o600}}, nil
+}
+
+// nmUUID derives the uuid of the connection from its name and key, a
+// config exported again replaces the connection instead of adding one.
+func nmUUID(name string, pub PublicKey) string {
+	sum := sha1.Sum(append([]byte("fire/wireguard/"+name+"/"), pub[:]...))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// nmList writes a list of a keyfile, every element ends with a semicolon.
+func nmList[T fmt.Stringer](values []T) string {
+	var sb strings.Builder
+	for _, v := range values {
+		sb.WriteString(v.String() + ";")
+	}
+	return sb.String()
+}
+
+func nmSplit(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ',' || r == ' ' })
+}
+
+func importNetworkManager(file File) (name string, config InterfaceWithPeers, warnings []error, err error) {
+	sections, err := parseINI(file)
+	if err != nil {
+		return "", InterfaceWithPeers{}, nil, err
+	}
+	at := func(v iniValue, err error) error {
+		return E.At(file.Name, v.line, 0, err)
+	}
+	iface := &config.Interface
+	var tables []Table
+	for _, s := range sections {
+		switch {
+		case s.name == "connection":
+			if v, ok := s.get("type"); ok && v.value != "wireguard" {
+				return "", InterfaceWithPeers{}, nil, at(v, E.New("not a wireguard connection but ", v.value))
+			}
+			if v, ok := s.get("interface-name"); ok {
+				name = v.value
+			} else if v, ok = s.get("id"); ok && name == "" {
+				name = v.value
+			}
+		case s.name == "wireguard":
+			for _, v := range s.values {
+				if err = nmInterfaceValue(iface, v); err != nil {
+					return "", InterfaceWithPeers{}, nil, at(v, err)
+				}
+			}
+		case strings.HasPrefix(s.name, nmPeerPrefix):
+			var peer Peer
+			if err = peer.PublicKey.UnmarshalText([]byte(strings.TrimPrefix(s.name, nmPeerPrefix))); err != nil {
+				return "", InterfaceWithPeers{}, nil, E.At(file.Name, s.line, 0, err)
+			}
+			for _, v := range s.values {
+				if err = nmPeerValue(&peer, v); err != nil {
+					return "", InterfaceWithPeers{}, nil, at(v, err)
+				}
+			}
+			config.Peers = append(config.Peers, peer)
+		case s.name == "ipv4" || s.name == "ipv6":
+			for _, v := range s.values {
+				switch {
+				case v.key == "addresses" || strings.HasPrefix(v.key, "address") && isDigits(strings.TrimPrefix(v.key, "address")):
+					for _, a := range strings.Split(v.value, ";") {
+						// an address may be followed by its gateway
+						a, _, _ = strings.Cut(a, ",")
+						if a = strings.TrimSpace(a); a == "" {
+							continue
+						}
+						addr, err := ParseAddress(a)
+						if err != nil {
+							return "", InterfaceWithPeers{}, nil, at(v, err)
+						}
+						iface.Addresses = append(iface.Addresses, addr)
+					}
+				case v.key == "dns":
+					for _, f := range nmSplit(v.value) {
+						dns, err := netip.ParseAddr(f)
+						if err != nil {
+							return "", InterfaceWithPeers{}, nil, at(v, E.New("invalid dns ", strconv.Quote(f)))
+						}
+						iface.DNS = append(iface.DNS, dns)
+					}
+				case v.key == "dns-search":
+					for _, f := range nmSplit(v.value) {
+						if strings.HasPrefix(f, "~") {
+							warnings = append(warnings, at(v, E.New("routing domain ", f, " is skipped")))
+							continue
+						}
+						iface.DNSSearch = append(iface.DNSSearch, f)
+					}
+				case v.key == "route-table" && v.value != "0":
+					table, err := ParseTable(v.value)
+					if _, ok := table.Number(); err != nil || !ok {
+						return "", InterfaceWithPeers{}, nil, at(v, E.New("invalid route-table ", strconv.Quote(v.value)))
+					}
+					tables = append(tables, table)
+				}
+			}
+		}
+	}
+	if !iface.PrivateKey.IsValid() {
+		return "", InterfaceWithPeers{}, nil, E.New(file.Name, ": the private-key is not stored in the connection")
+	}
+	if len(tables) > 0 && iface.Table != TableOff {
+		iface.Table = tables[0]
+		if len(tables) > 1 && tables[1] != tables[0] {
+			warnings = append(warnings, E.New(file.Name, ": ipv4 and ipv6 use the route tables ", tables[0], " and ", tables[1], ", using ", tables[0]))
+		}
+	}
+	return name, config, warnings, nil
+}
+
+func nmInterfaceValue(iface *Interface, v iniValue) (err error) {
+	switch v.key {
+	case "private-key":
+		return iface.PrivateKey.UnmarshalText([]byte(v.value))
+	case "listen-port":
+		port, err := strconv.ParseUint(v.value, 10, 16)
+		if err != nil {
+			return E.New("invalid listen-port ", strconv.Quote(v.value))
+		}
+		iface.ListenPort = uint16(port)
+	case "fwmark":
+		iface.FwMark, err = ParseFwMark(v.value)
+	case "mtu":
+		if iface.MTU, err = strconv.Atoi(v.value); err != nil {
+			return E.New("invalid mtu ", strconv.Quote(v.value))
+		}
+	case "peer-routes":
+		if v.value == "false" || v.value == "0" || v.value == "no" {
+			iface.Table = TableOff
+		}
+	}
+	return err
+}
+
+func nmPeerValue(peer *Peer, v iniValue) error {
+	switch v.key {
+	case "preshared-key":
+		return peer.PresharedKey.UnmarshalText([]byte(v.value))
+	case "allowed-ips":
+		for _, s := range nmSplit(v.value) {
+			prefix, err := ParseAddress(s)
+			if err != nil {
+				return err
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, prefix)
+		}
+	case "endpoint":
+		endpoint, err := ParseEndpoint(v.value)
+		if err != nil {
+			return err
+		}
+		peer.Endpoint = endpoint
+	case "persistent-keepalive":
+		keepalive, err := strconv.ParseUint(v.value, 10, 16)
+		if err != nil {
+			return E.New("invalid persistent-keepalive ", strconv.Quote(v.value))
+		}
+		peer.PersistentKeepalive = int(keepalive)
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
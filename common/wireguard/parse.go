@@ -0,0 +1,220 @@
+package wireguard
+
+import (
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+type ParseMode uint8
+
+const (
+	// Lenient skips malformed lines, unknown keys and peers it cannot use
+	// and reports them as warnings, like wg-quick mostly does.
+	Lenient ParseMode = iota
+	// Strict fails on them.
+	Strict
+)
+
+type ParseOptions struct {
+	// File names the config in the diagnostics.
+	File string
+	Mode ParseMode
+	// Syntax SyntaxSetconf treats the keys of wg-quick as unknown.
+	Syntax Syntax
+	// AllowUnknown keeps unknown keys warnings in strict mode, for the keys
+	// of forks like AmneziaWG that a config edited in place carries along.
+	AllowUnknown bool
+}
+
+// interfaceKeys and peerKeys are the keys of wg(8) and wg-quick(8), true for
+// the keys that may be given several times.
+var (
+	interfaceKeys = map[string]bool{
+		"PrivateKey": false, "ListenPort": false, "FwMark": false,
+		"Address": true, "DNS": true, "MTU": false, "Table": false,
+		"PreUp": true, "PostUp": true, "PreDown": true, "PostDown": true,
+		"SaveConfig": false,
+	}
+	peerKeys = map[string]bool{
+		"PublicKey": false, "PresharedKey": false, "AllowedIPs": true,
+		"Endpoint": false, "PersistentKeepalive": false,
+	}
+)
+
+// ParseWireguardConfWith parses a config in the mode of opts. Every problem
+// is reported as file:line:column, the warnings are what lenient mode
+// skipped over.
+func ParseWireguardConfWith(in io.Reader, opts ParseOptions) (iif Interface, peers []Peer, warnings []error, err error) {
+	d, err := ParseDocument(in)
+	if err != nil {
+		return Interface{}, nil, nil, err
+	}
+	return d.Decode(opts)
+}
+
+// Decode returns the interface and the peers of the document.
+func (d *Document) Decode(opts ParseOptions) (iif Interface, peers []Peer, warnings []error, err error) {
+	dec := &decoder{opts: opts}
+	iif, peers, err = dec.document(d)
+	if err != nil {
+		return Interface{}, nil, dec.warnings, err
+	}
+	return iif, peers, dec.warnings, nil
+}
+
+type decoder struct {
+	opts     ParseOptions
+	warnings []error
+}
+
+func (dec *decoder) at(l *Line, column int, err error) *E.PositionError {
+	return &E.PositionError{File: dec.opts.File, Line: l.Number, Column: column, Err: err}
+}
+
+// problem fails in strict mode and becomes a warning in lenient mode.
+func (dec *decoder) problem(err error) error {
+	if dec.opts.Mode == Strict {
+		return err
+	}
+	dec.warnings = append(dec.warnings, err)
+	return nil
+}
+
+func (dec *decoder) document(d *Document) (iif Interface, peers []Peer, err error) {
+	for _, l := range d.Preamble {
+		if !l.IsBlank() && !l.IsComment() {
+			if err = dec.problem(dec.at(l, l.keyColumn(), E.New("line outside of a section: ", strconv.Quote(strings.TrimSpace(l.Raw))))); err != nil {
+				return Interface{}, nil, err
+			}
+		}
+	}
+	var (
+		section *Section
+		seen    = make(map[PublicKey]int)
+	)
+	for _, s := range d.Sections {
+		switch s.Name {
+		case "Interface":
+			if section != nil {
+				return Interface{}, nil, dec.at(s.Header, 1, E.New("multiple [Interface] sections, the first is on line ", section.Header.Number))
+			}
+			section = s
+			if iif, err = dec.iface(s); err != nil {
+				return Interface{}, nil, err
+			}
+		case "Peer":
+			peer, err := dec.peer(s)
+			if err != nil {
+				if dec.opts.Mode == Strict {
+					return Interface{}, nil, err
+				}
+				err.Err = E.New(err.Err, ", the peer is skipped")
+				dec.warnings = append(dec.warnings, err)
+				continue
+			}
+			if first, ok := seen[peer.PublicKey]; ok {
+				if err := dec.problem(dec.at(s.Header, 1, E.New("duplicate peer ", peer.PublicKey, ", the first is on line ", first))); err != nil {
+					return Interface{}, nil, err
+				}
+				continue
+			}
+			seen[peer.PublicKey] = s.Header.Number
+			peers = append(peers, peer)
+		default:
+			return Interface{}, nil, dec.at(s.Header, 1, E.New("unknown section: ", s.Name))
+		}
+	}
+	if section == nil {
+		return Interface{}, nil, &E.PositionError{File: dec.opts.File, Err: E.New("missing Interface section")}
+	}
+	return iif, peers, nil
+}
+
+// keyValues checks the lines of s and returns the key lines to parse.
+func (dec *decoder) keyValues(s *Section, keys map[string]bool) ([]*Line, error) {
+	var (
+		lines []*Line
+		first = make(map[string]int)
+	)
+	for _, l := range s.Lines {
+		if l.IsBlank() || l.IsComment() {
+			continue
+		}
+		var problem error
+		repeatable, known := keys[l.Key]
+		switch {
+		case l.Key == "":
+			problem = dec.at(l, l.keyColumn(), E.New("malformed line ", strconv.Quote(strings.TrimSpace(l.Raw)), ", expected Key = Value"))
+		case !known:
+			problem = dec.at(l, l.keyColumn(), E.New("unknown key ", l.Key, " in [", s.Name, "]"))
+			if dec.opts.AllowUnknown {
+				dec.warnings = append(dec.warnings, problem)
+				continue
+			}
+		case dec.opts.Syntax == SyntaxSetconf && s.Name == "Interface" && slices.Contains(quickKeys, l.Key):
+			problem = dec.at(l, l.keyColumn(), E.New("key ", l.Key, " is only known to wg-quick, wg setconf rejects it"))
+		case first[l.Key] > 0 && !repeatable:
+			// the last one wins, like in wg and wg-quick
+			if err := dec.problem(dec.at(l, l.keyColumn(), E.New("duplicate key ", l.Key, ", first given on line ", first[l.Key]))); err != nil {
+				return nil, err
+			}
+			lines = append(lines, l)
+			continue
+		default:
+			if _, ok := first[l.Key]; !ok {
+				first[l.Key] = max(l.Number, 1)
+			}
+			lines = append(lines, l)
+			continue
+		}
+		if err := dec.problem(problem); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+func (dec *decoder) iface(s *Section) (Interface, error) {
+	var iif Interface
+	lines, err := dec.keyValues(s, interfaceKeys)
+	if err != nil {
+		return Interface{}, err
+	}
+	for _, l := range lines {
+		if err := iif.parseInterfaceKeyValue(l.Key, l.Value); err != nil {
+			return Interface{}, dec.at(l, l.valueColumn(), E.New("invalid ", l.Key, ": ", err))
+		}
+	}
+	if !iif.PrivateKey.IsValid() {
+		return Interface{}, dec.at(s.Header, 1, E.New("[Interface] has no valid PrivateKey"))
+	}
+	iif.ready = true
+	return iif, nil
+}
+
+// peer returns a *E.PositionError so that lenient mode can note the skipped
+// peer in it.
+func (dec *decoder) peer(s *Section) (Peer, *E.PositionError) {
+	var peer Peer
+	lines, err := dec.keyValues(s, peerKeys)
+	if err != nil {
+		return Peer{}, err.(*E.PositionError)
+	}
+	for _, l := range lines {
+		if err := peer.parsePeerKeyValue(l.Key, l.Value); err != nil {
+			return Peer{}, dec.at(l, l.valueColumn(), E.New("invalid ", l.Key, ": ", err))
+		}
+	}
+	if !peer.PublicKey.IsValid() {
+		return Peer{}, dec.at(s.Header, 1, E.New("[Peer] has no valid PublicKey"))
+	}
+	if len(peer.AllowedIPs) == 0 {
+		return Peer{}, dec.at(s.Header, 1, E.New("[Peer] ", peer.PublicKey, " has no AllowedIPs"))
+	}
+	peer.ready = true
+	return peer, nil
+}
@@ -0,0 +1,125 @@
+package wireguard
+
+import (
+	"strings"
+	"testing"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+const (
+	testPrivateKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	testPublicKey  = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+)
+
+func TestParseModes(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		conf     string
+		peers    int
+		warnings []string
+		strict   string
+	}{
+		{
+			name:   "clean",
+			conf:   "[Interface]\nPrivateKey = " + testPrivateKey + "\n[Peer]\nPublicKey = " + testPublicKey + "\nAllowedIPs = 10.0.0.2/32\n",
+			peers:  1,
+			strict: "",
+		},
+		{
+			name:     "line outside of a section",
+			conf:     "ListenPort = 1\n[Interface]\nPrivateKey = " + testPrivateKey + "\n",
+			warnings: []string{`wg0.conf:1:1: line outside of a section: "ListenPort = 1"`},
+			strict:   `wg0.conf:1:1: line outside of a section: "ListenPort = 1"`,
+		},
+		{
+			name:     "malformed line",
+			conf:     "[Interface]\nPrivateKey = " + testPrivateKey + "\n  garbage\n",
+			warnings: []string{`wg0.conf:3:3: malformed line "garbage", expected Key = Value`},
+			strict:   `wg0.conf:3:3: malformed line "garbage", expected Key = Value`,
+		},
+		{
+			name:     "duplicate key",
+			conf:     "[Interface]\nPrivateKey = " + testPrivateKey + "\nListenPort = 1\nListenPort = 2\n",
+			warnings: []string{"wg0.conf:4:1: duplicate key ListenPort, first given on line 3"},
+			strict:   "wg0.conf:4:1: duplicate key ListenPort, first given on line 3",
+		},
+		{
+			name:     "peer without AllowedIPs",
+			conf:     "[Interface]\nPrivateKey = " + testPrivateKey + "\n[Peer]\nPublicKey = " + testPublicKey + "\n",
+			warnings: []string{"wg0.conf:3:1: [Peer] " + testPublicKey + " has no AllowedIPs, the peer is skipped"},
+			strict:   "wg0.conf:3:1: [Peer] " + testPublicKey + " has no AllowedIPs",
+		},
+		{
+			name: "duplicate peer",
+			conf: "[Interface]\nPrivateKey = " + testPrivateKey +
+				"\n[Peer]\nPublicKey = " + testPublicKey + "\nAllowedIPs = 10.0.0.2/32" +
+				"\n[Peer]\nPublicKey = " + testPublicKey + "\nAllowedIPs = 10.0.0.3/32\n",
+			peers:    1,
+			warnings: []string{"wg0.conf:6:1: duplicate peer " + testPublicKey + ", the first is on line 3"},
+			strict:   "wg0.conf:6:1: duplicate peer " + testPublicKey + ", the first is on line 3",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, peers, warnings, err := ParseWireguardConfWith(strings.NewReader(tt.conf), ParseOptions{File: "wg0.conf"})
+			if err != nil {
+				t.Fatalf("lenient: %v", err)
+			}
+			if len(peers) != tt.peers {
+				t.Errorf("lenient: %d peers, want %d", len(peers), tt.peers)
+			}
+			var got []string
+			for _, w := range warnings {
+				got = append(got, w.Error())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.warnings, "\n") {
+				t.Errorf("lenient warnings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.warnings, "\n"))
+			}
+
+			_, _, _, err = ParseWireguardConfWith(strings.NewReader(tt.conf), ParseOptions{File: "wg0.conf", Mode: Strict})
+			if tt.strict == "" {
+				if err != nil {
+					t.Errorf("strict: %v", err)
+				}
+				return
+			}
+			var positionErr *E.PositionError
+			if !E.As(err, &positionErr) || err.Error() != tt.strict {
+				t.Errorf("strict error = %v, want %s", err, tt.strict)
+			}
+		})
+	}
+}
+
+func TestParseWireguardConfLenient(t *testing.T) {
+	conf := "[Interface]\nPrivateKey = " + testPrivateKey + "\nJc = 4\n[Peer]\nPublicKey = " + testPublicKey + "\nAllowedIPs = 10.0.0.2/32\n"
+	if _, peers, err := ParseWireguardConf(strings.NewReader(conf)); err != nil || len(peers) != 1 {
+		t.Errorf("unknown key: %d peers, %v", len(peers), err)
+	}
+	if _, _, _, err := ParseWireguardConfWith(strings.NewReader(conf), ParseOptions{Mode: Strict}); err == nil {
+		t.Error("strict parse accepted the unknown key Jc")
+	}
+	_, _, warnings, err := ParseWireguardConfWith(strings.NewReader(conf), ParseOptions{Mode: Strict, AllowUnknown: true})
+	if err != nil || len(warnings) != 1 || warnings[0].Error() != "3:1: unknown key Jc in [Interface]" {
+		t.Errorf("strict parse allowing unknown keys: %v, %v", warnings, err)
+	}
+}
+
+func TestParseFatal(t *testing.T) {
+	for _, tt := range []struct {
+		conf string
+		want string
+	}{
+		{"[Peer]\nPublicKey = " + testPublicKey + "\nAllowedIPs = 10.0.0.2/32\n", "missing Interface section"},
+		{"[Interface]\nPrivateKey = " + testPrivateKey + "\n[Interface]\n", "3:1: multiple [Interface] sections, the first is on line 1"},
+		{"[Interface]\nPrivateKey = " + testPrivateKey + "\n[Router]\n", "3:1: unknown section: Router"},
+		{"[Interface]\nListenPort = 1\n", "1:1: [Interface] has no valid PrivateKey"},
+		{"[Interface]\nPrivateKey = " + testPrivateKey + "\nListenPort =  high\n", "3:15: invalid ListenPort: "},
+	} {
+		// these fail in lenient mode too
+		_, _, _, err := ParseWireguardConfWith(strings.NewReader(tt.conf), ParseOptions{})
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%q: error = %v, want %s", tt.conf, err, tt.want)
+		}
+	}
+}
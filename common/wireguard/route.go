@@ -0,0 +1,84 @@
+package wireguard
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Table is the routing table wg-quick adds the routes of the allowed IPs to:
+// TableAuto, TableOff or a table number. The zero value leaves the key out
+// of the config, which wg-quick treats as auto.
+type Table string
+
+const (
+	TableAuto Table = "auto"
+	TableOff  Table = "off"
+)
+
+func ParseTable(s string) (Table, error) {
+	switch t := Table(s); t {
+	case TableAuto, TableOff:
+		return t, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return "", E.New("invalid table ", strconv.Quote(s), ", expected off, auto or a number")
+	}
+	return Table(strconv.FormatUint(n, 10)), nil
+}
+
+// Number returns the table number unless t is empty, auto or off.
+func (t Table) Number() (uint32, bool) {
+	n, err := strconv.ParseUint(string(t), 10, 32)
+	return uint32(n), err == nil
+}
+
+// MarshalJSON writes a table number as a number, off and auto as strings.
+func (t Table) MarshalJSON() ([]byte, error) {
+	if _, ok := t.Number(); ok {
+		return []byte(t), nil
+	}
+	return json.Marshal(string(t))
+}
+
+func (t *Table) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n uint32
+		if json.Unmarshal(data, &n) != nil {
+			return E.New("invalid table ", string(data))
+		}
+		s = strconv.FormatUint(uint64(n), 10)
+	}
+	table, err := ParseTable(s)
+	if err != nil {
+		return err
+	}
+	*t = table
+	return nil
+}
+
+// ParseFwMark parses a firewall mark like wg(8): a decimal or 0x prefixed
+// hexadecimal number, off is no mark.
+func ParseFwMark(s string) (uint32, error) {
+	if s == "off" {
+		return 0, nil
+	}
+	base, digits := 10, s
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		base, digits = 16, s[2:]
+	}
+	mark, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, E.New("invalid fwmark ", strconv.Quote(s))
+	}
+	return uint32(mark), nil
+}
+
+// FormatFwMark prints a firewall mark in hex like wg showconf.
+func FormatFwMark(mark uint32) string {
+	return "0x" + strconv.FormatUint(uint64(mark), 16)
+}
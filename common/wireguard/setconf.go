@@ -0,0 +1,102 @@
+package wireguard
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strconv"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// Syntax is the flavor of a config file.
+type Syntax uint8
+
+const (
+	// SyntaxQuick is the config of wg-quick(8), a superset of SyntaxSetconf.
+	SyntaxQuick Syntax = iota
+	// SyntaxSetconf is the config of wg setconf and wg showconf, only the
+	// keys of the device and of the peers.
+	SyntaxSetconf
+)
+
+// quickKeys are the interface keys only wg-quick knows, wg setconf rejects
+// them.
+var quickKeys = []string{"Address", "DNS", "MTU", "Table", "PreUp", "PostUp", "PreDown", "PostDown", "SaveConfig"}
+
+func ParseSyntax(s string) (Syntax, error) {
+	switch s {
+	case "quick", "wg-quick":
+		return SyntaxQuick, nil
+	case "setconf", "showconf":
+		return SyntaxSetconf, nil
+	}
+	return 0, E.New("unknown config syntax ", strconv.Quote(s), ", expected quick or setconf")
+}
+
+func (s Syntax) String() string {
+	if s == SyntaxSetconf {
+		return "setconf"
+	}
+	return "quick"
+}
+
+// MarshalSetconf writes the config for wg setconf, leaving out everything
+// only wg-quick knows.
+func MarshalSetconf(iface Interface, peers []Peer) ([]byte, error) {
+	return marshalConf(iface, peers, SyntaxSetconf)
+}
+
+// Strip removes the keys only wg-quick knows from the [Interface] section,
+// like wg-quick strip. Comments and the peers stay as they are.
+func (d *Document) Strip() {
+	if s := d.Interface(); s != nil {
+		for _, key := range quickKeys {
+			s.Delete(key)
+		}
+	}
+}
+
+// ConvertWireguardConf checks a config with opts and rewrites it in the
+// syntax to. Going to setconf strips the keys of wg-quick, going to
+// wg-quick keeps the config as it is, wg-quick reads setconf configs too.
+func ConvertWireguardConf(in io.Reader, opts ParseOptions, to Syntax) ([]byte, error) {
+	d, err := ParseDocument(in)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err = d.Decode(opts); err != nil {
+		return nil, err
+	}
+	if to == SyntaxSetconf {
+		d.Strip()
+	}
+	return d.Bytes(), nil
+}
+
+func marshalConf(iface Interface, peers []Peer, syntax Syntax) ([]byte, error) {
+	var buf bytes.Buffer
+
+	// [Interface]
+	buf.WriteString("[Interface]\n")
+	kvs, err := iface.keyValues()
+	if err != nil {
+		return nil, err
+	}
+	if syntax == SyntaxSetconf {
+		kvs = slices.DeleteFunc(kvs, func(kv keyValue) bool { return slices.Contains(quickKeys, kv.key) })
+	}
+	buf.Write(marshalKeyValues(kvs))
+
+	// [Peer], a blank line between the sections like wg showconf
+	for _, peer := range peers {
+		buf.WriteString("\n[Peer]\n")
+		peerText, err := peer.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(peerText)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package wireguard
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseSyntax(t *testing.T) {
+	for in, want := range map[string]Syntax{"quick": SyntaxQuick, "wg-quick": SyntaxQuick, "setconf": SyntaxSetconf, "showconf": SyntaxSetconf} {
+		if s, err := ParseSyntax(in); err != nil || s != want {
+			t.Errorf("ParseSyntax(%q) = %v, %v", in, s, err)
+		}
+	}
+	if _, err := ParseSyntax("ini"); err == nil {
+		t.Error("ParseSyntax accepted ini")
+	}
+}
+
+func TestMarshalSetconf(t *testing.T) {
+	iif, peers, err := ParseWireguardConf(bytes.NewReader(readConf(t, "wg0.conf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := MarshalSetconf(iif, peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range quickKeys {
+		if bytes.Contains(out, []byte("\n"+key+" =")) {
+			t.Errorf("setconf config has %s:\n%s", key, out)
+		}
+	}
+	if !bytes.HasPrefix(out, []byte("[Interface]\nPrivateKey = ")) || !bytes.Contains(out, []byte("FwMark = 0x1234\n")) {
+		t.Errorf("setconf config:\n%s", out)
+	}
+
+	// wg setconf reads it back as it is
+	iif, _, warnings, err := ParseWireguardConfWith(bytes.NewReader(out), ParseOptions{Mode: Strict, Syntax: SyntaxSetconf})
+	if err != nil || len(warnings) > 0 || len(iif.Addresses) > 0 {
+		t.Errorf("parse setconf config: %v %v %+v", err, warnings, iif)
+	}
+}
+
+func TestParseSetconfSyntax(t *testing.T) {
+	conf := "[Interface]\nPrivateKey = " + testPrivateKey + "\nAddress = 10.0.0.1/24\n"
+	_, _, err := ParseWireguardConf(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = ParseWireguardConfWith(strings.NewReader(conf), ParseOptions{Mode: Strict, Syntax: SyntaxSetconf})
+	if want := "3:1: key Address is only known to wg-quick, wg setconf rejects it"; err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %s", err, want)
+	}
+}
+
+func TestConvertWireguardConf(t *testing.T) {
+	data := readConf(t, "document.conf")
+	out, err := ConvertWireguardConf(bytes.NewReader(data), ParseOptions{}, SyntaxQuick)
+	if err != nil || !bytes.Equal(out, data) {
+		t.Errorf("convert to quick:\n%s\n%v", out, err)
+	}
+
+	out, err = ConvertWireguardConf(bytes.NewReader(data), ParseOptions{}, SyntaxSetconf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Address goes, the comments and the unknown key stay
+	want := bytes.Replace(data, []byte("Address = 10.8.0.1/24\n"), nil, 1)
+	if !bytes.Equal(out, want) {
+		t.Errorf("convert to setconf:\n%s\nwant:\n%s", out, want)
+	}
+
+	if _, err = ConvertWireguardConf(bytes.NewReader(data), ParseOptions{Mode: Strict}, SyntaxSetconf); err == nil {
+		t.Error("strict convert accepted the unknown key Jc")
+	}
+}
@@ -0,0 +1,345 @@
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	E "github.com/woshikedayaa/fire/common/errors"
+)
+
+// uciDefaultPort is the endpoint_port OpenWrt assumes without one.
+const uciDefaultPort = 51820
+
+// exportUCI writes the interface and its peers as sections of
+// /etc/config/network. The peers route their AllowedIPs unless the table is
+// off, a table number becomes ip4table and ip6table of the interface.
+func exportUCI(iface Interface, peers []Peer, opts ExportOptions) ([]File, error) {
+	var buf bytes.Buffer
+	option := func(key, value string) { buf.WriteString("\toption " + key + " " + uciQuote(value) + "\n") }
+	list := func(key, value string) { buf.WriteString("\tlist " + key + " " + uciQuote(value) + "\n") }
+
+	buf.WriteString("config interface " + uciQuote(opts.Name) + "\n")
+	option("proto", "wireguard")
+	option("private_key", iface.PrivateKey.String())
+	if iface.ListenPort > 0 {
+		option("listen_port", strconv.FormatUint(uint64(iface.ListenPort), 10))
+	}
+	for _, addr := range iface.Addresses {
+		list("addresses", addr.String())
+	}
+	if iface.MTU > 0 {
+		option("mtu", strconv.Itoa(iface.MTU))
+	}
+	if iface.FwMark != 0 {
+		option("fwmark", FormatFwMark(iface.FwMark))
+	}
+	for _, dns := range iface.DNS {
+		list("dns", dns.String())
+	}
+	for _, search := range iface.DNSSearch {
+		list("dns_search", search)
+	}
+	if _, ok := iface.Table.Number(); ok {
+		option("ip4table", string(iface.Table))
+		option("ip6table", string(iface.Table))
+	}
+
+	routeAllowedIPs := "1"
+	if iface.Table == TableOff {
+		routeAllowedIPs = "0"
+	}
+	for _, peer := range peers {
+		if !peer.PublicKey.IsValid() {
+			return nil, E.New("PublicKey is required")
+		}
+		if len(peer.AllowedIPs) == 0 {
+			return nil, E.New("AllowedIPs is required")
+		}
+		buf.WriteString("\nconfig wireguard_" + opts.Name + "\n")
+		option("public_key", peer.PublicKey.String())
+		if peer.PresharedKey.IsValid() {
+			option("preshared_key", peer.PresharedKey.String())
+		}
+		for _, prefix := range peer.AllowedIPs {
+			list("allowed_ips", prefix.String())
+		}
+		option("route_allowed_ips", routeAllowedIPs)
+		if peer.Endpoint.IsValid() {
+			host := peer.Endpoint.Host
+			if peer.Endpoint.Addr.IsValid() {
+				host = peer.Endpoint.Addr.String()
+			}
+			option("endpoint_host", host)
+			option("endpoint_port", strconv.FormatUint(uint64(peer.Endpoint.Port), 10))
+		}
+		if peer.PersistentKeepalive > 0 {
+			option("persistent_keepalive", strconv.Itoa(peer.PersistentKeepalive))
+		}
+	}
+	return []File{{Name: "network", Data: buf.Bytes(), Perm: 0o600}}, nil
+}
+
+// uciQuote quotes a value in single quotes, which uci takes literally.
+func uciQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// uciSection is a config section of a uci file, its options and lists by
+// name. Options are lists of one value.
+type uciSection struct {
+	typ, name string
+	line      int
+	values    map[string][]string
+	lines     map[string]int
+}
+
+func (s *uciSection) option(key string) string {
+	values := s.values[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// fields splits an option holding a space separated list, like option dns.
+func (s *uciSection) fields(key string) []string {
+	var fields []string
+	for _, v := range s.values[key] {
+		fields = append(fields, strings.Fields(v)...)
+	}
+	return fields
+}
+
+func parseUCI(file File) ([]*uciSection, error) {
+	var (
+		sections []*uciSection
+		current  *uciSection
+		scanner  = bufio.NewScanner(bytes.NewReader(file.Data))
+	)
+	for number := 1; scanner.Scan(); number++ {
+		words, err := uciWords(scanner.Text())
+		if err != nil {
+			return nil, E.At(file.Name, number, 0, err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "package":
+		case "config":
+			if len(words) < 2 || len(words) > 3 {
+				return nil, E.At(file.Name, number, 0, E.New("expected config <type> [name]"))
+			}
+			current = &uciSection{typ: words[1], line: number, values: make(map[string][]string), lines: make(map[string]int)}
+			if len(words) == 3 {
+				current.name = words[2]
+			}
+			sections = append(sections, current)
+		case "option", "list":
+			if len(words) != 3 {
+				return nil, E.At(file.Name, number, 0, E.New("expected ", words[0], " <name> <value>"))
+			}
+			if current == nil {
+				return nil, E.At(file.Name, number, 0, E.New(words[0], " outside of a config section"))
+			}
+			if words[0] == "option" {
+				current.values[words[1]] = nil
+			}
+			current.values[words[1]] = append(current.values[words[1]], words[2])
+			current.lines[words[1]] = number
+		default:
+			return nil, E.At(file.Name, number, 0, E.New("unknown statement ", strconv.Quote(words[0])))
+		}
+	}
+	return sections, E.When("read "+file.Name, scanner.Err())
+}
+
+// uciWords splits a line of a uci file into words like a shell: single
+// quotes are literal, double quotes and bare words take backslash escapes
+// and # starts a comment.
+func uciWords(line string) ([]string, error) {
+	var (
+		words []string
+		word  strings.Builder
+		in    bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if in {
+				words = append(words, word.String())
+				word.Reset()
+				in = false
+			}
+		case c == '#' && !in:
+			return words, nil
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, E.New("unclosed single quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			in = true
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, E.New("unclosed double quote")
+			}
+			in = true
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			in = true
+		default:
+			word.WriteByte(c)
+			in = true
+		}
+	}
+	if in {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func uciBool(s string) bool {
+	switch s {
+	case "1", "yes", "on", "true", "enabled":
+		return true
+	}
+	return false
+}
+
+func importUCI(file File, opts ImportOptions) (name string, config InterfaceWithPeers, warnings []error, err error) {
+	sections, err := parseUCI(file)
+	if err != nil {
+		return "", InterfaceWithPeers{}, nil, err
+	}
+	var found *uciSection
+	var names []string
+	for _, s := range sections {
+		if s.typ != "interface" || s.option("proto") != "wireguard" {
+			continue
+		}
+		names = append(names, s.name)
+		if opts.Name == "" || s.name == opts.Name {
+			found = s
+		}
+	}
+	switch {
+	case found == nil && opts.Name != "":
+		return "", InterfaceWithPeers{}, nil, E.New(file.Name, ": no wireguard interface ", opts.Name)
+	case found == nil:
+		return "", InterfaceWithPeers{}, nil, E.New(file.Name, ": no wireguard interface")
+	case opts.Name == "" && len(names) > 1:
+		return "", InterfaceWithPeers{}, nil, E.New(file.Name, ": several wireguard interfaces, choose one of ", strings.Join(names, ", "))
+	}
+	name = found.name
+	at := func(s *uciSection, key string, err error) error {
+		return E.At(file.Name, s.lines[key], 0, err)
+	}
+
+	iface := &config.Interface
+	if err = iface.PrivateKey.UnmarshalText([]byte(found.option("private_key"))); err != nil {
+		return "", InterfaceWithPeers{}, nil, at(found, "private_key", err)
+	}
+	if v := found.option("listen_port"); v != "" {
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return "", InterfaceWithPeers{}, nil, at(found, "listen_port", E.New("invalid listen_port ", strconv.Quote(v)))
+		}
+		iface.ListenPort = uint16(port)
+	}
+	for _, v := range found.fields("addresses") {
+		addr, err := ParseAddress(v)
+		if err != nil {
+			return "", InterfaceWithPeers{}, nil, at(found, "addresses", err)
+		}
+		iface.Addresses = append(iface.Addresses, addr)
+	}
+	if v := found.option("mtu"); v != "" {
+		if iface.MTU, err = strconv.Atoi(v); err != nil {
+			return "", InterfaceWithPeers{}, nil, at(found, "mtu", E.New("invalid mtu ", strconv.Quote(v)))
+		}
+	}
+	if v := found.option("fwmark"); v != "" {
+		if iface.FwMark, err = ParseFwMark(v); err != nil {
+			return "", InterfaceWithPeers{}, nil, at(found, "fwmark", err)
+		}
+	}
+	for _, v := range found.fields("dns") {
+		dns, err := netip.ParseAddr(v)
+		if err != nil {
+			return "", InterfaceWithPeers{}, nil, at(found, "dns", E.New("invalid dns ", strconv.Quote(v)))
+		}
+		iface.DNS = append(iface.DNS, dns)
+	}
+	iface.DNSSearch = found.fields("dns_search")
+
+	var routed []bool
+	for _, s := range sections {
+		if s.typ != "wireguard_"+name || uciBool(s.option("disabled")) {
+			continue
+		}
+		var peer Peer
+		if err = peer.PublicKey.UnmarshalText([]byte(s.option("public_key"))); err != nil {
+			return "", InterfaceWithPeers{}, nil, at(s, "public_key", err)
+		}
+		if v := s.option("preshared_key"); v != "" {
+			if err = peer.PresharedKey.UnmarshalText([]byte(v)); err != nil {
+				return "", InterfaceWithPeers{}, nil, at(s, "preshared_key", err)
+			}
+		}
+		for _, v := range s.fields("allowed_ips") {
+			prefix, err := ParseAddress(v)
+			if err != nil {
+				return "", InterfaceWithPeers{}, nil, at(s, "allowed_ips", err)
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, prefix)
+		}
+		if host := s.option("endpoint_host"); host != "" {
+			port := s.option("endpoint_port")
+			if port == "" {
+				port = strconv.Itoa(uciDefaultPort)
+			}
+			if peer.Endpoint, err = ParseEndpoint(net.JoinHostPort(host, port)); err != nil {
+				return "", InterfaceWithPeers{}, nil, at(s, "endpoint_host", err)
+			}
+		}
+		if v := s.option("persistent_keepalive"); v != "" {
+			keepalive, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				return "", InterfaceWithPeers{}, nil, at(s, "persistent_keepalive", E.New("invalid persistent_keepalive ", strconv.Quote(v)))
+			}
+			peer.PersistentKeepalive = int(keepalive)
+		}
+		routed = append(routed, uciBool(s.option("route_allowed_ips")))
+		config.Peers = append(config.Peers, peer)
+	}
+
+	// wg-quick routes all peers or none
+	switch {
+	case !slices.Contains(routed, true):
+		iface.Table = TableOff
+	case slices.Contains(routed, false):
+		warnings = append(warnings, E.New(file.Name, ": only some peers of ", name, " set route_allowed_ips, routing the AllowedIPs of all"))
+	}
+	if v := found.option("ip4table"); v != "" && iface.Table != TableOff {
+		if iface.Table, err = ParseTable(v); err != nil {
+			return "", InterfaceWithPeers{}, nil, at(found, "ip4table", err)
+		}
+	}
+	return name, config, warnings, nil
+}